@@ -0,0 +1,94 @@
+package drum
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// headerSize is the length of the SPLICE magic plus the pattern size.
+const headerSize = 14
+
+// Bundle is a file made of several concatenated .splice patterns.
+// The file is memory-mapped and only the headers are read when it is
+// opened: tracks are decoded on demand, so memory stays proportional to
+// the patterns being accessed rather than to the size of the file.
+type Bundle struct {
+	data    []byte
+	offsets []int64
+	unmap   func() error
+}
+
+// OpenBundle maps the bundle found at the provided path and indexes
+// the patterns it contains.
+func OpenBundle(path string) (*Bundle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, unmap, err := mmapFile(f, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("error mapping %s: %s", path, err)
+	}
+
+	b := &Bundle{data: data, unmap: unmap}
+	if err := b.index(); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// index walks the pattern headers, skipping over their bodies.
+func (b *Bundle) index() error {
+	var off int64
+	for off < int64(len(b.data)) {
+		if int64(len(b.data))-off < headerSize {
+			return fmt.Errorf("truncated header at offset %d", off)
+		}
+		if string(b.data[off:off+6]) != "SPLICE" {
+			return fmt.Errorf("Fail to parse header at offset %d: must contain SPLICE", off)
+		}
+		size := int64(binary.BigEndian.Uint64(b.data[off+6 : off+headerSize]))
+		if size < 0 || size > int64(len(b.data))-off-headerSize {
+			return fmt.Errorf("invalid pattern size %d at offset %d", size, off)
+		}
+		b.offsets = append(b.offsets, off)
+		off += headerSize + size
+	}
+	return nil
+}
+
+// Len returns the number of patterns in the bundle.
+func (b *Bundle) Len() int {
+	return len(b.offsets)
+}
+
+// Pattern decodes the i-th pattern of the bundle.
+func (b *Bundle) Pattern(i int) (*Pattern, error) {
+	if b.data == nil {
+		return nil, errors.New("bundle is closed")
+	}
+	if i < 0 || i >= len(b.offsets) {
+		return nil, fmt.Errorf("pattern %d out of range [0, %d)", i, len(b.offsets))
+	}
+	off := b.offsets[i]
+	size := int64(binary.BigEndian.Uint64(b.data[off+6 : off+headerSize]))
+	return decode(b.data[off : off+headerSize+size])
+}
+
+// Close unmaps the bundle. Patterns already decoded remain valid.
+func (b *Bundle) Close() error {
+	if b.data == nil {
+		return nil
+	}
+	b.data = nil
+	return b.unmap()
+}
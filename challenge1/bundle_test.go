@@ -0,0 +1,70 @@
+package drum
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestBundle(t *testing.T) {
+	files := []string{"pattern_1.splice", "pattern_5.splice", "pattern_3.splice"}
+
+	var bundle []byte
+	for _, name := range files {
+		data, err := ioutil.ReadFile(path.Join("fixtures", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Drop any trailing bytes past the size declared in the header
+		size := binary.BigEndian.Uint64(data[6:14])
+		bundle = append(bundle, data[:headerSize+size]...)
+	}
+	bundlePath := filepath.Join(t.TempDir(), "bundle.splice")
+	if err := ioutil.WriteFile(bundlePath, bundle, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := OpenBundle(bundlePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if b.Len() != len(files) {
+		t.Fatalf("expected %d patterns, got %d", len(files), b.Len())
+	}
+	for i, name := range files {
+		decoded, err := b.Pattern(i)
+		if err != nil {
+			t.Fatalf("something went wrong decoding pattern %d - %v", i, err)
+		}
+		expected, err := DecodeFile(path.Join("fixtures", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(decoded) != fmt.Sprint(expected) {
+			t.Fatalf("pattern %d wasn't decoded as expect.\nGot:\n%s\nExpected:\n%s",
+				i, decoded, expected)
+		}
+	}
+	if _, err := b.Pattern(len(files)); err == nil {
+		t.Fatal("expected an error decoding a pattern out of range")
+	}
+}
+
+func TestBundleInvalid(t *testing.T) {
+	bundlePath := filepath.Join(t.TempDir(), "bundle.splice")
+	if err := ioutil.WriteFile(bundlePath, []byte("SPLICE\x00\x00\x00\x00\x00\x00\xff\xff"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenBundle(bundlePath); err == nil {
+		t.Fatal("expected an error opening a bundle with an invalid size")
+	}
+	if _, err := OpenBundle(filepath.Join(os.TempDir(), "missing.splice")); err == nil {
+		t.Fatal("expected an error opening a missing bundle")
+	}
+}
@@ -3,8 +3,11 @@ package drum
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"io"
+	"io/fs"
 	"io/ioutil"
+	"path"
 )
 
 // Track represents each instrument being played
@@ -20,38 +23,72 @@ type Track struct {
 func DecodeFile(path string) (*Pattern, error) {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	return decode(data)
+}
+
+// DecodeFS decodes the drum machine file found at the provided path
+// of the file system, e.g. patterns embedded with go:embed.
+func DecodeFS(fsys fs.FS, path string) (*Pattern, error) {
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, err
+	}
+	return decode(data)
+}
 
+// DecodeDirFS decodes every .splice file found in the directory dir
+// of the file system. Patterns are keyed by file name.
+func DecodeDirFS(fsys fs.FS, dir string) (map[string]*Pattern, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	patterns := make(map[string]*Pattern)
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".splice" {
+			continue
+		}
+		p, err := DecodeFS(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		patterns[entry.Name()] = p
+	}
+	return patterns, nil
+}
+
+func decode(data []byte) (*Pattern, error) {
 	buf := bytes.NewReader(data)
 
 	var header [6]byte
-	err = binary.Read(buf, binary.LittleEndian, &header)
+	err := binary.Read(buf, binary.LittleEndian, &header)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// Header must contain SPLICE
 	if string(header[:]) != "SPLICE" {
-		panic("Fail to parse header: must contain SPLICE")
+		return nil, errors.New("Fail to parse header: must contain SPLICE")
 	}
 
 	var size int64
 	err = binary.Read(buf, binary.BigEndian, &size)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	var version [32]byte
 	err = binary.Read(buf, binary.BigEndian, &version)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	var tempo float32
 	err = binary.Read(buf, binary.LittleEndian, &tempo)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	tracks := []Track{}
@@ -59,7 +96,10 @@ func DecodeFile(path string) (*Pattern, error) {
 	size -= 36 // header length
 
 	for size > 0 {
-		track := readTrack(buf)
+		track, err := readTrack(buf)
+		if err != nil {
+			return nil, err
+		}
 		tracks = append(tracks, *track)
 		size -= 21 + int64(len(track.Name))
 	}
@@ -72,35 +112,35 @@ func DecodeFile(path string) (*Pattern, error) {
 	return p, nil
 }
 
-func readTrack(buf io.Reader) *Track {
+func readTrack(buf io.Reader) (*Track, error) {
 	var id int32
-	var nameLength int8
+	var nameLength uint8
 	var steps [16]bool
 
 	err := binary.Read(buf, binary.LittleEndian, &id)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	err = binary.Read(buf, binary.BigEndian, &nameLength)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	name := make([]byte, nameLength)
 	err = binary.Read(buf, binary.BigEndian, &name)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	err = binary.Read(buf, binary.BigEndian, &steps)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	return &Track{
 		ID:    id,
 		Name:  name,
 		Steps: steps,
-	}
+	}, nil
 }
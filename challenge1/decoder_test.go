@@ -1,7 +1,10 @@
 package drum
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"os"
 	"path"
 	"testing"
 )
@@ -73,3 +76,58 @@ Tempo: 999
 		}
 	}
 }
+
+func TestDecodeFS(t *testing.T) {
+	fsys := os.DirFS("fixtures")
+
+	decoded, err := DecodeFS(fsys, "pattern_1.splice")
+	if err != nil {
+		t.Fatalf("something went wrong decoding pattern_1.splice - %v", err)
+	}
+	expected, err := DecodeFile(path.Join("fixtures", "pattern_1.splice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(decoded) != fmt.Sprint(expected) {
+		t.Fatalf("pattern_1.splice wasn't decoded as expect.\nGot:\n%s\nExpected:\n%s",
+			decoded, expected)
+	}
+
+	if _, err := DecodeFS(fsys, "missing.splice"); err == nil {
+		t.Fatal("expected an error decoding a missing file")
+	}
+}
+
+func TestDecodeDirFS(t *testing.T) {
+	patterns, err := DecodeDirFS(os.DirFS("."), "fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patterns) != 5 {
+		t.Fatalf("expected 5 patterns, got %d", len(patterns))
+	}
+	if p := patterns["pattern_4.splice"]; p == nil || p.Tempo != 240 {
+		t.Fatalf("pattern_4.splice wasn't decoded as expect: %v", p)
+	}
+}
+
+func TestDecodeLongTrackName(t *testing.T) {
+	name := bytes.Repeat([]byte("a"), 200)
+	var data bytes.Buffer
+	data.WriteString("SPLICE")
+	binary.Write(&data, binary.BigEndian, int64(36+21+len(name)))
+	data.Write(make([]byte, 32))
+	binary.Write(&data, binary.LittleEndian, float32(120))
+	binary.Write(&data, binary.LittleEndian, int32(1))
+	data.WriteByte(byte(len(name)))
+	data.Write(name)
+	data.Write(make([]byte, 16))
+
+	decoded, err := decode(data.Bytes())
+	if err != nil {
+		t.Fatalf("something went wrong decoding a name of %d bytes - %v", len(name), err)
+	}
+	if len(decoded.Tracks) != 1 || !bytes.Equal(decoded.Tracks[0].Name, name) {
+		t.Fatalf("a name of %d bytes wasn't decoded as expect: %v", len(name), decoded)
+	}
+}
@@ -5,6 +5,7 @@ package drum
 import (
 	"fmt"
 	"math"
+	"strings"
 )
 
 // Pattern is the high level representation of the
@@ -16,24 +17,89 @@ type Pattern struct {
 }
 
 func (p Pattern) String() string {
+	return p.Render(ASCIIFormatter{})
+}
+
+// Render returns the textual representation of the pattern
+// using the given formatter to draw the steps of each track.
+func (p Pattern) Render(f Formatter) string {
 	str := fmt.Sprintf("Saved with HW Version: %s\n", formatVersion(p.Version))
 	str += fmt.Sprintf("Tempo: %g\n", p.Tempo)
 	for _, track := range p.Tracks {
-		str += fmt.Sprintf("(%d) %s\t%s\n", track.ID, string(track.Name[:]), formatSteps(track.Steps))
+		str += fmt.Sprintf("(%d) %s\t%s\n", track.ID, string(track.Name[:]), f.FormatSteps(track))
 	}
 	return str
 }
 
-func formatSteps(steps [16]bool) string {
+// Formatter draws the steps of a track.
+type Formatter interface {
+	FormatSteps(track Track) string
+}
+
+// ASCIIFormatter draws steps as x (played) and - (silent),
+// the format used by the drum machine itself.
+type ASCIIFormatter struct{}
+
+// FormatSteps implements Formatter.
+func (ASCIIFormatter) FormatSteps(track Track) string {
+	return formatSteps(track.Steps, "x", "-")
+}
+
+// BlockFormatter draws steps with unicode block characters,
+// which are easier to read in a terminal.
+type BlockFormatter struct{}
+
+// FormatSteps implements Formatter.
+func (BlockFormatter) FormatSteps(track Track) string {
+	return formatSteps(track.Steps, "▓", "░")
+}
+
+// EmojiFormatter draws played steps with an emoji matching the
+// instrument of the track, e.g. 🥁 for a kick and 👏 for a clap.
+type EmojiFormatter struct{}
+
+// FormatSteps implements Formatter.
+func (EmojiFormatter) FormatSteps(track Track) string {
+	return formatSteps(track.Steps, instrumentEmoji(string(track.Name)), "➖")
+}
+
+// instrumentEmojis maps parts of instrument names to emojis.
+// Order matters: the first match wins.
+var instrumentEmojis = []struct {
+	name  string
+	emoji string
+}{
+	{"kick", "🥁"},
+	{"snare", "🪘"},
+	{"clap", "👏"},
+	{"hh", "🎩"},
+	{"hihat", "🎩"},
+	{"cowbell", "🐄"},
+	{"tom", "🪘"},
+	{"conga", "🪘"},
+	{"maracas", "🪇"},
+}
+
+func instrumentEmoji(name string) string {
+	name = strings.ToLower(name)
+	for _, i := range instrumentEmojis {
+		if strings.Contains(name, i.name) {
+			return i.emoji
+		}
+	}
+	return "🎵"
+}
+
+func formatSteps(steps [16]bool, on, off string) string {
 	str := ""
 	for idx, step := range steps {
 		if math.Mod(float64(idx), 4) == 0 {
 			str += "|"
 		}
 		if step {
-			str += "x"
+			str += on
 		} else {
-			str += "-"
+			str += off
 		}
 	}
 	str += "|"
@@ -0,0 +1,39 @@
+package drum
+
+import (
+	"path"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tData := []struct {
+		formatter Formatter
+		output    string
+	}{
+		{BlockFormatter{},
+			`Saved with HW Version: 0.708-alpha
+Tempo: 999
+(1) Kick	|▓░░░|░░░░|▓░░░|░░░░|
+(2) HiHat	|▓░▓░|▓░▓░|▓░▓░|▓░▓░|
+`,
+		},
+		{EmojiFormatter{},
+			`Saved with HW Version: 0.708-alpha
+Tempo: 999
+(1) Kick	|🥁➖➖➖|➖➖➖➖|🥁➖➖➖|➖➖➖➖|
+(2) HiHat	|🎩➖🎩➖|🎩➖🎩➖|🎩➖🎩➖|🎩➖🎩➖|
+`,
+		},
+	}
+
+	decoded, err := DecodeFile(path.Join("fixtures", "pattern_5.splice"))
+	if err != nil {
+		t.Fatalf("something went wrong decoding pattern_5.splice - %v", err)
+	}
+	for _, exp := range tData {
+		if got := decoded.Render(exp.formatter); got != exp.output {
+			t.Fatalf("%T didn't render as expected.\nGot:\n%s\nExpected:\n%s",
+				exp.formatter, got, exp.output)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package drum
+
+import (
+	"io"
+	"os"
+)
+
+// mmapFile falls back to reading the whole file on platforms
+// without mmap support.
+func mmapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"filippo.io/age"
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+// ageFiles opens the input and output files of the age commands,
+// defaulting to the standard input and output.
+func ageFiles(fs *flag.FlagSet, out string) (io.ReadCloser, io.WriteCloser) {
+	in, w := io.ReadCloser(os.Stdin), io.WriteCloser(os.Stdout)
+	var err error
+	if fs.NArg() == 1 {
+		if in, err = os.Open(fs.Arg(0)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if out != "" {
+		if w, err = os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600); err != nil {
+			log.Fatal(err)
+		}
+	}
+	return in, w
+}
+
+func encryptCommand(args []string) {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	var recipients stringsFlag
+	fs.Var(&recipients, "r", "Encrypt to this age recipient, age1..., can be repeated")
+	keyfile := fs.String("keyfile", "", "Also encrypt to the public key of this key pair")
+	show := fs.Bool("show-recipient", false, "Print the age recipient of -keyfile and exit")
+	out := fs.String("o", "", "Write the encrypted file to this file instead of the standard output")
+	fs.Parse(args)
+	if (len(recipients) == 0 && *keyfile == "") || fs.NArg() > 1 || (*show && *keyfile == "") {
+		log.Fatalf("Usage: %s encrypt [-r recipient]... [-keyfile file] [-o file] [file]\n"+
+			"       %s encrypt -keyfile file -show-recipient", os.Args[0], os.Args[0])
+	}
+
+	var rs []age.Recipient
+	for _, s := range recipients {
+		r, err := age.ParseX25519Recipient(s)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rs = append(rs, r)
+	}
+	if *keyfile != "" {
+		kp, err := securecomm.LoadKeyPair(*keyfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		r, err := securecomm.AgeRecipient(kp)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *show {
+			fmt.Println(r)
+			return
+		}
+		rs = append(rs, r)
+	}
+
+	in, w := ageFiles(fs, *out)
+	defer in.Close()
+	encrypted, err := age.Encrypt(w, rs...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := io.Copy(encrypted, in); err != nil {
+		log.Fatal(err)
+	}
+	if err := encrypted.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func decryptCommand(args []string) {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keyfile := fs.String("keyfile", "", "Decrypt with the private key of this key pair")
+	out := fs.String("o", "", "Write the decrypted file to this file instead of the standard output")
+	fs.Parse(args)
+	if *keyfile == "" || fs.NArg() > 1 {
+		log.Fatalf("Usage: %s decrypt -keyfile file [-o file] [file]", os.Args[0])
+	}
+
+	kp, err := securecomm.LoadKeyPair(*keyfile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	identity, err := securecomm.AgeIdentity(kp)
+	if err != nil {
+		log.Fatal(err)
+	}
+	in, w := ageFiles(fs, *out)
+	defer in.Close()
+	decrypted, err := age.Decrypt(in, identity)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := io.Copy(w, decrypted); err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+// BenchResult is the outcome of a benchmark of a connection.
+type BenchResult struct {
+	Bytes    int64 // sent
+	Messages int
+	Elapsed  time.Duration
+
+	// Latencies of the round trips of the messages, when echoed.
+	Latencies []time.Duration
+}
+
+// RunBench sends messages of size bytes over conn until ctx is done.
+// With echo, it waits for each message to come back, measuring the
+// round trips; otherwise it streams the messages, discarding whatever
+// the server sends back.
+func RunBench(ctx context.Context, conn io.ReadWriter, size int, echo bool) (*BenchResult, error) {
+	msg := make([]byte, size)
+	reply := make([]byte, size)
+	if !echo {
+		go io.Copy(io.Discard, conn)
+	}
+	res := &BenchResult{}
+	start := time.Now()
+	for ctx.Err() == nil {
+		sent := time.Now()
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		if echo {
+			if _, err := io.ReadFull(conn, reply); err != nil {
+				return nil, err
+			}
+			res.Latencies = append(res.Latencies, time.Since(sent))
+		}
+		res.Bytes += int64(size)
+		res.Messages++
+	}
+	res.Elapsed = time.Since(start)
+	return res, nil
+}
+
+// merge adds the results of another connection run concurrently.
+func (r *BenchResult) merge(other *BenchResult) {
+	r.Bytes += other.Bytes
+	r.Messages += other.Messages
+	r.Elapsed = max(r.Elapsed, other.Elapsed)
+	r.Latencies = append(r.Latencies, other.Latencies...)
+}
+
+// Percentile returns the latency under which p percent of the round
+// trips completed.
+func (r *BenchResult) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(r.Latencies)
+	slices.Sort(sorted)
+	i := int(float64(len(sorted)-1) * p / 100)
+	return sorted[i]
+}
+
+func (r *BenchResult) String() string {
+	seconds := r.Elapsed.Seconds()
+	s := fmt.Sprintf("sent %s in %s: %s/s, %.1f msg/s",
+		formatSize(r.Bytes), r.Elapsed.Round(time.Millisecond), formatSize(int64(float64(r.Bytes)/seconds)), float64(r.Messages)/seconds)
+	if len(r.Latencies) > 0 {
+		s += fmt.Sprintf("\nlatency p50=%s p90=%s p99=%s max=%s",
+			r.Percentile(50), r.Percentile(90), r.Percentile(99), r.Percentile(100))
+	}
+	return s
+}
+
+// parseSize parses a size in bytes with an optional K, M or G suffix,
+// powers of 1024.
+func parseSize(size string) (int, error) {
+	s, shift := size, 0
+	switch {
+	case strings.HasSuffix(s, "K"):
+		shift = 10
+	case strings.HasSuffix(s, "M"):
+		shift = 20
+	case strings.HasSuffix(s, "G"):
+		shift = 30
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 || n > 1<<(31-shift)-1 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return n << shift, nil
+}
+
+// formatSize formats n bytes with a binary unit.
+func formatSize(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", f, units[i])
+}
+
+func benchCommand(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	target := fs.String("target", "", "Address of the server, host:port")
+	size := fs.String("size", "64K", "Size of the messages, with an optional K, M or G suffix")
+	duration := fs.Duration("duration", 10*time.Second, "Duration of the benchmark")
+	mode := fs.String("mode", "echo", "echo to wait for each message to be echoed, measuring the latency, or stream to send them back to back")
+	conns := fs.Int("conns", 1, "Number of concurrent connections")
+	config := configFlags(fs)
+	parseFlags(fs, args)
+	if *target == "" || fs.NArg() != 0 || (*mode != "echo" && *mode != "stream") || *conns < 1 {
+		log.Fatalf("Usage: %s bench [flags] -target <host:port> [-size 64K] [-duration 10s] [-mode echo|stream] [-conns 1]", os.Args[0])
+	}
+	n, err := parseSize(*size)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg := config(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	var mu sync.Mutex
+	total := &BenchResult{}
+	var wg sync.WaitGroup
+	var errs []error
+	for range *conns {
+		conn, err := securecomm.DialConfig(*target, cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer conn.Close()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := RunBench(ctx, conn, n, *mode == "echo")
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			total.merge(res)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(total)
+}
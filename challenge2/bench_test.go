@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int{"512": 512, "64K": 64 << 10, "1M": 1 << 20, "1G": 1 << 30} {
+		if got, err := parseSize(s); err != nil || got != want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "K", "-1", "0", "2G", "1T"} {
+		if _, err := parseSize(s); err == nil {
+			t.Errorf("parseSize(%q) succeeded", s)
+		}
+	}
+}
+
+func TestBenchPercentile(t *testing.T) {
+	r := &BenchResult{}
+	for i := 100; i > 0; i-- {
+		r.Latencies = append(r.Latencies, time.Duration(i)*time.Millisecond)
+	}
+	if got := r.Percentile(50); got != 50*time.Millisecond {
+		t.Errorf("got p50 %s", got)
+	}
+	if got := r.Percentile(100); got != 100*time.Millisecond {
+		t.Errorf("got max %s", got)
+	}
+}
+
+func TestRunBench(t *testing.T) {
+	for _, echo := range []bool{true, false} {
+		client, server := securecomm.SecurePipe()
+		if echo {
+			go io.Copy(server, server)
+		} else {
+			go io.Copy(io.Discard, server)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		res, err := RunBench(ctx, client, 1000, echo)
+		cancel()
+		client.Close()
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Messages == 0 || res.Bytes != int64(res.Messages)*1000 {
+			t.Errorf("sent %d messages, %d bytes", res.Messages, res.Bytes)
+		}
+		if echo != (len(res.Latencies) == res.Messages) {
+			t.Errorf("got %d latencies for %d messages", len(res.Latencies), res.Messages)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+func certCommand(args []string) {
+	fs := flag.NewFlagSet("cert", flag.ExitOnError)
+	ca := fs.String("ca", "", "Identity key of the certificate authority")
+	name := fs.String("name", "", "Name of the certificate, the host name for servers")
+	validity := fs.Duration("validity", 90*24*time.Hour, "Validity of the certificate")
+	out := fs.String("out", "", "Write the certificate to this file")
+	fs.Parse(args)
+	if *ca == "" || *out == "" || fs.NArg() != 1 {
+		log.Fatalf("Usage: %s cert -ca <file> -out <file> [-name name] [-validity duration] <public key>", os.Args[0])
+	}
+	identity, err := base64.StdEncoding.DecodeString(fs.Arg(0))
+	if err != nil || len(identity) != ed25519.PublicKeySize {
+		log.Fatalf("invalid public key %q", fs.Arg(0))
+	}
+	authority, err := loadIdentityFile(*ca)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cert, err := securecomm.SignCertificate(authority, identity, *name, time.Now().Add(*validity))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := securecomm.SaveCertificate(*out, cert); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Certificate of %s written to %s, valid until %s\n", securecomm.Fingerprint(identity), *out, cert.NotAfter.Format(time.RFC3339))
+	fmt.Printf("Peers trust it with the public key of the authority: %s\n", base64.StdEncoding.EncodeToString(cert.Authority))
+}
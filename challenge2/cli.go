@@ -0,0 +1,188 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+// configFlags defines the flags configuring the connections on fs. The
+// returned function builds the Config once fs is parsed, exiting on
+// errors; known hosts are only checked by clients and authorized keys by
+// servers, reloaded on SIGHUP.
+func configFlags(fs *flag.FlagSet) func(client bool) *securecomm.Config {
+	load := configLoader(fs)
+	return func(client bool) *securecomm.Config {
+		cfg, keys, err := load(client)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if keys != nil {
+			reloadOnSIGHUP(keys, keys.Logger)
+		}
+		return cfg
+	}
+}
+
+// configLoader is like configFlags, but the returned function returns
+// the errors, and the authorized keys to reload if any, so it can also
+// reload the config.
+func configLoader(fs *flag.FlagSet) func(client bool) (*securecomm.Config, *securecomm.AuthorizedKeys, error) {
+	fs.String("config", "", "Read the options missing from the command line from this TOML file, reloaded on SIGHUP in listen mode")
+	keyfile := fs.String("keyfile", "", "Long-term key pair. Generated if it does not exist")
+	identity := fs.String("identity", "", "Identity key signing the handshake. Generated if it does not exist")
+	knownHosts := fs.String("knownhosts", "", "Client mode. Verify server identities against this file, trusting them on first use")
+	replaceHostKey := fs.Bool("replace-hostkey", false, "Client mode. Trust the server identity even if it changed")
+	yes := fs.Bool("yes", false, "Client mode. Trust the identity of new servers without asking")
+	psk := fs.String("psk", "", "Pre-shared key. Both sides must use the same one")
+	password := fs.String("password", "", "Password-authenticated handshake. Both sides must use the same password")
+	postQuantum := fs.Bool("pq", false, "Use a hybrid post-quantum key exchange when the peer supports it")
+	readTimeout := fs.Duration("read-timeout", 0, "Fail when a frame from the peer takes longer than this to arrive")
+	writeTimeout := fs.Duration("write-timeout", 0, "Fail when a frame takes longer than this to send")
+	keepAlive := fs.Duration("tcp-keepalive", 0, "Period of the TCP keep-alive probes, negative to disable them")
+	nagle := fs.Bool("tcp-nagle", false, "Enable Nagle's algorithm, saving packets at the expense of latency")
+	readBuffer := fs.Int("tcp-read-buffer", 0, "Size of the socket receive buffer, in bytes")
+	writeBuffer := fs.Int("tcp-write-buffer", 0, "Size of the socket send buffer, in bytes")
+	authorizedKeys := fs.String("authorized-keys", "", "Listen mode. Only accept the client identities listed in this file, reloaded on SIGHUP")
+	cert := fs.String("cert", "", "Certificate of the identity presented to the peers, see the cert command")
+	authorities := fs.String("ca", "", "Require peers to present a certificate signed by one of the authority public keys listed in this file")
+	agentKey := fs.String("agent", "", "Client mode. Sign the handshake with this Ed25519 key of ssh-agent, by comment or fingerprint, or * for the first one")
+	trace := fs.Bool("trace", false, "Log the direction, type, length, sequence number and timing of each frame to the standard error")
+	tracePlaintext := fs.Bool("trace-plaintext", false, "Also log the decrypted payloads with -trace. Exposes the data, for debugging only")
+	record := fs.String("record", "", "Client mode. Record the session, with its keys, to this file, see the replay command. Anyone reading it can decrypt the session, for debugging only")
+	proxy := fs.String("proxy", "", "Client mode. Dial through this http:// or socks5:// proxy, defaults to $HTTPS_PROXY or $ALL_PROXY")
+	keyLog := fs.String("keylog", "", "Append the session keys to this file to decrypt captured traffic, like SSLKEYLOGFILE for TLS. Anyone reading it can decrypt the sessions, for debugging only")
+
+	// The key log stays open across the reloads, until its path changes
+	var keyLogFile *os.File
+	var keyLogPath string
+
+	return func(client bool) (*securecomm.Config, *securecomm.AuthorizedKeys, error) {
+		cfg := &securecomm.Config{
+			PSK:          []byte(*psk),
+			Password:     []byte(*password),
+			PostQuantum:  *postQuantum,
+			ReadTimeout:  *readTimeout,
+			WriteTimeout: *writeTimeout,
+		}
+		if *keepAlive != 0 || *nagle || *readBuffer != 0 || *writeBuffer != 0 {
+			cfg.TCP = &securecomm.TCPOptions{KeepAlive: *keepAlive, Nagle: *nagle, ReadBuffer: *readBuffer, WriteBuffer: *writeBuffer}
+		}
+		if client {
+			cfg.Proxy = *proxy
+		}
+		if *record != "" && client {
+			f, err := os.OpenFile(*record, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			if err != nil {
+				return nil, nil, err
+			}
+			// An existing file keeps its mode otherwise
+			if err := f.Chmod(0600); err != nil {
+				f.Close()
+				return nil, nil, err
+			}
+			slog.Warn("RECORDING THE SESSION WITH ITS KEYS, anyone reading the recording can decrypt the traffic", "record", *record)
+			cfg.Record = f
+		}
+		if *keyLog != keyLogPath {
+			if keyLogFile != nil {
+				keyLogFile.Close()
+				keyLogFile = nil
+			}
+			if *keyLog != "" {
+				f, err := os.OpenFile(*keyLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+				if err != nil {
+					return nil, nil, err
+				}
+				slog.Warn("WRITING THE SESSION KEYS, anyone reading the key log can decrypt the traffic", "keylog", *keyLog)
+				keyLogFile = f
+			}
+			keyLogPath = *keyLog
+		}
+		if keyLogFile != nil {
+			cfg.KeyLog = keyLogFile
+		}
+		if *trace {
+			cfg.Trace = securecomm.TraceWriter(os.Stderr)
+			cfg.TracePlaintext = *tracePlaintext
+		}
+		if *keyfile != "" {
+			kp, err := securecomm.LoadOrCreateKeyPair(*keyfile)
+			if err != nil {
+				return nil, nil, err
+			}
+			cfg.KeyPair = kp
+		}
+		if *identity != "" {
+			id, err := loadIdentityFile(*identity)
+			if err != nil {
+				return nil, nil, err
+			}
+			cfg.Identity = id
+		}
+		if *agentKey != "" && client {
+			match := *agentKey
+			if match == "*" {
+				match = ""
+			}
+			signer, err := securecomm.AgentSigner(match)
+			if err != nil {
+				return nil, nil, err
+			}
+			cfg.Signer = signer
+		}
+		if *cert != "" {
+			c, err := securecomm.LoadCertificate(*cert)
+			if err != nil {
+				return nil, nil, err
+			}
+			cfg.Certificate = c
+		}
+		if *authorities != "" {
+			cas, err := securecomm.LoadAuthorities(*authorities)
+			if err != nil {
+				return nil, nil, err
+			}
+			cfg.CertificateAuthorities = cas
+		}
+		if *knownHosts != "" && client {
+			kh := securecomm.NewKnownHosts(*knownHosts)
+			kh.Replace = *replaceHostKey
+			if !*yes {
+				kh.Confirm = confirmHost
+			}
+			cfg.VerifyPeer = kh.Verify
+			cfg.VerifyRotation = kh.VerifyRotation
+		}
+		if *authorizedKeys != "" && !client {
+			ak, err := securecomm.LoadAuthorizedKeys(*authorizedKeys)
+			if err != nil {
+				return nil, nil, err
+			}
+			ak.Logger = slog.Default()
+			cfg.VerifyPeer = ak.Verify
+			return cfg, ak, nil
+		}
+		return cfg, nil, nil
+	}
+}
+
+// stringsFlag collects the values of a repeated flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+func (f *stringsFlag) reset() {
+	*f = nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// parseFlags parses args with fs, then sets the flags missing from args
+// from the TOML file given with -config, if any, then from the
+// environment. Flags take precedence over the file, which takes
+// precedence over the environment. The keys of the file are the names
+// of the flags:
+//
+//	l = 2000
+//	identity = "/etc/gcsp/identity"
+//	authorized-keys = "/etc/gcsp/authorized_keys"
+//	max-conns = 100
+//	idle-timeout = "5m"
+//	chat = true
+//
+// The environment variables are named after the flags too, see envName.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	if err := applySources(fs); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// applySources sets the flags of fs not set yet from the config file,
+// then from the environment.
+func applySources(fs *flag.FlagSet) error {
+	if f := fs.Lookup("config"); f != nil {
+		path := f.Value.String()
+		if path == "" {
+			path = os.Getenv(envName("config"))
+		}
+		if path != "" {
+			if err := applyConfigFile(fs, path); err != nil {
+				return err
+			}
+		}
+	}
+	return applyEnv(fs, os.LookupEnv)
+}
+
+// reloadFlags sets the flags of fs again from args, the config file and
+// the environment, picking up the changes of the file and of the
+// environment. The flags are left untouched when a value is invalid.
+func reloadFlags(fs *flag.FlagSet, args []string) error {
+	// The flags of fs can't be unset, so the sources are parsed with
+	// another flag set recording the values
+	fresh := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	fresh.SetOutput(io.Discard)
+	recorded := make(map[string]*recordedValue)
+	fs.VisitAll(func(f *flag.Flag) {
+		// The values are checked with a scratch value of the same type
+		scratch := reflect.New(reflect.TypeOf(f.Value).Elem()).Interface().(flag.Value)
+		v := &recordedValue{scratch: scratch}
+		recorded[f.Name] = v
+		fresh.Var(v, f.Name, f.Usage)
+	})
+	if err := fresh.Parse(args); err != nil {
+		return err
+	}
+	if err := applySources(fresh); err != nil {
+		return err
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if r, ok := f.Value.(interface{ reset() }); ok {
+			r.reset()
+		} else if e := f.Value.Set(f.DefValue); e != nil && err == nil {
+			err = e
+		}
+		for _, value := range recorded[f.Name].values {
+			if e := fs.Set(f.Name, value); e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+	return err
+}
+
+// recordedValue records the values given to a flag, see reloadFlags.
+type recordedValue struct {
+	scratch flag.Value
+	values  []string
+}
+
+func (v *recordedValue) String() string {
+	if len(v.values) == 0 {
+		return ""
+	}
+	return v.values[len(v.values)-1]
+}
+
+func (v *recordedValue) Set(s string) error {
+	if err := v.scratch.Set(s); err != nil {
+		return err
+	}
+	v.values = append(v.values, s)
+	return nil
+}
+
+func (v *recordedValue) IsBoolFlag() bool {
+	b, ok := v.scratch.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// envName returns the environment variable setting the flag name, such
+// as SECURE_IDLE_TIMEOUT for -idle-timeout and SECURE_LISTEN for -l.
+func envName(name string) string {
+	if name == "l" {
+		name = "listen"
+	}
+	return "SECURE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnv sets the flags of fs not set yet from the environment.
+func applyEnv(fs *flag.FlagSet, lookup func(string) (string, bool)) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := lookup(envName(f.Name))
+		if !ok || set[f.Name] || err != nil {
+			return
+		}
+		if e := fs.Set(f.Name, value); e != nil {
+			err = fmt.Errorf("$%s: %s", envName(f.Name), e)
+		}
+	})
+	return err
+}
+
+// applyConfigFile sets the flags of fs not set yet from the TOML file
+// found at path.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var values map[string]any
+	if err := toml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for name, value := range values {
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("%s: unknown option %q", path, name)
+		}
+		if set[name] {
+			continue
+		}
+		switch value.(type) {
+		case string, bool, int64, float64:
+		default:
+			return fmt.Errorf("%s: invalid value for %q", path, name)
+		}
+		if err := fs.Set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("%s: invalid value for %q: %s", path, name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gcsp.toml")
+	data := `
+l = 2000
+psk = "from file"
+pq = true
+idle-timeout = "5m"
+conn-rate = 2.5
+`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	port := fs.Int("l", 0, "")
+	rate := fs.Float64("conn-rate", 0, "")
+	idle := fs.Duration("idle-timeout", 0, "")
+	config := configFlags(fs)
+	parseFlags(fs, []string{"-config", path, "-psk", "from flag"})
+	cfg := config(false)
+
+	if *port != 2000 || *rate != 2.5 || *idle != 5*time.Minute || !cfg.PostQuantum {
+		t.Fatalf("Unexpected result. Got port %d, rate %g, idle timeout %s, pq %t", *port, *rate, *idle, cfg.PostQuantum)
+	}
+	// Flags override the file
+	if string(cfg.PSK) != "from flag" {
+		t.Fatalf("Unexpected result. Got PSK %q", cfg.PSK)
+	}
+
+	tData := []struct {
+		data     string
+		expected string
+	}{
+		{`unknown = 1`, "unknown option"},
+		{`l = "two thousand"`, "invalid value"},
+		{`l = [1, 2]`, "invalid value"},
+		{`l =`, path},
+	}
+	for _, exp := range tData {
+		os.WriteFile(path, []byte(exp.data), 0600)
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Int("l", 0, "")
+		fs.Parse(nil)
+		if err := applyConfigFile(fs, path); err == nil || !strings.Contains(err.Error(), exp.expected) {
+			t.Fatalf("Unexpected result for %q. Got %v, expected %q", exp.data, err, exp.expected)
+		}
+	}
+}
+
+func TestConfigEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gcsp.toml")
+	if err := os.WriteFile(path, []byte(`psk = "from file"`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"SECURE_LISTEN":       "2000",
+		"SECURE_PSK":          "from env",
+		"SECURE_PASSWORD":     "from env",
+		"SECURE_IDLE_TIMEOUT": "1m",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	port := fs.Int("l", 0, "")
+	idle := fs.Duration("idle-timeout", 0, "")
+	config := configFlags(fs)
+	fs.Parse([]string{"-config", path, "-idle-timeout", "2m"})
+	if err := applyConfigFile(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyEnv(fs, lookup); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config(false)
+
+	// Flags, then the file, then the environment
+	if *idle != 2*time.Minute || string(cfg.PSK) != "from file" || string(cfg.Password) != "from env" || *port != 2000 {
+		t.Fatalf("Unexpected result. Got idle timeout %s, PSK %q, password %q, port %d", *idle, cfg.PSK, cfg.Password, *port)
+	}
+
+	env["SECURE_LISTEN"] = "x"
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("l", 0, "")
+	if err := applyEnv(fs, lookup); err == nil || !strings.Contains(err.Error(), "SECURE_LISTEN") {
+		t.Fatalf("Unexpected result. Got %v", err)
+	}
+}
+
+func TestReloadFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gcsp.toml")
+	if err := os.WriteFile(path, []byte("psk = \"from file\"\nmax-conns = 10\nconn-rate = 2.5\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	maxConns := fs.Int("max-conns", 0, "")
+	rate := fs.Float64("conn-rate", 0, "")
+	var listens stringsFlag
+	fs.Var(&listens, "listen", "")
+	config := configFlags(fs)
+	args := []string{"-config", path, "-listen", "a:1", "-listen", "b:2", "-max-conns", "5"}
+	parseFlags(fs, args)
+
+	// The file changes, the flags keep precedence and the options
+	// removed from the file go back to their defaults
+	if err := os.WriteFile(path, []byte("psk = \"changed\"\nmax-conns = 20\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadFlags(fs, args); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config(false)
+	if string(cfg.PSK) != "changed" || *maxConns != 5 || *rate != 0 || listens.String() != "a:1,b:2" {
+		t.Fatalf("Unexpected result. Got PSK %q, max conns %d, rate %g, listen %s", cfg.PSK, *maxConns, *rate, listens.String())
+	}
+
+	// Invalid values leave the flags untouched
+	if err := os.WriteFile(path, []byte("psk = \"invalid\"\nconn-rate = \"fast\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadFlags(fs, args); err == nil {
+		t.Fatal("Unexpected result. Reloading an invalid file succeeded")
+	}
+	if cfg := config(false); string(cfg.PSK) != "changed" {
+		t.Fatalf("Unexpected result. Got PSK %q", cfg.PSK)
+	}
+}
+
+func TestKeyLogReload(t *testing.T) {
+	dir := t.TempDir()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	load := configLoader(fs)
+	parseFlags(fs, []string{"-keylog", filepath.Join(dir, "keys.log")})
+
+	// The key log is opened once, not on every reload
+	first, _, err := load(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _, err := load(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.KeyLog == nil || again.KeyLog != first.KeyLog {
+		t.Fatal("Unexpected result. The key log was opened again")
+	}
+
+	// A new path closes the previous file
+	fs.Set("keylog", filepath.Join(dir, "other.log"))
+	moved, _, err := load(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.KeyLog == first.KeyLog {
+		t.Fatal("Unexpected result. The key log was not moved")
+	}
+	if _, err := first.KeyLog.Write([]byte("x")); err == nil {
+		t.Fatal("Unexpected result. The previous key log is still open")
+	}
+	moved.KeyLog.(*os.File).Close()
+}
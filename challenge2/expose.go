@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+func exposeCommand(args []string) {
+	fs := flag.NewFlagSet("expose", flag.ExitOnError)
+	port := fs.Int("l", 0, "Server mode. Listen for secure connections on this port")
+	local := fs.String("local", "", "Client mode. Expose the service at this host:port")
+	remotePort := fs.Int("remote", 0, "Client mode. Ask the server to expose the service on this port, any if 0")
+	config := configFlags(fs)
+	parseFlags(fs, args)
+
+	switch {
+	case *port != 0:
+		inner, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg := config(false)
+		cfg.Logger = slog.Default()
+		log.Fatal(securecomm.ServeExpose(securecomm.NewListener(inner, cfg), cfg.Logger))
+	case *local != "" && fs.NArg() == 1:
+		cfg := config(true)
+		cfg.Logger = slog.Default()
+		ctrl, public, err := securecomm.RegisterService(fs.Arg(0), *remotePort, cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		host, _, _ := net.SplitHostPort(fs.Arg(0))
+		fmt.Fprintf(os.Stderr, "%s exposed at %s\n", *local, net.JoinHostPort(host, fmt.Sprint(public)))
+		log.Fatal(securecomm.RelayService(ctrl, fs.Arg(0), *local, cfg))
+	default:
+		log.Fatalf("Usage: %s expose [flags] -l <port>\n"+
+			"       %s expose [flags] -local <host:port> [-remote port] <server addr>", os.Args[0], os.Args[0])
+	}
+}
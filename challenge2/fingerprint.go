@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+// printFingerprints prints all the encodings of the fingerprint of
+// identity.
+func printFingerprints(f *os.File, identity ed25519.PublicKey) {
+	fmt.Fprintf(f, "Fingerprint: %s\n", securecomm.Fingerprint(identity))
+	fmt.Fprintf(f, "Hex:         %s\n", securecomm.FingerprintHex(identity))
+	fmt.Fprintf(f, "Emoji:       %s\n", securecomm.FingerprintEmoji(identity))
+}
+
+// confirmHost asks the user on the terminal whether to trust the
+// identity of a server we never connected to. It can be used as
+// securecomm.KnownHosts.Confirm. The terminal is opened directly, as the
+// standard input may carry the data to send.
+func confirmHost(addr string, identity ed25519.PublicKey) bool {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "No terminal to confirm the identity of %s, use -yes to trust it\n", addr)
+		return false
+	}
+	defer tty.Close()
+
+	fmt.Fprintf(tty, "The identity of %s is not known yet.\n", addr)
+	printFingerprints(tty, identity)
+	fmt.Fprint(tty, "Check it matches the one of the server, then type yes to trust it: ")
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	return strings.TrimSpace(strings.ToLower(answer)) == "yes"
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+func forwardCommand(args []string) {
+	fs := flag.NewFlagSet("forward", flag.ExitOnError)
+	port := fs.Int("l", 0, "Server mode. Listen for secure connections on this port")
+	target := fs.String("target", "", "Server mode. Forward the connections to this host:port")
+	localPort := fs.Int("L", 0, "Client mode. Listen for connections to forward on this local port")
+	config := configFlags(fs)
+	parseFlags(fs, args)
+
+	switch {
+	case *port != 0 && *target != "":
+		inner, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg := config(false)
+		cfg.Logger = slog.Default()
+		log.Fatal(securecomm.ForwardToTarget(securecomm.NewListener(inner, cfg), *target, cfg.Logger))
+	case *localPort != 0 && fs.NArg() == 1:
+		l, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", *localPort))
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg := config(true)
+		cfg.Logger = slog.Default()
+		log.Fatal(securecomm.ForwardToServer(l, fs.Arg(0), cfg))
+	default:
+		log.Fatalf("Usage: %s forward [flags] -l <port> -target <host:port>\n"+
+			"       %s forward [flags] -L <local port> <server addr>", os.Args[0], os.Args[0])
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+	"golang.org/x/term"
+)
+
+// passphraseEnv, if set, holds the passphrase of the key files instead
+// of asking for it, for automation.
+const passphraseEnv = "GCSP_PASSPHRASE"
+
+// readPassphrase returns the passphrase from the environment or the
+// terminal, asking for it twice if confirm is set.
+func readPassphrase(prompt string, confirm bool) ([]byte, error) {
+	if p := os.Getenv(passphraseEnv); p != "" {
+		return []byte(p), nil
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("no terminal to read the passphrase from, set $%s", passphraseEnv)
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	p, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if string(again) != string(p) {
+			return nil, errors.New("passphrases do not match")
+		}
+	}
+	return p, nil
+}
+
+// loadIdentityFile is LoadOrCreateIdentity, asking for the passphrase
+// of encrypted key files.
+func loadIdentityFile(path string) (ed25519.PrivateKey, error) {
+	identity, err := securecomm.LoadOrCreateIdentity(path)
+	if !errors.Is(err, securecomm.ErrPassphraseRequired) {
+		return identity, err
+	}
+	passphrase, err := readPassphrase("Passphrase for "+path+": ", false)
+	if err != nil {
+		return nil, err
+	}
+	return securecomm.LoadIdentityPassphrase(path, passphrase)
+}
+
+func genkeyCommand(args []string) {
+	fs := flag.NewFlagSet("genkey", flag.ExitOnError)
+	out := fs.String("out", "", "Write the identity key to this file")
+	encrypt := fs.Bool("passphrase", false, "Encrypt the key with a passphrase, read from the terminal or $"+passphraseEnv)
+	force := fs.Bool("force", false, "Overwrite an existing key file")
+	fs.Parse(args)
+	if *out == "" || fs.NArg() != 0 {
+		log.Fatalf("Usage: %s genkey -out <file> [-passphrase] [-force]", os.Args[0])
+	}
+	if _, err := os.Stat(*out); err == nil && !*force {
+		log.Fatalf("%s already exists, use -force to overwrite it", *out)
+	}
+
+	public, identity, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *encrypt {
+		passphrase, err := readPassphrase("Passphrase: ", true)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = securecomm.SaveIdentityPassphrase(*out, identity, passphrase)
+	} else {
+		err = securecomm.SaveIdentity(*out, identity)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Identity key written to %s\n", *out)
+	fmt.Printf("Public key:  %s\n", base64.StdEncoding.EncodeToString(public))
+	printFingerprints(os.Stdout, public)
+	fmt.Println()
+	fmt.Printf("Clients can trust it by adding this line to their known hosts file:\n")
+	fmt.Printf("<host:port> %s\n", securecomm.Fingerprint(public))
+}
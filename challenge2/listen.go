@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+// listenAddrs returns the addresses to listen on: those given with
+// -listen, then all the interfaces on port, if not zero. With loopback,
+// port is only bound on the loopback interfaces and the other addresses
+// must be loopback ones, for tunnels only meant for local clients. Named
+// pipes, which are local, are kept as is.
+func listenAddrs(port int, listens []string, loopback bool) ([]string, error) {
+	var addrs []string
+	for _, addr := range listens {
+		if securecomm.IsNamedPipe(addr) {
+			addrs = append(addrs, addr)
+			continue
+		}
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen address %q: %s", addr, err)
+		}
+		if loopback && !isLoopback(host) {
+			return nil, fmt.Errorf("listen address %q is not a loopback address", addr)
+		}
+		addrs = append(addrs, addr)
+	}
+	if port == 0 {
+		return addrs, nil
+	}
+	p := strconv.Itoa(port)
+	if !loopback {
+		return append(addrs, net.JoinHostPort("", p)), nil
+	}
+	addrs = append(addrs, net.JoinHostPort("127.0.0.1", p))
+	if hasIPv6Loopback() {
+		addrs = append(addrs, net.JoinHostPort("::1", p))
+	}
+	return addrs, nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// hasIPv6Loopback tells whether ::1 can be bound, as IPv6 may be
+// disabled.
+func hasIPv6Loopback() bool {
+	l, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// listenAll listens on all the addresses, returning a listener accepting
+// the connections of all of them.
+func listenAll(addrs []string) (net.Listener, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address to listen on")
+	}
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		var l net.Listener
+		var err error
+		if securecomm.IsNamedPipe(addr) {
+			l, err = securecomm.ListenNamedPipe(addr)
+		} else {
+			l, err = net.Listen("tcp", addr)
+		}
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	if len(listeners) == 1 {
+		return listeners[0], nil
+	}
+	return newMultiListener(listeners), nil
+}
+
+// multiListener accepts the connections of several listeners.
+type multiListener struct {
+	listeners []net.Listener
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+	errOnce   sync.Once
+	err       error // set before done is closed
+}
+
+func newMultiListener(listeners []net.Listener) *multiListener {
+	ml := &multiListener{
+		listeners: listeners,
+		conns:     make(chan net.Conn),
+		done:      make(chan struct{}),
+	}
+	for _, l := range listeners {
+		go ml.serve(l)
+	}
+	return ml
+}
+
+func (ml *multiListener) serve(l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			// One failing listener stops them all, like a single one
+			ml.errOnce.Do(func() {
+				ml.err = err
+				close(ml.done)
+			})
+			ml.Close()
+			return
+		}
+		select {
+		case ml.conns <- c:
+		case <-ml.done:
+			c.Close()
+			return
+		}
+	}
+}
+
+// Accept waits for the next connection of any of the listeners.
+func (ml *multiListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-ml.conns:
+		return c, nil
+	case <-ml.done:
+		return nil, ml.err
+	}
+}
+
+// Close closes all the listeners.
+func (ml *multiListener) Close() error {
+	ml.closeOnce.Do(func() {
+		for _, l := range ml.listeners {
+			l.Close()
+		}
+	})
+	return nil
+}
+
+// Addr returns the address of the first listener.
+func (ml *multiListener) Addr() net.Addr {
+	return ml.listeners[0].Addr()
+}
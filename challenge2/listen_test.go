@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+func TestListenAddrs(t *testing.T) {
+	tData := []struct {
+		name     string
+		port     int
+		listens  []string
+		loopback bool
+		expected []string
+		ok       bool
+	}{
+		{"port", 2000, nil, false, []string{":2000"}, true},
+		{"addresses", 0, []string{"[::1]:2000", "192.0.2.1:2001"}, false, []string{"[::1]:2000", "192.0.2.1:2001"}, true},
+		{"missing port", 0, []string{"::1"}, false, nil, false},
+		{"loopback addresses", 0, []string{"localhost:2000", "[::1]:2001"}, true, []string{"localhost:2000", "[::1]:2001"}, true},
+		{"not loopback", 0, []string{"192.0.2.1:2000"}, true, nil, false},
+		{"named pipe", 0, []string{`\\.\pipe\secure`}, true, []string{`\\.\pipe\secure`}, true},
+	}
+	for _, exp := range tData {
+		got, err := listenAddrs(exp.port, exp.listens, exp.loopback)
+		if (err == nil) != exp.ok {
+			t.Fatalf("Unexpected result for %s: %v", exp.name, err)
+		}
+		if exp.ok && !reflect.DeepEqual(got, exp.expected) {
+			t.Fatalf("Unexpected result for %s. Got %v, expected %v", exp.name, got, exp.expected)
+		}
+	}
+
+	// Loopback ports are bound on 127.0.0.1, and ::1 when available
+	got, err := listenAddrs(2000, nil, true)
+	if err != nil || got[0] != "127.0.0.1:2000" {
+		t.Fatalf("Unexpected result. Got %v (%v)", got, err)
+	}
+}
+
+func TestListenAll(t *testing.T) {
+	l, err := listenAll([]string{"127.0.0.1:0", "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &securecomm.Server{}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(l)
+	}()
+
+	// Both listeners are served
+	for _, inner := range l.(*multiListener).listeners {
+		conn, err := securecomm.Dial(inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("x"))
+		if _, err := conn.Read(make([]byte, 1)); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+
+	s.Close()
+	if err := <-served; err != securecomm.ErrServerClosed {
+		t.Fatalf("Unexpected result. Expected %v, got %v", securecomm.ErrServerClosed, err)
+	}
+	for _, inner := range l.(*multiListener).listeners {
+		if _, err := net.Dial("tcp", inner.Addr().String()); err == nil {
+			t.Fatal("Unexpected result. A listener is still open.")
+		}
+	}
+}
@@ -1,230 +1,314 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/binary"
-	"errors"
+	"context"
+	"expvar"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"math"
-	"net"
+	"log/slog"
 	"os"
+	"time"
 
-	"golang.org/x/crypto/nacl/box"
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+	"golang.org/x/term"
 )
 
-// SecureReader container to the io.Reader interface
-type SecureReader struct {
-	r   io.Reader
-	buf []byte
-	key *[32]byte
-}
-
-// SecureWriter container to the io.Writer interface
-type SecureWriter struct {
-	w   io.Writer
-	key *[32]byte
-}
-
-// NewSecureReader instantiates a new SecureReader
-func NewSecureReader(r io.Reader, priv, pub *[32]byte) io.Reader {
-	sr := &SecureReader{r: r, key: &[32]byte{}}
-	box.Precompute(sr.key, pub, priv)
-	return sr
-}
-
-// NewSecureWriter instantiates a new SecureWriter
-func NewSecureWriter(w io.Writer, priv, pub *[32]byte) io.Writer {
-	sw := &SecureWriter{w: w, key: &[32]byte{}}
-	box.Precompute(sw.key, pub, priv)
-	return sw
-}
-
-func (sr *SecureReader) Read(p []byte) (int, error) {
-	var msgSize uint16
-	nonce := &[24]byte{}
-
-	err := binary.Read(sr.r, binary.BigEndian, &msgSize)
-	if err != nil {
-		return 0, fmt.Errorf("error reading message size: %s", err)
-	}
-
-	err = binary.Read(sr.r, binary.BigEndian, nonce)
-	if err != nil {
-		return 0, fmt.Errorf("error reading nonce: %s", err)
-	}
-
-	msg := make([]byte, msgSize)
-	_, err = io.ReadFull(sr.r, msg)
-	if err != nil {
-		return 0, fmt.Errorf("erro reading encrypted message: %s", err)
-	}
-
-	decryptedMsg, ok := box.OpenAfterPrecomputation(nil, msg, nonce, sr.key)
-	if !ok {
-		err = errors.New("could not decrypt box")
-		return 0, err
-	}
-	copy(p, decryptedMsg[:])
-	sr.r.Read(p)
-
-	return len(decryptedMsg), nil
-}
-
-func (sw *SecureWriter) Write(p []byte) (int, error) {
-	// Message size is the length of the message plus box overhead
-	msgSize := uint16(len(p) + box.Overhead)
-	if err := binary.Write(sw.w, binary.BigEndian, msgSize); err != nil {
-		return 0, err
-	}
-
-	var nonce [24]byte
-	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
-		return 0, err
-	}
-	if err := binary.Write(sw.w, binary.BigEndian, nonce[:]); err != nil {
-		return 0, err
-	}
-
-	encryptedMsg := box.SealAfterPrecomputation(nil, p, &nonce, sw.key)
-	n, err := sw.w.Write(encryptedMsg)
-	if n > box.Overhead {
-		n = n - box.Overhead
-	}
-
-	return n, err
-}
-
-// Conn representation of the ReaderWriterCloser interface
-type Conn struct {
-	io.Reader
-	io.Writer
-	conn net.Conn
-}
-
-// Close the underlying connection
-func (c *Conn) Close() error {
-	return c.conn.Close()
-}
-
-// NewConnection return a connection to the server
-// and an interface to retrieve a public/private key pair
-// Most of this code was based on the examples
-// here: https://godoc.org/golang.org/x/crypto/nacl/box
-func NewConnection(c net.Conn) (*Conn, error) {
-	// Read the public key from the server
-	serverPubKey := &[32]byte{}
-	if _, err := io.ReadFull(c, serverPubKey[:]); err != nil {
-		return &Conn{}, fmt.Errorf("error reading public key from server: %s", err)
-	}
-	// Generate a public/private key pair
-	senderPubKey, senderPrivateKey, err := box.GenerateKey(rand.Reader)
-	if err != nil {
-		return &Conn{}, fmt.Errorf("error on generating key: %s", err)
-	}
-	// We need to write the sender public key in the connection
-	// because it will be used to perform the handshake
-	if _, err := c.Write(senderPubKey[:]); err != nil {
-		return &Conn{}, fmt.Errorf("error on writing public key: %s", err)
-	}
-	conn := &Conn{
-		NewSecureReader(c, senderPrivateKey, serverPubKey),
-		NewSecureWriter(c, senderPrivateKey, serverPubKey),
-		c,
-	}
-	return conn, nil
-}
-
-// Dial generates a private/public key pair,
-// connects to the server, perform the handshake
-// and return a reader/writer.
-func Dial(addr string) (io.ReadWriteCloser, error) {
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to dial %s", addr)
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "send":
+			sendCommand(os.Args[2:])
+			return
+		case "recv":
+			recvCommand(os.Args[2:])
+			return
+		case "forward":
+			forwardCommand(os.Args[2:])
+			return
+		case "expose":
+			exposeCommand(os.Args[2:])
+			return
+		case "genkey":
+			genkeyCommand(os.Args[2:])
+			return
+		case "cert":
+			certCommand(os.Args[2:])
+			return
+		case "encrypt":
+			encryptCommand(os.Args[2:])
+			return
+		case "decrypt":
+			decryptCommand(os.Args[2:])
+			return
+		case "discover":
+			discoverCommand(os.Args[2:])
+			return
+		case "punch":
+			punchCommand(os.Args[2:])
+			return
+		case "introducer":
+			introducerCommand(os.Args[2:])
+			return
+		case "join":
+			joinCommand(os.Args[2:])
+			return
+		case "replay":
+			replayCommand(os.Args[2:])
+			return
+		case "bench":
+			benchCommand(os.Args[2:])
+			return
+		case "tui":
+			tuiCommand(os.Args[2:])
+			return
+		case "serial":
+			serialCommand(os.Args[2:])
+			return
+		}
 	}
-	return NewConnection(conn)
-}
 
-// Serve starts a secure echo server on the given listener.
-func Serve(l net.Listener) error {
-	for {
-		conn, err := l.Accept()
-		if err != nil {
-			return err
-		}
+	port := flag.Int("l", 0, "Listen mode. Specify port")
+	var listens stringsFlag
+	flag.Var(&listens, "listen", "Listen mode. Listen on this host:port, such as [::1]:2000, or Windows named pipe, such as \\\\.\\pipe\\secure, can be repeated")
+	loopback := flag.Bool("loopback", false, "Listen mode. Only listen on the loopback interfaces")
+	loadConfig := configLoader(flag.CommandLine)
+	metricsAddr := flag.String("metrics", "", "Listen mode. Serve Prometheus metrics on this address, at /metrics")
+	expvarAddr := flag.String("expvar", "", "Listen mode. Serve the expvar statistics on this loopback address, at /debug/vars")
+	debugAddr := flag.String("debug-addr", "", "Serve the pprof profiles on this loopback address, at /debug/pprof/")
+	auditPath := flag.String("audit", "", "Listen mode. Append the connections and disconnections, as JSON lines, to this file")
+	pipeMode := flag.Bool("pipe", false, "Listen mode. Connect the standard input and output to the first client, like netcat")
+	execCmd := flag.String("exec", "", "Listen mode. Run this shell command for each client, connected to its standard input and output")
+	pubsub := flag.Bool("pubsub", false, "Listen mode. Run a pub/sub hub, with the SUB, UNSUB and PUB commands")
+	chat := flag.Bool("chat", false, "Listen mode. Run a chat room, relaying the lines of each client to the others")
+	relay := flag.Bool("relay", false, "Listen mode. Run a relay, splicing the connections of the pairs of clients joining the same room")
+	discard := flag.Bool("discard", false, "Listen mode. Discard what the clients send instead of echoing it, for the stream mode of bench")
+	maxConns := flag.Int("max-conns", 0, "Listen mode. Limit the number of concurrent connections")
+	maxHandshakes := flag.Int("max-handshakes", 0, "Listen mode. Limit the number of handshakes in progress")
+	var allow, deny stringsFlag
+	flag.Var(&allow, "allow", "Listen mode. Only accept the clients in these comma-separated CIDR ranges, can be repeated, reloaded on SIGHUP")
+	flag.Var(&deny, "deny", "Listen mode. Reject the clients in these comma-separated CIDR ranges, can be repeated, reloaded on SIGHUP")
+	connRate := flag.Float64("conn-rate", 0, "Listen mode. Limit the new connections per second from each IP address")
+	connBurst := flag.Int("conn-burst", 1, "Listen mode. Allow bursts of this many connections above -conn-rate")
+	banThreshold := flag.Int("ban-threshold", 0, "Listen mode. Ban the IP addresses failing this many handshakes or frame authentications within -ban-window")
+	banWindow := flag.Duration("ban-window", time.Minute, "Listen mode. Window counting the failures for -ban-threshold")
+	banDuration := flag.Duration("ban-duration", 10*time.Minute, "Listen mode. Reject the connections of the banned addresses for this long")
+	puzzleRate := flag.Float64("puzzle-rate", 0, "Listen mode. Make the clients solve a proof-of-work puzzle above this many new connections per second")
+	puzzleDifficulty := flag.Int("puzzle-difficulty", 0, "Listen mode. Difficulty of the puzzles of -puzzle-rate, in bits, 16 if zero")
+	var proxyProtocol stringsFlag
+	flag.Var(&proxyProtocol, "proxy-protocol", "Listen mode. Read the client address from the PROXY protocol header sent by the load balancers in these comma-separated CIDR ranges, rejecting the other connections")
+	grace := flag.Duration("grace", 30*time.Second, "Listen mode. On SIGINT or SIGTERM, wait this long for the connections to end before closing them")
+	identityGrace := flag.Duration("identity-grace", 24*time.Hour, "Listen mode. When the identity changes on SIGHUP, present it endorsed by the previous one for this long")
+	idleTimeout := flag.Duration("idle-timeout", 0, "Listen mode. Close the connections of the clients sending nothing for this long")
+	advertiseServer := flag.Bool("advertise", false, "Listen mode. Advertise the server on the local network with mDNS, under the host name")
+	history := flag.String("history", defaultHistoryPath(), "Interactive client. Save the lines entered to this file, empty to keep no history")
+	var failovers stringsFlag
+	flag.Var(&failovers, "failover", "Client. Fail over to this host:port when the connection is lost, in order, can be repeated")
+	parseFlags(flag.CommandLine, os.Args[1:])
+	if *debugAddr != "" {
 		go func() {
-			if err := handleRequest(conn); err != nil {
-				log.Printf("error handling request from %s: %s\n", l.Addr().String(), err)
-			}
+			log.Fatal(securecomm.ServeDebug(*debugAddr))
 		}()
 	}
-}
-
-func handleRequest(c net.Conn) error {
-	// Generate a public/private key pair
-	recipientPublicKey, recipientPrivateKey, err := box.GenerateKey(rand.Reader)
+	listening := *port != 0 || len(listens) > 0
+	cfg, authorizedKeys, err := loadConfig(!listening)
 	if err != nil {
-		return fmt.Errorf("error generating key: %s", err)
-	}
-	if _, err := c.Write(recipientPublicKey[:]); err != nil {
-		return fmt.Errorf("error writing the public key: %s", err)
-	}
-	cliPubKey := &[32]byte{}
-	if _, err := io.ReadFull(c, cliPubKey[:]); err != nil {
-		return fmt.Errorf("error on reading public key from client: %s", err)
+		log.Fatal(err)
 	}
-	sr := NewSecureReader(c, recipientPrivateKey, cliPubKey)
-	sw := NewSecureWriter(c, recipientPrivateKey, cliPubKey)
-	buf := make([]byte, int64(math.Pow(2, 16)-1))
 
-	for {
-		rBytes, err := sr.Read(buf)
+	// Server mode
+	if listening {
+		addrs, err := listenAddrs(*port, listens, *loopback)
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return fmt.Errorf("error reading message from client: %s", err)
+			log.Fatal(err)
 		}
-		log.Printf("%d bytes read\n", rBytes)
-		wBytes, err := sw.Write(buf[:rBytes])
+		l, err := listenAll(addrs)
 		if err != nil {
-			return fmt.Errorf("error writing back to client: %s", err)
+			log.Fatal(err)
 		}
-		log.Printf("%d bytes written\n", wBytes)
-	}
-	return nil
-}
-
-func main() {
-	port := flag.Int("l", 0, "Listen mode. Specify port")
-	flag.Parse()
-
-	// Server mode
-	if *port != 0 {
-		l, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+		defer l.Close()
+		if len(proxyProtocol) > 0 {
+			trusted, err := securecomm.ParseIPFilter(proxyProtocol, nil)
+			if err != nil {
+				log.Fatal(err)
+			}
+			l = securecomm.NewProxyProtocolListener(l, trusted.Allow, slog.Default())
+		}
+		stop := func() {}
+		if *advertiseServer {
+			stop = advertise(l, cfg)
+		}
+		defer stop()
+		if *pipeMode || *execCmd != "" {
+			if len(allow) > 0 || len(deny) > 0 {
+				log.Fatal("-allow and -deny can't be used with -pipe or -exec")
+			}
+			if authorizedKeys != nil {
+				reloadOnSIGHUP(authorizedKeys, authorizedKeys.Logger)
+			}
+			sl := securecomm.NewListener(l, cfg)
+			for {
+				conn, err := sl.Accept()
+				if err != nil {
+					log.Fatal(err)
+				}
+				if *pipeMode {
+					if err := pipe(conn, os.Stdin, os.Stdout); err != nil {
+						log.Fatal(err)
+					}
+					return
+				}
+				go func() {
+					if err := execPipe(conn, *execCmd); err != nil {
+						log.Printf("%s: %s\n", *execCmd, err)
+					}
+				}()
+			}
+		}
+		cfg.Logger = slog.Default()
+		filter, err := securecomm.ParseIPFilter(allow, deny)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer l.Close()
-		log.Fatal(Serve(l))
+		s := &securecomm.Server{
+			Config:           cfg,
+			Filter:           filter,
+			MaxConns:         *maxConns,
+			MaxHandshakes:    *maxHandshakes,
+			ConnRate:         *connRate,
+			ConnBurst:        *connBurst,
+			BanThreshold:     *banThreshold,
+			BanWindow:        *banWindow,
+			BanDuration:      *banDuration,
+			PuzzleRate:       *puzzleRate,
+			PuzzleDifficulty: *puzzleDifficulty,
+			IdleTimeout:      *idleTimeout,
+		}
+		switch {
+		case *pubsub:
+			s.Handler = &securecomm.Hub{Logger: cfg.Logger}
+		case *chat:
+			s.Handler = &securecomm.ChatRoom{Logger: cfg.Logger}
+		case *relay:
+			s.Handler = &securecomm.Relay{Logger: cfg.Logger}
+		case *discard:
+			s.Handler = securecomm.DiscardHandler
+		}
+		if *auditPath != "" {
+			f, err := os.OpenFile(*auditPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			s.Audit = securecomm.NewAuditLog(f)
+		}
+		if *metricsAddr != "" {
+			s.Collector = securecomm.NewCollector()
+			go func() {
+				log.Fatal(securecomm.ServeMetrics(*metricsAddr, s.Collector))
+			}()
+		}
+		if *expvarAddr != "" {
+			s.Stats = securecomm.NewStats()
+			expvar.Publish("secure", s.Stats)
+			go func() {
+				log.Fatal(securecomm.ServeStats(*expvarAddr))
+			}()
+		}
+		// On SIGHUP, the config file, the keys and the limits are
+		// loaded again for the new connections. Changing how and what
+		// the server serves needs a restart.
+		reload := func() error {
+			if err := reloadFlags(flag.CommandLine, os.Args[1:]); err != nil {
+				return err
+			}
+			cfg, _, err := loadConfig(false)
+			if err != nil {
+				return err
+			}
+			cfg.Logger = slog.Default()
+			filter, err := securecomm.ParseIPFilter(allow, deny)
+			if err != nil {
+				return err
+			}
+			s.Reload(&securecomm.Server{
+				Config:           cfg,
+				Filter:           filter,
+				MaxConns:         *maxConns,
+				MaxHandshakes:    *maxHandshakes,
+				ConnRate:         *connRate,
+				ConnBurst:        *connBurst,
+				BanThreshold:     *banThreshold,
+				BanWindow:        *banWindow,
+				BanDuration:      *banDuration,
+				PuzzleRate:       *puzzleRate,
+				PuzzleDifficulty: *puzzleDifficulty,
+				IdleTimeout:      *idleTimeout,
+			}, *identityGrace)
+			return nil
+		}
+		code := serveUntilSignal(s, l, *grace, reload)
+		stop()
+		os.Exit(code)
 	}
 
-	// Client mode
-	if len(os.Args) != 3 {
-		log.Fatalf("Usage: %s <port> <message>", os.Args[0])
+	// Client mode, sending the message or, without message, the lines
+	// entered interactively or the standard input
+	if flag.NArg() != 1 && flag.NArg() != 2 {
+		log.Fatalf("Usage: %s [-keyfile file] [-identity file] [-knownhosts file] [-psk key] [-password password] [-history file] [-failover host:port]... <port> | <service> | <named pipe> [message]\n"+
+			"       %s tui [flags] <port> | <service> | <named pipe>\n"+
+			"       %s [flags] -l <port> | -listen <host:port>... [-loopback]\n"+
+			"       %s send [flags] [-relay <host:port>] <file> <addr> | <advertised name> | <room>\n"+
+			"       %s recv [flags] -l <port> [-advertise] | -relay <host:port> -room <room> [-o dir]\n"+
+			"       %s discover [-timeout duration]\n"+
+			"       %s punch [flags] -introducer <host:port> [-stun <host:port>] [-reliable] [-send file | -recv dir] <code>\n"+
+			"       %s introducer -l <port>\n"+
+			"       %s join [flags] -relay <host:port> <room>\n"+
+			"       %s serial [flags] [-baud 115200] [-accept] <port>\n"+
+			"       %s replay [-sent] <recording>\n"+
+			"       %s bench [flags] -target <host:port> [-size 64K] [-duration 10s] [-mode echo|stream]\n"+
+			"       %s forward [flags] -l <port> -target <host:port> | -L <local port> <server addr>\n"+
+			"       %s expose [flags] -l <port> | -local <host:port> <server addr>\n"+
+			"       %s genkey -out <file> [-passphrase]\n"+
+			"       %s cert -ca <file> -out <file> [-name name] <public key>\n"+
+			"       %s encrypt [-r recipient]... [-keyfile file] [-o file] [file]\n"+
+			"       %s decrypt -keyfile file [-o file] [file]", os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+	}
+	addr := "localhost:" + flag.Arg(0)
+	if securecomm.IsServiceName(flag.Arg(0)) || securecomm.IsNamedPipe(flag.Arg(0)) {
+		addr = flag.Arg(0)
+	}
+	var conn io.ReadWriteCloser
+	if len(failovers) > 0 {
+		f := &securecomm.Failover{Addrs: append([]string{addr}, failovers...), Config: cfg}
+		conn, err = f.Dial(context.Background())
+	} else {
+		conn, err = securecomm.DialConfig(addr, cfg)
 	}
-	conn, err := Dial("localhost:" + os.Args[1])
 	if err != nil {
 		log.Fatal(err)
 	}
-	if _, err := conn.Write([]byte(os.Args[2])); err != nil {
+	if flag.NArg() == 1 {
+		if term.IsTerminal(int(os.Stdin.Fd())) {
+			if err := runREPL(conn, *history); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+		if len(failovers) > 0 {
+			log.Fatal("-failover needs the interactive client or a message")
+		}
+		if err := pipe(conn.(*securecomm.Conn), os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if _, err := conn.Write([]byte(flag.Arg(1))); err != nil {
 		log.Fatal(err)
 	}
-	buf := make([]byte, len(os.Args[2]))
-	n, err := conn.Read(buf)
+	buf := make([]byte, len(flag.Arg(1)))
+	n, err := io.ReadFull(conn, buf)
 	if err != nil && err != io.EOF {
 		log.Fatal(err)
 	}
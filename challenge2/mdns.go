@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+// advertise advertises the server listening on l under the host name,
+// exiting on errors. The identity is only advertised when configured,
+// since otherwise each connection uses a new one.
+func advertise(l net.Listener, cfg *securecomm.Config) (stop func()) {
+	name, err := os.Hostname()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var identity ed25519.PublicKey
+	switch {
+	case cfg.Identity != nil:
+		identity = cfg.Identity.Public().(ed25519.PublicKey)
+	case cfg.Signer != nil:
+		identity, _ = cfg.Signer.Public().(ed25519.PublicKey)
+	}
+	addr, ok := l.Addr().(*net.TCPAddr)
+	if !ok {
+		log.Fatal("-advertise needs a TCP address to listen on")
+	}
+	stop, err = securecomm.Advertise(name, addr.Port, identity)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return stop
+}
+
+// resolveAdvertised returns the address of the server advertised under
+// addr when it is a name rather than a host:port, a service name or a
+// named pipe.
+func resolveAdvertised(addr string) (string, error) {
+	if _, _, err := net.SplitHostPort(addr); err == nil || securecomm.IsServiceName(addr) || securecomm.IsNamedPipe(addr) {
+		return addr, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return securecomm.LookupAdvertised(ctx, addr)
+}
+
+func discoverCommand(args []string) {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 2*time.Second, "Browse the local network for this long")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		log.Fatalf("Usage: %s discover [-timeout duration]", os.Args[0])
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	found, err := securecomm.Discover(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, a := range found {
+		fmt.Printf("%s\t%s\t%s\n", a.Name, strings.Join(a.Addrs, ","), a.Fingerprint)
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestResolveAdvertisedAddr(t *testing.T) {
+	for _, addr := range []string{"example.com:2000", "[::1]:2000", "_secure._tcp.example.com"} {
+		got, err := resolveAdvertised(addr)
+		if err != nil || got != addr {
+			t.Errorf("resolveAdvertised(%q) = %q, %v", addr, got, err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"os/exec"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+// pipe copies r to conn and conn to w, like netcat. Once r is done, the
+// writing side of conn is closed so the peer reads io.EOF. It returns
+// when the peer closes the connection, without waiting for r.
+func pipe(conn net.Conn, r io.Reader, w io.Writer) error {
+	go func() {
+		io.Copy(conn, r)
+		securecomm.CloseWrite(conn)
+	}()
+	_, err := io.Copy(w, conn)
+	return err
+}
+
+// execPipe runs command with its standard input and output connected to
+// conn, closing conn once the command exits.
+func execPipe(conn net.Conn, command string) error {
+	defer conn.Close()
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = conn
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		io.Copy(stdin, conn)
+		stdin.Close()
+	}()
+	return cmd.Wait()
+}
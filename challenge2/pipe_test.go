@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+func TestPipe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &securecomm.Server{}
+	go s.Serve(l)
+	defer s.Close()
+
+	conn, err := securecomm.DialConfig(l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The echo server closes the connection once the input is done
+	var out bytes.Buffer
+	input := strings.Repeat("hello world\n", 10000)
+	if err := pipe(conn.(*securecomm.Conn), strings.NewReader(input), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != input {
+		t.Fatalf("Unexpected result: got %d bytes, expected %d", out.Len(), len(input))
+	}
+}
+
+func TestExecPipe(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell")
+	}
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := securecomm.NewListener(inner, nil)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		execPipe(conn, "tr a-z A-Z")
+	}()
+
+	conn, err := securecomm.DialConfig(l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var out bytes.Buffer
+	if err := pipe(conn.(*securecomm.Conn), strings.NewReader("hello world\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "HELLO WORLD\n" {
+		t.Fatalf("Unexpected result.\nGot:\t\t%s\nExpected:\t%s\n", got, "HELLO WORLD\n")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+func introducerCommand(args []string) {
+	fs := flag.NewFlagSet("introducer", flag.ExitOnError)
+	port := fs.Int("l", 0, "Listen on this UDP port")
+	parseFlags(fs, args)
+	if *port == 0 {
+		log.Fatalf("Usage: %s introducer -l <port>", os.Args[0])
+	}
+	pc, err := net.ListenPacket("udp", fmt.Sprintf(":%d", *port))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(securecomm.ServeIntroducer(pc))
+}
+
+func punchCommand(args []string) {
+	fs := flag.NewFlagSet("punch", flag.ExitOnError)
+	introducer := fs.String("introducer", "", "Address of the introducer, host:port")
+	stun := fs.String("stun", "", "Discover the public address with this STUN server, host:port")
+	reliable := fs.Bool("reliable", false, "Run a reliable stream over the datagrams, retransmitting the lost ones")
+	send := fs.String("send", "", "Send this file to the peer, over a reliable stream")
+	recv := fs.String("recv", "", "Receive a file from the peer into this directory, over a reliable stream")
+	config := configFlags(fs)
+	parseFlags(fs, args)
+	if *introducer == "" || fs.NArg() != 1 || (*send != "" && *recv != "") {
+		log.Fatalf("Usage: %s punch [flags] -introducer <host:port> [-stun <host:port>] [-reliable] [-send file | -recv dir] <code>", os.Args[0])
+	}
+	p := &securecomm.Puncher{Introducer: *introducer, STUN: *stun, Config: config(true)}
+	dc, err := p.Punch(context.Background(), fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("connected to %s (%s)\n", dc.RemoteAddr(), securecomm.Fingerprint(dc.PeerIdentity()))
+
+	var conn net.Conn = dc
+	if *reliable || *send != "" || *recv != "" {
+		conn = securecomm.NewReliableConn(dc)
+	}
+	defer conn.Close()
+	switch {
+	case *send != "":
+		err = securecomm.SendFile(conn, *send, os.Stderr)
+	case *recv != "":
+		var path string
+		if path, err = securecomm.ReceiveFile(conn, *recv, os.Stderr); err == nil {
+			log.Printf("received %s\n", path)
+		}
+	default:
+		err = pipe(conn, os.Stdin, os.Stdout)
+	}
+	if errors.Is(err, securecomm.ErrBadHash) {
+		log.Print(err)
+		os.Exit(exitBadHash)
+	}
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+func replayCommand(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	sent := fs.Bool("sent", false, "Write the data sent by the recording side instead of the data it received")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalf("Usage: %s replay [-sent] <recording>", os.Args[0])
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	rp, err := securecomm.ReadReplay(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sr := rp.ReceivedReader()
+	if *sent {
+		sr = rp.SentReader()
+	}
+	if _, err := io.Copy(os.Stdout, sr); err != nil {
+		log.Fatal(fmt.Errorf("error replaying %s: %w", fs.Arg(0), err))
+	}
+}
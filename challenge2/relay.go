@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+func joinCommand(args []string) {
+	fs := flag.NewFlagSet("join", flag.ExitOnError)
+	relay := fs.String("relay", "", "Address of the relay, host:port")
+	config := configFlags(fs)
+	parseFlags(fs, args)
+	if *relay == "" || fs.NArg() != 1 {
+		log.Fatalf("Usage: %s join [flags] -relay <host:port> <room>", os.Args[0])
+	}
+	cfg := config(true)
+	conn, err := securecomm.DialRelay(context.Background(), *relay, fs.Arg(0), securecomm.RelayConfig(cfg), cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("joined %s (%s)\n", fs.Arg(0), securecomm.Fingerprint(conn.PeerIdentity()))
+	if err := pipe(conn, os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// maxHistory bounds the lines kept in the history of the interactive
+// client.
+const maxHistory = 1000
+
+// replPrompt prompts for the lines of the interactive client
+const replPrompt = "> "
+
+// repl runs the interactive client over the terminal tty: each line
+// entered, edited with the usual keys and recalled from history, is sent
+// to conn, and what conn sends is printed above the prompt. It returns
+// once the user presses Ctrl-D or the server closes the connection.
+func repl(conn io.ReadWriter, tty io.ReadWriter, history term.History) error {
+	t := term.NewTerminal(tty, replPrompt)
+	if history != nil {
+		t.History = history
+	}
+	received := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(t, conn)
+		if err == nil {
+			fmt.Fprintln(t, "connection closed by the server")
+		}
+		received <- err
+	}()
+
+	lines := make(chan string)
+	done := make(chan error, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			line, err := t.ReadLine()
+			if err != nil {
+				done <- err
+				return
+			}
+			select {
+			case lines <- line:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case err := <-received:
+			return err
+		case err := <-done:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case line := <-lines:
+			if _, err := io.WriteString(conn, line+"\n"); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// runREPL runs repl on the terminal of the standard input, in raw mode,
+// with the history saved to historyPath if not empty.
+func runREPL(conn io.ReadWriter, historyPath string) error {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+
+	var history term.History
+	if historyPath != "" {
+		history = loadHistory(historyPath)
+	}
+	tty := struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}
+	return repl(conn, tty, history)
+}
+
+// defaultHistoryPath returns the history file of the interactive
+// client, in the home directory.
+func defaultHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".secure_history")
+}
+
+// fileHistory is the history of the interactive client, saved to a
+// file so it's kept across sessions.
+type fileHistory struct {
+	path    string
+	entries []string // oldest first
+}
+
+// loadHistory loads the history saved at path, if any.
+func loadHistory(path string) *fileHistory {
+	h := &fileHistory{path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		return h
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+	if len(h.entries) > maxHistory {
+		h.entries = h.entries[len(h.entries)-maxHistory:]
+	}
+	return h
+}
+
+// Add adds a line to the history and appends it to the file, ignoring
+// the errors: the history is a convenience.
+func (h *fileHistory) Add(entry string) {
+	if strings.TrimSpace(entry) == "" || strings.Contains(entry, "\n") {
+		return
+	}
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > maxHistory {
+		h.entries = h.entries[1:]
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(f, entry)
+	f.Close()
+}
+
+func (h *fileHistory) Len() int {
+	return len(h.entries)
+}
+
+// At returns the entry idx, 0 being the most recent one.
+func (h *fileHistory) At(idx int) string {
+	return h.entries[len(h.entries)-1-idx]
+}
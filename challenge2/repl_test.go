@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+// fakeTerminal is the terminal of repl in tests, typing the keys written
+// to keys and recording the output.
+type fakeTerminal struct {
+	keys *io.PipeReader
+	mu   sync.Mutex
+	out  bytes.Buffer
+}
+
+func (t *fakeTerminal) Read(p []byte) (int, error) {
+	return t.keys.Read(p)
+}
+
+func (t *fakeTerminal) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.out.Write(p)
+}
+
+// waitOutput waits for the terminal to print s.
+func (t *fakeTerminal) waitOutput(tb testing.TB, s string) {
+	tb.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		t.mu.Lock()
+		found := strings.Contains(t.out.String(), s)
+		t.mu.Unlock()
+		if found {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	tb.Fatalf("%q not printed", s)
+}
+
+func TestREPL(t *testing.T) {
+	client, server := securecomm.SecurePipe()
+	defer client.Close()
+	go func() {
+		io.Copy(server, server)
+		server.Close()
+	}()
+
+	keys, typed := io.Pipe()
+	tty := &fakeTerminal{keys: keys}
+	history := loadHistory(filepath.Join(t.TempDir(), "history"))
+	done := make(chan error, 1)
+	go func() {
+		done <- repl(client, tty, history)
+	}()
+
+	io.WriteString(typed, "hello\r")
+	tty.waitOutput(t, "hello\r\n")
+	// The up arrow recalls the previous line, edited before sending it
+	io.WriteString(typed, "\x1b[A again\r")
+	tty.waitOutput(t, "hello again\r\n")
+	// Ctrl-D exits
+	io.WriteString(typed, "\x04")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if history.Len() != 2 || history.At(0) != "hello again" {
+		t.Errorf("unexpected history %q", history.entries)
+	}
+}
+
+func TestREPLServerClosed(t *testing.T) {
+	client, server := securecomm.SecurePipe()
+	defer client.Close()
+	server.Close()
+
+	keys, typed := io.Pipe()
+	defer typed.Close()
+	tty := &fakeTerminal{keys: keys}
+	if err := repl(client, tty, nil); err != nil {
+		t.Fatal(err)
+	}
+	tty.waitOutput(t, "connection closed")
+}
+
+func TestFileHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	h := loadHistory(path)
+	h.Add("first")
+	h.Add("  ")
+	h.Add("second")
+
+	// The history is kept across sessions
+	h = loadHistory(path)
+	if h.Len() != 2 || h.At(0) != "second" || h.At(1) != "first" {
+		t.Fatalf("unexpected history %q", h.entries)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "first\nsecond\n" {
+		t.Fatalf("got %q, %v", data, err)
+	}
+}
@@ -0,0 +1,77 @@
+package securecomm
+
+import (
+	"strings"
+
+	"filippo.io/age"
+)
+
+// AgeIdentity returns the age identity of the key pair, decrypting the
+// files encrypted to AgeRecipient(kp) by this package or the age tools.
+func AgeIdentity(kp *KeyPair) (*age.X25519Identity, error) {
+	return age.ParseX25519Identity(strings.ToUpper(bech32Encode("age-secret-key-", kp.Private[:])))
+}
+
+// AgeRecipient returns the age recipient of the key pair, age1...
+// once printed.
+func AgeRecipient(kp *KeyPair) (*age.X25519Recipient, error) {
+	return age.ParseX25519Recipient(bech32Encode("age", kp.Public[:]))
+}
+
+// bech32Charset maps the 5 bits groups to the bech32 characters
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32Encode encodes data with the human-readable part hrp, as the
+// age keys are, following BIP 173.
+func bech32Encode(hrp string, data []byte) string {
+	// Regroup the bytes in groups of 5 bits
+	var values []byte
+	acc, bits := 0, 0
+	for _, b := range data {
+		acc = acc<<8 | int(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			values = append(values, byte(acc>>bits&31))
+		}
+	}
+	if bits > 0 {
+		values = append(values, byte(acc<<(5-bits)&31))
+	}
+
+	// The checksum covers the expanded hrp and the values
+	var expanded []byte
+	for _, c := range hrp {
+		expanded = append(expanded, byte(c>>5))
+	}
+	expanded = append(expanded, 0)
+	for _, c := range hrp {
+		expanded = append(expanded, byte(c&31))
+	}
+	polymod := bech32Polymod(append(append(expanded, values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := 0; i < 6; i++ {
+		values = append(values, byte(polymod>>(5*(5-i))&31))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(hrp + "1")
+	for _, v := range values {
+		sb.WriteByte(bech32Charset[v])
+	}
+	return sb.String()
+}
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
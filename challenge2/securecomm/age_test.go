@@ -0,0 +1,67 @@
+package securecomm
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestBech32Encode(t *testing.T) {
+	// Valid strings of BIP 173
+	tData := []struct {
+		hrp      string
+		data     []byte
+		expected string
+	}{
+		{"a", nil, "a12uel5l"},
+		{"abcdef", []byte{0x00, 0x44, 0x32, 0x14, 0xc7, 0x42, 0x54, 0xb6, 0x35, 0xcf, 0x84, 0x65, 0x3a, 0x56, 0xd7, 0xc6, 0x75, 0xbe, 0x77, 0xdf},
+			"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw"},
+	}
+	for _, exp := range tData {
+		if got := bech32Encode(exp.hrp, exp.data); got != exp.expected {
+			t.Fatalf("Unexpected result. Got %s, expected %s", got, exp.expected)
+		}
+	}
+}
+
+func TestAgeKeys(t *testing.T) {
+	kp, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := AgeIdentity(kp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := AgeRecipient(kp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Recipient().String() != recipient.String() {
+		t.Fatalf("Unexpected result. The recipient %s does not match the identity %s", recipient, identity.Recipient())
+	}
+
+	msg := []byte("hello world\n")
+	var encrypted bytes.Buffer
+	w, err := age.Encrypt(&encrypted, recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(msg)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(encrypted.Bytes(), []byte("age-encryption.org/v1\n")) {
+		t.Fatal("Unexpected result. The file is not an age file.")
+	}
+	r, err := age.Decrypt(&encrypted, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, msg) {
+		t.Fatalf("Unexpected result. Got %q, expected %q (%v)", got, msg, err)
+	}
+}
@@ -0,0 +1,80 @@
+package securecomm
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrNoAgentKey is returned when ssh-agent holds no matching Ed25519 key.
+var ErrNoAgentKey = errors.New("no matching Ed25519 key in ssh-agent")
+
+// AgentSigner returns a signer using the Ed25519 key held by the
+// ssh-agent listening on $SSH_AUTH_SOCK whose comment or fingerprint is
+// match, or the first one if match is empty. The private key never
+// leaves the agent. The signer keeps the connection to the agent open.
+func AgentSigner(match string) (crypto.Signer, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, errors.New("ssh-agent not running: SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to ssh-agent: %s", err)
+	}
+	signer, err := newAgentSigner(agent.NewClient(conn), match)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return signer, nil
+}
+
+func newAgentSigner(a agent.Agent, match string) (*agentSigner, error) {
+	keys, err := a.List()
+	if err != nil {
+		return nil, fmt.Errorf("error listing the keys of ssh-agent: %s", err)
+	}
+	for _, key := range keys {
+		if key.Type() != ssh.KeyAlgoED25519 {
+			continue
+		}
+		pub, err := ssh.ParsePublicKey(key.Marshal())
+		if err != nil {
+			return nil, err
+		}
+		public := pub.(ssh.CryptoPublicKey).CryptoPublicKey().(ed25519.PublicKey)
+		if match == "" || match == key.Comment || match == Fingerprint(public) {
+			return &agentSigner{agent: a, key: key, public: public}, nil
+		}
+	}
+	return nil, ErrNoAgentKey
+}
+
+// agentSigner signs with a key held by ssh-agent.
+type agentSigner struct {
+	agent  agent.Agent
+	key    *agent.Key
+	public ed25519.PublicKey
+}
+
+func (s *agentSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign signs message with the agent. Like with ed25519.PrivateKey, the
+// message is not hashed.
+func (s *agentSigner) Sign(_ io.Reader, message []byte, _ crypto.SignerOpts) ([]byte, error) {
+	sig, err := s.agent.Sign(s.key, message)
+	if err != nil {
+		return nil, fmt.Errorf("ssh-agent refused to sign: %s", err)
+	}
+	return sig.Blob, nil
+}
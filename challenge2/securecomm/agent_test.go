@@ -0,0 +1,58 @@
+package securecomm
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAgentSigner(t *testing.T) {
+	keyring := agent.NewKeyring()
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv, Comment: "alice@laptop"}); err != nil {
+		t.Fatal(err)
+	}
+
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, c)
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	if _, err := AgentSigner("bob@laptop"); !errors.Is(err, ErrNoAgentKey) {
+		t.Fatalf("Unexpected result. Expected %v, got %v", ErrNoAgentKey, err)
+	}
+	for _, match := range []string{"", "alice@laptop", Fingerprint(pub)} {
+		if _, err := AgentSigner(match); err != nil {
+			t.Fatalf("Unexpected result for %q: %v", match, err)
+		}
+	}
+
+	// The handshake is signed by the agent
+	signer, err := AgentSigner("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := tcpConns(t, &Config{Signer: signer}, nil)
+	defer client.Close()
+	defer server.Close()
+	if !server.PeerIdentity().Equal(pub) {
+		t.Fatalf("Unexpected result. Got identity %s, expected %s", Fingerprint(server.PeerIdentity()), Fingerprint(pub))
+	}
+}
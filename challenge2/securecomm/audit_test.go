@@ -0,0 +1,60 @@
+package securecomm
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	s := &Server{Config: &Config{PSK: []byte("secret")}, Audit: NewAuditLog(&buf)}
+	addr, _ := startServer(t, s)
+
+	_, identity, _ := ed25519.GenerateKey(rand.Reader)
+	cfg := &Config{PSK: []byte("secret"), Identity: identity}
+	conn, err := DialConfig(addr, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if _, err := DialConfig(addr, &Config{PSK: []byte("wrong")}); err == nil {
+		t.Fatal("Unexpected result. The handshake with the wrong PSK succeeded")
+	}
+	s.Close()
+
+	events := make(map[string]AuditEvent)
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var e AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("Unexpected result. Invalid line %q: %v", scanner.Text(), err)
+		}
+		events[e.Event] = e
+	}
+	if len(events) != 3 {
+		t.Fatalf("Unexpected result. Got the events:\n%s", buf.String())
+	}
+	connect, disconnect, failed := events[auditConnect], events[auditDisconnect], events[auditHandshakeFailed]
+	fingerprint := Fingerprint(identity.Public().(ed25519.PublicKey))
+	if connect.Peer == "" || connect.Fingerprint != fingerprint || connect.Time.IsZero() {
+		t.Fatalf("Unexpected result. Got connect event %+v", connect)
+	}
+	if disconnect.Conn != connect.Conn || disconnect.Fingerprint != connect.Fingerprint ||
+		disconnect.Sent != 5 || disconnect.Received != 5 || disconnect.Reason != "client closed" {
+		t.Fatalf("Unexpected result. Got disconnect event %+v", disconnect)
+	}
+	if failed.Reason == "" || failed.Fingerprint != "" {
+		t.Fatalf("Unexpected result. Got handshake failure event %+v", failed)
+	}
+}
@@ -0,0 +1,102 @@
+package securecomm
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrUnauthorized is returned when a client identity is not authorized.
+var ErrUnauthorized = errors.New("client identity not authorized")
+
+// AuthorizedKeys restricts the clients of a server to the identities
+// listed in a file, one per line, as a base64 public key or a
+// fingerprint, optionally followed by a comment, like genkey prints
+// them. Empty lines and lines starting with # are ignored.
+type AuthorizedKeys struct {
+	// Logger, if not nil, logs the rejected clients.
+	Logger Logger
+
+	path string
+	mu   sync.RWMutex
+	keys map[string]string // comments by fingerprint
+}
+
+// LoadAuthorizedKeys loads the authorized identities listed in the file
+// found at path.
+func LoadAuthorizedKeys(path string) (*AuthorizedKeys, error) {
+	a := &AuthorizedKeys{path: path}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload loads the file again, keeping the current identities on
+// errors. Connected clients are not affected.
+func (a *AuthorizedKeys) Reload() error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	keys := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, comment, _ := strings.Cut(line, " ")
+		fingerprint, err := parseAuthorizedKey(key)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", a.path, n, err)
+		}
+		keys[fingerprint] = strings.TrimSpace(comment)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keys = keys
+	return nil
+}
+
+// parseAuthorizedKey returns the fingerprint of a key given as a
+// fingerprint or a base64 public key.
+func parseAuthorizedKey(key string) (string, error) {
+	if strings.HasPrefix(key, "SHA256:") {
+		return key, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return Fingerprint(b), nil
+}
+
+// Verify checks the identity of the client found at addr. It can be used
+// as Config.VerifyPeer.
+func (a *AuthorizedKeys) Verify(addr string, identity ed25519.PublicKey) error {
+	fingerprint := Fingerprint(identity)
+	a.mu.RLock()
+	_, ok := a.keys[fingerprint]
+	a.mu.RUnlock()
+	if !ok {
+		logger := a.Logger
+		if logger == nil {
+			logger = discardLogger
+		}
+		logger.Warn("unauthorized client rejected", "peer", addr, "identity", fingerprint)
+		return ErrUnauthorized
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package securecomm
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuthorizedKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	alice, aliceID, _ := ed25519.GenerateKey(rand.Reader)
+	bob, bobID, _ := ed25519.GenerateKey(rand.Reader)
+	_, carolID, _ := ed25519.GenerateKey(rand.Reader)
+
+	keys := "# clients\n" +
+		base64.StdEncoding.EncodeToString(alice) + " alice@laptop\n" +
+		"\n" +
+		Fingerprint(bob) + "\n"
+	if err := os.WriteFile(path, []byte(keys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ak, err := LoadAuthorizedKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{Config: &Config{VerifyPeer: ak.Verify}}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+	dial := func(id ed25519.PrivateKey) error {
+		conn, err := DialConfig(addr, &Config{Identity: id})
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		// The server closes the connection of unauthorized clients
+		// once the handshake is done
+		if _, err := conn.Write([]byte("x")); err != nil {
+			return err
+		}
+		_, err = conn.Read(make([]byte, 1))
+		return err
+	}
+
+	for _, id := range []ed25519.PrivateKey{aliceID, bobID} {
+		if err := dial(id); err != nil {
+			t.Fatalf("Unexpected result. An authorized client was rejected: %v", err)
+		}
+	}
+	if err := dial(carolID); err == nil {
+		t.Fatal("Unexpected result. An unknown client was accepted.")
+	}
+	if err := ak.Verify("peer", carolID.Public().(ed25519.PublicKey)); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Unexpected result. Expected %v, got %v", ErrUnauthorized, err)
+	}
+
+	// Reloading picks up the changes, and keeps the keys on errors
+	if err := os.WriteFile(path, []byte(Fingerprint(carolID.Public().(ed25519.PublicKey))+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ak.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dial(carolID); err != nil {
+		t.Fatalf("Unexpected result. A reloaded client was rejected: %v", err)
+	}
+	if err := dial(aliceID); err == nil {
+		t.Fatal("Unexpected result. A removed client was accepted.")
+	}
+	os.WriteFile(path, []byte("not a key\n"), 0600)
+	if err := ak.Reload(); err == nil {
+		t.Fatal("Unexpected result. An invalid file was loaded.")
+	}
+	if err := dial(carolID); err != nil {
+		t.Fatalf("Unexpected result. The keys were lost on error: %v", err)
+	}
+}
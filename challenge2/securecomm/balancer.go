@@ -0,0 +1,159 @@
+package securecomm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// BalancePolicy chooses the server of each connection of a Balancer.
+type BalancePolicy byte
+
+const (
+	// RoundRobin uses the servers in turn
+	RoundRobin BalancePolicy = iota
+	// LeastLatency uses the server answering the heartbeats the fastest,
+	// the least loaded one
+	LeastLatency
+)
+
+// Balancer distributes the connections across a set of servers, keeping
+// a Pool of warm connections to each. The servers with idle connections
+// are used first, in the order of the policy, so the ones down are only
+// dialed when no other is ready.
+type Balancer struct {
+	policy BalancePolicy
+	pools  []*Pool
+	addrs  []string
+	next   atomic.Uint64
+
+	mu    sync.Mutex
+	owner map[*Conn]*Pool // of the connections handed out
+}
+
+// NewBalancer returns a balancer across addrs, keeping size connections
+// to each ready, dialed with cfg.
+func NewBalancer(addrs []string, cfg *Config, size int, policy BalancePolicy) *Balancer {
+	b := &Balancer{policy: policy, addrs: addrs, owner: make(map[*Conn]*Pool)}
+	for _, addr := range addrs {
+		b.pools = append(b.pools, NewPool(addr, cfg, size))
+	}
+	return b
+}
+
+// NewServiceBalancer is like NewBalancer across the targets of the SRV
+// records of name, like _secure._tcp.example.com. The records are
+// resolved once.
+func NewServiceBalancer(ctx context.Context, name string, cfg *Config, size int, policy BalancePolicy) (*Balancer, error) {
+	_, records, err := lookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", name, err)
+	}
+	var addrs []string
+	for _, srv := range records {
+		if srv.Target == "." {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no target for %s", name)
+	}
+	return NewBalancer(addrs, cfg, size, policy), nil
+}
+
+// Addrs returns the addresses of the servers.
+func (b *Balancer) Addrs() []string {
+	return b.addrs
+}
+
+// order returns the pools in the order of the policy, the ones with idle
+// connections first.
+func (b *Balancer) order() []*Pool {
+	pools := slices.Clone(b.pools)
+	switch b.policy {
+	case RoundRobin:
+		start := int(b.next.Add(1)-1) % len(pools)
+		pools = append(pools[start:], pools[:start]...)
+	case LeastLatency:
+		latency := make(map[*Pool]int64, len(pools))
+		for _, p := range pools {
+			latency[p] = int64(p.Latency())
+		}
+		slices.SortStableFunc(pools, func(a, b *Pool) int {
+			// Not measured yet comes last
+			la, lb := latency[a], latency[b]
+			switch {
+			case la == lb:
+				return 0
+			case la == 0:
+				return 1
+			case lb == 0:
+				return -1
+			case la < lb:
+				return -1
+			}
+			return 1
+		})
+	}
+	ready := make(map[*Pool]bool, len(pools))
+	for _, p := range pools {
+		ready[p] = p.Len() > 0
+	}
+	slices.SortStableFunc(pools, func(a, b *Pool) int {
+		switch {
+		case ready[a] == ready[b]:
+			return 0
+		case ready[a]:
+			return -1
+		}
+		return 1
+	})
+	return pools
+}
+
+// Get returns a connection to a server chosen by the policy, trying the
+// next ones when it fails. The connection must be given back with Put,
+// even if it failed: the pools close the connections failing.
+func (b *Balancer) Get(ctx context.Context) (*Conn, error) {
+	var err error
+	for _, p := range b.order() {
+		var conn *Conn
+		if conn, err = p.Get(ctx); err == nil {
+			b.mu.Lock()
+			b.owner[conn] = p
+			b.mu.Unlock()
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("no server reachable, last error: %w", err)
+}
+
+// Put gives back a connection obtained with Get to the pool of its server.
+func (b *Balancer) Put(conn *Conn) {
+	b.mu.Lock()
+	p, ok := b.owner[conn]
+	delete(b.owner, conn)
+	b.mu.Unlock()
+	if !ok {
+		conn.Close()
+		return
+	}
+	p.Put(conn)
+}
+
+// Close closes the pools of the servers.
+func (b *Balancer) Close() error {
+	for _, p := range b.pools {
+		p.Close()
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package securecomm
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// waitReady waits until the pools of b have idle connections with their
+// latency measured.
+func waitReady(t *testing.T, b *Balancer) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, p := range b.pools {
+		for p.Len() == 0 || p.Latency() == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("Unexpected result. The pools did not get ready")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// slowListener accepts connections answering late, like a loaded server.
+type slowListener struct {
+	net.Listener
+}
+
+func (l slowListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return slowConn{c}, nil
+}
+
+type slowConn struct {
+	net.Conn
+}
+
+func (c slowConn) Write(p []byte) (int, error) {
+	time.Sleep(20 * time.Millisecond)
+	return c.Conn.Write(p)
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	s1 := &Server{}
+	addr1, _ := startServer(t, s1)
+	defer s1.Close()
+	s2 := &Server{}
+	addr2, _ := startServer(t, s2)
+	defer s2.Close()
+
+	b := NewBalancer([]string{addr1, addr2}, &Config{HeartbeatInterval: 20 * time.Millisecond}, 1, RoundRobin)
+	defer b.Close()
+	waitReady(t, b)
+
+	seen := make(map[string]int)
+	for range 4 {
+		conn, err := b.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[conn.RemoteAddr().String()]++
+		b.Put(conn)
+		waitReady(t, b)
+	}
+	if seen[addr1] != 2 || seen[addr2] != 2 {
+		t.Fatalf("Unexpected result. The connections went to %v", seen)
+	}
+}
+
+func TestBalancerLeastLatency(t *testing.T) {
+	fast := &Server{}
+	fastAddr, _ := startServer(t, fast)
+	defer fast.Close()
+	slow := &Server{}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go slow.Serve(slowListener{l})
+	slowAddr := l.Addr().String()
+	defer slow.Close()
+
+	b := NewBalancer([]string{slowAddr, fastAddr}, &Config{HeartbeatInterval: 20 * time.Millisecond}, 1, LeastLatency)
+	defer b.Close()
+	waitReady(t, b)
+
+	for range 3 {
+		conn, err := b.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := conn.RemoteAddr().String(); got != fastAddr {
+			t.Fatalf("Unexpected result. Connected to %s, expected the fastest server %s", got, fastAddr)
+		}
+		b.Put(conn)
+		waitReady(t, b)
+	}
+}
+
+func TestServiceBalancer(t *testing.T) {
+	s := &Server{}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+	_, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, refused, _ := net.SplitHostPort(closed.Addr().String())
+	closed.Close()
+	r, _ := strconv.Atoi(refused)
+
+	defer func(lookup func(context.Context, string, string, string) (string, []*net.SRV, error)) {
+		lookupSRV = lookup
+	}(lookupSRV)
+	lookupSRV = func(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		if name != "_secure._tcp.example.com" {
+			return "", nil, errors.New("no such host")
+		}
+		return name, []*net.SRV{
+			{Target: "127.0.0.1.", Port: uint16(r)},
+			{Target: "127.0.0.1.", Port: uint16(p)},
+		}, nil
+	}
+
+	b, err := NewServiceBalancer(context.Background(), "_secure._tcp.example.com", nil, 1, RoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if len(b.Addrs()) != 2 {
+		t.Fatalf("Unexpected result. Balancing across %v", b.Addrs())
+	}
+
+	// The server down is skipped
+	for range 2 {
+		conn, err := b.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := conn.RemoteAddr().String(); got != addr {
+			t.Fatalf("Unexpected result. Connected to %s, expected %s", got, addr)
+		}
+		b.Put(conn)
+	}
+
+	if _, err := NewServiceBalancer(context.Background(), "_secure._tcp.example.org", nil, 1, RoundRobin); err == nil {
+		t.Fatal("Unexpected result. Balancing across an unknown service succeeded")
+	}
+}
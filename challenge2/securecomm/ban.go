@@ -0,0 +1,98 @@
+package securecomm
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Defaults of the bans of a Server
+const (
+	defaultBanWindow   = time.Minute
+	defaultBanDuration = 10 * time.Minute
+)
+
+// maxBanEntries is the number of addresses tracked by a ban list,
+// forgetting the least recently used one past it.
+const maxBanEntries = 4096
+
+// banList bans the addresses failing too often, like fail2ban: threshold
+// failures within window ban an address for duration.
+type banList struct {
+	threshold int
+	window    time.Duration
+	duration  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	used    *list.List // of *banEntry, least recently used first
+}
+
+type banEntry struct {
+	key         string
+	failures    int
+	first       time.Time // of the failures counted
+	bannedUntil time.Time
+}
+
+func newBanList(threshold int, window, duration time.Duration) *banList {
+	if window <= 0 {
+		window = defaultBanWindow
+	}
+	if duration <= 0 {
+		duration = defaultBanDuration
+	}
+	return &banList{
+		threshold: threshold,
+		window:    window,
+		duration:  duration,
+		entries:   make(map[string]*list.Element),
+		used:      list.New(),
+	}
+}
+
+// banned reports whether key is banned at now.
+func (b *banList) banned(key string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.entries[key]
+	if !ok {
+		return false
+	}
+	b.used.MoveToBack(e)
+	return now.Before(e.Value.(*banEntry).bannedUntil)
+}
+
+// failed records a failure of key at now, telling whether it got key
+// banned.
+func (b *banList) failed(key string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var e *banEntry
+	if el, ok := b.entries[key]; ok {
+		b.used.MoveToBack(el)
+		e = el.Value.(*banEntry)
+	} else {
+		if len(b.entries) >= maxBanEntries {
+			oldest := b.used.Front()
+			b.used.Remove(oldest)
+			delete(b.entries, oldest.Value.(*banEntry).key)
+		}
+		e = &banEntry{key: key}
+		b.entries[key] = b.used.PushBack(e)
+	}
+	if now.Before(e.bannedUntil) {
+		// The connections accepted before the ban keep failing
+		return false
+	}
+	if now.Sub(e.first) > b.window {
+		e.failures, e.first = 0, now
+	}
+	e.failures++
+	if e.failures < b.threshold {
+		return false
+	}
+	e.failures = 0
+	e.bannedUntil = now.Add(b.duration)
+	return true
+}
@@ -0,0 +1,78 @@
+package securecomm
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestBanList(t *testing.T) {
+	b := newBanList(3, time.Minute, 10*time.Minute)
+	now := time.Now()
+
+	// Failures spread over more than the window don't ban
+	b.failed("a", now)
+	b.failed("a", now.Add(30*time.Second))
+	if b.failed("a", now.Add(90*time.Second)) || b.banned("a", now.Add(90*time.Second)) {
+		t.Fatal("Unexpected result. Banned after failures outside of the window")
+	}
+
+	// The threshold within the window bans for the duration
+	now = now.Add(time.Hour)
+	b.failed("a", now)
+	b.failed("a", now)
+	if !b.failed("a", now) {
+		t.Fatal("Unexpected result. Not banned at the threshold")
+	}
+	if !b.banned("a", now.Add(9*time.Minute)) || b.banned("b", now) {
+		t.Fatal("Unexpected result. The ban does not apply to the address alone")
+	}
+	if b.banned("a", now.Add(11*time.Minute)) {
+		t.Fatal("Unexpected result. Still banned after the duration")
+	}
+}
+
+func TestBanListMaxEntries(t *testing.T) {
+	b := newBanList(1, time.Minute, 10*time.Minute)
+	now := time.Now()
+	b.failed("banned", now)
+	for i := 0; i < maxBanEntries; i++ {
+		b.failed(fmt.Sprint(i), now)
+		b.banned("banned", now)
+	}
+	if len(b.entries) != maxBanEntries || b.used.Len() != maxBanEntries {
+		t.Fatalf("Unexpected result. Got %d entries, expected %d", len(b.entries), maxBanEntries)
+	}
+	// The least recently used address was forgotten
+	if !b.banned("banned", now) || b.banned("0", now) {
+		t.Fatal("Unexpected result. Forgot the wrong address")
+	}
+}
+
+func TestServerBan(t *testing.T) {
+	s := &Server{Config: &Config{PSK: []byte("secret")}, BanThreshold: 2, Collector: NewCollector()}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	for range 2 {
+		if _, err := DialConfig(addr, &Config{PSK: []byte("guess")}); err == nil {
+			t.Fatal("Unexpected result. The handshake with the wrong PSK succeeded")
+		}
+	}
+	// The failures are recorded once the server is done with the handshake
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(s.Collector.bans) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Unexpected result. The address was not banned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := DialConfig(addr, &Config{PSK: []byte("secret")}); err == nil {
+		t.Fatal("Unexpected result. The banned address was accepted")
+	}
+	if got := testutil.ToFloat64(s.Collector.rejectedConns); got != 1 {
+		t.Fatalf("Unexpected result. Got %g rejected connections, expected 1", got)
+	}
+}
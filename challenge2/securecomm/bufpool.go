@@ -0,0 +1,52 @@
+package securecomm
+
+import (
+	"math"
+	"sync"
+)
+
+// maxFrameHeader is the size of the largest frame header: its length,
+// 32 bits since Version9, and the largest nonce of the suites.
+const maxFrameHeader = 4 + 24
+
+// maxFrameSize is the size of the largest pooled frame, sealing a
+// message of up to 64 KiB. Larger frames, since Version9, use buffers
+// of their own.
+const maxFrameSize = maxFrameHeader + math.MaxUint16
+
+// bufferPool recycles the buffers used to read and write frames, saving
+// allocations per frame. Each buffer holds a frame followed by its
+// plaintext, as some AEADs don't seal or open in place.
+//
+// Once the pool is warm, reading and writing frames allocates nothing:
+// the target is 0 allocs/op in BenchmarkSecureReadWrite. Frames are
+// written with a single call, and read with one call for the length and
+// nonce and one for the sealed message.
+var bufferPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 2*maxFrameSize)
+		return &b
+	},
+}
+
+func getBuffer() *[]byte {
+	return bufferPool.Get().(*[]byte)
+}
+
+// frameBuffer returns a buffer holding a frame sealing size bytes and
+// its plaintext, which starts in its second half.
+func frameBuffer(size int) *[]byte {
+	if maxFrameHeader+size <= maxFrameSize {
+		return getBuffer()
+	}
+	b := make([]byte, 2*(maxFrameHeader+size))
+	return &b
+}
+
+func putBuffer(b *[]byte) {
+	if len(*b) != 2*maxFrameSize {
+		// Large frames are rare, their buffers aren't kept
+		return
+	}
+	bufferPool.Put(b)
+}
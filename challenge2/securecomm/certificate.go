@@ -0,0 +1,191 @@
+package securecomm
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+)
+
+// Certificate binds an identity key to a name until an expiry date,
+// signed by a certificate authority: an offline identity key trusted by
+// the peers, see Config.CertificateAuthorities. Peers then authenticate
+// each other without exchanging every key beforehand.
+type Certificate struct {
+	Identity  ed25519.PublicKey
+	Name      string
+	NotAfter  time.Time
+	Authority ed25519.PublicKey // signing the certificate
+	Signature []byte
+}
+
+// maxCertificateName bounds the length of the certificate names
+const maxCertificateName = 255
+
+// certificateSize is the length of a marshaled certificate without name
+const certificateSize = ed25519.PublicKeySize + 8 + 1 + ed25519.PublicKeySize + ed25519.SignatureSize
+
+// Errors verifying certificates
+var (
+	ErrNoCertificate       = errors.New("peer presented no certificate")
+	ErrUnknownAuthority    = errors.New("certificate signed by an unknown authority")
+	ErrCertificateExpired  = errors.New("certificate expired")
+	ErrCertificateMismatch = errors.New("certificate does not match the peer")
+)
+
+// SignCertificate returns the certificate of identity for name, valid
+// until notAfter, signed by authority.
+func SignCertificate(authority ed25519.PrivateKey, identity ed25519.PublicKey, name string, notAfter time.Time) (*Certificate, error) {
+	if len(name) > maxCertificateName {
+		return nil, fmt.Errorf("certificate name longer than %d bytes", maxCertificateName)
+	}
+	c := &Certificate{
+		Identity:  identity,
+		Name:      name,
+		NotAfter:  notAfter.Truncate(time.Second),
+		Authority: authority.Public().(ed25519.PublicKey),
+	}
+	c.Signature = ed25519.Sign(authority, c.signed())
+	return c, nil
+}
+
+// signed returns the part of the certificate covered by the signature.
+func (c *Certificate) signed() []byte {
+	b := append([]byte(handshakeContext+" certificate"), c.Identity...)
+	b = binary.BigEndian.AppendUint64(b, uint64(c.NotAfter.Unix()))
+	b = append(b, byte(len(c.Name)))
+	b = append(b, c.Name...)
+	return append(b, c.Authority...)
+}
+
+// Verify checks that c is signed by one of authorities and still valid
+// at now.
+func (c *Certificate) Verify(authorities []ed25519.PublicKey, now time.Time) error {
+	trusted := false
+	for _, authority := range authorities {
+		if authority.Equal(c.Authority) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted || !ed25519.Verify(c.Authority, c.signed(), c.Signature) {
+		return ErrUnknownAuthority
+	}
+	if now.After(c.NotAfter) {
+		return ErrCertificateExpired
+	}
+	return nil
+}
+
+// Marshal returns the binary form of c, read by ParseCertificate.
+func (c *Certificate) Marshal() []byte {
+	b := append([]byte{}, c.Identity...)
+	b = binary.BigEndian.AppendUint64(b, uint64(c.NotAfter.Unix()))
+	b = append(b, byte(len(c.Name)))
+	b = append(b, c.Name...)
+	b = append(b, c.Authority...)
+	return append(b, c.Signature...)
+}
+
+// ParseCertificate parses a certificate returned by Certificate.Marshal.
+// The signature is not verified.
+func ParseCertificate(b []byte) (*Certificate, error) {
+	if len(b) < certificateSize || len(b) != certificateSize+int(b[ed25519.PublicKeySize+8]) {
+		return nil, errors.New("invalid certificate")
+	}
+	c := &Certificate{}
+	c.Identity, _ = next(&b, ed25519.PublicKeySize)
+	notAfter, _ := next(&b, 8)
+	c.NotAfter = time.Unix(int64(binary.BigEndian.Uint64(notAfter)), 0)
+	size, _ := next(&b, 1)
+	name, _ := next(&b, int(size[0]))
+	c.Name = string(name)
+	c.Authority, _ = next(&b, ed25519.PublicKeySize)
+	c.Signature = b
+	return c, nil
+}
+
+// SaveCertificate writes c, base64 encoded, to the file found at path.
+func SaveCertificate(path string, c *Certificate) error {
+	data := base64.StdEncoding.EncodeToString(c.Marshal()) + "\n"
+	return ioutil.WriteFile(path, []byte(data), 0644)
+}
+
+// LoadCertificate reads a certificate saved with SaveCertificate.
+func LoadCertificate(path string) (*Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate file %s", path)
+	}
+	return ParseCertificate(b)
+}
+
+// LoadAuthorities reads the public keys of the certificate authorities
+// listed in the file found at path, base64 encoded, one per line. Empty
+// lines and lines starting with # are ignored.
+func LoadAuthorities(path string) ([]ed25519.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var authorities []ed25519.PublicKey
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, _, _ := strings.Cut(line, " ")
+		b, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%s:%d: invalid key %q", path, n+1, key)
+		}
+		authorities = append(authorities, b)
+	}
+	return authorities, nil
+}
+
+// verifyCertificate verifies the certificate presented by the peer with
+// identity when certificate authorities are configured. Clients also
+// check that the name of the certificate matches the host they dialed,
+// servers pass an empty host.
+func (c *Config) verifyCertificate(host string, identity ed25519.PublicKey, cert *Certificate) error {
+	if c == nil || len(c.CertificateAuthorities) == 0 {
+		return nil
+	}
+	if cert == nil {
+		return ErrNoCertificate
+	}
+	if err := cert.Verify(c.CertificateAuthorities, time.Now()); err != nil {
+		return err
+	}
+	if !cert.Identity.Equal(identity) || (host != "" && cert.Name != host) {
+		return ErrCertificateMismatch
+	}
+	return nil
+}
+
+// certificate returns the certificate presented to peers since Version7.
+func (c *Config) certificate() []byte {
+	if c == nil || c.Certificate == nil {
+		return nil
+	}
+	return c.Certificate.Marshal()
+}
+
+// hostOf returns the host of addr, without the port.
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
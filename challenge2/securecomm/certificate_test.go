@@ -0,0 +1,120 @@
+package securecomm
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCertificate(t *testing.T) {
+	caPub, ca, _ := ed25519.GenerateKey(rand.Reader)
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	id, _, _ := ed25519.GenerateKey(rand.Reader)
+	now := time.Now()
+
+	cert, err := SignCertificate(ca, id, "example.com", now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseCertificate(cert.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Name != "example.com" || !parsed.Identity.Equal(id) || !parsed.NotAfter.Equal(cert.NotAfter) {
+		t.Fatalf("Unexpected result. Got %+v, expected %+v", parsed, cert)
+	}
+
+	tData := []struct {
+		name        string
+		authorities []ed25519.PublicKey
+		now         time.Time
+		expected    error
+	}{
+		{"valid", []ed25519.PublicKey{otherPub, caPub}, now, nil},
+		{"unknown authority", []ed25519.PublicKey{otherPub}, now, ErrUnknownAuthority},
+		{"expired", []ed25519.PublicKey{caPub}, now.Add(2 * time.Hour), ErrCertificateExpired},
+	}
+	for _, exp := range tData {
+		if err := parsed.Verify(exp.authorities, exp.now); err != exp.expected {
+			t.Fatalf("Unexpected result for %s. Expected %v, got %v", exp.name, exp.expected, err)
+		}
+	}
+
+	// Tampering with the name breaks the signature
+	parsed.Name = "evil.com"
+	if err := parsed.Verify([]ed25519.PublicKey{caPub}, now); err != ErrUnknownAuthority {
+		t.Fatalf("Unexpected result. Expected %v, got %v", ErrUnknownAuthority, err)
+	}
+}
+
+func TestCertificateHandshake(t *testing.T) {
+	caPub, ca, _ := ed25519.GenerateKey(rand.Reader)
+	_, rogue, _ := ed25519.GenerateKey(rand.Reader)
+	authorities := []ed25519.PublicKey{caPub}
+	certified := func(ca ed25519.PrivateKey, name string, validity time.Duration) *Config {
+		_, id, _ := ed25519.GenerateKey(rand.Reader)
+		cert, err := SignCertificate(ca, id.Public().(ed25519.PublicKey), name, time.Now().Add(validity))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Config{Identity: id, Certificate: cert, CertificateAuthorities: authorities}
+	}
+
+	serverCfg := certified(ca, "127.0.0.1", time.Hour)
+	s := &Server{Config: serverCfg}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	client, server := tcpConns(t, certified(ca, "alice", time.Hour), serverCfg)
+	if cert := server.PeerCertificate(); cert == nil || cert.Name != "alice" {
+		t.Fatalf("Unexpected result. Got certificate %+v", cert)
+	}
+	if cert := client.PeerCertificate(); cert == nil || cert.Name != "127.0.0.1" {
+		t.Fatalf("Unexpected result. Got certificate %+v", cert)
+	}
+	client.Close()
+	server.Close()
+
+	// Clients check the certificate of the server
+	tData := []struct {
+		name     string
+		cfg      *Config
+		expected error
+	}{
+		{"no certificate", &Config{CertificateAuthorities: authorities, MaxVersion: Version6}, ErrNoCertificate},
+		{"unknown authority", &Config{CertificateAuthorities: []ed25519.PublicKey{rogue.Public().(ed25519.PublicKey)}}, ErrUnknownAuthority},
+	}
+	for _, exp := range tData {
+		conn, err := DialConfig(addr, exp.cfg)
+		if !errors.Is(err, exp.expected) {
+			t.Fatalf("Unexpected result for %s. Expected %v, got %v", exp.name, exp.expected, err)
+		}
+		if err == nil {
+			conn.Close()
+		}
+	}
+	// A certificate for another host is rejected
+	other := certified(ca, "example.com", time.Hour)
+	other.CertificateAuthorities = nil
+	s2 := &Server{Config: other}
+	addr2, _ := startServer(t, s2)
+	defer s2.Close()
+	if _, err := DialConfig(addr2, &Config{CertificateAuthorities: authorities}); err != ErrCertificateMismatch {
+		t.Fatalf("Unexpected result. Expected %v, got %v", ErrCertificateMismatch, err)
+	}
+
+	// Servers close the connections of clients without valid certificate
+	for _, cfg := range []*Config{nil, certified(rogue, "alice", time.Hour), certified(ca, "alice", -time.Hour)} {
+		conn, err := DialConfig(addr, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("x"))
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Fatal("Unexpected result. A client without valid certificate was accepted.")
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,117 @@
+package securecomm
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// maxNickname is the maximum length of the nicknames, in bytes.
+const maxNickname = 32
+
+// ChatRoom relays the lines sent by each member to the others, prefixed
+// by the nickname of the sender. Clients first send their nickname, and
+// the room announces who joins and leaves with "* " lines. Lines sealed
+// with SealGroupLine are only readable by the members they were sealed
+// for. It is a Handler.
+type ChatRoom struct {
+	// Logger, if not nil, logs who joins and leaves.
+	Logger Logger
+
+	mu      sync.Mutex
+	members map[string]*subscriber
+}
+
+// ServeConn serves a member until it closes conn. It then closes conn.
+func (r *ChatRoom) ServeConn(conn io.ReadWriteCloser, peer Peer) error {
+	defer conn.Close()
+	sub, stop := newSubscriber(conn, 0)
+	defer stop()
+	logger := r.Logger
+	if logger == nil {
+		logger = discardLogger
+	}
+
+	s := bufio.NewScanner(conn)
+	sub.enqueue([]byte("* Nickname?\n"), DropOldest, logger)
+	var nick string
+	for nick == "" {
+		if !s.Scan() {
+			return s.Err()
+		}
+		name := strings.TrimSpace(s.Text())
+		if err := r.join(name, sub); err != "" {
+			sub.enqueue([]byte("* "+err+", nickname?\n"), DropOldest, logger)
+			continue
+		}
+		nick = name
+	}
+	logger.Info("member joined", "nick", nick, "peer", peer.Addr.String())
+	defer func() {
+		r.leave(nick)
+		logger.Info("member left", "nick", nick, "peer", peer.Addr.String())
+	}()
+
+	for s.Scan() {
+		r.broadcast(nick, "<"+nick+"> "+s.Text()+"\n")
+	}
+	return s.Err()
+}
+
+// join adds the member called nick, or returns why it can't.
+func (r *ChatRoom) join(nick string, sub *subscriber) string {
+	if nick == "" || len(nick) > maxNickname || strings.IndexFunc(nick, func(c rune) bool {
+		return unicode.IsSpace(c) || !unicode.IsPrint(c)
+	}) >= 0 {
+		return "invalid nickname"
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.members == nil {
+		r.members = make(map[string]*subscriber)
+	}
+	if r.members[nick] != nil {
+		return "nickname taken"
+	}
+	var others []string
+	for name := range r.members {
+		others = append(others, name)
+	}
+	sort.Strings(others)
+	r.members[nick] = sub
+
+	welcome := "* Welcome " + nick
+	if len(others) > 0 {
+		welcome += ", also here: " + strings.Join(others, ", ")
+	}
+	sub.enqueue([]byte(welcome+"\n"), DropOldest, r.Logger)
+	r.send(nick, "* "+nick+" joined\n")
+	return ""
+}
+
+func (r *ChatRoom) leave(nick string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.members, nick)
+	r.send(nick, "* "+nick+" left\n")
+}
+
+// broadcast sends line to all members but the sender.
+func (r *ChatRoom) broadcast(sender, line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.send(sender, line)
+}
+
+// send queues line for all members but sender. r.mu must be held.
+func (r *ChatRoom) send(sender, line string) {
+	for name, sub := range r.members {
+		if name != sender {
+			sub.enqueue([]byte(line), DropOldest, r.Logger)
+		}
+	}
+}
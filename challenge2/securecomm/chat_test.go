@@ -0,0 +1,47 @@
+package securecomm
+
+import (
+	"bufio"
+	"testing"
+)
+
+func TestChatRoom(t *testing.T) {
+	s := &Server{Handler: new(ChatRoom)}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	join := func(nick string) *hubClient {
+		conn, err := Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		c := &hubClient{conn, bufio.NewReader(conn)}
+		c.expect(t, "* Nickname?")
+		c.send(t, nick)
+		return c
+	}
+
+	alice := join("alice")
+	alice.expect(t, "* Welcome alice")
+	bob := join("bob")
+	bob.expect(t, "* Welcome bob, also here: alice")
+	alice.expect(t, "* bob joined")
+
+	taken := join("alice")
+	taken.expect(t, "* nickname taken, nickname?")
+	taken.send(t, "not valid")
+	taken.expect(t, "* invalid nickname, nickname?")
+	taken.send(t, "carol")
+	taken.expect(t, "* Welcome carol, also here: alice, bob")
+	alice.expect(t, "* carol joined")
+	bob.expect(t, "* carol joined")
+
+	alice.send(t, "hello everyone")
+	bob.expect(t, "<alice> hello everyone")
+	taken.expect(t, "<alice> hello everyone")
+
+	bob.conn.Close()
+	alice.expect(t, "* bob left")
+	taken.expect(t, "* bob left")
+}
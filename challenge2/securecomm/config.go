@@ -0,0 +1,321 @@
+package securecomm
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"math"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Config configures the secure connections. A nil Config is valid
+// and uses the defaults.
+type Config struct {
+	// KeyPair is the long-term static key pair of this side of the
+	// handshake. A fresh key pair is generated for every connection
+	// when it is nil. Frames are always sealed with keys derived from
+	// ephemeral keys, for forward secrecy.
+	KeyPair *KeyPair
+
+	// Identity is the long-term key signing the handshake. A fresh
+	// identity is generated for every connection when it is nil.
+	Identity ed25519.PrivateKey
+
+	// Signer, if not nil, signs the handshake when Identity is nil, for
+	// identity keys kept elsewhere such as in ssh-agent, see AgentSigner.
+	// Its public key must be an ed25519.PublicKey.
+	Signer crypto.Signer
+
+	// PSK is an optional pre-shared key. When set, it is mixed into
+	// the session key and the handshake fails unless both sides use
+	// the same one.
+	PSK []byte
+
+	// Password is an optional password shared by both sides. When set,
+	// the handshake runs a password-authenticated key exchange, so the
+	// password can't be cracked offline from a recorded connection.
+	Password []byte
+
+	// Suites lists the cipher suites we support, in order of
+	// preference. Servers pick the first one supported by the client.
+	// It defaults to all suites, favoring AES-GCM when the CPU has
+	// AES instructions.
+	Suites []Suite
+
+	// PostQuantum enables the hybrid X25519 and ML-KEM-768 key
+	// exchange, protecting recorded sessions against future quantum
+	// computers. Clients offer it and servers accept it; the classic
+	// key exchange is used unless both sides enable it.
+	PostQuantum bool
+
+	// MinVersion and MaxVersion bound the accepted protocol versions,
+	// defaulting to all the versions supported by this package. The
+	// server advertises its range and the client picks the highest
+	// version in common.
+	MinVersion uint8
+	MaxVersion uint8
+
+	// HeartbeatInterval, if not zero, is the interval between the pings
+	// sent to the peer. Reads fail with ErrPeerTimeout when nothing was
+	// received from the peer for HeartbeatMisses intervals, 3 by
+	// default. Heartbeats need both sides to support Version2.
+	HeartbeatInterval time.Duration
+	HeartbeatMisses   int
+
+	// ReadTimeout and WriteTimeout, if not zero, bound the time to
+	// receive and send each frame, including the wait for the next frame
+	// to read, so stalled peers don't hang the connection. They are
+	// applied with the deadlines of the underlying connection, and the
+	// deadlines set by the user still apply when earlier. ReadTimeout is
+	// ignored when heartbeats are enabled, as they detect stalled peers.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// Padding pads the messages to 256, 1024 or 4096 bytes, or a
+	// multiple of 4096 bytes, so their length leaks less information.
+	// It needs both sides to support Version3.
+	Padding bool
+
+	// RatchetInterval, if not zero, is the interval between the
+	// Diffie-Hellman ratchet steps of the keys sealing our frames,
+	// taken before sending data. A key compromised at some point then
+	// only exposes the frames until the next step. It needs both sides
+	// to support Version5.
+	RatchetInterval time.Duration
+
+	// MaxFrameSize limits the size of the sealed messages of the
+	// frames, from 256 bytes to 65535 bytes, the default, or to 16 MiB
+	// when both sides support Version9. Messages are split accordingly,
+	// and receiving a larger frame closes the connection, so both sides
+	// should use the same value.
+	MaxFrameSize int
+
+	// TCP, if not nil, tunes the TCP connections.
+	TCP *TCPOptions
+
+	// Proxy is the URL of the proxy to dial the servers through,
+	// http:// for HTTP CONNECT proxies and socks5:// for SOCKS5 ones,
+	// with optional credentials. When empty, the HTTPS_PROXY or
+	// ALL_PROXY environment variables are used, except for local hosts
+	// and the hosts listed in NO_PROXY. "direct" disables proxies.
+	Proxy string
+
+	// Logger, if not nil, logs the connections handled by servers and
+	// listeners. The default is silent.
+	Logger Logger
+
+	// VerifyPeer, if not nil, is called after the handshake with the
+	// address and the verified identity of the peer. Returning an
+	// error aborts the connection.
+	VerifyPeer func(addr string, identity ed25519.PublicKey) error
+
+	// VerifyRotation, if not nil, is called by clients instead of
+	// VerifyPeer when the server identity is endorsed by its previous
+	// one, during the grace period of Server.RotateIdentity.
+	VerifyRotation func(addr string, previous, identity ed25519.PublicKey) error
+
+	// Protocols lists the application protocols, such as "echo" or
+	// "chat", offered by clients in order of preference, or supported by
+	// servers in order of preference. Servers select the first one
+	// offered by the client, failing the handshake if there is none.
+	// It needs both sides to support Version8, see Conn.Protocol.
+	Protocols []string
+
+	// Certificate, if not nil, is the certificate of Identity presented
+	// to the peers. It needs both sides to support Version7.
+	Certificate *Certificate
+
+	// CertificateAuthorities, if not empty, lists the authorities
+	// trusted to sign the certificates of the peers. Peers must then
+	// present a valid certificate of their identity, whose name is the
+	// host dialed for servers, before VerifyPeer is called.
+	CertificateAuthorities []ed25519.PublicKey
+
+	// Trace, if not nil, is called with each frame sent or received,
+	// telling its direction, type, length, sequence number and timing,
+	// to debug the protocol. See TraceWriter.
+	Trace func(FrameEvent)
+
+	// TracePlaintext also passes the decrypted payloads to Trace. It
+	// exposes the data of the connection and is only meant for
+	// debugging.
+	TracePlaintext bool
+
+	// Record, if not nil, receives a recording of the frames of the
+	// session as they are on the wire, along with its keys, see
+	// ReadReplay. The recording holds secrets: anyone reading it can
+	// decrypt the session, so it is meant for tests and debugging and
+	// must be kept private. Each connection needs its own writer.
+	Record io.Writer
+
+	// KeyLog, if not nil, receives the keys of the sessions, in the
+	// format of the SSLKEYLOGFILE of TLS, see keylog.go, to decrypt
+	// captured traffic. Anyone reading it can decrypt the sessions: it
+	// is only meant for debugging in a lab. It can be shared by several
+	// connections.
+	KeyLog io.Writer
+
+	// TracerProvider, if not nil, records OpenTelemetry spans of the
+	// dials, the handshakes and the connections, with the peer
+	// attributes, and the rekeys and ratchet steps as events.
+	TracerProvider trace.TracerProvider
+
+	// endorsement of Identity by the previous identity of a server
+	endorsedBy []byte
+
+	// difficulty of the puzzle sent by a server under load, if any
+	puzzle int
+}
+
+// keyPair returns the configured key pair or a freshly generated one.
+func (c *Config) keyPair() (*KeyPair, error) {
+	if c != nil && c.KeyPair != nil {
+		return c.KeyPair, nil
+	}
+	return GenerateKeyPair()
+}
+
+// identity returns the configured identity, its signer or a freshly
+// generated one.
+func (c *Config) identity() (crypto.Signer, error) {
+	if c != nil && c.Identity != nil {
+		return c.Identity, nil
+	}
+	if c != nil && c.Signer != nil {
+		if _, ok := c.Signer.Public().(ed25519.PublicKey); !ok {
+			return nil, errors.New("identity signer is not an Ed25519 key")
+		}
+		return c.Signer, nil
+	}
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	return priv, err
+}
+
+func (c *Config) verifyPeer(addr string, identity ed25519.PublicKey) error {
+	if c == nil || c.VerifyPeer == nil {
+		return nil
+	}
+	return c.VerifyPeer(addr, identity)
+}
+
+// verifyServer verifies the identity of a server, endorsed by previous
+// if not nil.
+func (c *Config) verifyServer(addr string, identity, previous ed25519.PublicKey) error {
+	if previous != nil && c != nil && c.VerifyRotation != nil {
+		return c.VerifyRotation(addr, previous, identity)
+	}
+	return c.verifyPeer(addr, identity)
+}
+
+// endorsement returns the endorsement sent by servers since Version6.
+func (c *Config) endorsement() []byte {
+	if c == nil || c.endorsedBy == nil {
+		return make([]byte, endorsementSize)
+	}
+	return c.endorsedBy
+}
+
+// puzzleDifficulty returns the difficulty of the puzzle sent by
+// servers before the preamble, zero for none.
+func (c *Config) puzzleDifficulty() int {
+	if c == nil {
+		return 0
+	}
+	return c.puzzle
+}
+
+func (c *Config) protocols() []string {
+	if c == nil {
+		return nil
+	}
+	return c.Protocols
+}
+
+func (c *Config) psk() []byte {
+	if c == nil || len(c.PSK) == 0 {
+		return nil
+	}
+	return c.PSK
+}
+
+func (c *Config) password() []byte {
+	if c == nil || len(c.Password) == 0 {
+		return nil
+	}
+	return c.Password
+}
+
+func (c *Config) suites() []Suite {
+	if c == nil || len(c.Suites) == 0 {
+		return defaultSuites()
+	}
+	return c.Suites
+}
+
+func (c *Config) postQuantum() bool {
+	return c != nil && c.PostQuantum
+}
+
+func (c *Config) versions() (uint8, uint8) {
+	minV, maxV := minVersion, maxVersion
+	if c != nil && c.MinVersion != 0 {
+		minV = c.MinVersion
+	}
+	if c != nil && c.MaxVersion != 0 {
+		maxV = c.MaxVersion
+	}
+	return minV, maxV
+}
+
+func (c *Config) logger() Logger {
+	if c == nil || c.Logger == nil {
+		return discardLogger
+	}
+	return c.Logger
+}
+
+func (c *Config) heartbeat() (time.Duration, int) {
+	if c == nil || c.HeartbeatInterval <= 0 {
+		return 0, 0
+	}
+	if c.HeartbeatMisses <= 0 {
+		return c.HeartbeatInterval, defaultHeartbeatMisses
+	}
+	return c.HeartbeatInterval, c.HeartbeatMisses
+}
+
+func (c *Config) timeouts() (time.Duration, time.Duration) {
+	if c == nil {
+		return 0, 0
+	}
+	return c.ReadTimeout, c.WriteTimeout
+}
+
+func (c *Config) tcp() *TCPOptions {
+	if c == nil {
+		return nil
+	}
+	return c.TCP
+}
+
+func (c *Config) padding() bool {
+	return c != nil && c.Padding
+}
+
+func (c *Config) maxFrameSize() int {
+	if c == nil || c.MaxFrameSize == 0 {
+		return math.MaxUint16
+	}
+	return min(max(c.MaxFrameSize, minFrameSize), maxLargeFrameSize)
+}
+
+func (c *Config) ratchetInterval() time.Duration {
+	if c == nil || c.RatchetInterval <= 0 {
+		return 0
+	}
+	return c.RatchetInterval
+}
@@ -0,0 +1,933 @@
+// Package securecomm implements the secure connections: a Noise
+// handshake authenticating both sides with their Ed25519 identities,
+// then frames sealed with the negotiated cipher suite. Clients connect
+// with Dial and DialConfig, servers accept the connections with Serve,
+// NewListener or a Server, all configured by a Config. The challenge2
+// command is a CLI over it.
+package securecomm
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// ErrReplay is returned when a frame is received twice or out of order
+var ErrReplay = errors.New("replayed or out-of-order frame")
+
+// ErrFrameLost is returned when frames are missing from the stream,
+// told apart from reordered ones since Version10.
+var ErrFrameLost = errors.New("lost frames")
+
+// errDecrypt is returned when a frame fails authentication.
+var errDecrypt = errors.New("could not decrypt box")
+
+// ErrMalformedFrame is returned when a frame can't be valid, whatever
+// the key. Reading from a Conn closes it on such errors.
+var ErrMalformedFrame = errors.New("malformed frame")
+
+// errBadPadding is returned when the length of a padded message
+// exceeds its frame.
+var errBadPadding = fmt.Errorf("%w: invalid padding", ErrMalformedFrame)
+
+// SecureReader container to the io.Reader interface
+type SecureReader struct {
+	r     io.Reader
+	buf   []byte // decrypted bytes not yet returned to the caller
+	suite Suite
+	key   *[32]byte
+	aead  cipher.AEAD
+	seq   uint64 // sequence number of the next expected frame
+
+	header [maxFrameHeader]byte // length and nonce of the frame being read
+	long   bool                 // 32 bits frame lengths, since Version9
+
+	// explicitSeq, since Version10, sends the sequence number of the
+	// frames before them, so lost and reordered frames are told apart
+	explicitSeq bool
+
+	// counterNonces, since Version4, derives the nonces from the
+	// direction and the sequence number instead of reading them
+	counterNonces bool
+	direction     byte
+	frame         *[]byte // pooled buffer holding the data of buf, if any
+
+	maxSize int   // largest sealed message accepted
+	err     error // set once the stream can't be trusted anymore
+
+	pong     *SecureWriter  // answers the pings of the peer, if not nil
+	hb       *heartbeat     // nil when heartbeats are disabled
+	deadline *frameDeadline // nil without read timeout
+	trace    *frameTracer   // nil without Config.Trace
+	keyLog   *keyLogger     // nil without Config.KeyLog
+
+	keyMu         sync.Mutex // guards the key changes from RatchetState
+	ratchetSecret []byte     // of the ratchet step in progress, if any
+	ratchetSteps  uint64
+}
+
+// SecureWriter container to the io.Writer interface.
+// It is safe for concurrent use: each Write is written as a whole,
+// without interleaving with other writes.
+type SecureWriter struct {
+	mu    sync.Mutex // serializes the writes and frames
+	w     io.Writer
+	suite Suite
+	key   *[32]byte
+	aead  cipher.AEAD
+	seq   uint64 // sequence number of the next frame
+
+	padding  bool           // whether data frames are padded
+	long     bool           // 32 bits frame lengths, since Version9
+	maxSize  int            // largest sealed message sent
+	deadline *frameDeadline // nil without write timeout
+	trace    *frameTracer   // nil without Config.Trace
+	keyLog   *keyLogger     // nil without Config.KeyLog
+
+	// counterNonces, since Version4, derives the nonces from the
+	// direction and the sequence number instead of sending them
+	counterNonces bool
+	direction     byte
+	nonce         [24]byte
+	explicitSeq   bool // sends the sequence numbers, since Version10
+
+	sent          uint64    // bytes sealed with the current key
+	rekeyed       time.Time // when the current key started being used
+	rekeyBytes    uint64
+	rekeyInterval time.Duration
+
+	ratchetInterval time.Duration // between Diffie-Hellman ratchet steps, 0 to disable
+	ratcheted       time.Time     // when the last step started
+	ratchetPriv     *[32]byte     // our key of the step in progress, until answered
+	ratchetSecret   []byte        // of the answered step, until switching
+	ratchetSteps    uint64
+}
+
+// NewSecureReader instantiates a new SecureReader
+func NewSecureReader(r io.Reader, priv, pub *[32]byte) io.Reader {
+	key := &[32]byte{}
+	box.Precompute(key, pub, priv)
+	return newSecureReader(r, SuiteNaClBox, key)
+}
+
+// NewSecureWriter instantiates a new SecureWriter
+func NewSecureWriter(w io.Writer, priv, pub *[32]byte) io.Writer {
+	key := &[32]byte{}
+	box.Precompute(key, pub, priv)
+	return newSecureWriter(w, SuiteNaClBox, key)
+}
+
+// newSecureReader instantiates a SecureReader from a shared key.
+// The key is copied as the reader changes it when rekeying.
+func newSecureReader(r io.Reader, suite Suite, key *[32]byte) *SecureReader {
+	k := *key
+	aead, err := suite.newAEAD(&k)
+	if err != nil {
+		// Suites are validated during the handshake
+		panic(err)
+	}
+	return &SecureReader{r: r, suite: suite, key: &k, aead: aead, maxSize: math.MaxUint16}
+}
+
+// newSecureWriter instantiates a SecureWriter from a shared key.
+// The key is copied as the writer changes it when rekeying.
+func newSecureWriter(w io.Writer, suite Suite, key *[32]byte) *SecureWriter {
+	k := *key
+	aead, err := suite.newAEAD(&k)
+	if err != nil {
+		// Suites are validated during the handshake
+		panic(err)
+	}
+	return &SecureWriter{
+		w:             w,
+		suite:         suite,
+		key:           &k,
+		aead:          aead,
+		rekeyed:       time.Now(),
+		rekeyBytes:    defaultRekeyBytes,
+		rekeyInterval: defaultRekeyInterval,
+		maxSize:       math.MaxUint16,
+	}
+}
+
+// Read decrypts the next message from the underlying reader.
+// Messages larger than p are buffered and returned by the following calls.
+func (sr *SecureReader) Read(p []byte) (int, error) {
+	for len(sr.buf) == 0 {
+		msg, err := sr.nextData()
+		if err != nil {
+			return 0, err
+		}
+		sr.buf = msg
+	}
+	n := copy(p, sr.buf)
+	sr.buf = sr.buf[n:]
+	if len(sr.buf) == 0 {
+		sr.release()
+	}
+	return n, nil
+}
+
+// WriteTo writes the decrypted messages to w until EOF, without
+// going through an intermediate buffer. It implements io.WriterTo,
+// used by io.Copy.
+func (sr *SecureReader) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	msg := sr.buf
+	sr.buf = nil
+	for {
+		if len(msg) > 0 {
+			written, err := w.Write(msg)
+			n += int64(written)
+			if err != nil {
+				return n, err
+			}
+		}
+		sr.release()
+		var err error
+		if msg, err = sr.nextData(); err != nil {
+			if err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+	}
+}
+
+// nextData returns the payload of the next data frame, handling the
+// control frames received before it. Once a frame fails authentication
+// or is malformed, the same error is returned by all the next calls.
+func (sr *SecureReader) nextData() ([]byte, error) {
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	msg, err := sr.nextFrame()
+	if errors.Is(err, ErrMalformedFrame) || errors.Is(err, errDecrypt) || errors.Is(err, ErrReplay) || errors.Is(err, ErrFrameLost) {
+		sr.err = err
+	}
+	return msg, err
+}
+
+func (sr *SecureReader) nextFrame() ([]byte, error) {
+	for {
+		if sr.hb != nil {
+			sr.hb.armReadDeadline()
+		} else {
+			sr.deadline.arm()
+		}
+		typ, msg, err := sr.readMessage()
+		if err != nil {
+			if sr.hb != nil && sr.hb.timedOut(err) {
+				return nil, ErrPeerTimeout
+			}
+			return nil, err
+		}
+		var peerKey []byte
+		if typ == frameRatchet || typ == frameRatchetAck {
+			if len(msg) != 32 {
+				sr.release()
+				return nil, fmt.Errorf("%w: invalid ratchet key", ErrMalformedFrame)
+			}
+			peerKey = append([]byte(nil), msg...)
+		}
+		if typ != frameData {
+			// Control frames have no payload to keep
+			sr.release()
+		}
+		switch typ {
+		case frameData:
+			return msg, nil
+		case framePadded:
+			msg, err := unpad(msg)
+			if err != nil {
+				sr.release()
+			}
+			return msg, err
+		case frameRekey:
+			sr.keyMu.Lock()
+			sr.aead, err = ratchet(sr.suite, sr.key)
+			sr.keyMu.Unlock()
+			if err != nil {
+				return nil, err
+			}
+		case framePing:
+			if sr.pong != nil {
+				if err := sr.pong.writeControl(framePong, nil); err != nil {
+					return nil, err
+				}
+			}
+		case frameRatchet:
+			if err := sr.ratchetRequested(peerKey); err != nil {
+				return nil, err
+			}
+		case frameRatchetAck:
+			if sr.pong == nil {
+				return nil, fmt.Errorf("%w: unexpected ratchet answer", ErrMalformedFrame)
+			}
+			if err := sr.pong.ratchetAcked(peerKey); err != nil {
+				return nil, err
+			}
+		case frameRatchetSwitch:
+			if err := sr.switchRatchet(); err != nil {
+				return nil, err
+			}
+		case framePong:
+			// Receiving it was enough to extend the read deadline
+			if sr.hb != nil {
+				sr.hb.ponged()
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown frame type %d", ErrMalformedFrame, typ)
+		}
+	}
+}
+
+// readMessage reads and decrypts a frame, returning its type and payload.
+// The payload is held in a pooled buffer until the reader releases it.
+// The frame is read in two calls, one for the length and the nonce and
+// one for the sealed message, and opened without further copies.
+func (sr *SecureReader) readMessage() (byte, []byte, error) {
+	lengthSize := frameLengthSize(sr.long)
+	header := sr.header[:lengthSize+sr.aead.NonceSize()]
+	if sr.explicitSeq {
+		header = header[:lengthSize+8]
+	} else if sr.counterNonces {
+		header = header[:lengthSize]
+	}
+	_, err := io.ReadFull(sr.r, header)
+	if err == io.EOF {
+		return 0, nil, err
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("error reading message size and nonce: %w", err)
+	}
+	var length uint32
+	if sr.long {
+		length = binary.BigEndian.Uint32(header)
+	} else {
+		length = uint32(binary.BigEndian.Uint16(header))
+	}
+	seq := sr.seq
+	if sr.explicitSeq {
+		// The sequence number makes the nonce, so it's authenticated
+		seq = binary.BigEndian.Uint64(header[lengthSize:])
+	}
+	nonce := sr.header[lengthSize : lengthSize+sr.aead.NonceSize()]
+	if sr.counterNonces {
+		// A replayed or reordered frame fails authentication, as
+		// it was sealed with another nonce
+		counterNonce(nonce, sr.direction, seq)
+	}
+	// Check the length before reading the frame, which can't be
+	// empty as the frame type is sealed with the message
+	if length > uint32(sr.maxSize) {
+		return 0, nil, fmt.Errorf("%w: frame of %d bytes exceeds the maximum of %d", ErrMalformedFrame, length, sr.maxSize)
+	}
+	msgSize := int(length)
+	if msgSize < sr.aead.Overhead()+1 {
+		return 0, nil, fmt.Errorf("%w: frame of %d bytes is too short", ErrMalformedFrame, msgSize)
+	}
+
+	// The buffer is only taken once a frame arrives, so idle
+	// connections don't hold one
+	sr.frame = frameBuffer(msgSize)
+	buf := *sr.frame
+	msg := buf[:msgSize]
+	plain := len(buf) / 2
+	_, err = io.ReadFull(sr.r, msg)
+	if err != nil {
+		sr.release()
+		return 0, nil, fmt.Errorf("erro reading encrypted message: %w", err)
+	}
+
+	decryptedMsg, err := sr.aead.Open(buf[plain:plain], nonce, msg, nil)
+	if err != nil {
+		sr.release()
+		sr.trace.frame("", msgSize, seq, nil, errDecrypt)
+		return 0, nil, errDecrypt
+	}
+	// The nonce is authenticated, so its sequence number can be trusted
+	if seq := binary.BigEndian.Uint64(nonce[len(nonce)-8:]); seq != sr.seq {
+		sr.release()
+		err := ErrReplay
+		if seq > sr.seq && sr.explicitSeq {
+			err = fmt.Errorf("%w: %d frames missing before frame %d", ErrFrameLost, seq-sr.seq, seq)
+		}
+		sr.trace.frame(frameTypeName(decryptedMsg[0]), msgSize, seq, nil, err)
+		return 0, nil, err
+	}
+	sr.trace.frame(frameTypeName(decryptedMsg[0]), msgSize, sr.seq, decryptedMsg[1:], nil)
+	sr.seq++
+	return decryptedMsg[0], decryptedMsg[1:], nil
+}
+
+// release gives the buffer of the last frame back to the pool.
+func (sr *SecureReader) release() {
+	if sr.frame != nil {
+		putBuffer(sr.frame)
+		sr.frame = nil
+	}
+}
+
+// maxMessageSize is the largest message sealed in a single frame by
+// default, as the frame length is sent as an uint16 before Version9 and
+// the frame type takes one byte. All suites have the same overhead.
+const maxMessageSize = math.MaxUint16 - box.Overhead - 1
+
+// maxLargeFrameSize is the largest value of Config.MaxFrameSize, for
+// the 32 bits frame lengths of Version9.
+const maxLargeFrameSize = 16 << 20
+
+// frameLengthSize returns the size of the length of the frames, 32 bits
+// when long.
+func frameLengthSize(long bool) int {
+	if long {
+		return 4
+	}
+	return 2
+}
+
+// minFrameSize is the smallest value of Config.MaxFrameSize, leaving
+// room for the messages.
+const minFrameSize = 256
+
+// Write encrypts p and writes it to the underlying writer.
+// Messages larger than a frame are split into several frames,
+// written one after the other even when Write is called concurrently.
+func (sw *SecureWriter) Write(p []byte) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	var n int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > sw.maxChunk() {
+			chunk = chunk[:sw.maxChunk()]
+		}
+		if err := sw.writeData(chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// ReadFrom encrypts the data read from r until EOF, sealing each read
+// in its own frame of up to maxMessageSize bytes by default. It implements
+// io.ReaderFrom, used by io.Copy. Each frame is written as a whole,
+// but concurrent writes may come in between.
+func (sw *SecureWriter) ReadFrom(r io.Reader) (int64, error) {
+	var n int64
+	b := frameBuffer(sw.maxChunk())
+	defer putBuffer(b)
+	buf := (*b)[:sw.maxChunk()]
+	for {
+		read, err := r.Read(buf)
+		if read > 0 {
+			sw.mu.Lock()
+			werr := sw.writeData(buf[:read])
+			sw.mu.Unlock()
+			if werr != nil {
+				return n, werr
+			}
+			n += int64(read)
+		}
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// maxChunk returns the largest message sealed in a single data frame.
+func (sw *SecureWriter) maxChunk() int {
+	n := sw.maxSize - sw.aead.Overhead() - 1
+	if sw.padding {
+		// The length of the message takes two bytes
+		return min(n-2, math.MaxUint16)
+	}
+	return n
+}
+
+// writeData writes a data frame, rekeying first if needed.
+// The caller holds sw.mu.
+func (sw *SecureWriter) writeData(p []byte) error {
+	if sw.needsRekey() {
+		if err := sw.rekey(); err != nil {
+			return err
+		}
+	}
+	if sw.ratchetSecret != nil {
+		if err := sw.switchRatchet(); err != nil {
+			return err
+		}
+	} else if sw.ratchetInterval > 0 && sw.ratchetPriv == nil && time.Since(sw.ratcheted) >= sw.ratchetInterval {
+		if err := sw.startRatchet(); err != nil {
+			return err
+		}
+	}
+	if sw.padding {
+		return sw.writeFrame(framePadded, p)
+	}
+	return sw.writeFrame(frameData, p)
+}
+
+// writeControl writes a control frame, such as a ping.
+func (sw *SecureWriter) writeControl(typ byte, p []byte) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.writeFrame(typ, p)
+}
+
+// writeFrame seals p in a frame of the given type, built in a pooled
+// buffer and written with a single call.
+func (sw *SecureWriter) writeFrame(typ byte, p []byte) error {
+	size := sw.aead.Overhead() + 1 + len(p)
+	if typ == framePadded {
+		size = sw.aead.Overhead() + paddedSize(1+2+len(p), sw.maxSize-sw.aead.Overhead())
+	}
+	b := frameBuffer(size)
+	defer putBuffer(b)
+	buf := *b
+	plain := len(buf) / 2
+
+	// The nonce is made of random bytes followed by the
+	// sequence number of the frame, or only implied by the
+	// sequence number with counter nonces
+	header := frameLengthSize(sw.long)
+	var nonce []byte
+	if sw.counterNonces {
+		nonce = sw.nonce[:sw.aead.NonceSize()]
+		counterNonce(nonce, sw.direction, sw.seq)
+		if sw.explicitSeq {
+			binary.BigEndian.PutUint64(buf[header:], sw.seq)
+			header += 8
+		}
+	} else {
+		nonce = buf[header : header+sw.aead.NonceSize()]
+		if _, err := io.ReadFull(rand.Reader, nonce[:len(nonce)-8]); err != nil {
+			return err
+		}
+		binary.BigEndian.PutUint64(nonce[len(nonce)-8:], sw.seq)
+		header += len(nonce)
+	}
+	sw.seq++
+
+	plaintext := append(buf[plain:plain], typ)
+	if typ == framePadded {
+		plaintext = appendPadded(plaintext, p, sw.maxSize-sw.aead.Overhead())
+	} else {
+		plaintext = append(plaintext, p...)
+	}
+	encryptedMsg := sw.aead.Seal(buf[header:header], nonce, plaintext, nil)
+
+	// Message size is the length of the message and its type plus AEAD overhead
+	if sw.long {
+		binary.BigEndian.PutUint32(buf, uint32(len(encryptedMsg)))
+	} else {
+		binary.BigEndian.PutUint16(buf, uint16(len(encryptedMsg)))
+	}
+	sw.deadline.arm()
+	if _, err := sw.w.Write(buf[:header+len(encryptedMsg)]); err != nil {
+		sw.trace.frame(frameTypeName(typ), len(encryptedMsg), sw.seq-1, p, err)
+		return err
+	}
+	sw.trace.frame(frameTypeName(typ), len(encryptedMsg), sw.seq-1, p, nil)
+	sw.sent += uint64(len(p))
+	return nil
+}
+
+// counterNonce fills nonce with the direction of the frame, telling
+// the peers apart, and its sequence number.
+func counterNonce(nonce []byte, direction byte, seq uint64) {
+	clear(nonce)
+	nonce[0] = direction
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+}
+
+// Conn representation of the ReaderWriterCloser interface.
+// It also implements net.Conn by delegating to the wrapped connection.
+type Conn struct {
+	io.Reader
+	io.Writer
+	conn         net.Conn
+	hb           *heartbeat
+	peerIdentity ed25519.PublicKey
+	peerCert     *Certificate
+	protocol     string
+	version      uint8
+	suite        Suite
+	postQuantum  bool
+	initiator    bool
+	span         *connSpan
+}
+
+// Conn is a drop-in replacement for the underlying net.Conn
+var _ net.Conn = (*Conn)(nil)
+
+// Read reads decrypted data, see SecureReader.Read. The connection is
+// closed when a frame is malformed or fails authentication, as the
+// peer can't be trusted anymore.
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	if err != nil && err == c.Reader.(*SecureReader).err {
+		c.Close()
+	}
+	return n, err
+}
+
+// ReadFrom sends the data read from r until EOF, see SecureWriter.ReadFrom.
+func (c *Conn) ReadFrom(r io.Reader) (int64, error) {
+	return c.Writer.(*SecureWriter).ReadFrom(r)
+}
+
+// WriteTo writes the data received to w, see SecureReader.WriteTo.
+func (c *Conn) WriteTo(w io.Writer) (int64, error) {
+	return c.Reader.(*SecureReader).WriteTo(w)
+}
+
+// CloseWrite closes the writing side of the underlying connection,
+// once the frame being written is done, so the peer reads io.EOF.
+func (c *Conn) CloseWrite() error {
+	cw, ok := c.conn.(interface{ CloseWrite() error })
+	if !ok {
+		return errors.New("connection can't be half-closed")
+	}
+	sw := c.Writer.(*SecureWriter)
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return cw.CloseWrite()
+}
+
+// Close the underlying connection
+func (c *Conn) Close() error {
+	if c.hb != nil {
+		c.hb.stop()
+	}
+	c.span.end()
+	return c.conn.Close()
+}
+
+// PeerIdentity returns the identity key of the peer,
+// verified during the handshake.
+func (c *Conn) PeerIdentity() ed25519.PublicKey {
+	return c.peerIdentity
+}
+
+// PeerCertificate returns the certificate presented by the peer, if any.
+// It is only verified when Config.CertificateAuthorities is set.
+func (c *Conn) PeerCertificate() *Certificate {
+	return c.peerCert
+}
+
+// Protocol returns the application protocol selected by the server
+// among Config.Protocols, empty if none was offered.
+func (c *Conn) Protocol() string {
+	return c.protocol
+}
+
+// Version returns the protocol version negotiated during the handshake.
+func (c *Conn) Version() uint8 {
+	return c.version
+}
+
+// Suite returns the cipher suite negotiated during the handshake.
+func (c *Conn) Suite() Suite {
+	return c.suite
+}
+
+// PostQuantum tells whether the hybrid post-quantum key exchange
+// was used during the handshake.
+func (c *Conn) PostQuantum() bool {
+	return c.postQuantum
+}
+
+// Latency returns the round trip time of the last heartbeat answered by
+// the peer, including the time it took to read the ping, or zero when
+// heartbeats are disabled or none was answered yet.
+func (c *Conn) Latency() time.Duration {
+	if c.hb == nil {
+		return 0
+	}
+	return c.hb.latency()
+}
+
+// LocalAddr returns the local address of the underlying connection
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the underlying connection
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines of the underlying connection
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the underlying connection
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	if c.hb != nil {
+		c.hb.setReadDeadline(t)
+	}
+	if sr, ok := c.Reader.(*SecureReader); ok {
+		sr.deadline.setUser(t)
+	}
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the underlying connection
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	if sw, ok := c.Writer.(*SecureWriter); ok {
+		sw.deadline.setUser(t)
+	}
+	return c.conn.SetWriteDeadline(t)
+}
+
+// NewConnection return a connection to the server
+// and an interface to retrieve a public/private key pair
+// Most of this code was based on the examples
+// here: https://godoc.org/golang.org/x/crypto/nacl/box
+func NewConnection(c net.Conn) (*Conn, error) {
+	return newClientConn(context.Background(), c, c.RemoteAddr().String(), nil)
+}
+
+func newClientConn(ctx context.Context, c net.Conn, addr string, cfg *Config) (*Conn, error) {
+	_, span := cfg.startSpan(ctx, "secure.handshake", trace.SpanKindClient, c.RemoteAddr())
+	var hs *handshake
+	err := withContext(ctx, c, func() (err error) {
+		hs, err = clientHandshake(c, cfg)
+		return err
+	})
+	if err == nil {
+		span.SetAttributes(peerAttributes(hs)...)
+		err = cfg.verifyCertificate(hostOf(addr), hs.peerIdentity, hs.certificate)
+	}
+	if err == nil {
+		err = cfg.verifyServer(addr, hs.peerIdentity, hs.previous)
+	}
+	endSpan(span, err)
+	if err != nil {
+		return &Conn{}, err
+	}
+	return newConn(ctx, c, hs, cfg), nil
+}
+
+func newServerConn(ctx context.Context, c net.Conn, cfg *Config) (*Conn, error) {
+	_, span := cfg.startSpan(ctx, "secure.handshake", trace.SpanKindServer, c.RemoteAddr())
+	var hs *handshake
+	err := withContext(ctx, c, func() (err error) {
+		hs, err = serverHandshake(c, cfg)
+		return err
+	})
+	if err == nil {
+		span.SetAttributes(peerAttributes(hs)...)
+		err = cfg.verifyCertificate("", hs.peerIdentity, hs.certificate)
+	}
+	if err == nil {
+		err = cfg.verifyPeer(c.RemoteAddr().String(), hs.peerIdentity)
+	}
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(ctx, c, hs, cfg), nil
+}
+
+// newConn returns the connection established by hs, whose span, if
+// any, is a child of the one of ctx.
+func newConn(ctx context.Context, c net.Conn, hs *handshake, cfg *Config) *Conn {
+	if cfg != nil && cfg.Record != nil {
+		c = newRecordingConn(c, cfg.Record, hs)
+	}
+	sr := newSecureReader(c, hs.suite, hs.recvKey)
+	sw := newSecureWriter(c, hs.suite, hs.sendKey)
+	sw.padding = hs.version >= Version3 && cfg.padding()
+	if hs.version >= Version4 {
+		// The client sends direction 0 and the server direction 1
+		sr.counterNonces, sw.counterNonces = true, true
+		if hs.initiator {
+			sr.direction = 1
+		} else {
+			sw.direction = 1
+		}
+	}
+	sr.maxSize = cfg.maxFrameSize()
+	sw.maxSize = cfg.maxFrameSize()
+	if hs.version >= Version10 {
+		sr.explicitSeq, sw.explicitSeq = true, true
+	}
+	if hs.version >= Version9 {
+		sr.long, sw.long = true, true
+	} else {
+		sr.maxSize = min(sr.maxSize, math.MaxUint16)
+		sw.maxSize = min(sw.maxSize, math.MaxUint16)
+	}
+	readTimeout, writeTimeout := cfg.timeouts()
+	sr.deadline = newFrameDeadline(c.SetReadDeadline, readTimeout)
+	sw.deadline = newFrameDeadline(c.SetWriteDeadline, writeTimeout)
+	start := time.Now()
+	span := startConnSpan(ctx, c, hs, cfg)
+	sr.trace = newFrameTracer(cfg, false, start, span)
+	sw.trace = newFrameTracer(cfg, true, start, span)
+	sr.keyLog = newKeyLogger(cfg, hs)
+	sw.keyLog = sr.keyLog
+	if hs.version >= Version5 {
+		sw.ratchetInterval = cfg.ratchetInterval()
+		sw.ratcheted = time.Now()
+	}
+	var hb *heartbeat
+	if hs.version >= Version2 {
+		sr.pong = sw
+		if interval, misses := cfg.heartbeat(); interval > 0 {
+			hb = startHeartbeat(c, sw, interval, misses)
+			sr.hb = hb
+		}
+	}
+	return &Conn{
+		sr,
+		sw,
+		c,
+		hb,
+		hs.peerIdentity,
+		hs.certificate,
+		hs.protocol,
+		hs.version,
+		hs.suite,
+		hs.hybrid,
+		hs.initiator,
+		span,
+	}
+}
+
+// withContext runs fn, which does I/O on c, interrupting it with a
+// past deadline once ctx is done. The deadline of c is left as the
+// caller set it otherwise, since net.Conn can't tell what it was.
+func withContext(ctx context.Context, c net.Conn, fn func() error) error {
+	done := make(chan struct{})
+	interrupted := make(chan error, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Unix(1, 0))
+			interrupted <- ctx.Err()
+		case <-done:
+			interrupted <- nil
+		}
+	}()
+	err := fn()
+	close(done)
+	if ctxErr := <-interrupted; ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil && ctx.Err() != nil {
+		// The deadline of ctx expired before it was done
+		return ctx.Err()
+	}
+	return err
+}
+
+// Dial generates a private/public key pair,
+// connects to the server, perform the handshake
+// and return a reader/writer.
+func Dial(addr string) (io.ReadWriteCloser, error) {
+	return DialConfig(addr, nil)
+}
+
+// DialConfig is like Dial but uses the keys of the given config.
+func DialConfig(addr string, cfg *Config) (io.ReadWriteCloser, error) {
+	return DialConfigContext(context.Background(), addr, cfg)
+}
+
+// DialContext is like Dial but gives up connecting, and performing the
+// handshake, once ctx is done.
+func DialContext(ctx context.Context, addr string) (io.ReadWriteCloser, error) {
+	return DialConfigContext(ctx, addr, nil)
+}
+
+// DialConfigContext is like DialContext but uses the keys of the given
+// config. When the host resolves to several addresses, they are tried
+// concurrently and the first completing the handshake is used. addr can
+// also be a service name, like _secure._tcp.example.com, dialing the
+// targets of its SRV records, or the path of a Windows named pipe, like
+// \\.\pipe\secure.
+func DialConfigContext(ctx context.Context, addr string, cfg *Config) (io.ReadWriteCloser, error) {
+	ctx, span := cfg.tracer().Start(ctx, "secure.dial", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("server.address", addr)))
+	conn, err := dialConfig(ctx, addr, cfg)
+	endSpan(span, err)
+	return conn, err
+}
+
+func dialConfig(ctx context.Context, addr string, cfg *Config) (io.ReadWriteCloser, error) {
+	if IsServiceName(addr) {
+		return dialService(ctx, addr, cfg)
+	}
+	if IsNamedPipe(addr) {
+		conn, err := dialNamedPipe(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		c, err := newClientConn(ctx, conn, addr, cfg)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+	proxy, err := cfg.proxyFor(addr)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	if proxy == nil {
+		return dialEyeballs(ctx, &d, addr, cfg)
+	}
+	conn, err := dialProxy(ctx, &d, proxy, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", addr, err)
+	}
+	if err := cfg.tcp().apply(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to set the TCP options: %w", err)
+	}
+	c, err := newClientConn(ctx, conn, addr, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Serve serves the secure connections accepted on l with h,
+// EchoHandler if nil.
+func Serve(l net.Listener, h Handler) error {
+	return ServeConfig(l, nil, h)
+}
+
+// ServeConfig is like Serve but uses the keys of the given config.
+func ServeConfig(l net.Listener, cfg *Config, h Handler) error {
+	s := &Server{Config: cfg, Handler: h}
+	return s.Serve(l)
+}
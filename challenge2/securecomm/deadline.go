@@ -0,0 +1,47 @@
+package securecomm
+
+import (
+	"sync"
+	"time"
+)
+
+// frameDeadline bounds the time to read or write each frame with a
+// deadline of the underlying connection, keeping the deadline of the
+// user if it's earlier.
+type frameDeadline struct {
+	set     func(time.Time) error // SetReadDeadline or SetWriteDeadline
+	timeout time.Duration
+
+	mu   sync.Mutex
+	user time.Time // deadline set by the user
+}
+
+func newFrameDeadline(set func(time.Time) error, timeout time.Duration) *frameDeadline {
+	if timeout <= 0 {
+		return nil
+	}
+	return &frameDeadline{set: set, timeout: timeout}
+}
+
+// arm sets the deadline before a frame.
+func (d *frameDeadline) arm() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	deadline := time.Now().Add(d.timeout)
+	if !d.user.IsZero() && d.user.Before(deadline) {
+		deadline = d.user
+	}
+	d.set(deadline)
+}
+
+func (d *frameDeadline) setUser(t time.Time) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.user = t
+}
@@ -0,0 +1,66 @@
+package securecomm
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReadTimeout(t *testing.T) {
+	client, server := tcpConns(t, &Config{ReadTimeout: 50 * time.Millisecond}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	// Frames received in time are read
+	go server.Write([]byte("x"))
+	if _, err := client.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err := client.Read(make([]byte, 1))
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Unexpected result. Expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Unexpected result. The read timed out after %s", elapsed)
+	}
+}
+
+func TestReadTimeoutUserDeadline(t *testing.T) {
+	client, server := tcpConns(t, &Config{ReadTimeout: time.Minute}, nil)
+	defer client.Close()
+	defer server.Close()
+
+	// An earlier deadline set by the user wins
+	client.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	done := make(chan error)
+	go func() {
+		_, err := client.Read(make([]byte, 1))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("Unexpected result. Expected %v, got %v", os.ErrDeadlineExceeded, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Unexpected result. The deadline of the user was ignored.")
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	sw := newSecureWriter(c1, SuiteNaClBox, &[32]byte{})
+	sw.deadline = newFrameDeadline(c1.SetWriteDeadline, 50*time.Millisecond)
+
+	// Nobody reads the other end of the pipe
+	if _, err := sw.Write([]byte("hello")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Unexpected result. Expected %v, got %v", os.ErrDeadlineExceeded, err)
+	}
+}
@@ -0,0 +1,26 @@
+package securecomm
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// ServeDebug serves the pprof profiles of the process on addr, at
+// /debug/pprof/, to diagnose a running server, like the goroutines left
+// by its connections. Only loopback addresses are accepted.
+func ServeDebug(addr string) error {
+	if err := checkLoopback(addr); err != nil {
+		return err
+	}
+	return http.ListenAndServe(addr, debugHandler())
+}
+
+func debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
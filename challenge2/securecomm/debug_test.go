@@ -0,0 +1,29 @@
+package securecomm
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugHandler(t *testing.T) {
+	srv := httptest.NewServer(debugHandler())
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL + "/debug/pprof/goroutine?debug=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || !strings.Contains(string(body), "TestDebugHandler") {
+		t.Fatalf("Unexpected result. Got status %d and the profile:\n%s", resp.StatusCode, body)
+	}
+	if err := ServeDebug("0.0.0.0:0"); err == nil {
+		t.Fatal("Unexpected result. Served on all the interfaces")
+	}
+}
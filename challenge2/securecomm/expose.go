@@ -0,0 +1,184 @@
+package securecomm
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Reverse proxying, like ngrok: the client registers a local service
+// over a control connection, asking for a port. The server listens on
+// it and, for each public connection, sends a random id on the control
+// connection. The client then opens a data connection announcing that
+// id and relays it to the local service.
+//
+// Secure connections start with their kind, then
+//
+//	control: [uint16 port] -> [uint16 public port] <- [id]... <-
+//	data:    [id] ->
+const (
+	tunnelControl byte = iota
+	tunnelData
+)
+
+// exposeServer matches the data connections of the clients to the
+// public connections waiting for them.
+type exposeServer struct {
+	mu      sync.Mutex
+	pending map[[8]byte]net.Conn
+	logger  Logger
+}
+
+// ServeExpose runs the server side of the reverse proxying, accepting
+// the control and data connections of the clients on l, a secure
+// listener.
+func ServeExpose(l net.Listener, logger Logger) error {
+	s := &exposeServer{pending: make(map[[8]byte]net.Conn), logger: logger}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *exposeServer) handle(conn net.Conn) {
+	var kind [1]byte
+	if _, err := io.ReadFull(conn, kind[:]); err != nil {
+		conn.Close()
+		return
+	}
+	switch kind[0] {
+	case tunnelControl:
+		s.control(conn)
+	case tunnelData:
+		var id [8]byte
+		if _, err := io.ReadFull(conn, id[:]); err != nil {
+			conn.Close()
+			return
+		}
+		public := s.take(id)
+		if public == nil {
+			conn.Close()
+			return
+		}
+		splice(public, conn)
+	default:
+		conn.Close()
+	}
+}
+
+// control exposes a public port until the client closes conn.
+func (s *exposeServer) control(conn net.Conn) {
+	defer conn.Close()
+
+	var port [2]byte
+	if _, err := io.ReadFull(conn, port[:]); err != nil {
+		return
+	}
+	public, err := net.Listen("tcp", fmt.Sprintf(":%d", binary.BigEndian.Uint16(port[:])))
+	if err != nil {
+		s.logger.Warn("expose failed", "peer", conn.RemoteAddr().String(), "err", err)
+		return
+	}
+	defer public.Close()
+	binary.BigEndian.PutUint16(port[:], uint16(public.Addr().(*net.TCPAddr).Port))
+	if _, err := conn.Write(port[:]); err != nil {
+		return
+	}
+	s.logger.Info("service exposed", "peer", conn.RemoteAddr().String(), "addr", public.Addr().String())
+
+	// The client never writes again, reading only notices it is gone
+	go func() {
+		io.Copy(io.Discard, conn)
+		public.Close()
+	}()
+	for {
+		c, err := public.Accept()
+		if err != nil {
+			s.logger.Info("service closed", "peer", conn.RemoteAddr().String(), "addr", public.Addr().String())
+			return
+		}
+		var id [8]byte
+		rand.Read(id[:])
+		s.mu.Lock()
+		s.pending[id] = c
+		s.mu.Unlock()
+		// Don't keep the connection forever if the client never comes
+		time.AfterFunc(handshakeTimeout, func() {
+			if c := s.take(id); c != nil {
+				c.Close()
+			}
+		})
+		if _, err := conn.Write(id[:]); err != nil {
+			return
+		}
+	}
+}
+
+// take removes and returns the public connection waiting for id, if any.
+func (s *exposeServer) take(id [8]byte) net.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.pending[id]
+	delete(s.pending, id)
+	return c
+}
+
+// RegisterService asks the server at addr to listen on port, any if 0,
+// for the connections to the service. It returns the control connection
+// and the port the server listens on.
+func RegisterService(addr string, port int, cfg *Config) (net.Conn, int, error) {
+	conn, err := DialConfig(addr, cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+	var msg [3]byte
+	msg[0] = tunnelControl
+	binary.BigEndian.PutUint16(msg[1:], uint16(port))
+	if _, err := conn.Write(msg[:]); err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	if _, err := io.ReadFull(conn, msg[1:]); err != nil {
+		conn.Close()
+		return nil, 0, fmt.Errorf("registering service: %w", err)
+	}
+	return conn.(*Conn), int(binary.BigEndian.Uint16(msg[1:])), nil
+}
+
+// RelayService relays the connections announced on the control
+// connection ctrl to the local service at local, until ctrl is closed.
+func RelayService(ctrl net.Conn, addr, local string, cfg *Config) error {
+	defer ctrl.Close()
+	for {
+		var msg [9]byte
+		if _, err := io.ReadFull(ctrl, msg[1:]); err != nil {
+			return err
+		}
+		msg[0] = tunnelData
+		go func() {
+			conn, err := DialConfig(addr, cfg)
+			if err != nil {
+				cfg.logger().Warn("relay failed", "server", addr, "err", err)
+				return
+			}
+			if _, err := conn.Write(msg[:]); err != nil {
+				conn.Close()
+				return
+			}
+			svc, err := net.Dial("tcp", local)
+			if err != nil {
+				cfg.logger().Warn("relay failed", "service", local, "err", err)
+				conn.Close()
+				return
+			}
+			splice(svc, conn.(*Conn))
+		}()
+	}
+}
@@ -0,0 +1,79 @@
+package securecomm
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestExpose(t *testing.T) {
+	// A plain echo service, local to the client
+	svc, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Close()
+	go func() {
+		for {
+			c, err := svc.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewListener(inner, nil)
+	defer server.Close()
+	go ServeExpose(server, discardLogger)
+
+	ctrl, port, err := RegisterService(server.Addr().String(), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctrl.Close()
+	go RelayService(ctrl, server.Addr().String(), svc.Addr().String(), nil)
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := fmt.Sprintf("hello %d\n", i)
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		conn.(*net.TCPConn).CloseWrite()
+		got, err := io.ReadAll(conn)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != msg {
+			t.Fatalf("Unexpected result.\nGot:\t\t%s\nExpected:\t%s\n", got, msg)
+		}
+	}
+
+	// Closing the control connection stops exposing the service
+	ctrl.Close()
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if i == 100 {
+			t.Fatal("Unexpected result. The service is still exposed.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,137 @@
+package securecomm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// attemptDelay is the delay before the next connection attempt when
+// the previous ones are still in progress, as recommended by RFC 8305.
+const attemptDelay = 250 * time.Millisecond
+
+// dialEyeballs resolves the host of addr and races the connection
+// attempts to its addresses, see raceAttempts.
+func dialEyeballs(ctx context.Context, d *net.Dialer, addr string, cfg *Config) (*Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", addr, err)
+	}
+	if net.ParseIP(host) != nil {
+		return dialAttempt(ctx, d, addr, addr, cfg)
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", addr, err)
+	}
+	var targets []string
+	for _, ip := range interleaveFamilies(ips) {
+		targets = append(targets, net.JoinHostPort(ip.String(), port))
+	}
+	return raceAttempts(ctx, d, targets, addr, cfg)
+}
+
+// interleaveFamilies orders the addresses alternating IPv6 and IPv4,
+// starting with IPv6, so one broken family doesn't delay the other.
+func interleaveFamilies(ips []net.IPAddr) []net.IPAddr {
+	var v4, v6 []net.IPAddr
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	ordered := make([]net.IPAddr, 0, len(ips))
+	for len(v4) > 0 || len(v6) > 0 {
+		if len(v6) > 0 {
+			ordered = append(ordered, v6[0])
+			v6 = v6[1:]
+		}
+		if len(v4) > 0 {
+			ordered = append(ordered, v4[0])
+			v4 = v4[1:]
+		}
+	}
+	return ordered
+}
+
+// raceAttempts connects to the targets in order, starting the next
+// attempt when the previous one fails or after attemptDelay, and returns
+// the first connection completing the handshake, aborting the others.
+// The server is verified as addr. The error of the first attempt is
+// returned when they all fail.
+func raceAttempts(ctx context.Context, d *net.Dialer, targets []string, addr string, cfg *Config) (*Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	results := make(chan result, len(targets))
+	next, pending := 0, 0
+	start := func() {
+		go func(target string) {
+			c, err := dialAttempt(ctx, d, target, addr, cfg)
+			results <- result{c, err}
+		}(targets[next])
+		next++
+		pending++
+	}
+	timer := time.NewTimer(attemptDelay)
+	defer timer.Stop()
+
+	var firstErr error
+	start()
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				cancel()
+				go func(pending int) {
+					for range pending {
+						if r := <-results; r.err == nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(targets) {
+				start()
+				timer.Reset(attemptDelay)
+			}
+		case <-timer.C:
+			if next < len(targets) {
+				start()
+				timer.Reset(attemptDelay)
+			}
+		}
+	}
+	return nil, firstErr
+}
+
+// dialAttempt connects to target and runs the handshake, verifying the
+// server as addr.
+func dialAttempt(ctx context.Context, d *net.Dialer, target, addr string, cfg *Config) (*Conn, error) {
+	conn, err := d.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", addr, err)
+	}
+	if err := cfg.tcp().apply(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to set the TCP options: %w", err)
+	}
+	c, err := newClientConn(ctx, conn, addr, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,88 @@
+package securecomm
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestInterleaveFamilies(t *testing.T) {
+	var ips []net.IPAddr
+	for _, s := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "::1", "::2"} {
+		ips = append(ips, net.IPAddr{IP: net.ParseIP(s)})
+	}
+	var got []string
+	for _, ip := range interleaveFamilies(ips) {
+		got = append(got, ip.String())
+	}
+	want := []string{"::1", "10.0.0.1", "::2", "10.0.0.2", "10.0.0.3"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRaceAttempts(t *testing.T) {
+	addr, _ := startServer(t, &Server{})
+
+	// Accepts connections but never completes the handshake
+	stalled, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalled.Close()
+	go func() {
+		for {
+			c, err := stalled.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	// Refuses connections
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := closed.Addr().String()
+	closed.Close()
+
+	var d net.Dialer
+	start := time.Now()
+	conn, err := raceAttempts(context.Background(), &d, []string{refused, stalled.Addr().String(), addr}, addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if elapsed := time.Since(start); elapsed > 5*attemptDelay {
+		t.Errorf("connected after %s", elapsed)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := conn.Read(buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("got %q, %v", buf, err)
+	}
+
+	if _, err := raceAttempts(context.Background(), &d, []string{refused, refused}, refused, nil); err == nil {
+		t.Fatal("dialing only refusing addresses succeeded")
+	}
+}
+
+func TestDialHostName(t *testing.T) {
+	addr, _ := startServer(t, &Server{})
+	_, port, _ := net.SplitHostPort(addr)
+	conn, err := Dial(net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,286 @@
+package securecomm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// defaultCheckInterval is the interval between the health checks of the
+// servers preferred to the current one, for failing back.
+const defaultCheckInterval = 30 * time.Second
+
+// Failover connects to the first reachable of a list of servers, in
+// order of preference. When the connection is lost, it fails over to the
+// next reachable server, and it fails back to a preferred server once its
+// health check succeeds again.
+//
+// Each server gets a new secure session: the data in flight when the
+// connection is lost or switched is lost, so the application must be
+// able to resume, see OnSwitch.
+type Failover struct {
+	// Addrs lists the servers, host:port or service names, in order of
+	// preference.
+	Addrs []string
+
+	// Config configures the connections to the servers, with a
+	// heartbeat interval of 5 seconds if it sets none.
+	Config *Config
+
+	// CheckInterval is the interval between the health checks of the
+	// servers preferred to the current one, 30 seconds if zero. A
+	// server is healthy when it completes the handshake and answers a
+	// heartbeat.
+	CheckInterval time.Duration
+
+	// OnSwitch, if not nil, is called with the address of the server
+	// after failing over or back to it.
+	OnSwitch func(addr string)
+}
+
+// FailoverConn is a connection to one of the servers of a Failover,
+// switching servers transparently to its reads and writes.
+type FailoverConn struct {
+	f        *Failover
+	cfg      *Config
+	interval time.Duration
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	mu    sync.Mutex
+	conn  *Conn
+	index int   // of the server in f.Addrs
+	err   error // once closed or no server is reachable
+}
+
+// Dial connects to the first reachable server.
+func (f *Failover) Dial(ctx context.Context) (*FailoverConn, error) {
+	if len(f.Addrs) == 0 {
+		return nil, fmt.Errorf("no server to dial")
+	}
+	// The heartbeats detect the loss of the connection and check the
+	// health of the servers
+	fc := &FailoverConn{f: f, cfg: WithHeartbeat(f.Config), interval: f.CheckInterval}
+	if fc.interval <= 0 {
+		fc.interval = defaultCheckInterval
+	}
+
+	var err error
+	for i, addr := range f.Addrs {
+		var conn *Conn
+		if conn, err = fc.dial(ctx, addr); err == nil {
+			fc.conn, fc.index = conn, i
+			break
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if fc.conn == nil {
+		return nil, fmt.Errorf("no server reachable, last error: %w", err)
+	}
+	fc.ctx, fc.cancel = context.WithCancel(context.Background())
+	go fc.checkPreferred()
+	return fc, nil
+}
+
+func (fc *FailoverConn) dial(ctx context.Context, addr string) (*Conn, error) {
+	conn, err := DialConfigContext(ctx, addr, fc.cfg)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*Conn), nil
+}
+
+// Addr returns the address of the server currently connected.
+func (fc *FailoverConn) Addr() string {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.f.Addrs[fc.index]
+}
+
+func (fc *FailoverConn) current() (*Conn, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.conn, fc.err
+}
+
+// Read reads from the current server, failing over when the connection
+// is lost, including when the server closes it.
+func (fc *FailoverConn) Read(p []byte) (int, error) {
+	for {
+		conn, err := fc.current()
+		if err != nil {
+			return 0, err
+		}
+		n, err := conn.Read(p)
+		if err == nil {
+			return n, nil
+		}
+		if err := fc.failover(conn); err != nil {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// Write writes to the current server, failing over and writing p again
+// to the next server when the connection is lost.
+func (fc *FailoverConn) Write(p []byte) (int, error) {
+	for {
+		conn, err := fc.current()
+		if err != nil {
+			return 0, err
+		}
+		n, err := conn.Write(p)
+		if err == nil {
+			return n, nil
+		}
+		if err := fc.failover(conn); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// failover replaces the failed connection by one to the first reachable
+// server, the failed server last. It does nothing when the connection was
+// already replaced, by a concurrent call or by failing back.
+func (fc *FailoverConn) failover(failed *Conn) error {
+	fc.mu.Lock()
+	if fc.err != nil {
+		fc.mu.Unlock()
+		return fc.err
+	}
+	if fc.conn != failed {
+		fc.mu.Unlock()
+		return nil
+	}
+	failed.Close()
+	order := make([]int, 0, len(fc.f.Addrs))
+	for i := range fc.f.Addrs {
+		if i != fc.index {
+			order = append(order, i)
+		}
+	}
+	order = append(order, fc.index)
+
+	var err error
+	for _, i := range order {
+		var conn *Conn
+		if conn, err = fc.dial(fc.ctx, fc.f.Addrs[i]); err == nil {
+			fc.conn, fc.index = conn, i
+			fc.mu.Unlock()
+			fc.switched(i)
+			return nil
+		}
+		if fc.ctx.Err() != nil {
+			err = net.ErrClosed
+			break
+		}
+	}
+	fc.err = fmt.Errorf("no server reachable, last error: %w", err)
+	fc.mu.Unlock()
+	return fc.err
+}
+
+func (fc *FailoverConn) switched(index int) {
+	fc.cfg.logger().Info("switched server", "addr", fc.f.Addrs[index])
+	if fc.f.OnSwitch != nil {
+		fc.f.OnSwitch(fc.f.Addrs[index])
+	}
+}
+
+// checkPreferred checks the health of the servers preferred to the
+// current one at regular intervals, failing back to the first healthy.
+func (fc *FailoverConn) checkPreferred() {
+	t := time.NewTicker(fc.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-fc.ctx.Done():
+			return
+		case <-t.C:
+		}
+		fc.mu.Lock()
+		index := fc.index
+		fc.mu.Unlock()
+		for i := range index {
+			if fc.healthCheck(fc.f.Addrs[i]) != nil {
+				continue
+			}
+			conn, err := fc.dial(fc.ctx, fc.f.Addrs[i])
+			if err != nil {
+				continue
+			}
+			fc.failBack(conn, i)
+			break
+		}
+	}
+}
+
+// healthCheck tells whether the server at addr completes the handshake
+// and answers a heartbeat before missing too many, if its version has
+// heartbeats.
+func (fc *FailoverConn) healthCheck(addr string) error {
+	conn, err := fc.dial(fc.ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if conn.hb == nil {
+		// The version of the server has no heartbeat, the handshake
+		// is all there is to check
+		return nil
+	}
+	// Reading processes the pongs
+	go io.Copy(io.Discard, conn)
+
+	interval, misses := fc.cfg.heartbeat()
+	timer := time.NewTimer(interval * time.Duration(misses+1))
+	defer timer.Stop()
+	select {
+	case <-conn.hb.answered:
+		return nil
+	case <-timer.C:
+		return ErrPeerTimeout
+	case <-fc.ctx.Done():
+		return net.ErrClosed
+	}
+}
+
+// failBack switches to conn, to the preferred server at index, unless the
+// connection was closed or already switched to a server as preferred.
+func (fc *FailoverConn) failBack(conn *Conn, index int) {
+	fc.mu.Lock()
+	if fc.err != nil || fc.index <= index {
+		fc.mu.Unlock()
+		conn.Close()
+		return
+	}
+	old := fc.conn
+	fc.conn, fc.index = conn, index
+	fc.mu.Unlock()
+	// The reads and writes in progress fail and continue on conn
+	old.Close()
+	fc.switched(index)
+}
+
+// Close closes the connection to the current server and stops the
+// health checks.
+func (fc *FailoverConn) Close() error {
+	fc.cancel()
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.err != nil {
+		// Closed already, or no server was reachable
+		fc.err = net.ErrClosed
+		return nil
+	}
+	fc.err = net.ErrClosed
+	return fc.conn.Close()
+}
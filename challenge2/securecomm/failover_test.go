@@ -0,0 +1,157 @@
+package securecomm
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFailover(t *testing.T) {
+	primary := &Server{}
+	primaryAddr, _ := startServer(t, primary)
+	backup := &Server{}
+	backupAddr, _ := startServer(t, backup)
+	defer backup.Close()
+
+	switched := make(chan string, 2)
+	f := &Failover{
+		Addrs:         []string{primaryAddr, backupAddr},
+		Config:        &Config{HeartbeatInterval: 20 * time.Millisecond},
+		CheckInterval: 50 * time.Millisecond,
+		OnSwitch:      func(addr string) { switched <- addr },
+	}
+	conn, err := f.Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.Addr() != primaryAddr {
+		t.Fatalf("Unexpected result. Connected to %s, expected %s", conn.Addr(), primaryAddr)
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	echo := func(msg string) {
+		t.Helper()
+		if _, err := io.WriteString(conn, msg+"\n"); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-lines; got != msg {
+			t.Fatalf("Unexpected result.\nGot:\t\t%s\nExpected:\t%s\n", got, msg)
+		}
+	}
+	echo("hello primary")
+
+	// The primary goes down, the connection fails over to the backup
+	primary.Close()
+	if addr := <-switched; addr != backupAddr {
+		t.Fatalf("Unexpected result. Failed over to %s, expected %s", addr, backupAddr)
+	}
+	echo("hello backup")
+
+	// The primary comes back, the connection fails back to it
+	l, err := net.Listen("tcp", primaryAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := &Server{}
+	go restarted.Serve(l)
+	defer restarted.Close()
+	select {
+	case addr := <-switched:
+		if addr != primaryAddr {
+			t.Fatalf("Unexpected result. Failed back to %s, expected %s", addr, primaryAddr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Unexpected result. The connection did not fail back")
+	}
+	echo("hello again")
+
+	conn.Close()
+	if _, ok := <-lines; ok {
+		t.Fatal("Unexpected result. Read after Close succeeded")
+	}
+}
+
+func TestFailoverUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	f := &Failover{Addrs: []string{addr, addr}}
+	if _, err := f.Dial(context.Background()); err == nil {
+		t.Fatal("Unexpected result. Dialing unreachable servers succeeded")
+	}
+
+	s := &Server{}
+	up, _ := startServer(t, s)
+	f.Addrs = []string{addr, up}
+	conn, err := f.Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// No server is left once the only reachable one goes down
+	s.Close()
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Unexpected result. Read succeeded with no server reachable")
+	}
+	if _, err := conn.Write([]byte("hello")); err == nil {
+		t.Fatal("Unexpected result. Write succeeded with no server reachable")
+	}
+}
+
+func TestFailbackVersion1(t *testing.T) {
+	// The preferred server is down at first
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	primaryAddr := l.Addr().String()
+	l.Close()
+	backup := &Server{}
+	backupAddr, _ := startServer(t, backup)
+	defer backup.Close()
+
+	switched := make(chan string, 1)
+	f := &Failover{
+		Addrs:         []string{primaryAddr, backupAddr},
+		Config:        &Config{HeartbeatInterval: 20 * time.Millisecond},
+		CheckInterval: 50 * time.Millisecond,
+		OnSwitch:      func(addr string) { switched <- addr },
+	}
+	conn, err := f.Dial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// It comes back without heartbeats, which the handshake stands for
+	if l, err = net.Listen("tcp", primaryAddr); err != nil {
+		t.Fatal(err)
+	}
+	primary := &Server{Config: &Config{MaxVersion: Version1}}
+	go primary.Serve(l)
+	defer primary.Close()
+	select {
+	case addr := <-switched:
+		if addr != primaryAddr {
+			t.Fatalf("Unexpected result. Failed back to %s, expected %s", addr, primaryAddr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Unexpected result. The connection did not fail back")
+	}
+}
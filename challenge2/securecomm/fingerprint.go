@@ -0,0 +1,57 @@
+package securecomm
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// fingerprintEmoji are the 64 emoji of the short authentication strings
+// of Matrix, easy to compare aloud.
+var fingerprintEmoji = [64]struct{ emoji, name string }{
+	{"🐶", "Dog"}, {"🐱", "Cat"}, {"🦁", "Lion"}, {"🐎", "Horse"},
+	{"🦄", "Unicorn"}, {"🐷", "Pig"}, {"🐘", "Elephant"}, {"🐰", "Rabbit"},
+	{"🐼", "Panda"}, {"🐓", "Rooster"}, {"🐧", "Penguin"}, {"🐢", "Turtle"},
+	{"🐟", "Fish"}, {"🐙", "Octopus"}, {"🦋", "Butterfly"}, {"🌷", "Flower"},
+	{"🌳", "Tree"}, {"🌵", "Cactus"}, {"🍄", "Mushroom"}, {"🌏", "Globe"},
+	{"🌙", "Moon"}, {"☁️", "Cloud"}, {"🔥", "Fire"}, {"🍌", "Banana"},
+	{"🍎", "Apple"}, {"🍓", "Strawberry"}, {"🌽", "Corn"}, {"🍕", "Pizza"},
+	{"🎂", "Cake"}, {"❤️", "Heart"}, {"😀", "Smiley"}, {"🤖", "Robot"},
+	{"🎩", "Hat"}, {"👓", "Glasses"}, {"🔧", "Spanner"}, {"🎅", "Santa"},
+	{"👍", "Thumbs Up"}, {"☂️", "Umbrella"}, {"⌛", "Hourglass"}, {"⏰", "Clock"},
+	{"🎁", "Gift"}, {"💡", "Light Bulb"}, {"📕", "Book"}, {"✏️", "Pencil"},
+	{"📎", "Paperclip"}, {"✂️", "Scissors"}, {"🔒", "Lock"}, {"🔑", "Key"},
+	{"🔨", "Hammer"}, {"☎️", "Telephone"}, {"🏁", "Flag"}, {"🚂", "Train"},
+	{"🚲", "Bicycle"}, {"✈️", "Aeroplane"}, {"🚀", "Rocket"}, {"🏆", "Trophy"},
+	{"⚽", "Ball"}, {"🎸", "Guitar"}, {"🎺", "Trumpet"}, {"🔔", "Bell"},
+	{"⚓", "Anchor"}, {"🎧", "Headphones"}, {"📁", "Folder"}, {"📌", "Pin"},
+}
+
+// FingerprintHex returns the SHA-256 hash of an identity key in hex,
+// grouped by 4 digits.
+func FingerprintHex(identity ed25519.PublicKey) string {
+	sum := sha256.Sum256(identity)
+	h := hex.EncodeToString(sum[:])
+	groups := make([]string, 0, len(h)/4)
+	for i := 0; i < len(h); i += 4 {
+		groups = append(groups, h[i:i+4])
+	}
+	return strings.Join(groups, " ")
+}
+
+// FingerprintEmoji returns the first 42 bits of the SHA-256 hash of an
+// identity key as 7 emoji and their names.
+func FingerprintEmoji(identity ed25519.PublicKey) string {
+	sum := sha256.Sum256(identity)
+	var bits uint64
+	for _, b := range sum[:6] {
+		bits = bits<<8 | uint64(b)
+	}
+	words := make([]string, 7)
+	for i := range words {
+		e := fingerprintEmoji[bits>>(42-6*uint(i))&63]
+		words[i] = e.emoji + " " + e.name
+	}
+	return strings.Join(words, ", ")
+}
@@ -0,0 +1,23 @@
+package securecomm
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+)
+
+func TestFingerprintEncodings(t *testing.T) {
+	id := ed25519.PublicKey(make([]byte, ed25519.PublicKeySize))
+
+	// SHA-256 of 32 zero bytes
+	expected := "66687aadf862bd776c8fc18b8e9f8e20089714856ee233b3902a591d0d5f2925"
+	if got := FingerprintHex(id); strings.ReplaceAll(got, " ", "") != expected || len(strings.Fields(got)) != 16 {
+		t.Fatalf("Unexpected result.\nGot:\t\t%s\nExpected:\t%s\n", got, expected)
+	}
+
+	// 0x66687aadf862 starts with the 6-bit groups 25, 38, 33, 58, 43, 31, 33
+	emoji := "🍓 Strawberry, ⌛ Hourglass, 👓 Glasses, 🎺 Trumpet, ✏️ Pencil, 🤖 Robot, 👓 Glasses"
+	if got := FingerprintEmoji(id); got != emoji {
+		t.Fatalf("Unexpected result.\nGot:\t\t%s\nExpected:\t%s\n", got, emoji)
+	}
+}
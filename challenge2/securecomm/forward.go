@@ -0,0 +1,75 @@
+package securecomm
+
+import (
+	"io"
+	"net"
+)
+
+// Port forwarding, like ssh -L: the client listens on a local port and
+// forwards each connection through a secure connection to the server,
+// which connects it to its target.
+
+// splice copies between a and b in both directions until both are done,
+// then closes them.
+func splice(a, b io.ReadWriteCloser) {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(a, b)
+		CloseWrite(a)
+		close(done)
+	}()
+	io.Copy(b, a)
+	CloseWrite(b)
+	<-done
+	a.Close()
+	b.Close()
+}
+
+// ForwardToTarget connects the secure connections accepted by l to
+// target.
+func ForwardToTarget(l net.Listener, target string, logger Logger) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			t, err := net.Dial("tcp", target)
+			if err != nil {
+				logger.Warn("forward failed", "peer", conn.RemoteAddr().String(), "target", target, "err", err)
+				conn.Close()
+				return
+			}
+			splice(conn, t)
+		}()
+	}
+}
+
+// ForwardToServer forwards the plain connections accepted by l through
+// secure connections to the server at addr.
+func ForwardToServer(l net.Listener, addr string, cfg *Config) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			s, err := DialConfig(addr, cfg)
+			if err != nil {
+				cfg.logger().Warn("forward failed", "peer", conn.RemoteAddr().String(), "server", addr, "err", err)
+				conn.Close()
+				return
+			}
+			splice(conn, s.(*Conn))
+		}()
+	}
+}
+
+// CloseWrite closes the writing side of conn, so the peer reads io.EOF,
+// or all of it if it can't be half-closed.
+func CloseWrite(conn io.Closer) error {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return conn.Close()
+}
@@ -0,0 +1,62 @@
+package securecomm
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestForward(t *testing.T) {
+	// A plain echo target
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			c, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewListener(inner, nil)
+	defer server.Close()
+	go ForwardToTarget(server, target.Addr().String(), discardLogger)
+
+	local, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	go ForwardToServer(local, server.Addr().String(), nil)
+
+	conn, err := net.Dial("tcp", local.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello world\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+
+	// The target echoes the data then closes the connection
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello world\n" {
+		t.Fatalf("Unexpected result.\nGot:\t\t%s\nExpected:\t%s\n", got, "hello world\n")
+	}
+}
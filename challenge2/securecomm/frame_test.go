@@ -0,0 +1,210 @@
+package securecomm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestMalformedFrames(t *testing.T) {
+	key := &[32]byte{'k', 'e', 'y'}
+
+	// frame returns a frame header declaring size bytes, followed by data
+	frame := func(size uint16, data []byte) []byte {
+		b := binary.BigEndian.AppendUint16(nil, size)
+		b = append(b, make([]byte, 24)...)
+		return append(b, data...)
+	}
+
+	tData := []struct {
+		name    string
+		wire    []byte
+		maxSize int
+	}{
+		{"empty", frame(0, nil), 0},
+		{"overhead only", frame(16, make([]byte, 16)), 0},
+		{"too large", frame(1000, make([]byte, 1000)), 512},
+		{"garbage", frame(100, bytes.Repeat([]byte{0xff}, 100)), 0},
+	}
+
+	for _, exp := range tData {
+		sr := newSecureReader(bytes.NewReader(exp.wire), SuiteNaClBox, key)
+		if exp.maxSize != 0 {
+			sr.maxSize = exp.maxSize
+		}
+		_, err := sr.Read(make([]byte, 10))
+		if !errors.Is(err, ErrMalformedFrame) && !errors.Is(err, errDecrypt) {
+			t.Fatalf("%s: unexpected error %v", exp.name, err)
+		}
+		// The error sticks
+		if _, again := sr.Read(make([]byte, 10)); again != err {
+			t.Fatalf("%s: unexpected error on the next read %v", exp.name, again)
+		}
+	}
+}
+
+func TestMaxFrameSize(t *testing.T) {
+	key := &[32]byte{'k', 'e', 'y'}
+
+	var wire bytes.Buffer
+	sw := newSecureWriter(&wire, SuiteNaClBox, key)
+	sw.maxSize = 512
+	msg := bytes.Repeat([]byte{'x'}, 2000)
+	if _, err := sw.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	sr := newSecureReader(&wire, SuiteNaClBox, key)
+	sr.maxSize = 512
+	got, err := io.ReadAll(sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("Unexpected result: got %d bytes, expected %d", len(got), len(msg))
+	}
+}
+
+func TestConnClosedOnGarbage(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// The server answers the handshake with garbage
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if _, err := serverHandshake(c, nil); err != nil {
+			t.Error(err)
+			return
+		}
+		c.Write(bytes.Repeat([]byte{0x01}, 2000))
+		io.Copy(io.Discard, c)
+	}()
+
+	conn, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Since Version9, the garbage declares a frame too large to read
+	if _, err := conn.Read(make([]byte, 10)); !errors.Is(err, ErrMalformedFrame) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := conn.Write([]byte("hello")); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Unexpected result. The connection is still open: %v", err)
+	}
+}
+
+func TestLargeFrames(t *testing.T) {
+	key := &[32]byte{'k', 'e', 'y'}
+
+	var wire bytes.Buffer
+	sw := newSecureWriter(&wire, SuiteNaClBox, key)
+	sw.long, sw.maxSize = true, 1<<20
+	msg := bytes.Repeat([]byte{'x'}, 3<<20)
+	if _, err := sw.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	// Each frame holds 1 MiB less the overhead
+	if frames := sw.seq; frames != 4 {
+		t.Fatalf("Unexpected result: %d frames", frames)
+	}
+
+	sr := newSecureReader(&wire, SuiteNaClBox, key)
+	sr.long, sr.maxSize = true, 1<<20
+	got, err := io.ReadAll(sr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("Unexpected result: got %d bytes, expected %d", len(got), len(msg))
+	}
+
+	// The maximum is enforced before reading the frame
+	header := binary.BigEndian.AppendUint32(nil, 0xffffffff)
+	sr = newSecureReader(bytes.NewReader(append(header, make([]byte, 24)...)), SuiteNaClBox, key)
+	sr.long, sr.maxSize = true, maxLargeFrameSize
+	if _, err := sr.Read(make([]byte, 10)); !errors.Is(err, ErrMalformedFrame) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestLargeFramesVersions(t *testing.T) {
+	tData := []struct {
+		name    string
+		server  *Config
+		maxSize int
+	}{
+		{"version 9", &Config{MaxFrameSize: 1 << 20}, 1 << 20},
+		{"version 8 peer", &Config{MaxFrameSize: 1 << 20, MaxVersion: Version8}, 65535},
+	}
+
+	for _, exp := range tData {
+		client, server := tcpConns(t, &Config{MaxFrameSize: 1 << 20}, exp.server)
+		sw := client.Writer.(*SecureWriter)
+		if sw.maxSize != exp.maxSize {
+			t.Errorf("%s: unexpected maximum frame size %d", exp.name, sw.maxSize)
+		}
+		msg := bytes.Repeat([]byte{'x'}, 2<<20)
+		go client.Write(msg)
+		got := make([]byte, len(msg))
+		if _, err := io.ReadFull(server, got); err != nil || !bytes.Equal(got, msg) {
+			t.Errorf("%s: unexpected result %v", exp.name, err)
+		}
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestFrameSequence(t *testing.T) {
+	key := &[32]byte{'k', 'e', 'y'}
+
+	// frames returns the frames of three messages
+	frames := func() [][]byte {
+		var wire bytes.Buffer
+		sw := newSecureWriter(&wire, SuiteNaClBox, key)
+		sw.counterNonces, sw.explicitSeq = true, true
+		var frames [][]byte
+		for _, msg := range []string{"one", "two", "three"} {
+			sw.Write([]byte(msg))
+			frames = append(frames, bytes.Clone(wire.Bytes()))
+			wire.Reset()
+		}
+		return frames
+	}()
+
+	tData := []struct {
+		name  string
+		order []int
+		err   error
+	}{
+		{"in order", []int{0, 1, 2}, nil},
+		{"lost", []int{0, 2}, ErrFrameLost},
+		{"reordered", []int{1, 0, 2}, ErrFrameLost},
+		{"replayed", []int{0, 1, 1, 2}, ErrReplay},
+	}
+
+	for _, exp := range tData {
+		var wire []byte
+		for _, i := range exp.order {
+			wire = append(wire, frames[i]...)
+		}
+		sr := newSecureReader(bytes.NewReader(wire), SuiteNaClBox, key)
+		sr.counterNonces, sr.explicitSeq = true, true
+		got, err := io.ReadAll(sr)
+		if !errors.Is(err, exp.err) {
+			t.Errorf("%s: unexpected error %v", exp.name, err)
+		}
+		if exp.err == nil && string(got) != "onetwothree" {
+			t.Errorf("%s: unexpected result %q", exp.name, got)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package securecomm
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// The fuzz targets feed arbitrary bytes to the frame parser and both
+// sides of the handshake, which must fail cleanly: no panic, no hang and
+// no allocation out of proportion with the input. Run them with
+//
+//	go test -fuzz FuzzSecureReader
+//
+// and likewise for the other targets. The seeds are real frames and
+// handshake messages, so the fuzzer mutates plausible inputs.
+
+// fuzzSuites maps the fuzzed suite byte to a suite
+var fuzzSuites = []Suite{SuiteNaClBox, SuiteXChaCha20Poly1305, SuiteAES256GCM}
+
+// fuzzKey is the key of the fuzzed frames
+var fuzzKey = &[32]byte{'f', 'u', 'z', 'z'}
+
+// maxFuzzAlloc bounds the memory allocated to handle an input, beyond
+// the fixed cost of a connection.
+const maxFuzzAlloc = 4 << 20
+
+// checkAlloc fails the test when fn allocates more than limit bytes.
+func checkAlloc(t *testing.T, limit uint64, fn func()) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	fn()
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > limit {
+		t.Fatalf("allocated %d bytes, more than %d", n, limit)
+	}
+}
+
+// readAll reads sr until it fails, checking that it returns no more
+// than the size of the wire and that the error sticks.
+func readAll(t *testing.T, sr *SecureReader, wireSize int) {
+	var n int
+	buf := make([]byte, 1024)
+	for {
+		read, err := sr.Read(buf)
+		n += read
+		if n > wireSize {
+			t.Fatalf("read %d bytes out of %d bytes of frames", n, wireSize)
+		}
+		if err != nil {
+			if err != io.EOF && sr.err != nil {
+				if _, again := sr.Read(buf); again != err {
+					t.Fatalf("error %v followed by %v", err, again)
+				}
+			}
+			return
+		}
+	}
+}
+
+// newFuzzReader returns a reader of wire, answering the control frames
+// to io.Discard.
+func newFuzzReader(wire []byte, suite byte, counterNonces bool) *SecureReader {
+	s := fuzzSuites[int(suite)%len(fuzzSuites)]
+	sr := newSecureReader(bytes.NewReader(wire), s, fuzzKey)
+	sr.counterNonces = counterNonces
+	sr.pong = newSecureWriter(io.Discard, s, fuzzKey)
+	sr.pong.counterNonces = counterNonces
+	sr.pong.direction = 1
+	return sr
+}
+
+func FuzzSecureReader(f *testing.F) {
+	for i, s := range fuzzSuites {
+		for _, counterNonces := range []bool{false, true} {
+			var wire bytes.Buffer
+			sw := newSecureWriter(&wire, s, fuzzKey)
+			sw.counterNonces = counterNonces
+			sw.Write([]byte("hello"))
+			sw.writeControl(framePing, nil)
+			sw.writeControl(frameRekey, nil)
+			sw.padding = true
+			sw.Write([]byte("padded"))
+			f.Add(wire.Bytes(), byte(i), counterNonces)
+			f.Add(wire.Bytes()[:wire.Len()/2], byte(i), counterNonces)
+		}
+	}
+	f.Add([]byte{0xff, 0xff}, byte(0), false)
+
+	f.Fuzz(func(t *testing.T, wire []byte, suite byte, counterNonces bool) {
+		checkAlloc(t, maxFuzzAlloc+uint64(4*len(wire)), func() {
+			readAll(t, newFuzzReader(wire, suite, counterNonces), len(wire))
+		})
+	})
+}
+
+// sealFrames seals each plaintext in a frame as a peer would, so the
+// reader parses attacker-chosen frame types and payloads.
+func sealFrames(suite byte, plaintexts [][]byte) []byte {
+	aead, _ := fuzzSuites[int(suite)%len(fuzzSuites)].newAEAD(fuzzKey)
+	var wire []byte
+	for seq, plaintext := range plaintexts {
+		nonce := make([]byte, aead.NonceSize())
+		binary.BigEndian.PutUint64(nonce[len(nonce)-8:], uint64(seq))
+		sealed := aead.Seal(nil, nonce, plaintext, nil)
+		wire = binary.BigEndian.AppendUint16(wire, uint16(len(sealed)))
+		wire = append(wire, nonce...)
+		wire = append(wire, sealed...)
+	}
+	return wire
+}
+
+func FuzzFramePlaintext(f *testing.F) {
+	// Each frame is a length byte followed by the frame type and payload
+	f.Add([]byte("\x06\x00hello"), byte(0))
+	f.Add([]byte("\x01\x02\x01\x03\x06\x00hello"), byte(1))
+	f.Add(append([]byte{9, framePadded, 0, 5, 'h', 'e', 'l', 'l', 'o'}, 1, frameRekey), byte(2))
+	f.Add(append([]byte{33, frameRatchet}, make([]byte, 32)...), byte(0))
+	f.Add(append([]byte{33, frameRatchetAck}, make([]byte, 32)...), byte(0))
+	f.Add([]byte{1, frameRatchetSwitch, 1, 42}, byte(0))
+
+	f.Fuzz(func(t *testing.T, frames []byte, suite byte) {
+		var plaintexts [][]byte
+		for len(frames) > 0 {
+			size := min(int(frames[0]), len(frames)-1)
+			plaintexts = append(plaintexts, frames[1:1+size])
+			frames = frames[1+size:]
+		}
+		wire := sealFrames(suite, plaintexts)
+		checkAlloc(t, maxFuzzAlloc+uint64(4*len(wire)), func() {
+			readAll(t, newFuzzReader(wire, suite, false), len(wire))
+		})
+	})
+}
+
+// recorder records what is written to the wrapped connection.
+type recorder struct {
+	io.ReadWriter
+	written bytes.Buffer
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.written.Write(p)
+	return r.ReadWriter.Write(p)
+}
+
+// fuzzConfig returns the config selected by the bits of mode, so the
+// fuzzer explores the optional parts of the handshake.
+func fuzzConfig(mode byte) *Config {
+	cfg := &Config{}
+	if mode&1 != 0 {
+		cfg.PSK = []byte("fuzz psk")
+	}
+	if mode&2 != 0 {
+		cfg.PostQuantum = true
+	}
+	if mode&4 != 0 {
+		cfg.Protocols = []string{"echo", "chat"}
+	}
+	if mode&8 != 0 {
+		cfg.Padding = true
+	}
+	return cfg
+}
+
+// handshakeMessages returns what the client and the server send during
+// a successful handshake with the config of mode.
+func handshakeMessages(mode byte) ([]byte, []byte) {
+	c, s := net.Pipe()
+	defer c.Close()
+	client, server := &recorder{ReadWriter: c}, &recorder{ReadWriter: s}
+	done := make(chan struct{})
+	go func() {
+		serverHandshake(server, fuzzConfig(mode))
+		s.Close()
+		close(done)
+	}()
+	clientHandshake(client, fuzzConfig(mode))
+	c.Close()
+	<-done
+	return client.written.Bytes(), server.written.Bytes()
+}
+
+// fuzzHandshake runs side over a pipe whose peer sends msg, reading
+// everything side sends, and checks that it fails cleanly.
+func fuzzHandshake(t *testing.T, msg []byte, side func(io.ReadWriter) (*handshake, error)) {
+	c, s := net.Pipe()
+	defer c.Close()
+	s.SetDeadline(time.Now().Add(5 * time.Second))
+	go io.Copy(io.Discard, c)
+	go func() {
+		c.Write(msg)
+		c.Close()
+	}()
+	checkAlloc(t, maxFuzzAlloc+uint64(4*len(msg)), func() {
+		hs, err := side(s)
+		if err == nil {
+			t.Fatalf("handshake succeeded with %d arbitrary bytes: %+v", len(msg), hs)
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatal("handshake hung")
+		}
+	})
+}
+
+func FuzzServerHandshake(f *testing.F) {
+	for mode := range byte(16) {
+		client, _ := handshakeMessages(mode)
+		f.Add(client, mode)
+	}
+	f.Add([]byte(magic), byte(0))
+
+	_, identity, _ := ed25519.GenerateKey(rand.Reader)
+	f.Fuzz(func(t *testing.T, msg []byte, mode byte) {
+		cfg := fuzzConfig(mode)
+		cfg.Identity = identity
+		fuzzHandshake(t, msg, func(rw io.ReadWriter) (*handshake, error) {
+			return serverHandshake(rw, cfg)
+		})
+	})
+}
+
+func FuzzClientHandshake(f *testing.F) {
+	for mode := range byte(16) {
+		_, server := handshakeMessages(mode)
+		f.Add(server, mode)
+	}
+	f.Add(make([]byte, preambleSize), byte(0))
+
+	_, identity, _ := ed25519.GenerateKey(rand.Reader)
+	f.Fuzz(func(t *testing.T, msg []byte, mode byte) {
+		cfg := fuzzConfig(mode)
+		cfg.Identity = identity
+		fuzzHandshake(t, msg, func(rw io.ReadWriter) (*handshake, error) {
+			return clientHandshake(rw, cfg)
+		})
+	})
+}
@@ -0,0 +1,132 @@
+package securecomm
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Group messages are sealed once with a random key, wrapped for each
+// recipient with the key pair of the sender, so the servers relaying
+// them, like Hub and ChatRoom, can't read them:
+//
+//	[sender public key][uint16 count][count × [nonce][box of the key and hash]][nonce][secretbox of the message]
+//
+// The hash of the sealed message is wrapped along with the key, so the
+// recipients can't forge messages from the sender to the others with
+// the key they share.
+
+// groupLinePrefix starts the group messages encoded in a line.
+const groupLinePrefix = "sealed:"
+
+// groupWrapSize is the size of a wrapped key.
+const groupWrapSize = 24 + box.Overhead + 32 + sha256.Size
+
+var (
+	errGroupMalformed = errors.New("malformed group message")
+	errNotRecipient   = errors.New("not a recipient of the group message")
+)
+
+// SealGroup seals msg for all the recipients, from sender. Include the
+// public key of the sender to read the message back.
+func SealGroup(msg []byte, sender *KeyPair, recipients []*[32]byte) ([]byte, error) {
+	if len(recipients) == 0 || len(recipients) > math.MaxUint16 {
+		return nil, fmt.Errorf("invalid number of recipients: %d", len(recipients))
+	}
+	var key [32]byte
+	var nonce [24]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	body := secretbox.Seal(nonce[:], msg, &nonce, &key)
+	hash := sha256.Sum256(body)
+
+	sealed := append([]byte{}, sender.Public[:]...)
+	sealed = binary.BigEndian.AppendUint16(sealed, uint16(len(recipients)))
+	for _, recipient := range recipients {
+		var wrapNonce [24]byte
+		if _, err := rand.Read(wrapNonce[:]); err != nil {
+			return nil, err
+		}
+		sealed = append(sealed, wrapNonce[:]...)
+		sealed = box.Seal(sealed, append(key[:], hash[:]...), &wrapNonce, recipient, sender.Private)
+	}
+	return append(sealed, body...), nil
+}
+
+// OpenGroup opens a message sealed with SealGroup for kp, returning it
+// with the public key of its sender.
+func OpenGroup(sealed []byte, kp *KeyPair) ([]byte, *[32]byte, error) {
+	if len(sealed) < 32+2 {
+		return nil, nil, errGroupMalformed
+	}
+	var sender [32]byte
+	copy(sender[:], sealed)
+	count := int(binary.BigEndian.Uint16(sealed[32:]))
+	wraps := sealed[32+2:]
+	if len(wraps) < count*groupWrapSize+24+secretbox.Overhead {
+		return nil, nil, errGroupMalformed
+	}
+	body := wraps[count*groupWrapSize:]
+	hash := sha256.Sum256(body)
+
+	var shared [32]byte
+	box.Precompute(&shared, &sender, kp.Private)
+	for i := 0; i < count; i++ {
+		wrap := wraps[i*groupWrapSize : (i+1)*groupWrapSize]
+		var nonce [24]byte
+		copy(nonce[:], wrap)
+		opened, ok := box.OpenAfterPrecomputation(nil, wrap[24:], &nonce, &shared)
+		if !ok {
+			continue
+		}
+		if [sha256.Size]byte(opened[32:]) != hash {
+			return nil, nil, fmt.Errorf("%w: the message doesn't match its key", errGroupMalformed)
+		}
+		var key [32]byte
+		copy(key[:], opened)
+		copy(nonce[:], body)
+		msg, ok := secretbox.Open(nil, body[24:], &nonce, &key)
+		if !ok {
+			return nil, nil, errGroupMalformed
+		}
+		return msg, &sender, nil
+	}
+	return nil, nil, errNotRecipient
+}
+
+// SealGroupLine seals msg like SealGroup, encoded as a single word of
+// text, to publish it on a Hub or send it to a ChatRoom.
+func SealGroupLine(msg string, sender *KeyPair, recipients []*[32]byte) (string, error) {
+	sealed, err := SealGroup([]byte(msg), sender, recipients)
+	if err != nil {
+		return "", err
+	}
+	return groupLinePrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenGroupLine opens a word of text from SealGroupLine, like the last
+// one of the lines received from a Hub or a ChatRoom.
+func OpenGroupLine(word string, kp *KeyPair) (string, *[32]byte, error) {
+	encoded, ok := strings.CutPrefix(word, groupLinePrefix)
+	if !ok {
+		return "", nil, errGroupMalformed
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %s", errGroupMalformed, err)
+	}
+	msg, sender, err := OpenGroup(sealed, kp)
+	return string(msg), sender, err
+}
@@ -0,0 +1,99 @@
+package securecomm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+func TestGroup(t *testing.T) {
+	var keys []*KeyPair
+	for i := 0; i < 4; i++ {
+		kp, err := GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, kp)
+	}
+	sender, alice, bob, eve := keys[0], keys[1], keys[2], keys[3]
+	sealed, err := SealGroup([]byte("hello group"), sender, []*[32]byte{alice.Public, bob.Public})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, kp := range []*KeyPair{alice, bob} {
+		msg, from, err := OpenGroup(sealed, kp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg) != "hello group" || *from != *sender.Public {
+			t.Fatalf("Unexpected result. Got %q from %x", msg, from[:])
+		}
+	}
+	if _, _, err := OpenGroup(sealed, eve); !errors.Is(err, errNotRecipient) {
+		t.Fatalf("Unexpected result. Got %v, expected %v", err, errNotRecipient)
+	}
+
+	// Bob replaces the message with the key he was given
+	wrap := sealed[32+2+groupWrapSize : 32+2+2*groupWrapSize]
+	opened, ok := box.Open(nil, wrap[24:], (*[24]byte)(wrap[:24]), sender.Public, bob.Private)
+	if !ok {
+		t.Fatal("Unexpected result. Bob's key can't be unwrapped")
+	}
+	var nonce [24]byte
+	forged := bytes.Clone(sealed[:32+2+2*groupWrapSize])
+	forged = secretbox.Seal(append(forged, nonce[:]...), []byte("forged"), &nonce, (*[32]byte)(opened[:32]))
+	if _, _, err := OpenGroup(forged, alice); !errors.Is(err, errGroupMalformed) {
+		t.Fatalf("Unexpected result. Got %v, expected %v", err, errGroupMalformed)
+	}
+}
+
+func TestGroupHub(t *testing.T) {
+	s := &Server{Handler: new(Hub)}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	var clients []*hubClient
+	var keys []*KeyPair
+	for i := 0; i < 2; i++ {
+		conn, err := Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		clients = append(clients, &hubClient{conn, bufio.NewReader(conn)})
+		kp, err := GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, kp)
+	}
+	alice, bob := clients[0], clients[1]
+
+	alice.send(t, "SUB news")
+	alice.expect(t, "OK")
+	sealed, err := SealGroupLine("end-to-end", keys[1], []*[32]byte{keys[0].Public, keys[1].Public})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob.send(t, "PUB news "+sealed)
+
+	// The hub only relays the sealed message
+	line, err := alice.r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(line)
+	msg, from, err := OpenGroupLine(fields[len(fields)-1], keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != "end-to-end" || *from != *keys[1].Public {
+		t.Fatalf("Unexpected result. Got %q", msg)
+	}
+}
@@ -0,0 +1,144 @@
+package securecomm
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// errIdle is returned by the reads of handlers when the client stays
+// idle too long.
+var errIdle = errors.New("idle timeout")
+
+// Handler serves the secure connections accepted by a Server.
+type Handler interface {
+	// ServeConn is called once the handshake succeeded, with the
+	// decrypted connection and what the handshake told about the
+	// peer. The connection is closed when it returns.
+	ServeConn(conn io.ReadWriteCloser, peer Peer) error
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(conn io.ReadWriteCloser, peer Peer) error
+
+func (f HandlerFunc) ServeConn(conn io.ReadWriteCloser, peer Peer) error {
+	return f(conn, peer)
+}
+
+// Peer describes the other side of a secure connection.
+type Peer struct {
+	Addr        net.Addr
+	Identity    ed25519.PublicKey
+	Version     uint8
+	Suite       Suite
+	PostQuantum bool
+	Certificate *Certificate
+	Protocol    string
+}
+
+func peerOf(conn *Conn) Peer {
+	return Peer{
+		Addr:        conn.RemoteAddr(),
+		Identity:    conn.PeerIdentity(),
+		Version:     conn.Version(),
+		Suite:       conn.Suite(),
+		PostQuantum: conn.PostQuantum(),
+		Certificate: conn.PeerCertificate(),
+		Protocol:    conn.Protocol(),
+	}
+}
+
+// EchoHandler writes back everything read from the client until it is
+// done, the default of servers.
+var EchoHandler Handler = HandlerFunc(func(conn io.ReadWriteCloser, peer Peer) error {
+	_, err := echo(conn)
+	return err
+})
+
+// DiscardHandler reads everything sent by the client and discards it,
+// for benchmarks.
+var DiscardHandler Handler = HandlerFunc(func(conn io.ReadWriteCloser, peer Peer) error {
+	_, err := io.Copy(io.Discard, conn)
+	return err
+})
+
+// echo writes back everything read from rw until the client is done,
+// returning the number of bytes echoed.
+func echo(rw io.ReadWriter) (int64, error) {
+	buf := make([]byte, int64(math.Pow(2, 16)-1))
+
+	var n int64
+	for {
+		rBytes, err := rw.Read(buf)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return n, fmt.Errorf("error reading message from client: %w", err)
+		}
+		wBytes, err := rw.Write(buf[:rBytes])
+		n += int64(wBytes)
+		if err != nil {
+			return n, fmt.Errorf("error writing back to client: %s", err)
+		}
+	}
+	return n, nil
+}
+
+// serverConn is the connection given to the handlers of a server. It
+// records the traffic and the frames failing authentication and, if idle
+// is not zero, fails reads with errIdle once nothing was received for
+// that long.
+type serverConn struct {
+	conn     *Conn
+	m        *Collector
+	stats    *Stats
+	failed   func() // called when a frame fails authentication
+	idle     time.Duration
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+func (c *serverConn) Read(p []byte) (int, error) {
+	if c.idle > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.idle))
+	}
+	n, err := c.conn.Read(p)
+	c.m.received(n)
+	c.stats.received(n)
+	c.received.Add(int64(n))
+	if err != nil && err != io.EOF {
+		if c.idle > 0 && errors.Is(err, os.ErrDeadlineExceeded) {
+			return n, errIdle
+		}
+		c.m.readFailed(err)
+		c.stats.readFailed(err)
+		if c.failed != nil && isAuthFailure(err) {
+			c.failed()
+		}
+	}
+	return n, err
+}
+
+func (c *serverConn) Write(p []byte) (int, error) {
+	n, err := c.conn.Write(p)
+	c.m.sent(n)
+	c.stats.sent(n)
+	c.sent.Add(int64(n))
+	return n, err
+}
+
+// CloseWrite closes the writing side of the connection.
+func (c *serverConn) CloseWrite() error {
+	return c.conn.CloseWrite()
+}
+
+func (c *serverConn) Close() error {
+	return c.conn.Close()
+}
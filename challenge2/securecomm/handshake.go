@@ -0,0 +1,608 @@
+package securecomm
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/mlkem"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The handshake follows the Noise XX pattern, preceded by a preamble
+// sent by the server:
+//
+//	server -> client: preamble (magic, version range, session nonce[, password element])
+//	client -> server: selected version, e, client suites[, client protocols][, e1][, password element]
+//	server -> client: e, ee, hybrid flag[, ekem1], s, es[, psk], selected suite, server identity and signature[, selected protocol][, certificate]
+//	client -> server: s, se, client identity and signature[, certificate]
+//
+// The client picks the highest protocol version both sides support. The
+// preamble and the selected version are mixed into the handshake as the
+// prologue, so they can't be tampered with to downgrade the version. The static
+// keys (s) come from Config.KeyPair, and each side proves they belong to
+// its Ed25519 identity by signing the handshake hash. The pre-shared key
+// and the key resulting from the password exchange (see pake.go) are
+// mixed in with the psk token, so peers with different secrets fail
+// the handshake. Once done, each direction gets its own key, used with
+// the cipher suite selected by the server. As the suites offered by the
+// client are part of the handshake hash, they can't be downgraded.
+//
+// The e1 and ekem1 tokens implement the optional hybrid post-quantum key
+// exchange, following the hfs modifier of Noise: the client offers an
+// ML-KEM-768 encapsulation key (e1) and, if the server accepts, it sends
+// back a ciphertext (ekem1) whose shared secret is mixed into the keys.
+
+// handshakeContext prefixes the prologue and the signed handshake hash
+const handshakeContext = "go-challenges secure handshake"
+
+// magic starts every connection, identifying the protocol
+const magic = "GCSP"
+
+// Protocol versions
+const (
+	// Version1 is the first version of the protocol
+	Version1 uint8 = 1
+	// Version2 adds the heartbeat frames
+	Version2 uint8 = 2
+	// Version3 adds the padded frames
+	Version3 uint8 = 3
+	// Version4 replaces the random nonces of the frames with counters,
+	// not sent on the wire
+	Version4 uint8 = 4
+	// Version5 adds the Diffie-Hellman ratchet frames
+	Version5 uint8 = 5
+	// Version6 adds the endorsement of the server identity by its
+	// previous one, see Server.RotateIdentity
+	Version6 uint8 = 6
+	// Version7 adds the certificates of the identities, see Certificate
+	Version7 uint8 = 7
+	// Version8 adds the negotiation of the application protocol
+	Version8 uint8 = 8
+	// Version9 sends the length of the frames on 32 bits, allowing
+	// frames larger than 64 KiB, see Config.MaxFrameSize
+	Version9 uint8 = 9
+	// Version10 sends the sequence number of the frames, telling lost
+	// frames apart from reordered ones, see ErrFrameLost
+	Version10 uint8 = 10
+
+	minVersion = Version1
+	maxVersion = Version10
+)
+
+// nonceSize is the length of the random session nonce of the preamble
+const nonceSize = 32
+
+// preambleSize is the length of the preamble without password element
+const preambleSize = len(magic) + 2 + nonceSize
+
+// authSize is the length of an identity key followed by its signature
+const authSize = ed25519.PublicKeySize + ed25519.SignatureSize
+
+// endorsementSize is the length of the endorsement sent by servers
+// since Version6: a flag, then the previous identity and its signature
+// of the current one if set, zeros otherwise.
+const endorsementSize = 1 + authSize
+
+// errBadSignature is returned when the peer signature does not match
+// the handshake hash.
+var errBadSignature = errors.New("invalid handshake signature")
+
+// errPSKMismatch is returned when a handshake message using a
+// pre-shared key or a password can't be decrypted, as a mismatch
+// is the likely cause.
+var errPSKMismatch = errors.New("could not decrypt handshake message: pre-shared key or password mismatch")
+
+var errShortMessage = errors.New("handshake message too short")
+
+var errBadMagic = errors.New("not a secure connection: bad magic")
+
+// handshake is the result of a successful handshake.
+type handshake struct {
+	keys         *KeyPair  // our static key pair
+	peerKey      *[32]byte // static public key of the peer
+	peerIdentity ed25519.PublicKey
+	previous     ed25519.PublicKey // endorsing peerIdentity, if any
+	certificate  *Certificate      // of peerIdentity, if any
+	protocol     string            // application protocol, if any
+	version      uint8
+	suite        Suite
+	hybrid       bool      // whether the post-quantum key exchange was used
+	initiator    bool      // whether we are the client
+	sendKey      *[32]byte // key sealing the frames we send
+	recvKey      *[32]byte // key opening the frames we receive
+}
+
+func clientHandshake(c io.ReadWriter, cfg *Config) (*handshake, error) {
+	// Use our long-term key pair or generate a new one
+	static, err := cfg.keyPair()
+	if err != nil {
+		return nil, fmt.Errorf("error on generating key: %s", err)
+	}
+	identity, err := cfg.identity()
+	if err != nil {
+		return nil, fmt.Errorf("error on generating identity: %s", err)
+	}
+	psk, password := cfg.psk(), cfg.password()
+	usePSK := psk != nil || password != nil
+
+	preamble := make([]byte, preambleSize)
+	if password != nil {
+		preamble = make([]byte, preambleSize+pakeElementSize)
+	}
+	if err := readPreamble(c, preamble); err != nil {
+		return nil, err
+	}
+	if string(preamble[:len(magic)]) != magic {
+		return nil, errBadMagic
+	}
+	version, err := selectVersion(cfg, preamble[len(magic)], preamble[len(magic)+1])
+	if err != nil {
+		return nil, err
+	}
+	nonce := preamble[len(magic)+2 : preambleSize]
+	ss := newHandshakeState(usePSK, append(preamble, version))
+
+	// -> selected version, e, client suites[, client protocols][, e1][, password element]
+	e, err := GenerateKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("error on generating key: %s", err)
+	}
+	suites := cfg.suites()
+	payload := []byte{byte(len(suites))}
+	for _, s := range suites {
+		payload = append(payload, byte(s))
+	}
+	protocols := cfg.protocols()
+	if version >= Version8 {
+		if payload, err = appendProtocols(payload, protocols); err != nil {
+			return nil, err
+		}
+	}
+	var kem *mlkem.DecapsulationKey768
+	if cfg.postQuantum() {
+		if kem, err = mlkem.GenerateKey768(); err != nil {
+			return nil, fmt.Errorf("error on generating post-quantum key: %s", err)
+		}
+		payload = append(payload, 1)
+		payload = append(payload, kem.EncapsulationKey().Bytes()...)
+	} else {
+		payload = append(payload, 0)
+	}
+	var p *pake
+	if password != nil {
+		if p, err = newPAKE(password, nonce); err != nil {
+			return nil, fmt.Errorf("error on generating password element: %s", err)
+		}
+		payload = append(payload, p.element...)
+	}
+	msg := ss.writeEphemeral(e, usePSK)
+	msg = append(msg, ss.encryptAndHash(payload)...)
+	if err := writeNoiseMessage(c, msg, version); err != nil {
+		return nil, fmt.Errorf("error on writing public key: %s", err)
+	}
+
+	// <- e, ee, hybrid flag[, ekem1], s, es[, psk], selected suite, server identity and signature[, selected protocol][, certificate]
+	msg, err = readNoiseMessage(c)
+	if err != nil {
+		return nil, fmt.Errorf("error reading public key from server: %s", err)
+	}
+	re, err := next(&msg, 32)
+	if err != nil {
+		return nil, err
+	}
+	ss.readEphemeral(re, usePSK)
+	if err := ss.mixDH(e.Private, re); err != nil {
+		return nil, err
+	}
+	hybrid, err := ss.readKEMCiphertext(&msg, kem)
+	if err != nil {
+		return nil, fmt.Errorf("error authenticating server: %s", err)
+	}
+	encryptedStatic, err := next(&msg, 48)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := ss.decryptAndHash(encryptedStatic)
+	if err != nil {
+		return nil, fmt.Errorf("error authenticating server: %s", err)
+	}
+	if err := ss.mixDH(e.Private, rs); err != nil {
+		return nil, err
+	}
+	if usePSK {
+		if password != nil {
+			serverElement := preamble[preambleSize:]
+			key, err := p.key(serverElement, serverElement, p.element)
+			if err != nil {
+				return nil, err
+			}
+			psk = append(append([]byte{}, psk...), key...)
+		}
+		ss.mixKeyAndHash(psk)
+	}
+	prefixSize := 1
+	if version >= Version6 {
+		prefixSize += endorsementSize
+	}
+	selected, peerIdentity, suffix, err := ss.readIdentity(msg, usePSK, prefixSize, version >= Version7)
+	if err != nil {
+		return nil, fmt.Errorf("error authenticating server: %s", err)
+	}
+	var protocol string
+	if version >= Version8 {
+		if protocol, err = readProtocol(&suffix, protocols); err != nil {
+			return nil, err
+		}
+	}
+	cert, err := readCertificate(suffix)
+	if err != nil {
+		return nil, fmt.Errorf("error authenticating server: %s", err)
+	}
+	suite := Suite(selected[0])
+	var previousIdentity ed25519.PublicKey
+	if version >= Version6 && selected[1] == 1 {
+		if previousIdentity, err = verifyEndorsement(selected[2:], peerIdentity); err != nil {
+			return nil, fmt.Errorf("error authenticating server: %s", err)
+		}
+	}
+	if _, err := selectSuite(suites, []Suite{suite}); err != nil {
+		return nil, fmt.Errorf("server selected an unsupported cipher suite %s", suite)
+	}
+
+	// -> s, se, client identity and signature[, certificate]
+	msg = ss.encryptAndHash(static.Public[:])
+	if err := ss.mixDH(static.Private, re); err != nil {
+		return nil, err
+	}
+	var certificate []byte
+	if version >= Version7 {
+		certificate = cfg.certificate()
+	}
+	auth, err := ss.writeIdentity(identity, nil, certificate)
+	if err != nil {
+		return nil, err
+	}
+	msg = append(msg, auth...)
+	if err := writeNoiseMessage(c, msg); err != nil {
+		return nil, fmt.Errorf("error on writing public key: %s", err)
+	}
+
+	sendKey, recvKey := ss.split()
+	return &handshake{
+		keys:         static,
+		peerKey:      toKey(rs),
+		peerIdentity: peerIdentity,
+		previous:     previousIdentity,
+		certificate:  cert,
+		protocol:     protocol,
+		version:      version,
+		suite:        suite,
+		hybrid:       hybrid,
+		initiator:    true,
+		sendKey:      sendKey,
+		recvKey:      recvKey,
+	}, nil
+}
+
+func serverHandshake(c io.ReadWriter, cfg *Config) (*handshake, error) {
+	if difficulty := cfg.puzzleDifficulty(); difficulty > 0 {
+		if err := challengePuzzle(c, difficulty); err != nil {
+			return nil, err
+		}
+	}
+	// Use our long-term key pair or generate a new one
+	static, err := cfg.keyPair()
+	if err != nil {
+		return nil, fmt.Errorf("error generating key: %s", err)
+	}
+	identity, err := cfg.identity()
+	if err != nil {
+		return nil, fmt.Errorf("error generating identity: %s", err)
+	}
+	psk, password := cfg.psk(), cfg.password()
+	usePSK := psk != nil || password != nil
+
+	minV, maxV := cfg.versions()
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %s", err)
+	}
+	preamble := append([]byte(magic), minV, maxV)
+	preamble = append(preamble, nonce...)
+	var p *pake
+	if password != nil {
+		if p, err = newPAKE(password, nonce); err != nil {
+			return nil, fmt.Errorf("error generating password element: %s", err)
+		}
+		preamble = append(preamble, p.element...)
+	}
+	if _, err := c.Write(preamble); err != nil {
+		return nil, fmt.Errorf("error writing the preamble: %s", err)
+	}
+
+	// <- selected version, e, client suites[, client protocols][, e1][, password element]
+	var version uint8
+	if err := binary.Read(c, binary.BigEndian, &version); err != nil {
+		return nil, fmt.Errorf("error reading version from client: %s", err)
+	}
+	if version < minV || version > maxV {
+		return nil, fmt.Errorf("unsupported protocol version %d", version)
+	}
+	ss := newHandshakeState(usePSK, append(preamble, version))
+	msg, err := readNoiseMessage(c)
+	if err != nil {
+		return nil, fmt.Errorf("error on reading public key from client: %s", err)
+	}
+	re, err := next(&msg, 32)
+	if err != nil {
+		return nil, err
+	}
+	ss.readEphemeral(re, usePSK)
+	payload, err := ss.decryptAndHash(msg)
+	if err != nil {
+		return nil, err
+	}
+	count, err := next(&payload, 1)
+	if err != nil {
+		return nil, err
+	}
+	offered, err := next(&payload, int(count[0]))
+	if err != nil {
+		return nil, err
+	}
+	var theirs []Suite
+	for _, s := range offered {
+		theirs = append(theirs, Suite(s))
+	}
+	suite, err := selectSuite(cfg.suites(), theirs)
+	if err != nil {
+		return nil, err
+	}
+	var protocol string
+	if version >= Version8 {
+		offered, err := readProtocols(&payload)
+		if err != nil {
+			return nil, err
+		}
+		if protocol, err = selectProtocol(cfg.protocols(), offered); err != nil {
+			return nil, err
+		}
+	}
+	kemOffered, err := next(&payload, 1)
+	if err != nil {
+		return nil, err
+	}
+	var kemKey []byte
+	if kemOffered[0] == 1 {
+		if kemKey, err = next(&payload, mlkem.EncapsulationKeySize768); err != nil {
+			return nil, err
+		}
+	}
+	if password != nil {
+		cliElement := payload
+		key, err := p.key(cliElement, p.element, cliElement)
+		if err != nil {
+			return nil, err
+		}
+		psk = append(append([]byte{}, psk...), key...)
+	}
+
+	// -> e, ee, hybrid flag[, ekem1], s, es[, psk], selected suite, server identity and signature[, selected protocol][, certificate]
+	e, err := GenerateKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("error generating key: %s", err)
+	}
+	msg = ss.writeEphemeral(e, usePSK)
+	if err := ss.mixDH(e.Private, re); err != nil {
+		return nil, err
+	}
+	hybrid := kemKey != nil && cfg.postQuantum()
+	if !hybrid {
+		kemKey = nil
+	}
+	ekem, err := ss.writeKEMCiphertext(kemKey)
+	if err != nil {
+		return nil, err
+	}
+	msg = append(msg, ekem...)
+	msg = append(msg, ss.encryptAndHash(static.Public[:])...)
+	if err := ss.mixDH(static.Private, re); err != nil {
+		return nil, err
+	}
+	if usePSK {
+		ss.mixKeyAndHash(psk)
+	}
+	prefix := []byte{byte(suite)}
+	if version >= Version6 {
+		prefix = append(prefix, cfg.endorsement()...)
+	}
+	var suffix []byte
+	if version >= Version8 {
+		suffix = append([]byte{byte(len(protocol))}, protocol...)
+	}
+	if version >= Version7 {
+		suffix = append(suffix, cfg.certificate()...)
+	}
+	auth, err := ss.writeIdentity(identity, prefix, suffix)
+	if err != nil {
+		return nil, err
+	}
+	msg = append(msg, auth...)
+	if err := writeNoiseMessage(c, msg); err != nil {
+		return nil, fmt.Errorf("error writing the public key: %s", err)
+	}
+
+	// <- s, se, client identity and signature[, certificate]
+	msg, err = readNoiseMessage(c)
+	if err != nil {
+		return nil, fmt.Errorf("error on reading public key from client: %s", err)
+	}
+	encryptedStatic, err := next(&msg, 48)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := ss.decryptAndHash(encryptedStatic)
+	if err != nil {
+		return nil, fmt.Errorf("error authenticating client: %s", err)
+	}
+	if err := ss.mixDH(e.Private, rs); err != nil {
+		return nil, err
+	}
+	_, peerIdentity, suffix, err := ss.readIdentity(msg, false, 0, version >= Version7)
+	if err != nil {
+		return nil, fmt.Errorf("error authenticating client: %s", err)
+	}
+	cert, err := readCertificate(suffix)
+	if err != nil {
+		return nil, fmt.Errorf("error authenticating client: %s", err)
+	}
+
+	recvKey, sendKey := ss.split()
+	return &handshake{
+		keys:         static,
+		peerKey:      toKey(rs),
+		peerIdentity: peerIdentity,
+		certificate:  cert,
+		protocol:     protocol,
+		version:      version,
+		suite:        suite,
+		hybrid:       hybrid,
+		sendKey:      sendKey,
+		recvKey:      recvKey,
+	}, nil
+}
+
+// selectVersion returns the highest version supported by both the
+// server and us.
+func selectVersion(cfg *Config, serverMin, serverMax uint8) (uint8, error) {
+	minV, maxV := cfg.versions()
+	if serverMin > serverMax || serverMin > maxV || serverMax < minV {
+		return 0, fmt.Errorf("no protocol version in common: server supports %d to %d, we support %d to %d",
+			serverMin, serverMax, minV, maxV)
+	}
+	return min(serverMax, maxV), nil
+}
+
+// handshakeState adds the handshake tokens on top of the symmetric state.
+type handshakeState struct {
+	*symmetricState
+}
+
+func newHandshakeState(usePSK bool, preamble []byte) *handshakeState {
+	protocol := noiseProtocol
+	if usePSK {
+		protocol = noisePSKProtocol
+	}
+	ss := newSymmetricState(protocol)
+	ss.mixHash(append([]byte(handshakeContext), preamble...))
+	return &handshakeState{ss}
+}
+
+// writeEphemeral processes our e token. Its key is mixed in as well
+// when a pre-shared key is used, as required by the psk modifiers.
+func (hs *handshakeState) writeEphemeral(e *KeyPair, usePSK bool) []byte {
+	hs.readEphemeral(e.Public[:], usePSK)
+	return append([]byte{}, e.Public[:]...)
+}
+
+// readEphemeral processes the e token of the peer.
+func (hs *handshakeState) readEphemeral(re []byte, usePSK bool) {
+	hs.mixHash(re)
+	if usePSK {
+		hs.mixKey(re)
+	}
+}
+
+func (hs *handshakeState) mixDH(priv *[32]byte, pub []byte) error {
+	shared, err := dh(priv, pub)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %s", err)
+	}
+	hs.mixKey(shared)
+	return nil
+}
+
+// writeIdentity returns the payload prefix followed by our identity key,
+// the signature of the handshake hash and the suffix, encrypted.
+func (hs *handshakeState) writeIdentity(identity crypto.Signer, prefix, suffix []byte) ([]byte, error) {
+	sig, err := identity.Sign(rand.Reader, append([]byte(handshakeContext), hs.h[:]...), crypto.Hash(0))
+	if err != nil {
+		return nil, fmt.Errorf("error signing the handshake: %s", err)
+	}
+	payload := append(append([]byte{}, prefix...), identity.Public().(ed25519.PublicKey)...)
+	payload = append(payload, sig...)
+	return hs.encryptAndHash(append(payload, suffix...)), nil
+}
+
+// readIdentity decrypts the payload prefix, the identity key and the
+// signature of the peer and verifies it, followed by the rest of the
+// payload if withSuffix is set.
+func (hs *handshakeState) readIdentity(msg []byte, usePSK bool, prefixSize int, withSuffix bool) ([]byte, ed25519.PublicKey, []byte, error) {
+	signed := append([]byte(handshakeContext), hs.h[:]...)
+	payload, err := hs.decryptAndHash(msg)
+	if err != nil {
+		if usePSK {
+			return nil, nil, nil, errPSKMismatch
+		}
+		return nil, nil, nil, err
+	}
+	if len(payload) < prefixSize+authSize || (!withSuffix && len(payload) != prefixSize+authSize) {
+		return nil, nil, nil, errShortMessage
+	}
+	prefix, auth, suffix := payload[:prefixSize], payload[prefixSize:prefixSize+authSize], payload[prefixSize+authSize:]
+	peerIdentity := ed25519.PublicKey(auth[:ed25519.PublicKeySize])
+	if !ed25519.Verify(peerIdentity, signed, auth[ed25519.PublicKeySize:]) {
+		return nil, nil, nil, errBadSignature
+	}
+	return prefix, peerIdentity, suffix, nil
+}
+
+// readCertificate parses the certificate ending the identity payload
+// since Version7, if any. It is verified later, see
+// Config.verifyCertificate.
+func readCertificate(suffix []byte) (*Certificate, error) {
+	if len(suffix) == 0 {
+		return nil, nil
+	}
+	return ParseCertificate(suffix)
+}
+
+// endorse returns the endorsement of identity by previous.
+func endorse(previous ed25519.PrivateKey, identity ed25519.PublicKey) []byte {
+	sig := ed25519.Sign(previous, rotationMessage(identity))
+	e := append([]byte{1}, previous.Public().(ed25519.PublicKey)...)
+	return append(e, sig...)
+}
+
+// verifyEndorsement returns the previous identity signing the
+// endorsement of identity.
+func verifyEndorsement(endorsement []byte, identity ed25519.PublicKey) (ed25519.PublicKey, error) {
+	previous := ed25519.PublicKey(endorsement[:ed25519.PublicKeySize])
+	if !ed25519.Verify(previous, rotationMessage(identity), endorsement[ed25519.PublicKeySize:]) {
+		return nil, errBadSignature
+	}
+	return previous, nil
+}
+
+func rotationMessage(identity ed25519.PublicKey) []byte {
+	return append([]byte(handshakeContext+" rotation"), identity...)
+}
+
+// next returns the next n bytes of msg and advances it.
+func next(msg *[]byte, n int) ([]byte, error) {
+	if len(*msg) < n {
+		return nil, errShortMessage
+	}
+	b := (*msg)[:n]
+	*msg = (*msg)[n:]
+	return b, nil
+}
+
+func toKey(b []byte) *[32]byte {
+	key := &[32]byte{}
+	copy(key[:], b)
+	return key
+}
@@ -0,0 +1,219 @@
+package securecomm
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+)
+
+// pipeHandshake runs both sides of the handshake over an in-memory pipe.
+func pipeHandshake(clientCfg, serverCfg *Config) (*handshake, *handshake, error) {
+	c, s := net.Pipe()
+	defer c.Close()
+
+	done := make(chan *handshake)
+	go func() {
+		hs, _ := serverHandshake(s, serverCfg)
+		s.Close()
+		done <- hs
+	}()
+
+	clientHs, err := clientHandshake(c, clientCfg)
+	c.Close()
+	serverHs := <-done
+	if err == nil && serverHs == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return clientHs, serverHs, err
+}
+
+func TestHandshakeIdentities(t *testing.T) {
+	_, clientID, _ := ed25519.GenerateKey(rand.Reader)
+	_, serverID, _ := ed25519.GenerateKey(rand.Reader)
+	serverKeys, _ := GenerateKeyPair()
+
+	clientHs, serverHs, err := pipeHandshake(
+		&Config{Identity: clientID},
+		&Config{Identity: serverID, KeyPair: serverKeys},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !clientHs.peerIdentity.Equal(serverID.Public()) {
+		t.Fatal("Unexpected result. The client did not see the server identity.")
+	}
+	if !serverHs.peerIdentity.Equal(clientID.Public()) {
+		t.Fatal("Unexpected result. The server did not see the client identity.")
+	}
+	if *clientHs.peerKey != *serverKeys.Public {
+		t.Fatal("Unexpected result. The client did not see the server static key.")
+	}
+	if *clientHs.sendKey != *serverHs.recvKey || *clientHs.recvKey != *serverHs.sendKey {
+		t.Fatal("Unexpected result. The session keys differ.")
+	}
+	if *clientHs.sendKey == *clientHs.recvKey {
+		t.Fatal("Unexpected result. Both directions use the same key.")
+	}
+}
+
+func TestHandshakeForwardSecrecy(t *testing.T) {
+	clientKeys, _ := GenerateKeyPair()
+	serverKeys, _ := GenerateKeyPair()
+	clientCfg := &Config{KeyPair: clientKeys}
+	serverCfg := &Config{KeyPair: serverKeys}
+
+	first, _, err := pipeHandshake(clientCfg, serverCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := pipeHandshake(clientCfg, serverCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *first.sendKey == *second.sendKey {
+		t.Fatal("Unexpected result. Session keys are reused across connections.")
+	}
+}
+
+// flipWriter flips a bit of the byte at offset when copying to w.
+type flipWriter struct {
+	w      io.Writer
+	offset int
+}
+
+func (f *flipWriter) Write(p []byte) (int, error) {
+	b := append([]byte{}, p...)
+	if f.offset >= 0 && f.offset < len(b) {
+		b[f.offset] ^= 1
+	}
+	f.offset -= len(b)
+	return f.w.Write(b)
+}
+
+func TestHandshakeTamperedKey(t *testing.T) {
+	c, mitmC := net.Pipe()
+	mitmS, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	// A man in the middle relays the messages, changing the
+	// ephemeral key of the server
+	go func() {
+		io.Copy(&flipWriter{mitmC, preambleSize + 2}, mitmS)
+		mitmC.Close()
+	}()
+	go func() {
+		io.Copy(mitmS, mitmC)
+		mitmS.Close()
+	}()
+	go serverHandshake(s, nil)
+
+	if _, err := clientHandshake(c, nil); err == nil {
+		t.Fatal("expected the handshake to fail with a tampered key")
+	}
+}
+
+func TestHandshakePSK(t *testing.T) {
+	tData := []struct {
+		name      string
+		clientPSK string
+		serverPSK string
+		ok        bool
+	}{
+		{"same", "secret", "secret", true},
+		{"different", "secret", "guess", false},
+		{"client only", "secret", "", false},
+		{"server only", "", "secret", false},
+	}
+
+	for _, exp := range tData {
+		clientHs, serverHs, err := pipeHandshake(
+			&Config{PSK: []byte(exp.clientPSK)},
+			&Config{PSK: []byte(exp.serverPSK)},
+		)
+		if !exp.ok {
+			if err == nil {
+				t.Fatalf("%s: expected the handshake to fail", exp.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected handshake failure: %v", exp.name, err)
+		}
+		if *clientHs.sendKey != *serverHs.recvKey {
+			t.Fatalf("%s: the session keys differ", exp.name)
+		}
+	}
+}
+
+func TestConnPeerIdentity(t *testing.T) {
+	_, serverID, _ := ed25519.GenerateKey(rand.Reader)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go ServeConfig(l, &Config{Identity: serverID}, nil)
+
+	conn, err := DialConfig(l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if got := conn.(*Conn).PeerIdentity(); !bytes.Equal(got, serverID.Public().(ed25519.PublicKey)) {
+		t.Fatal("Unexpected result. The connection does not expose the server identity.")
+	}
+}
+
+func TestHandshakeVersion(t *testing.T) {
+	tData := []struct {
+		name      string
+		clientCfg *Config
+		serverCfg *Config
+		version   uint8
+		ok        bool
+	}{
+		{"defaults", nil, nil, maxVersion, true},
+		{"old client", &Config{MaxVersion: Version1}, nil, Version1, true},
+		{"old server", nil, &Config{MaxVersion: Version1}, Version1, true},
+		{"server too new", nil, &Config{MinVersion: maxVersion + 1, MaxVersion: maxVersion + 1}, 0, false},
+		{"client too new", &Config{MinVersion: maxVersion + 1, MaxVersion: maxVersion + 1}, nil, 0, false},
+		{"server too old", &Config{MinVersion: Version2}, &Config{MaxVersion: Version1}, 0, false},
+	}
+
+	for _, exp := range tData {
+		clientHs, serverHs, err := pipeHandshake(exp.clientCfg, exp.serverCfg)
+		if !exp.ok {
+			if err == nil {
+				t.Fatalf("%s: expected the handshake to fail", exp.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected handshake failure: %v", exp.name, err)
+		}
+		if clientHs.version != exp.version || serverHs.version != exp.version {
+			t.Fatalf("%s: unexpected versions %d and %d", exp.name, clientHs.version, serverHs.version)
+		}
+	}
+}
+
+func TestHandshakeBadMagic(t *testing.T) {
+	c, s := net.Pipe()
+	defer c.Close()
+	go func() {
+		s.Write(make([]byte, preambleSize))
+		s.Close()
+	}()
+
+	if _, err := clientHandshake(c, nil); err != errBadMagic {
+		t.Fatalf("Unexpected result. Expected %v, got %v", errBadMagic, err)
+	}
+}
@@ -0,0 +1,157 @@
+package securecomm
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrPeerTimeout is returned by Read when nothing was received from the
+// peer for too many heartbeat intervals.
+var ErrPeerTimeout = errors.New("peer missed too many heartbeats")
+
+// defaultHeartbeatMisses is the number of heartbeat intervals without
+// receiving anything before the peer is considered dead.
+const defaultHeartbeatMisses = 3
+
+// maxTimedPings bounds the unanswered pings timed, when nothing reads the
+// pongs.
+const maxTimedPings = 16
+
+// defaultHeartbeatInterval is the heartbeat interval of the connections
+// relying on the heartbeats, like the ones of failovers and pools, when
+// the config sets none.
+const defaultHeartbeatInterval = 5 * time.Second
+
+// WithHeartbeat returns a copy of cfg with the heartbeats enabled, at
+// the interval of cfg if any, for the connections measuring their
+// latency.
+func WithHeartbeat(cfg *Config) *Config {
+	c := &Config{}
+	if cfg != nil {
+		*c = *cfg
+	}
+	if c.HeartbeatInterval <= 0 {
+		c.HeartbeatInterval = defaultHeartbeatInterval
+	}
+	return c
+}
+
+// heartbeat pings the peer at regular intervals, so both sides receive
+// frames even when the connection is idle. Reads use a deadline of a few
+// intervals, detecting dead peers and half-open connections. The peer
+// answers pings while it reads.
+type heartbeat struct {
+	conn    net.Conn
+	w       *SecureWriter
+	timeout time.Duration // interval times the allowed misses
+
+	mu           sync.Mutex
+	userDeadline time.Time     // read deadline set by the user
+	deadline     time.Time     // read deadline set by the heartbeat
+	pings        []time.Time   // when the unanswered pings were sent, oldest first
+	untimed      int           // unanswered pings sent before those
+	rtt          time.Duration // round trip time of the last ping answered
+
+	answered chan struct{} // closed once the first ping is answered
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func startHeartbeat(conn net.Conn, w *SecureWriter, interval time.Duration, misses int) *heartbeat {
+	hb := &heartbeat{
+		conn:     conn,
+		w:        w,
+		timeout:  interval * time.Duration(misses),
+		answered: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go hb.ping(interval)
+	return hb
+}
+
+func (hb *heartbeat) ping(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-hb.done:
+			return
+		case <-t.C:
+		}
+		hb.mu.Lock()
+		hb.pings = append(hb.pings, time.Now())
+		if len(hb.pings) > maxTimedPings {
+			hb.pings = hb.pings[1:]
+			hb.untimed++
+		}
+		hb.mu.Unlock()
+		if err := hb.w.writeControl(framePing, nil); err != nil {
+			// The connection is broken, Read will tell
+			return
+		}
+	}
+}
+
+// ponged records the round trip time of the ping answered by the peer.
+// The pongs come in the order of the pings.
+func (hb *heartbeat) ponged() {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	if hb.untimed > 0 {
+		hb.untimed--
+		return
+	}
+	if len(hb.pings) == 0 {
+		return
+	}
+	select {
+	case <-hb.answered:
+	default:
+		close(hb.answered)
+	}
+	hb.rtt = time.Since(hb.pings[0])
+	hb.pings = hb.pings[1:]
+}
+
+func (hb *heartbeat) latency() time.Duration {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	return hb.rtt
+}
+
+func (hb *heartbeat) stop() {
+	hb.stopOnce.Do(func() { close(hb.done) })
+}
+
+// armReadDeadline sets the read deadline of the connection before
+// reading a frame, keeping the deadline of the user if it's earlier.
+func (hb *heartbeat) armReadDeadline() {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	hb.deadline = time.Now().Add(hb.timeout)
+	if !hb.userDeadline.IsZero() && hb.userDeadline.Before(hb.deadline) {
+		hb.conn.SetReadDeadline(hb.userDeadline)
+		return
+	}
+	hb.conn.SetReadDeadline(hb.deadline)
+}
+
+func (hb *heartbeat) setReadDeadline(t time.Time) {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	hb.userDeadline = t
+}
+
+// timedOut tells whether err was caused by the deadline of the
+// heartbeat rather than the one of the user.
+func (hb *heartbeat) timedOut(err error) bool {
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		return false
+	}
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	return hb.userDeadline.IsZero() || hb.deadline.Before(hb.userDeadline)
+}
@@ -0,0 +1,94 @@
+package securecomm
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatDeadPeer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// The server completes the handshake then goes silent
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if _, err := newServerConn(context.Background(), c, nil); err != nil {
+			t.Error(err)
+			return
+		}
+		time.Sleep(time.Second)
+	}()
+
+	conn, err := DialConfig(l.Addr().String(), &Config{HeartbeatInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	if _, err := conn.Read(make([]byte, 1)); err != ErrPeerTimeout {
+		t.Fatalf("Unexpected result. Expected %v, got %v", ErrPeerTimeout, err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("Unexpected result. The dead peer was detected after %s", d)
+	}
+}
+
+func TestHeartbeatIdlePeer(t *testing.T) {
+	s := &Server{}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	conn, err := DialConfig(addr, &Config{HeartbeatInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The server answers the pings, so an idle connection stays up
+	read := make(chan error)
+	buf := make([]byte, len("hello world\n"))
+	go func() {
+		_, err := io.ReadFull(conn, buf)
+		read <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+	if _, err := conn.Write([]byte("hello world\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-read; err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf); got != "hello world\n" {
+		t.Fatalf("Unexpected result.\nGot:\t\t%s\nExpected:\t%s\n", got, "hello world\n")
+	}
+	// The pongs read meanwhile measured the latency
+	if conn.(*Conn).Latency() <= 0 {
+		t.Fatal("Unexpected result. The latency was not measured")
+	}
+}
+
+func TestHeartbeatVersion1(t *testing.T) {
+	s := &Server{Config: &Config{MaxVersion: Version1}}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	conn, err := DialConfig(addr, &Config{HeartbeatInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.(*Conn).hb != nil {
+		t.Fatal("Unexpected result. Heartbeats enabled with a Version1 peer")
+	}
+}
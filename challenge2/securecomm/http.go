@@ -0,0 +1,64 @@
+package securecomm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// NewHTTPTransport returns an http.RoundTripper sending the requests of
+// http:// URLs over secure connections to their host, using cfg. The
+// connections are kept alive between the requests, like with
+// http.DefaultTransport. Proxies are set by Config.Proxy.
+func NewHTTPTransport(cfg *Config) *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			rwc, err := DialConfigContext(ctx, addr, cfg)
+			if err != nil {
+				return nil, err
+			}
+			conn, ok := rwc.(net.Conn)
+			if !ok {
+				rwc.Close()
+				return nil, fmt.Errorf("failed to dial %s: not a connection", addr)
+			}
+			return conn, nil
+		},
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// peerContextKey holds the Peer of the connection of the HTTP requests
+type peerContextKey struct{}
+
+// NewHTTPServer returns an http.Server serving h over the secure
+// connections of a listener from NewListener, passed to Serve. The
+// handlers can get the peer of the requests with HTTPPeer.
+func NewHTTPServer(h http.Handler) *http.Server {
+	return &http.Server{
+		Handler: h,
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			if conn, ok := c.(*Conn); ok {
+				return context.WithValue(ctx, peerContextKey{}, peerOf(conn))
+			}
+			return ctx
+		},
+	}
+}
+
+// ServeHTTP serves h over the secure connections accepted on l, with
+// cfg, like http.Serve.
+func ServeHTTP(l net.Listener, cfg *Config, h http.Handler) error {
+	return NewHTTPServer(h).Serve(NewListener(l, cfg))
+}
+
+// HTTPPeer returns the peer of the secure connection of a request
+// served by NewHTTPServer, false if it was not received on one.
+func HTTPPeer(r *http.Request) (Peer, bool) {
+	peer, ok := r.Context().Value(peerContextKey{}).(Peer)
+	return peer, ok
+}
@@ -0,0 +1,53 @@
+package securecomm
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestHTTP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer, ok := HTTPPeer(r)
+		if !ok {
+			http.Error(w, "no peer", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "%s %s", r.URL.Path, Fingerprint(peer.Identity))
+	})
+	srv := NewHTTPServer(h)
+	go srv.Serve(NewListener(l, &Config{PSK: []byte("secret")}))
+	defer srv.Close()
+
+	_, identity, _ := ed25519.GenerateKey(rand.Reader)
+	client := &http.Client{Transport: NewHTTPTransport(&Config{PSK: []byte("secret"), Identity: identity})}
+	expected := "/hello " + Fingerprint(identity.Public().(ed25519.PublicKey))
+	for range 2 {
+		resp, err := client.Get("http://" + l.Addr().String() + "/hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || string(body) != expected {
+			t.Fatalf("Unexpected result. Got %d %q, expected %q", resp.StatusCode, body, expected)
+		}
+	}
+
+	// Servers with another key are not reached
+	wrong := &http.Client{Transport: NewHTTPTransport(&Config{PSK: []byte("wrong")})}
+	if _, err := wrong.Get("http://" + l.Addr().String() + "/hello"); err == nil {
+		t.Fatal("Unexpected result. The request with the wrong PSK succeeded")
+	}
+}
@@ -0,0 +1,63 @@
+package securecomm
+
+import (
+	"crypto/mlkem"
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+var errUnexpectedKEM = errors.New("unexpected post-quantum key exchange")
+
+// writeKEMCiphertext encapsulates a secret to the key offered by the
+// client and mixes it into the handshake (the ekem1 token). The message
+// starts with a flag telling whether the hybrid exchange is used, which
+// is not the case when kemKey is nil.
+func (hs *handshakeState) writeKEMCiphertext(kemKey []byte) ([]byte, error) {
+	if kemKey == nil {
+		return hs.encryptAndHash([]byte{0}), nil
+	}
+	ek, err := mlkem.NewEncapsulationKey768(kemKey)
+	if err != nil {
+		return nil, err
+	}
+	shared, ciphertext := ek.Encapsulate()
+	msg := hs.encryptAndHash([]byte{1})
+	msg = append(msg, hs.encryptAndHash(ciphertext)...)
+	hs.mixKey(shared)
+	return msg, nil
+}
+
+// readKEMCiphertext reads the message written by writeKEMCiphertext,
+// decapsulating the secret with our key if the server accepted the
+// hybrid exchange.
+func (hs *handshakeState) readKEMCiphertext(msg *[]byte, kem *mlkem.DecapsulationKey768) (bool, error) {
+	encryptedFlag, err := next(msg, 1+chacha20poly1305.Overhead)
+	if err != nil {
+		return false, err
+	}
+	flag, err := hs.decryptAndHash(encryptedFlag)
+	if err != nil {
+		return false, err
+	}
+	if flag[0] == 0 {
+		return false, nil
+	}
+	if kem == nil {
+		return false, errUnexpectedKEM
+	}
+	encryptedCiphertext, err := next(msg, mlkem.CiphertextSize768+chacha20poly1305.Overhead)
+	if err != nil {
+		return false, err
+	}
+	ciphertext, err := hs.decryptAndHash(encryptedCiphertext)
+	if err != nil {
+		return false, err
+	}
+	shared, err := kem.Decapsulate(ciphertext)
+	if err != nil {
+		return false, err
+	}
+	hs.mixKey(shared)
+	return true, nil
+}
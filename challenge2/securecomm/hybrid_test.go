@@ -0,0 +1,33 @@
+package securecomm
+
+import "testing"
+
+func TestHandshakePostQuantum(t *testing.T) {
+	tData := []struct {
+		name   string
+		client bool
+		server bool
+	}{
+		{"both", true, true},
+		{"client only", true, false},
+		{"server only", false, true},
+		{"none", false, false},
+	}
+
+	for _, exp := range tData {
+		clientHs, serverHs, err := pipeHandshake(
+			&Config{PostQuantum: exp.client, Password: []byte("password")},
+			&Config{PostQuantum: exp.server, Password: []byte("password")},
+		)
+		if err != nil {
+			t.Fatalf("%s: unexpected handshake failure: %v", exp.name, err)
+		}
+		expected := exp.client && exp.server
+		if clientHs.hybrid != expected || serverHs.hybrid != expected {
+			t.Fatalf("%s: expected hybrid to be %v, got %v and %v", exp.name, expected, clientHs.hybrid, serverHs.hybrid)
+		}
+		if *clientHs.sendKey != *serverHs.recvKey || *clientHs.recvKey != *serverHs.sendKey {
+			t.Fatalf("%s: the session keys differ", exp.name)
+		}
+	}
+}
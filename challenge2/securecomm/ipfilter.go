@@ -0,0 +1,93 @@
+package securecomm
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// IPFilter accepts or rejects the connections by the IP address of the
+// client, before the handshake. A nil IPFilter accepts everything.
+type IPFilter struct {
+	// Allow, if not empty, lists the only ranges accepted.
+	Allow []netip.Prefix
+	// Deny lists the ranges rejected, even if allowed.
+	Deny []netip.Prefix
+}
+
+// ParseIPFilter parses the allowed and denied ranges, each a
+// comma-separated list of CIDR ranges or IP addresses, like
+// 10.0.0.0/8,192.168.1.1.
+func ParseIPFilter(allow, deny []string) (*IPFilter, error) {
+	f := &IPFilter{}
+	var err error
+	if f.Allow, err = parsePrefixes(allow); err != nil {
+		return nil, err
+	}
+	if f.Deny, err = parsePrefixes(deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func parsePrefixes(lists []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, list := range lists {
+		for _, s := range strings.Split(list, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			if !strings.Contains(s, "/") {
+				addr, err := netip.ParseAddr(s)
+				if err != nil {
+					return nil, fmt.Errorf("invalid address range %q", s)
+				}
+				prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+				continue
+			}
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address range %q", s)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+		}
+	}
+	return prefixes, nil
+}
+
+// Allowed tells whether the client at addr is accepted.
+func (f *IPFilter) Allowed(addr netip.Addr) bool {
+	if f == nil {
+		return true
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, p := range f.Deny {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	if len(f.Allow) == 0 {
+		return true
+	}
+	for _, p := range f.Allow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedAddr is like Allowed for the address of a connection, rejecting
+// the addresses that aren't IP addresses when ranges are allowed.
+func (f *IPFilter) allowedAddr(addr net.Addr) bool {
+	if f == nil {
+		return true
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return len(f.Allow) == 0
+	}
+	return f.Allowed(ap.Addr())
+}
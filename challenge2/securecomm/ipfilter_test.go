@@ -0,0 +1,75 @@
+package securecomm
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	f, err := ParseIPFilter([]string{"10.0.0.0/8,192.168.1.1", "2001:db8::/32"}, []string{"10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{
+		"10.2.3.4":         true,
+		"10.1.2.3":         false,
+		"192.168.1.1":      true,
+		"192.168.1.2":      false,
+		"::ffff:10.2.3.4":  true,
+		"2001:db8::1":      true,
+		"2001:db9::1":      false,
+		"fe80::1%eth0":     false,
+		"2001:db8::1%eth0": true,
+	} {
+		if got := f.Allowed(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Allowed(%s) = %v, want %v", addr, got, want)
+		}
+	}
+
+	// Without allowed ranges, only the denied ones are rejected
+	f, err = ParseIPFilter(nil, []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Allowed(netip.MustParseAddr("127.0.0.1")) || !f.Allowed(netip.MustParseAddr("127.0.0.2")) {
+		t.Fatal("Unexpected result. The denied address was not rejected alone")
+	}
+	var none *IPFilter
+	if !none.Allowed(netip.MustParseAddr("127.0.0.1")) {
+		t.Fatal("Unexpected result. A nil filter rejected an address")
+	}
+
+	for _, invalid := range []string{"10.0.0.0/33", "example.com", "10.0.0/8"} {
+		if _, err := ParseIPFilter([]string{invalid}, nil); err == nil {
+			t.Errorf("Unexpected result. Parsed the invalid range %q", invalid)
+		}
+	}
+}
+
+func TestServerIPFilter(t *testing.T) {
+	s := &Server{Filter: &IPFilter{Deny: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}}}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	if _, err := Dial(addr); err == nil {
+		t.Fatal("Unexpected result. The denied client was accepted")
+	}
+
+	// The filter is reloaded for the new connections
+	allowed, err := ParseIPFilter([]string{"127.0.0.1/32"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Reload(&Server{Filter: allowed}, 0)
+	conn, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// Only IP addresses are allowed in ranges
+	if allowed.allowedAddr(&net.UnixAddr{Name: "/tmp/socket", Net: "unix"}) {
+		t.Fatal("Unexpected result. A Unix socket client was allowed")
+	}
+}
@@ -0,0 +1,70 @@
+package securecomm
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// A key log has a line per key of the sessions, like the SSLKEYLOGFILE
+// of TLS, so captured traffic can be decrypted in a lab:
+//
+//	CLIENT_KEY <session> <suite> <version> <key>
+//	SERVER_KEY <session> <suite> <version> <key>
+//	CLIENT_RATCHET <session> <step> <secret>
+//	SERVER_RATCHET <session> <step> <secret>
+//
+// The CLIENT lines are about the frames sent by the client, the SERVER
+// ones about the frames sent by the server. The session is the start of
+// the sha256 of the client key, the same on both sides, and the suite
+// is its value in the protocol. The keys of the rekeys derive from the
+// logged ones, while the ratchet steps of Version5 mix their secret
+// into the key of their direction. Keys and secrets are in hex.
+
+// keyLogMu serializes the lines written to the key logs shared by
+// several connections.
+var keyLogMu sync.Mutex
+
+// keyLogger logs the keys of a connection. A nil keyLogger logs nothing.
+type keyLogger struct {
+	w       io.Writer
+	session string
+	client  bool // whether we are the client
+}
+
+// newKeyLogger logs the keys established by hs to Config.KeyLog,
+// returning nil without it.
+func newKeyLogger(cfg *Config, hs *handshake) *keyLogger {
+	if cfg == nil || cfg.KeyLog == nil {
+		return nil
+	}
+	clientKey, serverKey := hs.sendKey, hs.recvKey
+	if !hs.initiator {
+		clientKey, serverKey = serverKey, clientKey
+	}
+	sum := sha256.Sum256(clientKey[:])
+	l := &keyLogger{w: cfg.KeyLog, session: fmt.Sprintf("%x", sum[:8]), client: hs.initiator}
+	l.write(fmt.Sprintf("CLIENT_KEY %s %d %d %x\nSERVER_KEY %s %d %d %x\n",
+		l.session, hs.suite, hs.version, clientKey[:], l.session, hs.suite, hs.version, serverKey[:]))
+	return l
+}
+
+// ratchet logs the secret of a ratchet step of the frames we send, or
+// of the ones we receive.
+func (l *keyLogger) ratchet(sent bool, step uint64, secret []byte) {
+	if l == nil {
+		return
+	}
+	side := "SERVER"
+	if sent == l.client {
+		side = "CLIENT"
+	}
+	l.write(fmt.Sprintf("%s_RATCHET %s %d %x\n", side, l.session, step, secret))
+}
+
+func (l *keyLogger) write(lines string) {
+	keyLogMu.Lock()
+	defer keyLogMu.Unlock()
+	io.WriteString(l.w, lines)
+}
@@ -0,0 +1,59 @@
+package securecomm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyLog(t *testing.T) {
+	var clientLog, serverLog bytes.Buffer
+	client, server := tcpConns(t,
+		&Config{RatchetInterval: time.Nanosecond, KeyLog: &clientLog},
+		&Config{RatchetInterval: time.Nanosecond, KeyLog: &serverLog})
+	defer client.Close()
+	defer server.Close()
+	clientKey := fmt.Sprintf("%x", client.Writer.(*SecureWriter).key[:])
+	go echo(server)
+
+	for i := 0; i < 10; i++ {
+		msg := bytes.Repeat([]byte{byte(i)}, 100)
+		if _, err := client.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(client, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keyLogMu.Lock()
+	defer keyLogMu.Unlock()
+	clientLines := strings.Split(clientLog.String(), "\n")
+	serverLines := strings.Split(serverLog.String(), "\n")
+	if len(clientLines) < 3 || len(serverLines) < 3 {
+		t.Fatalf("Unexpected result. Got the logs:\n%s\n%s", clientLog.String(), serverLog.String())
+	}
+	// Both sides log the same keys, the client ones sealing its frames
+	if clientLines[0] != serverLines[0] || clientLines[1] != serverLines[1] {
+		t.Fatalf("Unexpected result. Got different keys:\n%s\n%s", clientLines[:2], serverLines[:2])
+	}
+	if fields := strings.Fields(clientLines[0]); fields[0] != "CLIENT_KEY" || fields[len(fields)-1] != clientKey {
+		t.Fatalf("Unexpected result. Got %s, expected the key %s", clientLines[0], clientKey)
+	}
+
+	ratchets := 0
+	for _, line := range clientLines[2:] {
+		if strings.HasPrefix(line, "CLIENT_RATCHET ") {
+			ratchets++
+			if !strings.Contains(serverLog.String(), line+"\n") {
+				t.Fatalf("Unexpected result. The server did not log %s", line)
+			}
+		}
+	}
+	if ratchets == 0 {
+		t.Fatal("Unexpected result. No ratchet step logged")
+	}
+}
@@ -0,0 +1,220 @@
+package securecomm
+
+import (
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KeyPair is a long-term X25519 key pair, the static key of the
+// handshake. It never seals messages itself: each connection derives
+// its session keys from fresh ephemeral keys, for forward secrecy.
+type KeyPair struct {
+	Public  *[32]byte
+	Private *[32]byte
+}
+
+// GenerateKeyPair generates a new random key pair.
+func GenerateKeyPair() (*KeyPair, error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{Public: pub, Private: priv}, nil
+}
+
+// Fingerprint returns a short printable representation of an identity key.
+func Fingerprint(identity ed25519.PublicKey) string {
+	sum := sha256.Sum256(identity)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// SaveKeyPair writes the private key of kp, hex encoded, to the file
+// found at path. The file is only readable by its owner.
+func SaveKeyPair(path string, kp *KeyPair) error {
+	return saveKey(path, kp.Private[:])
+}
+
+// LoadKeyPair reads a key pair saved with SaveKeyPair.
+func LoadKeyPair(path string) (*KeyPair, error) {
+	b, err := loadKey(path)
+	if err != nil {
+		return nil, err
+	}
+	priv := &[32]byte{}
+	copy(priv[:], b)
+	b, err = curve25519.X25519(priv[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	pub := &[32]byte{}
+	copy(pub[:], b)
+	return &KeyPair{Public: pub, Private: priv}, nil
+}
+
+// SaveIdentity writes the seed of the identity key, hex encoded,
+// to the file found at path. The file is only readable by its owner.
+func SaveIdentity(path string, identity ed25519.PrivateKey) error {
+	return saveKey(path, identity.Seed())
+}
+
+// LoadIdentity reads an identity key saved with SaveIdentity.
+func LoadIdentity(path string) (ed25519.PrivateKey, error) {
+	seed, err := loadKey(path)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// SaveIdentityPassphrase is like SaveIdentity but encrypts the seed with
+// a key derived from passphrase.
+func SaveIdentityPassphrase(path string, identity ed25519.PrivateKey, passphrase []byte) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := passphraseAEAD(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := aead.Seal(append(salt, nonce...), nonce, identity.Seed(), nil)
+	return writeKeyFile(path, encryptedKeyPrefix+hex.EncodeToString(sealed))
+}
+
+// LoadIdentityPassphrase reads an identity key saved with
+// SaveIdentityPassphrase, or SaveIdentity.
+func LoadIdentityPassphrase(path string, passphrase []byte) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(text, encryptedKeyPrefix) {
+		return LoadIdentity(path)
+	}
+	b, err := hex.DecodeString(strings.TrimPrefix(text, encryptedKeyPrefix))
+	if err != nil || len(b) < 16+chacha20poly1305.NonceSizeX {
+		return nil, fmt.Errorf("invalid key file %s", path)
+	}
+	aead, err := passphraseAEAD(passphrase, b[:16])
+	if err != nil {
+		return nil, err
+	}
+	seed, err := aead.Open(nil, b[16:16+aead.NonceSize()], b[16+aead.NonceSize():], nil)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, ErrBadPassphrase
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// encryptedKeyPrefix starts the key files encrypted with a passphrase,
+// followed by the salt, the nonce and the sealed key, hex encoded.
+const encryptedKeyPrefix = "encrypted:"
+
+// Errors loading key files encrypted with a passphrase
+var (
+	ErrPassphraseRequired = errors.New("key file encrypted with a passphrase")
+	ErrBadPassphrase      = errors.New("wrong passphrase")
+)
+
+// passphraseAEAD derives a key from passphrase with scrypt, so guessing
+// it is slow.
+func passphraseAEAD(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, 1<<15, 8, 1, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(key)
+}
+
+// saveKey writes a 32 bytes key, hex encoded, to the file found at path.
+func saveKey(path string, key []byte) error {
+	return writeKeyFile(path, hex.EncodeToString(key))
+}
+
+// writeKeyFile writes text to the file found at path, only readable by
+// its owner.
+func writeKeyFile(path string, text string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	// The file may have existed with looser permissions
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := fmt.Fprintln(f, text); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadKey reads a key saved with saveKey.
+func loadKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(string(data), encryptedKeyPrefix) {
+		return nil, ErrPassphraseRequired
+	}
+	b, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(b) != 32 {
+		return nil, fmt.Errorf("invalid key file %s", path)
+	}
+	return b, nil
+}
+
+// LoadOrCreateKeyPair loads the key pair found at path,
+// generating and saving a new one if the file does not exist.
+func LoadOrCreateKeyPair(path string) (*KeyPair, error) {
+	kp, err := LoadKeyPair(path)
+	if !os.IsNotExist(err) {
+		return kp, err
+	}
+	kp, err = GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	if err := SaveKeyPair(path, kp); err != nil {
+		return nil, fmt.Errorf("error saving key pair: %s", err)
+	}
+	return kp, nil
+}
+
+// LoadOrCreateIdentity loads the identity key found at path,
+// generating and saving a new one if the file does not exist.
+func LoadOrCreateIdentity(path string) (ed25519.PrivateKey, error) {
+	identity, err := LoadIdentity(path)
+	if !os.IsNotExist(err) {
+		return identity, err
+	}
+	_, identity, err = ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := SaveIdentity(path, identity); err != nil {
+		return nil, fmt.Errorf("error saving identity: %s", err)
+	}
+	return identity, nil
+}
@@ -0,0 +1,105 @@
+package securecomm
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSaveLoadKeyPair(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "id.key")
+
+	kp, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveKeyPair(path, kp); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Fatalf("Unexpected key file permissions: %s", info.Mode().Perm())
+	}
+
+	loaded, err := LoadKeyPair(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *loaded.Public != *kp.Public || *loaded.Private != *kp.Private {
+		t.Fatal("Unexpected result. The loaded key pair differs from the saved one.")
+	}
+}
+
+func TestLoadOrCreateKeyPair(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "id.key")
+
+	created, err := LoadOrCreateKeyPair(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadOrCreateKeyPair(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *loaded.Public != *created.Public {
+		t.Fatal("Unexpected result. A new key pair was generated instead of loading the saved one.")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKeyPair(path); err == nil {
+		t.Fatal("expected an error loading an invalid key file")
+	}
+}
+
+func TestSaveLoadIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "id.key")
+
+	created, err := LoadOrCreateIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Equal(created) {
+		t.Fatal("Unexpected result. The loaded identity differs from the saved one.")
+	}
+}
+
+func TestSaveLoadIdentityPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "id.key")
+	_, identity, _ := ed25519.GenerateKey(rand.Reader)
+
+	if err := SaveIdentityPassphrase(path, identity, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIdentity(path); err != ErrPassphraseRequired {
+		t.Fatalf("Unexpected result. Expected %v, got %v", ErrPassphraseRequired, err)
+	}
+	if _, err := LoadIdentityPassphrase(path, []byte("guess")); err != ErrBadPassphrase {
+		t.Fatalf("Unexpected result. Expected %v, got %v", ErrBadPassphrase, err)
+	}
+	loaded, err := LoadIdentityPassphrase(path, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Equal(identity) {
+		t.Fatal("Unexpected result. The loaded identity differs from the saved one.")
+	}
+
+	// Unencrypted files load as well
+	if err := SaveIdentity(path, identity); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := LoadIdentityPassphrase(path, nil); err != nil || !loaded.Equal(identity) {
+		t.Fatalf("Unexpected result loading an unencrypted key: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package securecomm
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrHostKeyChanged is returned when a server presents an identity
+// different from the one recorded on a previous connection.
+var ErrHostKeyChanged = errors.New("server identity has changed")
+
+// ErrHostNotConfirmed is returned when the identity of a new server is
+// not confirmed.
+var ErrHostNotConfirmed = errors.New("server identity not confirmed")
+
+// KnownHosts trusts server identities on first use: the fingerprint of
+// each server identity is recorded the first time we connect to it and
+// must match on the following connections.
+type KnownHosts struct {
+	// Replace trusts and records a changed identity instead of failing.
+	Replace bool
+
+	// Confirm, if not nil, is asked whether to trust the identity of a
+	// server we never connected to. It is trusted on first use
+	// otherwise.
+	Confirm func(addr string, identity ed25519.PublicKey) bool
+
+	path string
+	mu   sync.Mutex
+}
+
+// NewKnownHosts returns a KnownHosts backed by the file found at path.
+// The file is created on the first connection.
+func NewKnownHosts(path string) *KnownHosts {
+	return &KnownHosts{path: path}
+}
+
+// Verify checks the identity of the server found at addr.
+// It can be used as Config.VerifyPeer.
+func (k *KnownHosts) Verify(addr string, identity ed25519.PublicKey) error {
+	return k.VerifyRotation(addr, nil, identity)
+}
+
+// VerifyRotation is like Verify, but also trusts and records the
+// identity if it is endorsed by the previous one we know. It can be used
+// as Config.VerifyRotation.
+func (k *KnownHosts) VerifyRotation(addr string, previous, identity ed25519.PublicKey) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	hosts, err := k.load()
+	if err != nil {
+		return err
+	}
+	fingerprint := Fingerprint(identity)
+	known, ok := hosts[addr]
+	if ok && known == fingerprint {
+		return nil
+	}
+	if ok && !k.Replace && (previous == nil || known != Fingerprint(previous)) {
+		return fmt.Errorf("%w!\n"+
+			"Someone could be eavesdropping on you right now (man-in-the-middle attack),\n"+
+			"or the server identity has just been changed.\n"+
+			"Known fingerprint for %s: %s\n"+
+			"Received fingerprint:  %s\n"+
+			"Fix %s or use -replace-hostkey to trust the new identity.",
+			ErrHostKeyChanged, addr, known, fingerprint, k.path)
+	}
+	if !ok && k.Confirm != nil && !k.Confirm(addr, identity) {
+		return ErrHostNotConfirmed
+	}
+	hosts[addr] = fingerprint
+	return k.save(hosts)
+}
+
+func (k *KnownHosts) load() (map[string]string, error) {
+	hosts := make(map[string]string)
+	f, err := os.Open(k.path)
+	if os.IsNotExist(err) {
+		return hosts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		hosts[fields[0]] = fields[1]
+	}
+	return hosts, scanner.Err()
+}
+
+func (k *KnownHosts) save(hosts map[string]string) error {
+	addrs := make([]string, 0, len(hosts))
+	for addr := range hosts {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	var b strings.Builder
+	for _, addr := range addrs {
+		fmt.Fprintf(&b, "%s %s\n", addr, hosts[addr])
+	}
+	return ioutil.WriteFile(k.path, []byte(b.String()), 0600)
+}
@@ -0,0 +1,65 @@
+package securecomm
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestKnownHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	id, _, _ := ed25519.GenerateKey(rand.Reader)
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	kh := NewKnownHosts(path)
+	// Trusted on first use
+	if err := kh.Verify("example.com:4000", id); err != nil {
+		t.Fatal(err)
+	}
+	// Recorded on disk
+	kh = NewKnownHosts(path)
+	if err := kh.Verify("example.com:4000", id); err != nil {
+		t.Fatal(err)
+	}
+	if err := kh.Verify("example.com:4000", other); !errors.Is(err, ErrHostKeyChanged) {
+		t.Fatalf("expected ErrHostKeyChanged, got %v", err)
+	}
+	// Other hosts are not affected
+	if err := kh.Verify("example.org:4000", other); err != nil {
+		t.Fatal(err)
+	}
+
+	kh.Replace = true
+	if err := kh.Verify("example.com:4000", other); err != nil {
+		t.Fatal(err)
+	}
+	kh = NewKnownHosts(path)
+	if err := kh.Verify("example.com:4000", other); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKnownHostsConfirm(t *testing.T) {
+	id, _, _ := ed25519.GenerateKey(rand.Reader)
+	kh := NewKnownHosts(filepath.Join(t.TempDir(), "known_hosts"))
+
+	var asked int
+	confirmed := false
+	kh.Confirm = func(addr string, identity ed25519.PublicKey) bool {
+		asked++
+		return confirmed
+	}
+	if err := kh.Verify("example.com:4000", id); err != ErrHostNotConfirmed {
+		t.Fatalf("expected ErrHostNotConfirmed, got %v", err)
+	}
+	confirmed = true
+	if err := kh.Verify("example.com:4000", id); err != nil {
+		t.Fatal(err)
+	}
+	// Known hosts are not confirmed again
+	if err := kh.Verify("example.com:4000", id); err != nil || asked != 2 {
+		t.Fatalf("Unexpected result: %v, asked %d times", err, asked)
+	}
+}
@@ -0,0 +1,83 @@
+package securecomm
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
+// handshakeTimeout bounds the handshake of accepted connections, so
+// stalled clients don't pile up.
+const handshakeTimeout = 10 * time.Second
+
+// listener performs the handshake of the accepted connections.
+type listener struct {
+	net.Listener
+	cfg *Config
+	tls *tls.Config // if not nil, TLS clients are also accepted
+
+	once  sync.Once
+	conns chan net.Conn
+	done  chan struct{}
+	err   error // set before done is closed
+}
+
+// NewListener returns a listener whose Accept returns connections that
+// completed the handshake, using the given config. Handshakes run
+// concurrently, so a slow client doesn't hold the others back, and
+// connections failing the handshake are closed and logged.
+func NewListener(inner net.Listener, cfg *Config) net.Listener {
+	return &listener{
+		Listener: inner,
+		cfg:      cfg,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+}
+
+// Accept waits for the next connection that completed the handshake.
+func (l *listener) Accept() (net.Conn, error) {
+	l.once.Do(func() { go l.serve() })
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+func (l *listener) serve() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			l.err = err
+			close(l.done)
+			return
+		}
+		go l.handshake(c)
+	}
+}
+
+func (l *listener) handshake(c net.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	var conn net.Conn
+	var err error
+	if l.tls != nil {
+		conn, err = sniffHandshake(ctx, c, l.cfg, l.tls)
+	} else {
+		conn, err = newServerConn(ctx, c, l.cfg)
+	}
+	if err != nil {
+		l.cfg.logger().Warn("handshake failed", "conn", nextConnID(), "peer", c.RemoteAddr().String(), "err", err)
+		c.Close()
+		return
+	}
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
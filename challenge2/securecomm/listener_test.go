@@ -0,0 +1,70 @@
+package securecomm
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, clientID, _ := ed25519.GenerateKey(rand.Reader)
+	l := NewListener(inner, nil)
+	defer l.Close()
+
+	// A client failing the handshake doesn't stop the listener
+	raw, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.Close()
+
+	go func() {
+		conn, err := DialConfig(l.Addr().String(), &Config{Identity: clientID})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("hello world\n"))
+	}()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if !conn.(*Conn).PeerIdentity().Equal(clientID.Public()) {
+		t.Fatal("Unexpected result. The accepted connection does not expose the client identity.")
+	}
+	buf := make([]byte, len("hello world\n"))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf); got != "hello world\n" {
+		t.Fatalf("Unexpected result.\nGot:\t\t%s\nExpected:\t%s\n", got, "hello world\n")
+	}
+}
+
+func TestListenerClose(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(inner, nil)
+
+	done := make(chan error)
+	go func() {
+		_, err := l.Accept()
+		done <- err
+	}()
+	l.Close()
+	if err := <-done; err == nil {
+		t.Fatal("Unexpected result. Accept succeeded on a closed listener")
+	}
+}
@@ -0,0 +1,26 @@
+package securecomm
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// Logger is the structured logger used by servers and listeners, with
+// the arguments of the messages given as key-value pairs. *slog.Logger
+// implements it.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// discardLogger is used when no logger is configured.
+var discardLogger Logger = slog.New(slog.DiscardHandler)
+
+// connID numbers the connections, identifying them in the logs.
+var connID atomic.Uint64
+
+func nextConnID() uint64 {
+	return connID.Add(1)
+}
@@ -0,0 +1,93 @@
+package securecomm
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/grandcat/zeroconf"
+)
+
+// mdnsService is the DNS-SD service type advertised on the local network
+const mdnsService = "_secure._tcp"
+
+// mdnsFingerprint prefixes the TXT record carrying the server identity
+const mdnsFingerprint = "fp="
+
+// Advertised is a server found on the local network by Discover.
+type Advertised struct {
+	Name        string
+	Addrs       []string // host:port, IPv4 first
+	Fingerprint string   // of the server identity, if advertised
+}
+
+// Advertise announces the server listening on port on the local network
+// with mDNS under name, along with the fingerprint of its identity when
+// not nil, until stop is called.
+func Advertise(name string, port int, identity ed25519.PublicKey) (stop func(), err error) {
+	var text []string
+	if identity != nil {
+		text = append(text, mdnsFingerprint+Fingerprint(identity))
+	}
+	server, err := zeroconf.Register(name, mdnsService, "local.", port, text, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error advertising %s: %w", name, err)
+	}
+	return server.Shutdown, nil
+}
+
+// Discover returns the servers advertised on the local network,
+// browsing until ctx is done.
+func Discover(ctx context.Context) ([]Advertised, error) {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(chan *zeroconf.ServiceEntry)
+	if err := resolver.Browse(ctx, mdnsService, "local.", entries); err != nil {
+		return nil, err
+	}
+	var found []Advertised
+	for entry := range entries {
+		found = append(found, advertisedOf(entry))
+	}
+	return found, nil
+}
+
+// LookupAdvertised returns the address of the server advertised on the
+// local network under name.
+func LookupAdvertised(ctx context.Context, name string) (string, error) {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	entries := make(chan *zeroconf.ServiceEntry)
+	if err := resolver.Lookup(ctx, name, mdnsService, "local.", entries); err != nil {
+		return "", err
+	}
+	for entry := range entries {
+		if a := advertisedOf(entry); len(a.Addrs) > 0 {
+			return a.Addrs[0], nil
+		}
+	}
+	return "", fmt.Errorf("%s not found on the local network", name)
+}
+
+func advertisedOf(entry *zeroconf.ServiceEntry) Advertised {
+	a := Advertised{Name: entry.Instance}
+	port := strconv.Itoa(entry.Port)
+	for _, ip := range append(entry.AddrIPv4, entry.AddrIPv6...) {
+		a.Addrs = append(a.Addrs, net.JoinHostPort(ip.String(), port))
+	}
+	for _, text := range entry.Text {
+		if fp, ok := strings.CutPrefix(text, mdnsFingerprint); ok {
+			a.Fingerprint = fp
+		}
+	}
+	return a
+}
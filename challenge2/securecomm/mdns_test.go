@@ -0,0 +1,56 @@
+package securecomm
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+func TestAdvertisedOf(t *testing.T) {
+	entry := zeroconf.NewServiceEntry("laptop", mdnsService, "local.")
+	entry.Port = 2000
+	entry.AddrIPv4 = []net.IP{net.ParseIP("192.168.1.2")}
+	entry.AddrIPv6 = []net.IP{net.ParseIP("fe80::1")}
+	entry.Text = []string{"other=1", mdnsFingerprint + "SHA256:abc"}
+
+	a := advertisedOf(entry)
+	if a.Name != "laptop" || a.Fingerprint != "SHA256:abc" {
+		t.Fatalf("got %+v", a)
+	}
+	if len(a.Addrs) != 2 || a.Addrs[0] != "192.168.1.2:2000" || a.Addrs[1] != "[fe80::1]:2000" {
+		t.Fatalf("got addresses %v", a.Addrs)
+	}
+}
+
+func TestAdvertise(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop, err := Advertise("secure-test", 2000, public)
+	if err != nil {
+		t.Skipf("mDNS unavailable: %s", err)
+	}
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	found, err := Discover(ctx)
+	if err != nil {
+		t.Skipf("mDNS unavailable: %s", err)
+	}
+	for _, a := range found {
+		if a.Name == "secure-test" {
+			if a.Fingerprint != Fingerprint(public) {
+				t.Errorf("got fingerprint %s, want %s", a.Fingerprint, Fingerprint(public))
+			}
+			return
+		}
+	}
+	t.Skip("mDNS unavailable: advertised server not found")
+}
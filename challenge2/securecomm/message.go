@@ -0,0 +1,63 @@
+package securecomm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrMessageTooLarge is returned when writing a message that does not
+// fit in a frame, see Conn.MaxMessageSize.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// ReadMessage returns the next message written with WriteMessage, the
+// payload of the next data frame. After a Read returning part of a
+// frame, it returns the rest of that frame.
+func (sr *SecureReader) ReadMessage() ([]byte, error) {
+	msg := sr.buf
+	if len(msg) == 0 {
+		var err error
+		if msg, err = sr.nextData(); err != nil {
+			return nil, err
+		}
+	}
+	msg = append([]byte{}, msg...)
+	sr.buf = nil
+	sr.release()
+	return msg, nil
+}
+
+// WriteMessage encrypts p in a single frame, so the peer reads it as a
+// whole with ReadMessage. Unlike Write, messages larger than a frame
+// are not split but fail with ErrMessageTooLarge.
+func (sw *SecureWriter) WriteMessage(p []byte) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if len(p) > sw.maxChunk() {
+		return fmt.Errorf("%w: %d bytes exceeds the maximum of %d", ErrMessageTooLarge, len(p), sw.maxChunk())
+	}
+	return sw.writeData(p)
+}
+
+// ReadMessage reads the next message, see SecureReader.ReadMessage. The
+// connection is closed when a frame is malformed or fails
+// authentication, like with Read.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	sr := c.Reader.(*SecureReader)
+	msg, err := sr.ReadMessage()
+	if err != nil && err == sr.err {
+		c.Close()
+	}
+	return msg, err
+}
+
+// WriteMessage writes p as a single message, see
+// SecureWriter.WriteMessage.
+func (c *Conn) WriteMessage(p []byte) error {
+	return c.Writer.(*SecureWriter).WriteMessage(p)
+}
+
+// MaxMessageSize returns the size of the largest message written with
+// WriteMessage, set by the frame size negotiated during the handshake.
+func (c *Conn) MaxMessageSize() int {
+	return c.Writer.(*SecureWriter).maxChunk()
+}
@@ -0,0 +1,46 @@
+package securecomm
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMessages(t *testing.T) {
+	client, server, err := SecurePipeConfig(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	defer server.Close()
+
+	messages := [][]byte{[]byte("first"), {}, bytes.Repeat([]byte("x"), client.MaxMessageSize())}
+	go func() {
+		for _, msg := range messages {
+			client.WriteMessage(msg)
+		}
+		client.Write([]byte("stream"))
+	}()
+	for _, exp := range messages {
+		got, err := server.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, exp) {
+			t.Fatalf("Unexpected result. Got a message of %d bytes, expected %d", len(got), len(exp))
+		}
+	}
+
+	// The rest of a frame partly read is a message
+	buf := make([]byte, 2)
+	if _, err := server.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := server.ReadMessage(); err != nil || string(got) != "ream" {
+		t.Fatalf("Unexpected result. Got %q, %v", got, err)
+	}
+
+	if err := client.WriteMessage(make([]byte, client.MaxMessageSize()+1)); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("Unexpected result. Got %v, expected %v", err, ErrMessageTooLarge)
+	}
+}
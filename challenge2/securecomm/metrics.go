@@ -0,0 +1,154 @@
+package securecomm
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Collector records the metrics of a Server. It implements
+// prometheus.Collector, so it can be registered with a Prometheus
+// registry. Its methods do nothing on a nil Collector.
+type Collector struct {
+	activeConns       prometheus.Gauge
+	rejectedConns     prometheus.Counter
+	handshakeFailures prometheus.Counter
+	handshakeDuration prometheus.Histogram
+	bytesIn           prometheus.Counter
+	bytesOut          prometheus.Counter
+	decryptErrors     prometheus.Counter
+	bans              prometheus.Counter
+}
+
+// NewCollector returns a new Collector, with all the metrics at zero.
+func NewCollector() *Collector {
+	const ns = "secure"
+	return &Collector{
+		activeConns: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: ns, Name: "active_connections",
+			Help: "Number of connections that completed the handshake and are still open.",
+		}),
+		rejectedConns: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns, Name: "rejected_connections_total",
+			Help: "Number of connections rejected by the connection limits, the address filter or the bans.",
+		}),
+		handshakeFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns, Name: "handshake_failures_total",
+			Help: "Number of failed handshakes.",
+		}),
+		handshakeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: ns, Name: "handshake_duration_seconds",
+			Help:    "Duration of the handshakes, successful or not.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+		}),
+		bytesIn: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns, Name: "received_bytes_total",
+			Help: "Number of plaintext bytes received.",
+		}),
+		bytesOut: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns, Name: "sent_bytes_total",
+			Help: "Number of plaintext bytes sent.",
+		}),
+		decryptErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns, Name: "decrypt_errors_total",
+			Help: "Number of frames that failed authentication or were replayed.",
+		}),
+		bans: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: ns, Name: "bans_total",
+			Help: "Number of IP addresses banned after failing too often.",
+		}),
+	}
+}
+
+func (c *Collector) metrics() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.activeConns, c.rejectedConns, c.handshakeFailures, c.handshakeDuration,
+		c.bytesIn, c.bytesOut, c.decryptErrors, c.bans,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics() {
+		m.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c.metrics() {
+		m.Collect(ch)
+	}
+}
+
+// ServeMetrics serves the metrics of c on addr, at /metrics.
+func ServeMetrics(addr string, c *Collector) error {
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(c); err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	return http.ListenAndServe(addr, mux)
+}
+
+func (c *Collector) rejected() {
+	if c != nil {
+		c.rejectedConns.Inc()
+	}
+}
+
+func (c *Collector) handshakeDone(d time.Duration, err error) {
+	if c == nil {
+		return
+	}
+	c.handshakeDuration.Observe(d.Seconds())
+	if err != nil {
+		c.handshakeFailures.Inc()
+	}
+}
+
+func (c *Collector) connOpened() {
+	if c != nil {
+		c.activeConns.Inc()
+	}
+}
+
+func (c *Collector) connClosed() {
+	if c != nil {
+		c.activeConns.Dec()
+	}
+}
+
+func (c *Collector) received(n int) {
+	if c != nil {
+		c.bytesIn.Add(float64(n))
+	}
+}
+
+func (c *Collector) sent(n int) {
+	if c != nil {
+		c.bytesOut.Add(float64(n))
+	}
+}
+
+func (c *Collector) readFailed(err error) {
+	if c != nil && isAuthFailure(err) {
+		c.decryptErrors.Inc()
+	}
+}
+
+func (c *Collector) banned() {
+	if c != nil {
+		c.bans.Inc()
+	}
+}
+
+// isAuthFailure tells whether err is caused by a frame failing
+// authentication or replayed.
+func isAuthFailure(err error) bool {
+	return errors.Is(err, errDecrypt) || errors.Is(err, ErrReplay) || errors.Is(err, ErrFrameLost)
+}
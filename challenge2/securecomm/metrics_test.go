@@ -0,0 +1,53 @@
+package securecomm
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+	s := &Server{Collector: c}
+	addr, _ := startServer(t, s)
+
+	// A client failing the handshake
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.Close()
+
+	conn, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello world\n"))
+	io.ReadFull(conn, make([]byte, len("hello world\n")))
+	conn.Close()
+	s.Shutdown(context.Background())
+
+	tData := []struct {
+		name     string
+		got      float64
+		expected float64
+	}{
+		{"active connections", testutil.ToFloat64(c.activeConns), 0},
+		{"handshake failures", testutil.ToFloat64(c.handshakeFailures), 1},
+		{"received bytes", testutil.ToFloat64(c.bytesIn), 12},
+		{"sent bytes", testutil.ToFloat64(c.bytesOut), 12},
+		{"decrypt errors", testutil.ToFloat64(c.decryptErrors), 0},
+		{"bans", testutil.ToFloat64(c.bans), 0},
+	}
+	for _, exp := range tData {
+		if exp.got != exp.expected {
+			t.Fatalf("Unexpected %s. Got %v, expected %v", exp.name, exp.got, exp.expected)
+		}
+	}
+	if n := testutil.CollectAndCount(c); n != 8 {
+		t.Fatalf("Unexpected number of metrics: %d", n)
+	}
+}
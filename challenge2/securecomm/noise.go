@@ -0,0 +1,170 @@
+package securecomm
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// This file implements the parts of the Noise protocol framework
+// (https://noiseprotocol.org/noise.html) needed by the handshake,
+// with the 25519 DH functions, the ChaChaPoly cipher and SHA256.
+
+const (
+	noiseProtocol    = "Noise_XX_25519_ChaChaPoly_SHA256"
+	noisePSKProtocol = "Noise_XXpsk2_25519_ChaChaPoly_SHA256"
+)
+
+var errNoiseDecrypt = errors.New("could not decrypt handshake message")
+
+// cipherState encrypts the handshake payloads once a key is known.
+type cipherState struct {
+	k      [32]byte
+	hasKey bool
+	n      uint64
+}
+
+func (cs *cipherState) initializeKey(k [32]byte) {
+	cs.k = k
+	cs.hasKey = true
+	cs.n = 0
+}
+
+func (cs *cipherState) nonce() []byte {
+	var nonce [chacha20poly1305.NonceSize]byte
+	binary.LittleEndian.PutUint64(nonce[4:], cs.n)
+	cs.n++
+	return nonce[:]
+}
+
+func (cs *cipherState) encryptWithAd(ad, plaintext []byte) []byte {
+	if !cs.hasKey {
+		return plaintext
+	}
+	aead, _ := chacha20poly1305.New(cs.k[:])
+	return aead.Seal(nil, cs.nonce(), plaintext, ad)
+}
+
+func (cs *cipherState) decryptWithAd(ad, ciphertext []byte) ([]byte, error) {
+	if !cs.hasKey {
+		return ciphertext, nil
+	}
+	aead, _ := chacha20poly1305.New(cs.k[:])
+	plaintext, err := aead.Open(nil, cs.nonce(), ciphertext, ad)
+	if err != nil {
+		return nil, errNoiseDecrypt
+	}
+	return plaintext, nil
+}
+
+// symmetricState holds the chaining key and the handshake hash,
+// which covers everything sent so far.
+type symmetricState struct {
+	cs cipherState
+	ck [32]byte
+	h  [32]byte
+}
+
+func newSymmetricState(protocolName string) *symmetricState {
+	ss := &symmetricState{}
+	if len(protocolName) <= sha256.Size {
+		copy(ss.h[:], protocolName)
+	} else {
+		ss.h = sha256.Sum256([]byte(protocolName))
+	}
+	ss.ck = ss.h
+	return ss
+}
+
+func (ss *symmetricState) mixHash(data []byte) {
+	ss.h = sha256.Sum256(append(ss.h[:], data...))
+}
+
+func (ss *symmetricState) mixKey(ikm []byte) {
+	out := noiseHKDF(ss.ck[:], ikm, 2)
+	ss.ck = out[0]
+	ss.cs.initializeKey(out[1])
+}
+
+func (ss *symmetricState) mixKeyAndHash(ikm []byte) {
+	out := noiseHKDF(ss.ck[:], ikm, 3)
+	ss.ck = out[0]
+	ss.mixHash(out[1][:])
+	ss.cs.initializeKey(out[2])
+}
+
+func (ss *symmetricState) encryptAndHash(plaintext []byte) []byte {
+	ciphertext := ss.cs.encryptWithAd(ss.h[:], plaintext)
+	ss.mixHash(ciphertext)
+	return ciphertext
+}
+
+func (ss *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	plaintext, err := ss.cs.decryptWithAd(ss.h[:], ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	ss.mixHash(ciphertext)
+	return plaintext, nil
+}
+
+// split returns the keys of the initiator and responder directions.
+func (ss *symmetricState) split() (*[32]byte, *[32]byte) {
+	out := noiseHKDF(ss.ck[:], nil, 2)
+	return &out[0], &out[1]
+}
+
+func noiseHKDF(ck, ikm []byte, outputs int) [][32]byte {
+	tempKey := hmacSHA256(ck, ikm)
+	out := make([][32]byte, outputs)
+	var prev []byte
+	for i := range out {
+		out[i] = hmacSHA256(tempKey[:], append(prev, byte(i+1)))
+		prev = out[i][:]
+	}
+	return out
+}
+
+func hmacSHA256(key, data []byte) [32]byte {
+	var sum [32]byte
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	copy(sum[:], mac.Sum(nil))
+	return sum
+}
+
+func dh(priv *[32]byte, pub []byte) ([]byte, error) {
+	return curve25519.X25519(priv[:], pub)
+}
+
+// Handshake messages are prefixed by their length as an uint16.
+
+// writeNoiseMessage writes msg, preceded by prefix, in a single write.
+func writeNoiseMessage(w io.Writer, msg []byte, prefix ...byte) error {
+	if len(msg) > math.MaxUint16 {
+		return fmt.Errorf("handshake message too long: %d bytes", len(msg))
+	}
+	buf := append(prefix, 0, 0)
+	binary.BigEndian.PutUint16(buf[len(prefix):], uint16(len(msg)))
+	_, err := w.Write(append(buf, msg...))
+	return err
+}
+
+func readNoiseMessage(r io.Reader) ([]byte, error) {
+	var size uint16
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,22 @@
+package securecomm
+
+import (
+	"net"
+	"strings"
+)
+
+// IsNamedPipe reports whether addr is the path of a Windows named pipe,
+// like \\.\pipe\secure, rather than a host:port.
+func IsNamedPipe(addr string) bool {
+	host, name, ok := strings.Cut(strings.TrimPrefix(addr, `\\`), `\`)
+	return strings.HasPrefix(addr, `\\`) && ok && host != "" &&
+		strings.HasPrefix(strings.ToLower(name), `pipe\`) && len(name) > len(`pipe\`)
+}
+
+// ListenNamedPipe listens on the Windows named pipe at path, for local
+// clients to connect with DialConfig like with TCP. The connections are
+// accepted as is, so the listener is passed to Server.Serve or
+// NewListener. It fails on other systems.
+func ListenNamedPipe(path string) (net.Listener, error) {
+	return listenNamedPipe(path)
+}
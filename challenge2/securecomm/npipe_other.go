@@ -0,0 +1,19 @@
+//go:build !windows
+
+package securecomm
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+var errNoNamedPipes = errors.New("named pipes are only supported on Windows")
+
+func listenNamedPipe(path string) (net.Listener, error) {
+	return nil, errNoNamedPipes
+}
+
+func dialNamedPipe(ctx context.Context, path string) (net.Conn, error) {
+	return nil, errNoNamedPipes
+}
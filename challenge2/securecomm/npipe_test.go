@@ -0,0 +1,23 @@
+package securecomm
+
+import "testing"
+
+func TestIsNamedPipe(t *testing.T) {
+	tData := []struct {
+		addr     string
+		expected bool
+	}{
+		{`\\.\pipe\secure`, true},
+		{`\\server\PIPE\secure`, true},
+		{`\\.\pipe\`, false},
+		{`\\.\secure`, false},
+		{`\\\pipe\secure`, false},
+		{"localhost:2000", false},
+		{"_secure._tcp.example.com", false},
+	}
+	for _, exp := range tData {
+		if got := IsNamedPipe(exp.addr); got != exp.expected {
+			t.Fatalf("Unexpected result for %s. Got %t, expected %t", exp.addr, got, exp.expected)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package securecomm
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/Microsoft/go-winio"
+)
+
+func listenNamedPipe(path string) (net.Listener, error) {
+	l, err := winio.ListenPipe(path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	return l, nil
+}
+
+func dialNamedPipe(ctx context.Context, path string) (net.Conn, error) {
+	conn, err := winio.DialPipeContext(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", path, err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,31 @@
+package securecomm
+
+import (
+	"io"
+	"testing"
+)
+
+func TestNamedPipe(t *testing.T) {
+	path := `\\.\pipe\securecomm-test`
+	l, err := ListenNamedPipe(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s := &Server{}
+	go s.Serve(l)
+	defer s.Close()
+
+	conn, err := DialConfig(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("Unexpected result. Got %q, %v", buf, err)
+	}
+}
@@ -0,0 +1,124 @@
+package securecomm
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the instrumentation scope of the spans
+const tracerName = "github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+
+// Attributes of the spans, besides the semantic conventions
+const (
+	attrIdentity    = attribute.Key("secure.peer.identity")
+	attrVersion     = attribute.Key("secure.version")
+	attrSuite       = attribute.Key("secure.suite")
+	attrPostQuantum = attribute.Key("secure.post_quantum")
+	attrProtocol    = attribute.Key("secure.protocol")
+	attrSent        = attribute.Key("secure.sent_bytes")
+	attrReceived    = attribute.Key("secure.received_bytes")
+)
+
+func (c *Config) tracer() trace.Tracer {
+	if c == nil || c.TracerProvider == nil {
+		return noop.Tracer{}
+	}
+	return c.TracerProvider.Tracer(tracerName)
+}
+
+// startSpan starts a span of the given kind, with the address of the
+// peer if known.
+func (c *Config) startSpan(ctx context.Context, name string, kind trace.SpanKind, peer net.Addr) (context.Context, trace.Span) {
+	opts := []trace.SpanStartOption{trace.WithSpanKind(kind)}
+	if peer != nil {
+		opts = append(opts, trace.WithAttributes(attribute.String("network.peer.address", peer.String())))
+	}
+	return c.tracer().Start(ctx, name, opts...)
+}
+
+// endSpan ends span, marked as failed if err is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// peerAttributes describes the peer of a successful handshake.
+func peerAttributes(hs *handshake) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attrIdentity.String(Fingerprint(hs.peerIdentity)),
+		attrVersion.Int(int(hs.version)),
+		attrSuite.String(hs.suite.String()),
+		attrPostQuantum.Bool(hs.hybrid),
+	}
+	if hs.protocol != "" {
+		attrs = append(attrs, attrProtocol.String(hs.protocol))
+	}
+	return attrs
+}
+
+// connSpan is the span of a connection, from the end of the handshake
+// to Close, recording the key changes as events and the sealed bytes of
+// the frames transferred.
+type connSpan struct {
+	span     trace.Span
+	sent     atomic.Int64
+	received atomic.Int64
+	ended    sync.Once
+}
+
+// startConnSpan returns the span of a connection, nil without
+// Config.TracerProvider.
+func startConnSpan(ctx context.Context, c net.Conn, hs *handshake, cfg *Config) *connSpan {
+	if cfg == nil || cfg.TracerProvider == nil {
+		return nil
+	}
+	kind := trace.SpanKindServer
+	if hs.initiator {
+		kind = trace.SpanKindClient
+	}
+	_, span := cfg.startSpan(ctx, "secure.connection", kind, c.RemoteAddr())
+	span.SetAttributes(peerAttributes(hs)...)
+	return &connSpan{span: span}
+}
+
+// frame records a frame sent or received, of length sealed bytes.
+func (s *connSpan) frame(sent bool, typ string, length int, err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.span.AddEvent("frame rejected", trace.WithAttributes(attribute.String("error", err.Error())))
+		return
+	}
+	if sent {
+		s.sent.Add(int64(length))
+	} else {
+		s.received.Add(int64(length))
+	}
+	switch typ {
+	case "rekey", "ratchet", "ratchet-ack", "ratchet-switch":
+		s.span.AddEvent(typ, trace.WithAttributes(attribute.Bool("sent", sent)))
+	}
+}
+
+// end ends the span once the connection is closed, the first time
+// only.
+func (s *connSpan) end() {
+	if s == nil {
+		return
+	}
+	s.ended.Do(func() {
+		s.span.SetAttributes(attrSent.Int64(s.sent.Load()), attrReceived.Int64(s.received.Load()))
+		s.span.End()
+	})
+}
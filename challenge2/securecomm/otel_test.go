@@ -0,0 +1,95 @@
+package securecomm
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	l := NewListener(mustListen(t), &Config{PSK: []byte("secret"), TracerProvider: tp})
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	cfg := &Config{PSK: []byte("secret"), TracerProvider: tp}
+	conn, err := DialConfig(l.Addr().String(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.(*Conn).Rekey(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if _, err := DialConfig(l.Addr().String(), &Config{PSK: []byte("wrong"), TracerProvider: tp}); err == nil {
+		t.Fatal("Unexpected result. The handshake with the wrong PSK succeeded")
+	}
+
+	spans := make(map[string]int)
+	var dial, handshake, connection sdktrace.ReadOnlySpan
+	deadline := time.Now().Add(5 * time.Second)
+	for spans["secure.dial"] != 2 || spans["secure.handshake"] != 4 || spans["secure.connection"] != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Unexpected result. Got the spans %v", spans)
+		}
+		time.Sleep(10 * time.Millisecond)
+		clear(spans)
+		for _, s := range recorder.Ended() {
+			spans[s.Name()]++
+			if s.SpanKind().String() != "client" || s.Status().Code == codes.Error {
+				continue
+			}
+			switch s.Name() {
+			case "secure.dial":
+				dial = s
+			case "secure.handshake":
+				handshake = s
+			case "secure.connection":
+				connection = s
+			}
+		}
+	}
+	// The client and the server both record the handshakes and the
+	// connections
+	if handshake.Parent().SpanID() != dial.SpanContext().SpanID() || connection.Parent().SpanID() != dial.SpanContext().SpanID() {
+		t.Fatal("Unexpected result. The handshake and the connection are not part of the dial")
+	}
+	attrs := attribute.NewSet(connection.Attributes()...)
+	if v, _ := attrs.Value(attrVersion); v.AsInt64() != int64(maxVersion) {
+		t.Fatalf("Unexpected result. Got version %v", v.Emit())
+	}
+	if v, _ := attrs.Value(attrSent); v.AsInt64() == 0 {
+		t.Fatal("Unexpected result. No bytes sent recorded")
+	}
+	if events := connection.Events(); len(events) == 0 || events[0].Name != "rekey" {
+		t.Fatalf("Unexpected result. Got the events %v", events)
+	}
+}
+
+func mustListen(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
@@ -0,0 +1,513 @@
+package securecomm
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Datagram mode seals each datagram on its own, as UDP may drop, reorder
+// or duplicate them: every packet carries its nonce, made of random bytes
+// followed by a sequence number checked against a window of recent
+// packets.
+//
+// The handshake is the same as for streams, a handshake message per
+// datagram, started by the client sending a hello to the server: the
+// magic padded with zeros, as large as the first reply of the server so
+// spoofed hellos can't amplify traffic. Lost handshake datagrams aren't
+// retransmitted, so the handshake fails after handshakeTimeout. Data
+// packets failing authentication or replayed are silently dropped, and
+// keys are never rotated.
+
+// maxDatagramSize is the largest payload of a sealed UDP datagram.
+const maxDatagramSize = 65507
+
+// replayWindowSize is the number of recent sequence numbers remembered
+// to detect replayed datagrams.
+const replayWindowSize = 64
+
+// sessionTimeout is how long a server keeps the session of a silent peer.
+const sessionTimeout = 2 * time.Minute
+
+// maxPacketSessions is the number of peers of ServePacket, whose hellos
+// are ignored past it.
+const maxPacketSessions = 1024
+
+// packetHello starts a session, the largest first reply of the server
+// being its preamble with a password element.
+var packetHello = magic + strings.Repeat("\x00", preambleSize+pakeElementSize-len(magic))
+
+// errDatagramTooLarge is returned when writing a datagram that can't fit
+// a sealed UDP packet.
+var errDatagramTooLarge = errors.New("datagram too large")
+
+// replayWindow tracks the sequence numbers received recently.
+type replayWindow struct {
+	highest uint64 // highest sequence number received
+	seen    uint64 // bit i is set if highest-i was received
+	started bool
+}
+
+// accept tells whether seq is new and records it.
+func (w *replayWindow) accept(seq uint64) bool {
+	switch {
+	case !w.started || seq > w.highest:
+		shift := seq - w.highest
+		if !w.started || shift >= replayWindowSize {
+			w.seen = 0
+		} else {
+			w.seen <<= shift
+		}
+		w.seen |= 1
+		w.highest = seq
+		w.started = true
+		return true
+	case w.highest-seq >= replayWindowSize:
+		return false
+	default:
+		bit := uint64(1) << (w.highest - seq)
+		if w.seen&bit != 0 {
+			return false
+		}
+		w.seen |= bit
+		return true
+	}
+}
+
+// packetCipher seals and opens the datagrams of a session.
+type packetCipher struct {
+	send, recv cipher.AEAD
+
+	mu     sync.Mutex
+	seq    uint64 // sequence number of the next datagram sent
+	window replayWindow
+}
+
+func newPacketCipher(hs *handshake) (*packetCipher, error) {
+	send, err := hs.suite.newAEAD(hs.sendKey)
+	if err != nil {
+		return nil, err
+	}
+	recv, err := hs.suite.newAEAD(hs.recvKey)
+	if err != nil {
+		return nil, err
+	}
+	return &packetCipher{send: send, recv: recv}, nil
+}
+
+// seal appends the sealed datagram of p to dst.
+func (pc *packetCipher) seal(dst, p []byte) ([]byte, error) {
+	if len(p) > maxDatagramSize-pc.send.NonceSize()-pc.send.Overhead() {
+		return nil, errDatagramTooLarge
+	}
+	nonceSize := pc.send.NonceSize()
+	dst = append(dst, make([]byte, nonceSize)...)
+	nonce := dst[len(dst)-nonceSize:]
+	if _, err := io.ReadFull(rand.Reader, nonce[:nonceSize-8]); err != nil {
+		return nil, err
+	}
+	pc.mu.Lock()
+	binary.BigEndian.PutUint64(nonce[nonceSize-8:], pc.seq)
+	pc.seq++
+	pc.mu.Unlock()
+	return pc.send.Seal(dst, nonce, p, nil), nil
+}
+
+// open authenticates and decrypts a datagram, appending its payload to
+// dst. It reports false for forged, corrupted and replayed datagrams.
+func (pc *packetCipher) open(dst, packet []byte) ([]byte, bool) {
+	nonceSize := pc.recv.NonceSize()
+	if len(packet) < nonceSize+pc.recv.Overhead() {
+		return nil, false
+	}
+	nonce := packet[:nonceSize]
+	payload, err := pc.recv.Open(dst, nonce, packet[nonceSize:], nil)
+	if err != nil {
+		return nil, false
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if !pc.window.accept(binary.BigEndian.Uint64(nonce[nonceSize-8:])) {
+		return nil, false
+	}
+	return payload, true
+}
+
+// datagramStream presents datagrams as a stream during the handshake,
+// each write being sent as a datagram.
+type datagramStream struct {
+	recv func() ([]byte, error)
+	send func([]byte) error
+	buf  []byte
+}
+
+func (s *datagramStream) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		var err error
+		if s.buf, err = s.recv(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *datagramStream) Write(p []byte) (int, error) {
+	if err := s.send(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// DatagramConn is a secure UDP connection. Each Write sends a sealed
+// datagram and each Read returns the payload of one.
+type DatagramConn struct {
+	conn         net.Conn
+	cipher       *packetCipher
+	peerIdentity ed25519.PublicKey
+	buf          []byte
+}
+
+// DatagramConn is a drop-in replacement for the underlying net.Conn
+var _ net.Conn = (*DatagramConn)(nil)
+
+// DialUDP performs the handshake with the server at addr over UDP, using
+// the keys of the given config.
+func DialUDP(addr string, cfg *Config) (*DatagramConn, error) {
+	return DialUDPContext(context.Background(), addr, cfg)
+}
+
+// DialUDPContext is like DialUDP but gives up once ctx is done. The
+// handshake always fails after handshakeTimeout, as lost handshake
+// datagrams aren't sent again.
+func DialUDPContext(ctx context.Context, addr string, cfg *Config) (*DatagramConn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", addr, err)
+	}
+	c, err := newDatagramConn(ctx, conn, addr, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func newDatagramConn(ctx context.Context, conn net.Conn, addr string, cfg *Config) (*DatagramConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	buf := make([]byte, maxDatagramSize)
+	stream := connStream(conn, buf)
+	var hs *handshake
+	err := withContext(ctx, conn, func() (err error) {
+		if _, err := conn.Write([]byte(packetHello)); err != nil {
+			return err
+		}
+		hs, err = clientHandshake(stream, cfg)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.verifyCertificate(hostOf(addr), hs.peerIdentity, hs.certificate); err != nil {
+		return nil, err
+	}
+	if err := cfg.verifyPeer(addr, hs.peerIdentity); err != nil {
+		return nil, err
+	}
+	pc, err := newPacketCipher(hs)
+	if err != nil {
+		return nil, err
+	}
+	return &DatagramConn{conn: conn, cipher: pc, peerIdentity: hs.peerIdentity, buf: buf}, nil
+}
+
+// newDatagramServerConn performs the server side of the handshake over
+// conn, whose hello was already received, verifying the peer as addr.
+func newDatagramServerConn(ctx context.Context, conn net.Conn, addr string, cfg *Config) (*DatagramConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	buf := make([]byte, maxDatagramSize)
+	stream := connStream(conn, buf)
+	var hs *handshake
+	err := withContext(ctx, conn, func() (err error) {
+		hs, err = serverHandshake(stream, cfg)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.verifyCertificate("", hs.peerIdentity, hs.certificate); err != nil {
+		return nil, err
+	}
+	if err := cfg.verifyPeer(addr, hs.peerIdentity); err != nil {
+		return nil, err
+	}
+	pc, err := newPacketCipher(hs)
+	if err != nil {
+		return nil, err
+	}
+	return &DatagramConn{conn: conn, cipher: pc, peerIdentity: hs.peerIdentity, buf: buf}, nil
+}
+
+// connStream returns the handshake stream of conn, reading the
+// datagrams into buf.
+func connStream(conn net.Conn, buf []byte) *datagramStream {
+	return &datagramStream{
+		recv: func() ([]byte, error) {
+			n, err := conn.Read(buf)
+			return buf[:n], err
+		},
+		send: func(p []byte) error {
+			_, err := conn.Write(p)
+			return err
+		},
+	}
+}
+
+// Read waits for the next authentic datagram and copies its payload
+// to p. Like with UDP, the rest of the payload is discarded if p is too
+// small.
+func (c *DatagramConn) Read(p []byte) (int, error) {
+	for {
+		n, err := c.conn.Read(c.buf)
+		if err != nil {
+			return 0, err
+		}
+		payload, ok := c.cipher.open(c.buf[n:n], c.buf[:n])
+		if !ok {
+			continue
+		}
+		return copy(p, payload), nil
+	}
+}
+
+// Write seals p in a datagram and sends it.
+func (c *DatagramConn) Write(p []byte) (int, error) {
+	packet, err := c.cipher.seal(nil, p)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := c.conn.Write(packet); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// PeerIdentity returns the identity key of the peer,
+// verified during the handshake.
+func (c *DatagramConn) PeerIdentity() ed25519.PublicKey {
+	return c.peerIdentity
+}
+
+// Close the underlying connection
+func (c *DatagramConn) Close() error {
+	return c.conn.Close()
+}
+
+// LocalAddr returns the local address of the underlying connection
+func (c *DatagramConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the underlying connection
+func (c *DatagramConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines of the underlying connection
+func (c *DatagramConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the underlying connection
+func (c *DatagramConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the underlying connection
+func (c *DatagramConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// packetSession is the state of a peer of ServePacket.
+type packetSession struct {
+	packets     chan []byte
+	lastSeen    time.Time      // guarded by the sessions mutex
+	established bool           // guarded by the sessions mutex
+	next        *packetSession // handshake of a new hello, guarded by the sessions mutex
+}
+
+// ServePacket starts a secure UDP echo server on pc, using the keys of
+// the given config. Each peer address gets its own session, started by
+// a hello and followed by the handshake. A hello from the address of an
+// established session, like a restarted client, replaces it once its
+// handshake succeeds, so spoofed ones can't end sessions. A handshake
+// replacing a session is given up on the next hello, like a session
+// still in its handshake.
+func ServePacket(pc net.PacketConn, cfg *Config) error {
+	var mu sync.Mutex
+	sessions := make(map[string]*packetSession)
+	var lastSweep time.Time
+
+	// start runs the session s of addr, replacing prev once established
+	start := func(addr net.Addr, s, prev *packetSession) {
+		key := addr.String()
+		established := func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case sessions[key] == s:
+			case prev != nil && sessions[key] == prev && prev.next == s:
+				close(prev.packets)
+				sessions[key] = s
+			default:
+				// Replaced or timed out during the handshake
+				return false
+			}
+			s.established = true
+			s.lastSeen = time.Now()
+			return true
+		}
+		go func() {
+			servePacketSession(pc, addr, s, cfg, established)
+			mu.Lock()
+			if sessions[key] == s {
+				delete(sessions, key)
+			} else if prev != nil && prev.next == s {
+				prev.next = nil
+			}
+			mu.Unlock()
+		}()
+	}
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		packet := append([]byte(nil), buf[:n]...)
+		now := time.Now()
+
+		mu.Lock()
+		s, ok := sessions[addr.String()]
+		hello := string(packet) == packetHello
+		switch {
+		case hello && !ok:
+			if len(sessions) < maxPacketSessions {
+				s = &packetSession{packets: make(chan []byte, 16), lastSeen: now}
+				sessions[addr.String()] = s
+				start(addr, s, nil)
+			}
+		case hello && s.established:
+			if s.next != nil {
+				close(s.next.packets)
+			}
+			s.next = &packetSession{packets: make(chan []byte, 16)}
+			start(addr, s.next, s)
+		case hello:
+			close(s.packets)
+			s = &packetSession{packets: make(chan []byte, 16), lastSeen: now}
+			sessions[addr.String()] = s
+			start(addr, s, nil)
+		case ok:
+			s.lastSeen = now
+			// The packets go to the handshake of a new hello as well,
+			// the session ignoring them unless authentic
+			for _, s := range []*packetSession{s, s.next} {
+				if s == nil {
+					continue
+				}
+				select {
+				case s.packets <- packet:
+				default:
+					// Drop the packet like a full network queue
+				}
+			}
+		}
+		if now.Sub(lastSweep) > time.Second {
+			for key, s := range sessions {
+				if now.Sub(s.lastSeen) > sessionTimeout {
+					close(s.packets)
+					if s.next != nil {
+						close(s.next.packets)
+					}
+					delete(sessions, key)
+				}
+			}
+			lastSweep = now
+		}
+		mu.Unlock()
+	}
+}
+
+// servePacketSession performs the handshake with addr, then echoes its
+// datagrams until the session is closed, unless established, called
+// once the handshake succeeds, reports the session was dropped.
+func servePacketSession(pc net.PacketConn, addr net.Addr, s *packetSession, cfg *Config, established func() bool) {
+	logger := cfg.logger()
+	timeout := time.NewTimer(handshakeTimeout)
+	defer timeout.Stop()
+
+	stream := &datagramStream{
+		recv: func() ([]byte, error) {
+			select {
+			case packet, ok := <-s.packets:
+				if !ok {
+					return nil, io.EOF
+				}
+				return packet, nil
+			case <-timeout.C:
+				return nil, errors.New("handshake timed out")
+			}
+		},
+		send: func(p []byte) error {
+			_, err := pc.WriteTo(p, addr)
+			return err
+		},
+	}
+	hs, err := serverHandshake(stream, cfg)
+	if err == nil {
+		err = cfg.verifyCertificate("", hs.peerIdentity, hs.certificate)
+	}
+	if err == nil {
+		err = cfg.verifyPeer(addr.String(), hs.peerIdentity)
+	}
+	var c *packetCipher
+	if err == nil {
+		c, err = newPacketCipher(hs)
+	}
+	if err != nil {
+		logger.Warn("handshake failed", "peer", addr.String(), "err", err)
+		return
+	}
+	if !established() {
+		return
+	}
+
+	for packet := range s.packets {
+		payload, ok := c.open(nil, packet)
+		if !ok {
+			continue
+		}
+		reply, err := c.seal(nil, payload)
+		if err != nil {
+			continue
+		}
+		pc.WriteTo(reply, addr)
+	}
+}
@@ -0,0 +1,194 @@
+package securecomm
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReplayWindow(t *testing.T) {
+	var w replayWindow
+	tData := []struct {
+		seq uint64
+		ok  bool
+	}{
+		{0, true},
+		{0, false},
+		{2, true},
+		{1, true},
+		{1, false},
+		{100, true},
+		{36, false},
+		{37, true},
+		{99, true},
+		{99, false},
+	}
+
+	for _, exp := range tData {
+		if got := w.accept(exp.seq); got != exp.ok {
+			t.Fatalf("Unexpected result for %d. Got %t, expected %t", exp.seq, got, exp.ok)
+		}
+	}
+}
+
+func TestPacketCipher(t *testing.T) {
+	client, server, err := pipeHandshake(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send, err := newPacketCipher(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recv, err := newPacketCipher(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := send.seal(nil, []byte("first"))
+	second, _ := send.seal(nil, []byte("second"))
+
+	// Datagrams may arrive out of order, but only once
+	if got, ok := recv.open(nil, second); !ok || string(got) != "second" {
+		t.Fatalf("Unexpected result: %q, %t", got, ok)
+	}
+	if got, ok := recv.open(nil, first); !ok || string(got) != "first" {
+		t.Fatalf("Unexpected result: %q, %t", got, ok)
+	}
+	if _, ok := recv.open(nil, first); ok {
+		t.Fatal("Unexpected result. A replayed datagram was accepted")
+	}
+	first[len(first)-1] ^= 1
+	if _, ok := recv.open(nil, first); ok {
+		t.Fatal("Unexpected result. A corrupted datagram was accepted")
+	}
+}
+
+func TestDatagramEcho(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go ServePacket(pc, nil)
+
+	conn, err := DialUDP(pc.LocalAddr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	for _, msg := range [][]byte{[]byte("hello world\n"), bytes.Repeat([]byte{'x'}, 8000)} {
+		if _, err := conn.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, len(msg))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], msg) {
+			t.Fatalf("Unexpected result: got %d bytes, expected %d", n, len(msg))
+		}
+	}
+}
+
+// echoDatagram writes msg on c and checks it is echoed.
+func echoDatagram(t *testing.T, c *DatagramConn, msg string) {
+	t.Helper()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != msg {
+		t.Fatalf("Unexpected result. Got %q, expected %q", buf[:n], msg)
+	}
+}
+
+func TestServePacketHello(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go ServePacket(pc, nil)
+
+	// The bare magic gets no reply larger than itself
+	raw, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	raw.Write([]byte(magic))
+	raw.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := raw.Read(make([]byte, 512)); err == nil {
+		t.Fatalf("Unexpected result. Got a reply of %d bytes", n)
+	}
+
+	laddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	conn, err := net.DialUDP("udp", laddr, pc.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newDatagramConn(t.Context(), conn, pc.LocalAddr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echoDatagram(t, client, "hello")
+
+	// A hello with the address of the session, never followed by a
+	// handshake, doesn't end it
+	conn.Write([]byte(packetHello))
+	echoDatagram(t, client, "still there")
+
+	// A restarted client replaces the session
+	laddr = conn.LocalAddr().(*net.UDPAddr)
+	client.Close()
+	conn, err = net.DialUDP("udp", laddr, pc.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err = newDatagramConn(t.Context(), conn, pc.LocalAddr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	echoDatagram(t, client, "restarted")
+}
+
+func TestServePacketHelloDuringHandshake(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go ServePacket(pc, nil)
+
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A session whose handshake never completes
+	conn.Write([]byte(packetHello))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, maxDatagramSize)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	// The next hello ends it before its own handshake completes, still
+	// getting a working session
+	client, err := newDatagramConn(t.Context(), conn, pc.LocalAddr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	echoDatagram(t, client, "hello")
+}
@@ -0,0 +1,45 @@
+package securecomm
+
+import "encoding/binary"
+
+// Padded frames carry the length of the message before it, followed by
+// zeros up to the size of a bucket, so their size only tells the bucket
+// of the message.
+var paddingBuckets = []int{256, 1024, 4096}
+
+// paddedSize returns the size a plaintext of n bytes is padded to: the
+// smallest bucket it fits in, or the next multiple of the largest
+// bucket, within limit, the largest plaintext of a frame.
+func paddedSize(n, limit int) int {
+	for _, b := range paddingBuckets {
+		if n <= b {
+			return min(b, limit)
+		}
+	}
+	last := paddingBuckets[len(paddingBuckets)-1]
+	return min((n+last-1)/last*last, limit)
+}
+
+// appendPadded appends the length of p, p and the padding to plaintext,
+// which already holds the frame type, up to limit bytes at most. Its
+// capacity must fit a frame.
+func appendPadded(plaintext, p []byte, limit int) []byte {
+	plaintext = binary.BigEndian.AppendUint16(plaintext, uint16(len(p)))
+	plaintext = append(plaintext, p...)
+	n := len(plaintext)
+	plaintext = plaintext[:paddedSize(n, limit)]
+	clear(plaintext[n:])
+	return plaintext
+}
+
+// unpad returns the message of the payload of a padded frame.
+func unpad(payload []byte) ([]byte, error) {
+	if len(payload) < 2 {
+		return nil, errBadPadding
+	}
+	n := int(binary.BigEndian.Uint16(payload))
+	if n > len(payload)-2 {
+		return nil, errBadPadding
+	}
+	return payload[2 : 2+n], nil
+}
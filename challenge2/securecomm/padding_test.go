@@ -0,0 +1,86 @@
+package securecomm
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestPaddedSize(t *testing.T) {
+	tData := []struct {
+		size     int
+		limit    int
+		expected int
+	}{
+		{1, maxMessageSize + 1, 256},
+		{256, maxMessageSize + 1, 256},
+		{257, maxMessageSize + 1, 1024},
+		{4096, maxMessageSize + 1, 4096},
+		{4097, maxMessageSize + 1, 8192},
+		{maxMessageSize + 1, maxMessageSize + 1, maxMessageSize + 1},
+		{257, 300, 300},
+	}
+
+	for _, exp := range tData {
+		if got := paddedSize(exp.size, exp.limit); got != exp.expected {
+			t.Fatalf("Unexpected result for %d bytes. Got %d, expected %d", exp.size, got, exp.expected)
+		}
+	}
+}
+
+func TestPaddedFrames(t *testing.T) {
+	key := &[32]byte{'k', 'e', 'y'}
+
+	for _, size := range []int{0, 1, 254, 300, 5000, maxMessageSize - 2, maxMessageSize * 2} {
+		var wire bytes.Buffer
+		sw := newSecureWriter(&wire, SuiteNaClBox, key)
+		sw.padding = true
+		msg := bytes.Repeat([]byte{'x'}, size)
+		if _, err := sw.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+
+		// Every frame of a small message has the size of a bucket
+		if size > 0 && size < 4096 {
+			frame := 2 + 24 + box.Overhead + paddedSize(1+2+size, maxMessageSize+1)
+			if wire.Len() != frame {
+				t.Fatalf("Unexpected frame size for %d bytes: got %d, expected %d", size, wire.Len(), frame)
+			}
+		}
+
+		got, err := io.ReadAll(newSecureReader(&wire, SuiteNaClBox, key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatalf("Unexpected result: got %d bytes, expected %d", len(got), size)
+		}
+	}
+}
+
+func TestPaddingNegotiation(t *testing.T) {
+	for _, version := range []uint8{Version2, Version3} {
+		s := &Server{Config: &Config{MaxVersion: version}}
+		addr, _ := startServer(t, s)
+
+		conn, err := DialConfig(addr, &Config{Padding: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		padded := conn.(*Conn).Writer.(*SecureWriter).padding
+		if padded != (version >= Version3) {
+			t.Fatalf("Unexpected result. Padding is %t with version %d", padded, version)
+		}
+
+		// The server reads the padded frames
+		conn.Write([]byte("hello world\n"))
+		buf := make([]byte, len("hello world\n"))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		s.Close()
+	}
+}
@@ -0,0 +1,62 @@
+package securecomm
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"io"
+
+	"github.com/gtank/ristretto255"
+)
+
+// The password-authenticated key exchange follows CPace over
+// ristretto255: both sides hash the password into a secret generator,
+// exchange ephemeral multiples of it and hash the shared element into
+// a key. An eavesdropper gets nothing to test password guesses against
+// and an active attacker only gets one guess per connection.
+
+// pakeElementSize is the length of an encoded ristretto255 element
+const pakeElementSize = 32
+
+var errPAKEElement = errors.New("invalid password exchange element")
+
+type pake struct {
+	secret  *ristretto255.Scalar
+	element []byte // our public element, sent to the peer
+}
+
+// newPAKE starts an exchange bound to the session sid.
+func newPAKE(password, sid []byte) (*pake, error) {
+	h := sha512.New()
+	h.Write([]byte("go-challenges CPace generator"))
+	h.Write(password)
+	h.Write(sid)
+	generator := ristretto255.NewElement().FromUniformBytes(h.Sum(nil))
+
+	var b [64]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		return nil, err
+	}
+	secret := ristretto255.NewScalar().FromUniformBytes(b[:])
+	element := ristretto255.NewElement().ScalarMult(secret, generator)
+	return &pake{secret: secret, element: element.Encode(nil)}, nil
+}
+
+// key returns the key shared with the peer, given its public element.
+func (p *pake) key(peerElement, serverElement, cliElement []byte) ([]byte, error) {
+	peer := ristretto255.NewElement()
+	if err := peer.Decode(peerElement); err != nil {
+		return nil, errPAKEElement
+	}
+	shared := ristretto255.NewElement().ScalarMult(p.secret, peer)
+	if shared.Equal(ristretto255.NewElement().Zero()) == 1 {
+		return nil, errPAKEElement
+	}
+	h := sha256.New()
+	h.Write([]byte("go-challenges CPace key"))
+	h.Write(shared.Encode(nil))
+	h.Write(serverElement)
+	h.Write(cliElement)
+	return h.Sum(nil), nil
+}
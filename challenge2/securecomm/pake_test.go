@@ -0,0 +1,52 @@
+package securecomm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHandshakePassword(t *testing.T) {
+	tData := []struct {
+		name           string
+		clientPassword string
+		serverPassword string
+		ok             bool
+	}{
+		{"same", "correct horse", "correct horse", true},
+		{"different", "correct horse", "battery staple", false},
+	}
+
+	for _, exp := range tData {
+		clientHs, serverHs, err := pipeHandshake(
+			&Config{Password: []byte(exp.clientPassword)},
+			&Config{Password: []byte(exp.serverPassword)},
+		)
+		if !exp.ok {
+			if err == nil {
+				t.Fatalf("%s: expected the handshake to fail", exp.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected handshake failure: %v", exp.name, err)
+		}
+		if *clientHs.sendKey != *serverHs.recvKey {
+			t.Fatalf("%s: the session keys differ", exp.name)
+		}
+	}
+}
+
+func TestPAKEInvalidElement(t *testing.T) {
+	p, err := newPAKE([]byte("password"), []byte("sid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The identity element would give a key known to anyone
+	identity := make([]byte, pakeElementSize)
+	if _, err := p.key(identity, identity, p.element); err != errPAKEElement {
+		t.Fatalf("expected errPAKEElement, got %v", err)
+	}
+	if _, err := p.key(bytes.Repeat([]byte{0xff}, pakeElementSize), nil, nil); err != errPAKEElement {
+		t.Fatalf("expected errPAKEElement, got %v", err)
+	}
+}
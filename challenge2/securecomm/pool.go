@@ -0,0 +1,256 @@
+package securecomm
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed is returned by Pool.Get once the pool is closed.
+var ErrPoolClosed = errors.New("pool closed")
+
+// Delays between the attempts to refill a pool when the server is
+// unreachable.
+const (
+	minPoolRetry = 100 * time.Millisecond
+	maxPoolRetry = 30 * time.Second
+)
+
+// Pool keeps connections to a server warm, handshaked in the background,
+// so requests don't wait for a dial and a handshake each.
+//
+// The idle connections are read while they wait, processing the
+// heartbeats: the ones missing too many heartbeats, closed by the server
+// or receiving unexpected data are closed and replaced.
+type Pool struct {
+	addr string
+	cfg  *Config
+	size int
+
+	mu      sync.Mutex
+	idle    []*idleConn
+	dialing int
+	closed  bool
+	latency time.Duration // last measured, see Latency
+
+	refill chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// idleConn is a connection waiting in a pool.
+type idleConn struct {
+	conn    *Conn
+	stopped chan error // the error ending the read while idle
+}
+
+// NewPool returns a pool keeping size connections to addr ready, dialed
+// with cfg, with a heartbeat interval of 5 seconds if it sets none. The
+// pool is filled in the background.
+func NewPool(addr string, cfg *Config, size int) *Pool {
+	p := &Pool{
+		addr:   addr,
+		cfg:    WithHeartbeat(cfg),
+		size:   max(size, 1),
+		refill: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.fill()
+	return p
+}
+
+// Get returns an idle connection, or dials a new one when there is none.
+// The connection must be given back with Put, or closed if it failed.
+func (p *Pool) Get(ctx context.Context) (*Conn, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+		ic := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+		p.wakeFiller()
+		if conn := ic.take(); conn != nil {
+			return conn, nil
+		}
+	}
+	p.wakeFiller()
+	conn, err := DialConfigContext(ctx, p.addr, p.cfg)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*Conn), nil
+}
+
+// take stops reading the idle connection, returning it unless it failed
+// meanwhile.
+func (ic *idleConn) take() *Conn {
+	ic.conn.SetReadDeadline(time.Now())
+	err := <-ic.stopped
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		ic.conn.Close()
+		return nil
+	}
+	ic.conn.SetReadDeadline(time.Time{})
+	return ic.conn
+}
+
+// Put gives back a connection obtained with Get, once the exchange with
+// the server is over. It is closed when the pool is full or closed.
+func (p *Pool) Put(conn *Conn) {
+	conn.SetDeadline(time.Time{})
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle)+p.dialing >= p.size {
+		conn.Close()
+		return
+	}
+	p.add(conn)
+}
+
+// add makes conn idle, reading it until it is taken or fails. It is
+// called with the lock held.
+func (p *Pool) add(conn *Conn) {
+	ic := &idleConn{conn: conn, stopped: make(chan error, 1)}
+	p.idle = append(p.idle, ic)
+	go func() {
+		// Reading processes the heartbeats, and the server isn't
+		// expected to send anything
+		_, err := conn.Read(make([]byte, 1))
+		if err == nil {
+			err = errors.New("unexpected data from an idle connection")
+		}
+		ic.stopped <- err
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// Taken by Get
+			return
+		}
+		p.mu.Lock()
+		for i, c := range p.idle {
+			if c == ic {
+				p.idle = append(p.idle[:i], p.idle[i+1:]...)
+				conn.Close()
+				p.cfg.logger().Debug("idle connection failed", "addr", p.addr, "err", err)
+				break
+			}
+		}
+		p.mu.Unlock()
+		p.wakeFiller()
+	}()
+}
+
+func (p *Pool) wakeFiller() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// fill dials connections until the pool is full, each time it is woken
+// up, backing off while the server is unreachable.
+func (p *Pool) fill() {
+	defer p.wg.Done()
+	retry := minPoolRetry
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-p.done
+		cancel()
+	}()
+	for {
+		p.mu.Lock()
+		full := p.closed || len(p.idle) >= p.size
+		if !full {
+			p.dialing++
+		}
+		p.mu.Unlock()
+		if full {
+			select {
+			case <-p.done:
+				return
+			case <-p.refill:
+				continue
+			}
+		}
+
+		conn, err := DialConfigContext(ctx, p.addr, p.cfg)
+		p.mu.Lock()
+		p.dialing--
+		if err == nil {
+			if p.closed {
+				conn.Close()
+			} else {
+				p.add(conn.(*Conn))
+			}
+		}
+		p.mu.Unlock()
+		if err == nil {
+			retry = minPoolRetry
+			continue
+		}
+		p.cfg.logger().Warn("failed to fill the pool", "addr", p.addr, "err", err)
+		select {
+		case <-p.done:
+			return
+		case <-time.After(retry):
+		}
+		retry = min(retry*2, maxPoolRetry)
+	}
+}
+
+// Len returns the number of idle connections.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+// Latency returns the average round trip time measured by the heartbeats
+// of the idle connections, or the last one measured while none is idle.
+// It is zero until a heartbeat is answered.
+func (p *Pool) Latency() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var sum time.Duration
+	var n int
+	for _, ic := range p.idle {
+		if rtt := ic.conn.Latency(); rtt > 0 {
+			sum += rtt
+			n++
+		}
+	}
+	if n > 0 {
+		p.latency = sum / time.Duration(n)
+	}
+	return p.latency
+}
+
+// Close closes the idle connections and stops filling the pool. The
+// connections obtained with Get are closed when they are put back.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return net.ErrClosed
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	close(p.done)
+	for _, ic := range idle {
+		ic.conn.Close()
+	}
+	p.wg.Wait()
+	return nil
+}
@@ -0,0 +1,82 @@
+package securecomm
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	s := &Server{}
+	addr, _ := startServer(t, s)
+
+	p := NewPool(addr, &Config{HeartbeatInterval: 20 * time.Millisecond}, 2)
+	defer p.Close()
+	waitLen := func(n int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for p.Len() != n {
+			if time.Now().After(deadline) {
+				t.Fatalf("Unexpected result. The pool has %d idle connections, expected %d", p.Len(), n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	echo := func() {
+		t.Helper()
+		conn, err := p.Get(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+			t.Fatalf("got %q, %v", buf, err)
+		}
+		p.Put(conn)
+	}
+	waitLen(2)
+
+	// The idle connections stay up, answering the heartbeats
+	time.Sleep(200 * time.Millisecond)
+	echo()
+	waitLen(2)
+
+	// The connections closed by the server are replaced once it's back
+	s.Close()
+	waitLen(0)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := &Server{}
+	go restarted.Serve(l)
+	defer restarted.Close()
+	waitLen(2)
+	echo()
+
+	p.Close()
+	if _, err := p.Get(context.Background()); err != ErrPoolClosed {
+		t.Fatalf("Unexpected result. Expected %v, got %v", ErrPoolClosed, err)
+	}
+}
+
+func TestPoolEmpty(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	// With nothing idle, Get dials and reports the failure
+	p := NewPool(addr, nil, 1)
+	defer p.Close()
+	if _, err := p.Get(context.Background()); err == nil {
+		t.Fatal("Unexpected result. Getting a connection to an unreachable server succeeded")
+	}
+}
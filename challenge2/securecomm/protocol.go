@@ -0,0 +1,118 @@
+package securecomm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// maxProtocols bounds the number of application protocols offered
+const maxProtocols = 255
+
+// errNoProtocol is returned when the client offers application
+// protocols and the server supports none of them.
+var errNoProtocol = errors.New("no application protocol in common")
+
+// appendProtocols appends the count of protocols and the protocols,
+// each prefixed with its length, to b.
+func appendProtocols(b []byte, protocols []string) ([]byte, error) {
+	if len(protocols) > maxProtocols {
+		return nil, fmt.Errorf("too many application protocols: %d", len(protocols))
+	}
+	b = append(b, byte(len(protocols)))
+	for _, p := range protocols {
+		if len(p) == 0 || len(p) > 255 {
+			return nil, fmt.Errorf("invalid application protocol %q", p)
+		}
+		b = append(b, byte(len(p)))
+		b = append(b, p...)
+	}
+	return b, nil
+}
+
+// readProtocols reads the protocols appended by appendProtocols.
+func readProtocols(msg *[]byte) ([]string, error) {
+	count, err := next(msg, 1)
+	if err != nil {
+		return nil, err
+	}
+	protocols := make([]string, 0, count[0])
+	for range int(count[0]) {
+		size, err := next(msg, 1)
+		if err != nil {
+			return nil, err
+		}
+		p, err := next(msg, int(size[0]))
+		if err != nil {
+			return nil, err
+		}
+		protocols = append(protocols, string(p))
+	}
+	return protocols, nil
+}
+
+// readProtocol reads the protocol selected by the server, which must be
+// one of the offered ones.
+func readProtocol(msg *[]byte, offered []string) (string, error) {
+	size, err := next(msg, 1)
+	if err != nil {
+		return "", err
+	}
+	p, err := next(msg, int(size[0]))
+	if err != nil {
+		return "", err
+	}
+	protocol := string(p)
+	if protocol == "" && len(offered) == 0 {
+		return "", nil
+	}
+	for _, o := range offered {
+		if o == protocol {
+			return protocol, nil
+		}
+	}
+	return "", fmt.Errorf("server selected an unsupported application protocol %q", protocol)
+}
+
+// selectProtocol returns the first of our protocols offered by the
+// client, or none if the client offers none.
+func selectProtocol(ours, theirs []string) (string, error) {
+	if len(theirs) == 0 {
+		return "", nil
+	}
+	for _, p := range ours {
+		for _, t := range theirs {
+			if p == t {
+				return p, nil
+			}
+		}
+	}
+	return "", errNoProtocol
+}
+
+// ProtocolHandlers serves each connection with the handler of its
+// application protocol, see Config.Protocols. The handler of the empty
+// protocol serves the clients offering none.
+type ProtocolHandlers map[string]Handler
+
+func (m ProtocolHandlers) ServeConn(conn io.ReadWriteCloser, peer Peer) error {
+	h, ok := m[peer.Protocol]
+	if !ok {
+		return fmt.Errorf("no handler for application protocol %q", peer.Protocol)
+	}
+	return h.ServeConn(conn, peer)
+}
+
+// Protocols returns the protocols served, sorted, to be set as
+// Config.Protocols.
+func (m ProtocolHandlers) Protocols() []string {
+	var protocols []string
+	for p := range m {
+		if p != "" {
+			protocols = append(protocols, p)
+		}
+	}
+	sort.Strings(protocols)
+	return protocols
+}
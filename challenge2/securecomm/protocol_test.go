@@ -0,0 +1,74 @@
+package securecomm
+
+import (
+	"bufio"
+	"io"
+	"testing"
+)
+
+func TestProtocolNegotiation(t *testing.T) {
+	tData := []struct {
+		name     string
+		client   *Config
+		server   *Config
+		expected string
+		ok       bool
+	}{
+		{"server preference", &Config{Protocols: []string{"echo", "chat"}}, &Config{Protocols: []string{"chat", "echo"}}, "chat", true},
+		{"none offered", nil, &Config{Protocols: []string{"chat"}}, "", true},
+		{"none in common", &Config{Protocols: []string{"rpc"}}, &Config{Protocols: []string{"chat"}}, "", false},
+		{"old server", &Config{Protocols: []string{"chat"}}, &Config{Protocols: []string{"chat"}, MaxVersion: Version7}, "", true},
+	}
+	for _, exp := range tData {
+		s := &Server{Config: exp.server}
+		addr, _ := startServer(t, s)
+		conn, err := DialConfig(addr, exp.client)
+		if (err == nil) != exp.ok {
+			t.Fatalf("Unexpected result for %s: %v", exp.name, err)
+		}
+		if err == nil {
+			if got := conn.(*Conn).Protocol(); got != exp.expected {
+				t.Fatalf("Unexpected result for %s. Got %q, expected %q", exp.name, got, exp.expected)
+			}
+			conn.Close()
+		}
+		s.Close()
+	}
+}
+
+func TestProtocolHandlers(t *testing.T) {
+	handlers := ProtocolHandlers{
+		"echo": EchoHandler,
+		"hello": HandlerFunc(func(conn io.ReadWriteCloser, peer Peer) error {
+			_, err := io.WriteString(conn, "hello "+peer.Protocol+"\n")
+			return err
+		}),
+	}
+	s := &Server{Config: &Config{Protocols: handlers.Protocols()}, Handler: handlers}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	conn, err := DialConfig(addr, &Config{Protocols: []string{"hello"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "hello hello\n" {
+		t.Fatalf("Unexpected result. Got %q", line)
+	}
+
+	// Clients offering no protocol are closed, as there is no handler
+	conn, err = Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("x"))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Unexpected result. A client without protocol was served.")
+	}
+}
@@ -0,0 +1,249 @@
+package securecomm
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var errProxyAuth = errors.New("proxy authentication failed")
+
+// proxyFor returns the URL of the proxy to dial addr through, or nil to
+// dial it directly.
+func (c *Config) proxyFor(addr string) (*url.URL, error) {
+	proxy := ""
+	if c != nil {
+		proxy = c.Proxy
+	}
+	if proxy == "" {
+		// Like Go's HTTP client, local servers are never proxied
+		host, _, _ := net.SplitHostPort(addr)
+		if ip := net.ParseIP(host); host == "localhost" || ip != nil && ip.IsLoopback() || noProxy(host, getenv("NO_PROXY")) {
+			return nil, nil
+		}
+		proxy = getenv("HTTPS_PROXY")
+		if proxy == "" {
+			proxy = getenv("ALL_PROXY")
+		}
+	}
+	if proxy == "" || proxy == "direct" {
+		return nil, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		// Like curl, a bare host:port is an HTTP proxy
+		u, err = url.Parse("http://" + proxy)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", proxy, err)
+	}
+	switch u.Scheme {
+	case "http", "socks5", "socks5h":
+		return u, nil
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+}
+
+// getenv looks up the environment variable, in upper or lower case.
+func getenv(name string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return os.Getenv(strings.ToLower(name))
+}
+
+// noProxy reports whether host matches the comma-separated list of
+// hosts and domains, or "*" for all of them.
+func noProxy(host, list string) bool {
+	for _, h := range strings.Split(list, ",") {
+		h = strings.TrimPrefix(strings.TrimSpace(h), ".")
+		if h == "*" || h != "" && (host == h || strings.HasSuffix(host, "."+h)) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialProxy connects to addr through proxy.
+func dialProxy(ctx context.Context, d *net.Dialer, proxy *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxy.Host
+	if proxy.Port() == "" {
+		port := "8080"
+		if proxy.Scheme != "http" {
+			port = "1080"
+		}
+		proxyAddr = net.JoinHostPort(proxy.Hostname(), port)
+	}
+	conn, err := d.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	err = withContext(ctx, conn, func() error {
+		var err error
+		if proxy.Scheme == "http" {
+			conn, err = httpConnect(conn, proxy.User, addr)
+		} else {
+			err = socks5Connect(conn, proxy.User, addr)
+		}
+		return err
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", proxyAddr, err)
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection whose first bytes were already read
+// into r.
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// NetConn returns the wrapped connection.
+func (c *bufferedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// CloseWrite half-closes the connection, if supported.
+func (c *bufferedConn) CloseWrite() error {
+	cw, ok := c.Conn.(interface{ CloseWrite() error })
+	if !ok {
+		return errors.New("connection can't be half-closed")
+	}
+	return cw.CloseWrite()
+}
+
+// httpConnect asks the HTTP proxy at the other end of conn to connect
+// to addr. As the server speaks first, its first bytes may already be
+// buffered with the response, so the returned connection replaces conn.
+func httpConnect(conn net.Conn, user *url.Userinfo, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user != nil {
+		pass, _ := user.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return conn, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		return conn, errProxyAuth
+	case resp.StatusCode != http.StatusOK:
+		return conn, fmt.Errorf("connect: %s", resp.Status)
+	case br.Buffered() > 0:
+		return &bufferedConn{conn, io.MultiReader(br, conn)}, nil
+	}
+	return conn, nil
+}
+
+// socks5Connect asks the SOCKS5 proxy at the other end of conn to
+// connect to addr, as in RFC 1928. The proxy resolves the host name.
+func socks5Connect(conn net.Conn, user *url.Userinfo, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+	if len(host) > 255 {
+		return fmt.Errorf("host name too long")
+	}
+
+	// Offer no authentication or, with a user, username and password
+	// authentication (RFC 1929)
+	greeting := []byte{5, 1, 0}
+	if user != nil {
+		greeting = []byte{5, 1, 2}
+	}
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != 5 || reply[1] != greeting[2] {
+		return errProxyAuth
+	}
+	if user != nil {
+		pass, _ := user.Password()
+		if len(user.Username()) > 255 || len(pass) > 255 {
+			return fmt.Errorf("proxy credentials too long")
+		}
+		auth := []byte{1, byte(len(user.Username()))}
+		auth = append(auth, user.Username()...)
+		auth = append(auth, byte(len(pass)))
+		auth = append(auth, pass...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply[:]); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errProxyAuth
+		}
+	}
+
+	req := []byte{5, 1, 0, 3, byte(len(host))}
+	req = append(req, host...)
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	var resp [4]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return err
+	}
+	if resp[1] != 0 {
+		return fmt.Errorf("connect: socks error %d", resp[1])
+	}
+	// Skip the bound address and port
+	n := 0
+	switch resp[3] {
+	case 1:
+		n = net.IPv4len
+	case 4:
+		n = net.IPv6len
+	case 3:
+		var l [1]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return err
+		}
+		n = int(l[0])
+	default:
+		return fmt.Errorf("connect: invalid socks reply")
+	}
+	_, err = io.CopyN(io.Discard, conn, int64(n+2))
+	return err
+}
@@ -0,0 +1,175 @@
+package securecomm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// startProxy runs a proxy on a local port, serving each connection with
+// handle, which returns the address to connect to.
+func startProxy(t *testing.T, handle func(c net.Conn) (io.Reader, string)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				r, addr := handle(c)
+				if addr == "" {
+					return
+				}
+				target, err := net.Dial("tcp", addr)
+				if err != nil {
+					return
+				}
+				defer target.Close()
+				go io.Copy(target, r)
+				io.Copy(c, target)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func httpProxy(c net.Conn) (io.Reader, string) {
+	br := bufio.NewReader(c)
+	req, err := http.ReadRequest(br)
+	if err != nil || req.Method != http.MethodConnect {
+		return nil, ""
+	}
+	if user, pass, _ := parseBasicAuth(req.Header.Get("Proxy-Authorization")); user != "user" || pass != "pass" {
+		io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		return nil, ""
+	}
+	io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+	return br, req.Host
+}
+
+func parseBasicAuth(auth string) (string, string, bool) {
+	req := &http.Request{Header: http.Header{"Authorization": {auth}}}
+	return req.BasicAuth()
+}
+
+func socks5Proxy(c net.Conn) (io.Reader, string) {
+	// No authentication only
+	var greeting [3]byte
+	if _, err := io.ReadFull(c, greeting[:]); err != nil || greeting[2] != 0 {
+		c.Write([]byte{5, 0xff})
+		return nil, ""
+	}
+	c.Write([]byte{5, 0})
+	var req [5]byte
+	if _, err := io.ReadFull(c, req[:]); err != nil || req[3] != 3 {
+		return nil, ""
+	}
+	host := make([]byte, req[4]+2)
+	if _, err := io.ReadFull(c, host); err != nil {
+		return nil, ""
+	}
+	port := binary.BigEndian.Uint16(host[len(host)-2:])
+	c.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+	return c, net.JoinHostPort(string(host[:len(host)-2]), strconv.Itoa(int(port)))
+}
+
+func TestDialProxy(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go Serve(l, nil)
+
+	httpAddr := startProxy(t, httpProxy)
+	socksAddr := startProxy(t, socks5Proxy)
+
+	tData := []struct {
+		name  string
+		proxy string
+		ok    bool
+	}{
+		{"http", "http://user:pass@" + httpAddr, true},
+		{"bare http", "user:pass@" + httpAddr, true},
+		{"http bad password", "http://user:guess@" + httpAddr, false},
+		{"socks5", "socks5://" + socksAddr, true},
+		{"socks5 with user", "socks5://user:pass@" + socksAddr, false},
+	}
+
+	for _, exp := range tData {
+		conn, err := DialConfig(l.Addr().String(), &Config{Proxy: exp.proxy})
+		if !exp.ok {
+			if err == nil {
+				conn.Close()
+				t.Fatalf("%s: expected the dial to fail", exp.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected dial failure: %v", exp.name, err)
+		}
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		if string(buf) != "hello" {
+			t.Fatalf("%s: Unexpected result.\nGot:\t\t%s\nExpected:\t%s\n", exp.name, buf, "hello")
+		}
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	tData := []struct {
+		name     string
+		proxy    string
+		https    string
+		all      string
+		noProxy  string
+		addr     string
+		expected string
+	}{
+		{"none", "", "", "", "", "example.com:1", ""},
+		{"https", "", "http://p:3128", "socks5://s:1080", "", "example.com:1", "http://p:3128"},
+		{"all", "", "", "socks5://s:1080", "", "example.com:1", "socks5://s:1080"},
+		{"no proxy", "", "http://p:3128", "", "localhost,.example.com", "www.example.com:1", ""},
+		{"no proxy other", "", "http://p:3128", "", "example.com", "example.org:1", "http://p:3128"},
+		{"config", "socks5://c:1080", "http://p:3128", "", "*", "example.com:1", "socks5://c:1080"},
+		{"loopback", "", "http://p:3128", "", "", "127.0.0.1:1", ""},
+		{"direct", "direct", "http://p:3128", "", "", "example.com:1", ""},
+	}
+
+	for _, exp := range tData {
+		t.Setenv("HTTPS_PROXY", exp.https)
+		t.Setenv("ALL_PROXY", exp.all)
+		t.Setenv("NO_PROXY", exp.noProxy)
+		u, err := (&Config{Proxy: exp.proxy}).proxyFor(exp.addr)
+		if err != nil {
+			t.Fatalf("%s: %v", exp.name, err)
+		}
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != exp.expected {
+			t.Fatalf("%s: Unexpected result.\nGot:\t\t%s\nExpected:\t%s\n", exp.name, got, exp.expected)
+		}
+	}
+
+	if _, err := (&Config{Proxy: "ftp://p:21"}).proxyFor("example.com:1"); err == nil {
+		t.Fatal("expected an unsupported proxy scheme to fail")
+	}
+}
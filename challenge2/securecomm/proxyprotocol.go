@@ -0,0 +1,238 @@
+package securecomm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout bounds the time to read the PROXY protocol header
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyV2Signature starts the headers of the PROXY protocol version 2
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// errProxyHeader is returned when the PROXY protocol header is invalid.
+var errProxyHeader = errors.New("invalid PROXY protocol header")
+
+// errProxyUntrusted is returned for the connections not coming from a
+// trusted load balancer.
+var errProxyUntrusted = errors.New("not a trusted load balancer")
+
+// proxyListener reads the PROXY protocol header of the accepted
+// connections, see NewProxyProtocolListener.
+type proxyListener struct {
+	net.Listener
+	trusted []netip.Prefix
+	logger  Logger
+
+	once  sync.Once
+	conns chan net.Conn
+	done  chan struct{}
+	err   error // set before done is closed
+}
+
+// NewProxyProtocolListener returns a listener for servers behind a load
+// balancer sending the PROXY protocol header, version 1 or 2, before the
+// data of the client. The RemoteAddr of the accepted connections is the
+// address of the client instead of the one of the load balancer, so the
+// logs, the limits and the checks of the server use it. Only the load
+// balancers in the trusted ranges may send the header: the connections
+// from other addresses, which could claim any client address, are
+// closed like those without valid header and logged to logger, if not
+// nil.
+func NewProxyProtocolListener(inner net.Listener, trusted []netip.Prefix, logger Logger) net.Listener {
+	if logger == nil {
+		logger = discardLogger
+	}
+	return &proxyListener{
+		Listener: inner,
+		trusted:  trusted,
+		logger:   logger,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+}
+
+// Accept waits for the next connection with a valid header.
+func (l *proxyListener) Accept() (net.Conn, error) {
+	l.once.Do(func() { go l.serve() })
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+func (l *proxyListener) serve() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			l.err = err
+			close(l.done)
+			return
+		}
+		// Headers are read concurrently, so a slow load balancer
+		// connection doesn't hold the others back
+		go l.readHeader(c)
+	}
+}
+
+func (l *proxyListener) readHeader(c net.Conn) {
+	if !l.isTrusted(c.RemoteAddr()) {
+		l.logger.Warn("PROXY protocol header rejected", "peer", c.RemoteAddr().String(), "err", errProxyUntrusted)
+		c.Close()
+		return
+	}
+	c.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	br := bufio.NewReader(c)
+	remote, err := readProxyHeader(br)
+	c.SetReadDeadline(time.Time{})
+	if err != nil {
+		l.logger.Warn("PROXY protocol header rejected", "peer", c.RemoteAddr().String(), "err", err)
+		c.Close()
+		return
+	}
+	conn := net.Conn(&bufferedConn{c, br})
+	if remote != nil {
+		conn = &proxiedConn{bufferedConn{c, br}, remote}
+	}
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// isTrusted tells whether addr is in the trusted ranges.
+func (l *proxyListener) isTrusted(addr net.Addr) bool {
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := ap.Addr().Unmap().WithZone("")
+	for _, p := range l.trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxiedConn is a connection whose client address was sent with the
+// PROXY protocol.
+type proxiedConn struct {
+	bufferedConn
+	remote net.Addr
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// readProxyHeader reads the PROXY protocol header from br and returns the
+// address of the client, or nil when the load balancer sends none, as
+// with its own health checks.
+func readProxyHeader(br *bufio.Reader) (net.Addr, error) {
+	sig, err := br.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(sig, proxyV2Signature) {
+		return readProxyHeaderV2(br)
+	}
+	return readProxyHeaderV1(br)
+}
+
+// readProxyHeaderV1 reads the text header of the version 1:
+//
+//	PROXY TCP4 <client ip> <proxy ip> <client port> <proxy port>\r\n
+func readProxyHeaderV1(br *bufio.Reader) (net.Addr, error) {
+	// The header is 107 bytes at most
+	var line []byte
+	for len(line) < 107 {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errProxyHeader
+	}
+	fields := strings.Split(text, " ")
+	if fields[0] != "PROXY" || len(fields) < 2 {
+		return nil, errProxyHeader
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("%w: unsupported protocol %q", errProxyHeader, fields[1])
+	}
+	if len(fields) != 6 {
+		return nil, errProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyHeaderV2 reads the binary header of the version 2: the
+// signature, the version and command, the family, the length of the
+// addresses and the addresses, followed by extensions we ignore.
+func readProxyHeaderV2(br *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	verCmd, family := header[12], header[13]
+	body := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, err
+	}
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("%w: unsupported version %d", errProxyHeader, verCmd>>4)
+	}
+	switch verCmd & 0xf {
+	case 0:
+		// LOCAL: a connection of the load balancer itself
+		return nil, nil
+	case 1:
+	default:
+		return nil, fmt.Errorf("%w: unsupported command %d", errProxyHeader, verCmd&0xf)
+	}
+	var ipSize int
+	switch family {
+	case 0x11: // TCP over IPv4
+		ipSize = net.IPv4len
+	case 0x21: // TCP over IPv6
+		ipSize = net.IPv6len
+	default:
+		return nil, nil
+	}
+	if len(body) < 2*ipSize+4 {
+		return nil, errProxyHeader
+	}
+	ip := net.IP(append([]byte(nil), body[:ipSize]...))
+	port := binary.BigEndian.Uint16(body[2*ipSize:])
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
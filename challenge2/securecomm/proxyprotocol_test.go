@@ -0,0 +1,116 @@
+package securecomm
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := func(verCmd, family byte, body string) string {
+		return string(proxyV2Signature) + string([]byte{verCmd, family, 0, byte(len(body))}) + body
+	}
+	v4 := "\xc0\x00\x02\x01" + "\x0a\x00\x00\x01" + "\x30\x39" + "\x00\x50"
+	v6 := "\x20\x01\x0d\xb8" + strings.Repeat("\x00", 11) + "\x01" + strings.Repeat("\x00", 16) + "\x30\x39" + "\x00\x50"
+
+	tData := []struct {
+		header   string
+		expected string
+		ok       bool
+	}{
+		{"PROXY TCP4 192.0.2.1 10.0.0.1 12345 80\r\n", "192.0.2.1:12345", true},
+		{"PROXY TCP6 2001:db8::1 ::1 12345 80\r\n", "[2001:db8::1]:12345", true},
+		{"PROXY UNKNOWN\r\n", "", true},
+		{"PROXY TCP4 2001:db8::1 ::1 12345 80\r\n", "", false},
+		{"PROXY TCP4 192.0.2.1 10.0.0.1 12345\r\n", "", false},
+		{"GET / HTTP/1.1\r\n", "", false},
+		{v2(0x21, 0x11, v4), "192.0.2.1:12345", true},
+		{v2(0x21, 0x21, v6), "[2001:db8::1]:12345", true},
+		{v2(0x21, 0x11, v4+"\x04\x00\x01x"), "192.0.2.1:12345", true},
+		{v2(0x20, 0x00, ""), "", true},
+		{v2(0x31, 0x11, v4), "", false},
+		{v2(0x21, 0x11, v4[:8]), "", false},
+	}
+	for _, exp := range tData {
+		br := bufio.NewReader(strings.NewReader(exp.header + "data"))
+		addr, err := readProxyHeader(br)
+		if (err == nil) != exp.ok {
+			t.Fatalf("Unexpected result for %q: %v", exp.header, err)
+		}
+		if !exp.ok {
+			continue
+		}
+		got := ""
+		if addr != nil {
+			got = addr.String()
+		}
+		if got != exp.expected {
+			t.Fatalf("Unexpected result for %q. Got %q, expected %q", exp.header, got, exp.expected)
+		}
+		// The data following the header is kept
+		if rest, _ := io.ReadAll(br); string(rest) != "data" {
+			t.Fatalf("Unexpected result for %q. Got %q after the header", exp.header, rest)
+		}
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := make(chan string, 1)
+	s := &Server{Handler: HandlerFunc(func(conn io.ReadWriteCloser, peer Peer) error {
+		peers <- peer.Addr.String()
+		return nil
+	})}
+	go s.Serve(NewProxyProtocolListener(inner, []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}, nil))
+	defer s.Close()
+
+	c, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(c, "PROXY TCP4 192.0.2.1 10.0.0.1 12345 80\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := NewConnection(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if peer := <-peers; peer != "192.0.2.1:12345" {
+		t.Fatalf("Unexpected result. Got peer %s", peer)
+	}
+}
+
+func TestProxyProtocolUntrusted(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := make(chan string, 1)
+	s := &Server{Handler: HandlerFunc(func(conn io.ReadWriteCloser, peer Peer) error {
+		peers <- peer.Addr.String()
+		return nil
+	})}
+	go s.Serve(NewProxyProtocolListener(inner, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, nil))
+	defer s.Close()
+
+	// A client connecting directly can't claim another address
+	c, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := io.WriteString(c, "PROXY TCP4 192.0.2.1 10.0.0.1 12345 80\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := NewConnection(c); err == nil {
+		conn.Close()
+		t.Fatalf("Unexpected result. The connection was accepted from %s", <-peers)
+	}
+}
@@ -0,0 +1,191 @@
+package securecomm
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Pub/sub over secure connections, with a line protocol usable from the
+// pipe mode of the client:
+//
+//	SUB <topic>
+//	UNSUB <topic>
+//	PUB <topic> <message>
+//
+// SUB and UNSUB are acknowledged with an "OK" line and invalid commands
+// get an "ERR <reason>" line. Subscribers receive the messages as
+// "MSG <topic> <message>" lines. Messages sealed with SealGroupLine are
+// only readable by the subscribers they were sealed for.
+
+// defaultQueueSize is the number of messages queued for a subscriber.
+const defaultQueueSize = 64
+
+// OverflowPolicy decides what happens when a subscriber is too slow to
+// keep up with the published messages and its queue is full.
+type OverflowPolicy int
+
+const (
+	DropOldest OverflowPolicy = iota // drop the oldest queued message
+	DropNewest                       // drop the published message
+	Disconnect                       // close the connection of the subscriber
+)
+
+// Hub fans out the published messages to the subscribers of their
+// topic, each one through its own queue so slow subscribers don't
+// slow down the others. It is a Handler.
+type Hub struct {
+	// QueueSize is the size of the queue of each subscriber, 64 by
+	// default. Overflow applies when it is full.
+	QueueSize int
+	Overflow  OverflowPolicy
+
+	// Logger, if not nil, logs the overflows.
+	Logger Logger
+
+	mu     sync.Mutex
+	topics map[string]map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	conn  io.ReadWriteCloser
+	queue chan []byte
+}
+
+// newSubscriber starts writing the messages queued for conn, queueing
+// up to size messages, defaultQueueSize if 0. Once no one enqueues
+// anymore, stop closes the queue and waits for the writes.
+func newSubscriber(conn io.ReadWriteCloser, size int) (sub *subscriber, stop func()) {
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	sub = &subscriber{conn: conn, queue: make(chan []byte, size)}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range sub.queue {
+			if _, err := conn.Write(msg); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+	return sub, func() {
+		close(sub.queue)
+		<-done
+	}
+}
+
+// ServeConn serves the commands of a client until it closes conn. It
+// then closes conn.
+func (h *Hub) ServeConn(conn io.ReadWriteCloser, peer Peer) error {
+	sub, stop := newSubscriber(conn, h.QueueSize)
+
+	topics := make(map[string]bool)
+	s := bufio.NewScanner(conn)
+	for s.Scan() {
+		cmd, arg, _ := strings.Cut(s.Text(), " ")
+		switch cmd {
+		case "SUB":
+			if arg == "" {
+				h.send(sub, "ERR missing topic\n")
+				continue
+			}
+			h.subscribe(sub, arg)
+			topics[arg] = true
+			h.send(sub, "OK\n")
+		case "UNSUB":
+			h.unsubscribe(sub, arg)
+			delete(topics, arg)
+			h.send(sub, "OK\n")
+		case "PUB":
+			topic, msg, _ := strings.Cut(arg, " ")
+			if topic == "" {
+				h.send(sub, "ERR missing topic\n")
+				continue
+			}
+			h.Publish(topic, msg)
+		default:
+			h.send(sub, "ERR unknown command\n")
+		}
+	}
+
+	// No one sends to the queue once unsubscribed
+	for topic := range topics {
+		h.unsubscribe(sub, topic)
+	}
+	stop()
+	conn.Close()
+	return s.Err()
+}
+
+func (h *Hub) subscribe(sub *subscriber, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.topics == nil {
+		h.topics = make(map[string]map[*subscriber]struct{})
+	}
+	if h.topics[topic] == nil {
+		h.topics[topic] = make(map[*subscriber]struct{})
+	}
+	h.topics[topic][sub] = struct{}{}
+}
+
+func (h *Hub) unsubscribe(sub *subscriber, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.topics[topic], sub)
+	if len(h.topics[topic]) == 0 {
+		delete(h.topics, topic)
+	}
+}
+
+// Publish sends msg to the subscribers of topic.
+func (h *Hub) Publish(topic, msg string) {
+	line := "MSG " + topic + " " + msg + "\n"
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.topics[topic] {
+		sub.enqueue([]byte(line), h.Overflow, h.Logger)
+	}
+}
+
+// send queues a reply to sub, holding h.mu so the queue isn't closed
+// meanwhile.
+func (h *Hub) send(sub *subscriber, line string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sub.enqueue([]byte(line), h.Overflow, h.Logger)
+}
+
+// enqueue queues msg, applying policy if the queue is full. Callers
+// must make sure the queue isn't closed meanwhile.
+func (sub *subscriber) enqueue(msg []byte, policy OverflowPolicy, logger Logger) {
+	select {
+	case sub.queue <- msg:
+		return
+	default:
+	}
+
+	if logger == nil {
+		logger = discardLogger
+	}
+	switch policy {
+	case DropOldest:
+		select {
+		case <-sub.queue:
+		default:
+		}
+		select {
+		case sub.queue <- msg:
+		default:
+		}
+		logger.Warn("subscriber queue full, dropped oldest message")
+	case DropNewest:
+		logger.Warn("subscriber queue full, dropped message")
+	case Disconnect:
+		logger.Warn("subscriber queue full, disconnecting")
+		sub.conn.Close()
+	}
+}
@@ -0,0 +1,104 @@
+package securecomm
+
+import (
+	"bufio"
+	"io"
+	"testing"
+)
+
+type hubClient struct {
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+}
+
+func (c *hubClient) send(t *testing.T, line string) {
+	if _, err := io.WriteString(c.conn, line+"\n"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *hubClient) expect(t *testing.T, line string) {
+	got, err := c.r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != line+"\n" {
+		t.Fatalf("Unexpected result.\nGot:\t\t%q\nExpected:\t%q\n", got, line+"\n")
+	}
+}
+
+func TestHub(t *testing.T) {
+	s := &Server{Handler: new(Hub)}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	var clients []*hubClient
+	for i := 0; i < 3; i++ {
+		conn, err := Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		clients = append(clients, &hubClient{conn, bufio.NewReader(conn)})
+	}
+	alice, bob, carol := clients[0], clients[1], clients[2]
+
+	alice.send(t, "SUB news")
+	alice.expect(t, "OK")
+	bob.send(t, "SUB news")
+	bob.expect(t, "OK")
+	bob.send(t, "SUB sports")
+	bob.expect(t, "OK")
+
+	carol.send(t, "PUB news hello world")
+	alice.expect(t, "MSG news hello world")
+	bob.expect(t, "MSG news hello world")
+
+	bob.send(t, "UNSUB news")
+	bob.expect(t, "OK")
+	carol.send(t, "PUB news again")
+	carol.send(t, "PUB sports goal")
+	alice.expect(t, "MSG news again")
+	bob.expect(t, "MSG sports goal")
+
+	carol.send(t, "HELLO")
+	carol.expect(t, "ERR unknown command")
+}
+
+type closeRecorder struct {
+	io.ReadWriter
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestHubOverflow(t *testing.T) {
+	tData := []struct {
+		policy   OverflowPolicy
+		expected []string
+		closed   bool
+	}{
+		{DropOldest, []string{"2", "3"}, false},
+		{DropNewest, []string{"1", "2"}, false},
+		{Disconnect, []string{"1", "2"}, true},
+	}
+
+	for _, exp := range tData {
+		conn := &closeRecorder{}
+		sub := &subscriber{conn: conn, queue: make(chan []byte, 2)}
+		for _, msg := range []string{"1", "2", "3"} {
+			sub.enqueue([]byte(msg), exp.policy, nil)
+		}
+		close(sub.queue)
+		var got []string
+		for msg := range sub.queue {
+			got = append(got, string(msg))
+		}
+		if len(got) != 2 || got[0] != exp.expected[0] || got[1] != exp.expected[1] || conn.closed != exp.closed {
+			t.Fatalf("policy %d: Unexpected result.\nGot:\t\t%v, closed %v\nExpected:\t%v, closed %v\n", exp.policy, got, conn.closed, exp.expected, exp.closed)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package securecomm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Hole punching opens a direct secure UDP channel between two peers
+// behind NATs. Both register the same code with an introducer, which
+// tells each the public address of the other: the one it sees, or the
+// one the peer discovered with STUN. Both then send probes to each
+// other, opening the mappings of their NATs, and the handshake runs over
+// the punched path, the peer registered first taking the server role.
+
+const (
+	// punchProbe is the datagram opening the NAT mappings
+	punchProbe = "secure-punch"
+	// punchInterval is the delay between probes, and between retries
+	// of the registration
+	punchInterval = 100 * time.Millisecond
+	// punchTimeout bounds the registration and the probes
+	punchTimeout = 30 * time.Second
+	// introductionTimeout is how long the introducer keeps the
+	// registrations
+	introductionTimeout = 30 * time.Second
+)
+
+var errIntroduction = errors.New("invalid introduction")
+
+// Puncher connects to peers registering the same code with an
+// introducer, see ServeIntroducer.
+type Puncher struct {
+	Introducer string // host:port of the introducer
+	STUN       string // host:port of a STUN server, optional
+	Config     *Config
+}
+
+// Punch registers code with the introducer and performs the handshake
+// with the peer registering the same code, once reachable. It gives up
+// once ctx is done, or after punchTimeout.
+func (p *Puncher) Punch(ctx context.Context, code string) (*DatagramConn, error) {
+	pc, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	c, err := p.punch(ctx, pc, code)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (p *Puncher) punch(ctx context.Context, pc net.PacketConn, code string) (*DatagramConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, punchTimeout)
+	defer cancel()
+
+	var public string
+	if p.STUN != "" {
+		addr, err := stunMappedAddr(ctx, pc, p.STUN)
+		if err != nil {
+			return nil, fmt.Errorf("error discovering the public address: %w", err)
+		}
+		public = addr.String()
+	}
+	peer, server, err := introduce(ctx, pc, p.Introducer, code, public)
+	if err != nil {
+		return nil, err
+	}
+	conn := &punchedConn{PacketConn: pc, peer: peer}
+	if err := probe(ctx, conn, server); err != nil {
+		return nil, err
+	}
+	if server {
+		return newDatagramServerConn(ctx, conn, peer.String(), p.Config)
+	}
+	return newDatagramConn(ctx, conn, peer.String(), p.Config)
+}
+
+// introduce registers code, and public if not empty, with the
+// introducer until it returns the address of the peer and whether to
+// take the server role.
+func introduce(ctx context.Context, pc net.PacketConn, introducer, code, public string) (*net.UDPAddr, bool, error) {
+	introducerAddr, err := net.ResolveUDPAddr("udp", introducer)
+	if err != nil {
+		return nil, false, err
+	}
+	register := strings.TrimSpace("PUNCH " + code + " " + public)
+	defer pc.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 512)
+	for ctx.Err() == nil {
+		if _, err := pc.WriteTo([]byte(register), introducerAddr); err != nil {
+			return nil, false, err
+		}
+		pc.SetReadDeadline(time.Now().Add(punchInterval))
+		n, from, err := pc.ReadFrom(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if !sameAddr(from, introducerAddr) {
+			continue
+		}
+		fields := strings.Fields(string(buf[:n]))
+		if len(fields) != 3 || fields[0] != "PEER" {
+			return nil, false, errIntroduction
+		}
+		peer, err := net.ResolveUDPAddr("udp", fields[1])
+		if err != nil {
+			return nil, false, errIntroduction
+		}
+		return peer, fields[2] == "server", nil
+	}
+	return nil, false, ctx.Err()
+}
+
+// probe sends probes to the peer until the path is open. Clients wait
+// for a datagram of the peer, then start the handshake. Servers wait for
+// its hello, so their probes keep the path open until the client gets
+// through.
+func probe(ctx context.Context, conn *punchedConn, server bool) error {
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, maxDatagramSize)
+	for ctx.Err() == nil {
+		if _, err := conn.Write([]byte(punchProbe)); err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(punchInterval))
+		for {
+			n, err := conn.readPeer(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if !server || string(buf[:n]) == packetHello {
+				return nil
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// punchedConn is the connection to the peer over the punched path,
+// ignoring the datagrams of others and the probes.
+type punchedConn struct {
+	net.PacketConn
+	peer *net.UDPAddr
+}
+
+func (c *punchedConn) Read(p []byte) (int, error) {
+	for {
+		n, err := c.readPeer(p)
+		if err != nil || string(p[:n]) != punchProbe {
+			return n, err
+		}
+	}
+}
+
+// readPeer reads the next datagram of the peer.
+func (c *punchedConn) readPeer(p []byte) (int, error) {
+	for {
+		n, from, err := c.ReadFrom(p)
+		if err != nil || sameAddr(from, c.peer) {
+			return n, err
+		}
+	}
+}
+
+func (c *punchedConn) Write(p []byte) (int, error) {
+	return c.WriteTo(p, c.peer)
+}
+
+func (c *punchedConn) RemoteAddr() net.Addr {
+	return c.peer
+}
+
+// introduction is a registration kept by the introducer.
+type introduction struct {
+	code   string
+	addr   net.Addr
+	public string
+	reply  string // once introduced
+	since  time.Time
+}
+
+// ServeIntroducer introduces the peers registering the same code on pc
+// to each other, see Puncher. It never sees the traffic between them.
+func ServeIntroducer(pc net.PacketConn) error {
+	waiting := make(map[string]*introduction)    // by code
+	introduced := make(map[string]*introduction) // by address, to answer retries
+
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(string(buf[:n]))
+		if len(fields) < 2 || len(fields) > 3 || fields[0] != "PUNCH" {
+			continue
+		}
+		r := &introduction{code: fields[1], addr: addr, public: addr.String(), since: time.Now()}
+		if len(fields) == 3 {
+			r.public = fields[2]
+		}
+
+		for key, old := range waiting {
+			if time.Since(old.since) > introductionTimeout {
+				delete(waiting, key)
+			}
+		}
+		for key, old := range introduced {
+			if time.Since(old.since) > introductionTimeout {
+				delete(introduced, key)
+			}
+		}
+		if done, ok := introduced[addr.String()]; ok && done.code == r.code {
+			// The reply was lost
+			pc.WriteTo([]byte(done.reply), addr)
+		} else if first, ok := waiting[r.code]; !ok || first.addr.String() == addr.String() {
+			waiting[r.code] = r
+		} else {
+			delete(waiting, r.code)
+			first.reply = "PEER " + r.public + " server"
+			r.reply = "PEER " + first.public + " client"
+			introduced[first.addr.String()] = first
+			introduced[addr.String()] = r
+			pc.WriteTo([]byte(first.reply), first.addr)
+			pc.WriteTo([]byte(r.reply), addr)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package securecomm
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPunch(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go ServeIntroducer(pc)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	type result struct {
+		conn *DatagramConn
+		err  error
+	}
+	results := make(chan result, 2)
+	for _, stun := range []string{"", startSTUN(t, false)} {
+		p := &Puncher{Introducer: pc.LocalAddr().String(), STUN: stun}
+		go func() {
+			c, err := p.Punch(ctx, "room-42")
+			results <- result{c, err}
+		}()
+	}
+	var conns []*DatagramConn
+	for range 2 {
+		r := <-results
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		defer r.conn.Close()
+		conns = append(conns, r.conn)
+	}
+
+	for i, c := range conns {
+		peer := conns[1-i]
+		if _, err := c.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 16)
+		peer.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := peer.Read(buf)
+		if err != nil || string(buf[:n]) != "hello" {
+			t.Fatalf("got %q, %v", buf[:n], err)
+		}
+	}
+}
+
+func TestPunchTimeout(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go ServeIntroducer(pc)
+
+	// No peer registers the same code
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	p := &Puncher{Introducer: pc.LocalAddr().String()}
+	if _, err := p.Punch(ctx, "lonely"); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,121 @@
+package securecomm
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Under load, a server can make the clients solve a proof-of-work
+// puzzle before the handshake, sent in place of the preamble:
+//
+//	server -> client: puzzle magic, difficulty, challenge
+//	client -> server: solution
+//	server -> client: preamble...
+//
+// The solution is a counter such that the SHA-256 hash of the challenge
+// followed by the counter starts with difficulty zero bits. Finding it
+// takes about 2^difficulty hashes, checking it only one, so a flood of
+// connections costs the clients more CPU than the server, which
+// generates no key before the puzzle is solved. The clients not
+// supporting puzzles fail with errBadMagic.
+
+// puzzleMagic starts a puzzle, instead of magic
+const puzzleMagic = "GCPZ"
+
+// challengeSize is the length of the random challenge of a puzzle
+const challengeSize = 16
+
+// Difficulties of the puzzles, in bits. Clients refuse the puzzles above
+// maxPuzzleDifficulty, which take seconds to solve.
+const (
+	defaultPuzzleDifficulty = 16
+	maxPuzzleDifficulty     = 24
+)
+
+var errPuzzleFailed = errors.New("invalid puzzle solution")
+
+// challengePuzzle sends a puzzle of the given difficulty and checks the
+// solution of the client.
+func challengePuzzle(c io.ReadWriter, difficulty int) error {
+	challenge := make([]byte, challengeSize)
+	if _, err := io.ReadFull(rand.Reader, challenge); err != nil {
+		return fmt.Errorf("error generating puzzle: %s", err)
+	}
+	msg := append([]byte(puzzleMagic), byte(difficulty))
+	if _, err := c.Write(append(msg, challenge...)); err != nil {
+		return fmt.Errorf("error writing the puzzle: %s", err)
+	}
+	var solution uint64
+	if err := binary.Read(c, binary.BigEndian, &solution); err != nil {
+		return fmt.Errorf("error reading puzzle solution from client: %s", err)
+	}
+	if !solves(challenge, solution, difficulty) {
+		return errPuzzleFailed
+	}
+	return nil
+}
+
+// solvePuzzle reads the rest of a puzzle, after its magic, and sends
+// its solution.
+func solvePuzzle(c io.ReadWriter) error {
+	msg := make([]byte, 1+challengeSize)
+	if _, err := io.ReadFull(c, msg); err != nil {
+		return fmt.Errorf("error reading puzzle from server: %s", err)
+	}
+	difficulty, challenge := int(msg[0]), msg[1:]
+	if difficulty > maxPuzzleDifficulty {
+		return fmt.Errorf("puzzle too hard: %d bits", difficulty)
+	}
+	var solution uint64
+	for !solves(challenge, solution, difficulty) {
+		solution++
+	}
+	if err := binary.Write(c, binary.BigEndian, solution); err != nil {
+		return fmt.Errorf("error writing puzzle solution: %s", err)
+	}
+	return nil
+}
+
+// solves tells whether solution solves the puzzle of challenge.
+func solves(challenge []byte, solution uint64, difficulty int) bool {
+	h := sha256.New()
+	h.Write(challenge)
+	binary.Write(h, binary.BigEndian, solution)
+	return leadingZeros(h.Sum(nil)) >= difficulty
+}
+
+func leadingZeros(b []byte) int {
+	n := 0
+	for _, c := range b {
+		if c != 0 {
+			return n + bits.LeadingZeros8(c)
+		}
+		n += 8
+	}
+	return n
+}
+
+// readPreamble reads the preamble into p, solving the puzzle sent
+// before if any.
+func readPreamble(c io.ReadWriter, p []byte) error {
+	if _, err := io.ReadFull(c, p[:len(magic)]); err != nil {
+		return fmt.Errorf("error reading preamble from server: %s", err)
+	}
+	if string(p[:len(magic)]) == puzzleMagic {
+		if err := solvePuzzle(c); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(c, p[:len(magic)]); err != nil {
+			return fmt.Errorf("error reading preamble from server: %s", err)
+		}
+	}
+	if _, err := io.ReadFull(c, p[len(magic):]); err != nil {
+		return fmt.Errorf("error reading preamble from server: %s", err)
+	}
+	return nil
+}
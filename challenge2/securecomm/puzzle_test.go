@@ -0,0 +1,78 @@
+package securecomm
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestPuzzle(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	checked := make(chan error, 1)
+	go func() {
+		checked <- challengePuzzle(c1, 12)
+		c1.Write([]byte(magic))
+	}()
+	preamble := make([]byte, len(magic))
+	if err := readPreamble(c2, preamble); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-checked; err != nil {
+		t.Fatal(err)
+	}
+	if string(preamble) != magic {
+		t.Fatalf("Unexpected result. Got preamble %q after the puzzle", preamble)
+	}
+
+	// Wrong solutions fail, too hard puzzles are refused
+	go func() {
+		checked <- challengePuzzle(c1, maxPuzzleDifficulty)
+	}()
+	if _, err := io.ReadFull(c2, make([]byte, len(puzzleMagic)+1+challengeSize)); err != nil {
+		t.Fatal(err)
+	}
+	c2.Write(make([]byte, 8))
+	if err := <-checked; err != errPuzzleFailed {
+		t.Fatalf("Unexpected result. Got %v, expected %v", err, errPuzzleFailed)
+	}
+	go challengePuzzle(c1, maxPuzzleDifficulty+1)
+	if err := readPreamble(c2, preamble); err == nil {
+		t.Fatal("Unexpected result. Solved a puzzle above the maximum difficulty")
+	}
+}
+
+func TestServerPuzzle(t *testing.T) {
+	s := &Server{PuzzleRate: 0.001, PuzzleDifficulty: 8}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	// The first connection is under the rate, the next ones get a puzzle
+	for i, expected := range []string{magic, puzzleMagic} {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len(magic))
+		if _, err := io.ReadFull(c, got); err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+		if string(got) != expected {
+			t.Fatalf("%d: unexpected result. Got %q, expected %q", i, got, expected)
+		}
+	}
+	conn, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,169 @@
+package securecomm
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// The Diffie-Hellman ratchet, since Version5, completes the symmetric
+// ratchet of the rekeys into a double ratchet: a writer periodically
+// sends a fresh public key, the reader answers with its own and, once
+// the answer is received, the writer switches to a key mixing the
+// current one with their shared secret. A key compromised at some point
+// then exposes neither the past frames nor those following the next
+// ratchet step.
+//
+//	frameRatchet       -> [public key]
+//	frameRatchetAck    <- [public key]
+//	frameRatchetSwitch ->
+
+// startRatchet sends a fresh public key, unless a step is in progress.
+// The caller holds sw.mu.
+func (sw *SecureWriter) startRatchet() error {
+	var priv [32]byte
+	if _, err := io.ReadFull(rand.Reader, priv[:]); err != nil {
+		return err
+	}
+	pub, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	if err != nil {
+		return err
+	}
+	if err := sw.writeFrame(frameRatchet, pub); err != nil {
+		return err
+	}
+	sw.ratchetPriv = &priv
+	sw.ratcheted = time.Now()
+	return nil
+}
+
+// ratchetAcked computes the shared secret of the step from the public
+// key answered by the peer. The next frame switches to the new key.
+func (sw *SecureWriter) ratchetAcked(peer []byte) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.ratchetPriv == nil {
+		return fmt.Errorf("%w: unexpected ratchet answer", ErrMalformedFrame)
+	}
+	secret, err := curve25519.X25519(sw.ratchetPriv[:], peer)
+	if err != nil {
+		return err
+	}
+	sw.ratchetPriv = nil
+	sw.ratchetSecret = secret
+	return nil
+}
+
+// switchRatchet announces the peer that the next frames are sealed with
+// the key of the finished ratchet step and switches to it. The caller
+// holds sw.mu.
+func (sw *SecureWriter) switchRatchet() error {
+	if err := sw.writeFrame(frameRatchetSwitch, nil); err != nil {
+		return err
+	}
+	sw.keyLog.ratchet(true, sw.ratchetSteps+1, sw.ratchetSecret)
+	aead, err := mixKey(sw.suite, sw.key, sw.ratchetSecret)
+	if err != nil {
+		return err
+	}
+	sw.aead = aead
+	sw.ratchetSecret = nil
+	sw.ratchetSteps++
+	sw.sent = 0
+	sw.rekeyed = time.Now()
+	return nil
+}
+
+// ratchetRequested answers the public key of the peer with a fresh one,
+// keeping their shared secret until the peer switches to the new key.
+func (sr *SecureReader) ratchetRequested(peer []byte) error {
+	if sr.ratchetSecret != nil || sr.pong == nil {
+		return fmt.Errorf("%w: unexpected ratchet step", ErrMalformedFrame)
+	}
+	var priv [32]byte
+	if _, err := io.ReadFull(rand.Reader, priv[:]); err != nil {
+		return err
+	}
+	secret, err := curve25519.X25519(priv[:], peer)
+	if err != nil {
+		return err
+	}
+	pub, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	if err != nil {
+		return err
+	}
+	sr.ratchetSecret = secret
+	return sr.pong.writeControl(frameRatchetAck, pub)
+}
+
+// switchRatchet switches to the key of the finished ratchet step.
+func (sr *SecureReader) switchRatchet() error {
+	if sr.ratchetSecret == nil {
+		return fmt.Errorf("%w: unexpected ratchet switch", ErrMalformedFrame)
+	}
+	sr.keyMu.Lock()
+	defer sr.keyMu.Unlock()
+	sr.keyLog.ratchet(false, sr.ratchetSteps+1, sr.ratchetSecret)
+	aead, err := mixKey(sr.suite, sr.key, sr.ratchetSecret)
+	if err != nil {
+		return err
+	}
+	sr.aead = aead
+	sr.ratchetSecret = nil
+	sr.ratchetSteps++
+	return nil
+}
+
+// mixKey replaces key with a new one derived from it and secret and
+// returns the AEAD of the suite using the new key.
+func mixKey(suite Suite, key *[32]byte, secret []byte) (cipher.AEAD, error) {
+	r := hkdf.New(sha256.New, secret, key[:], []byte("dh ratchet"))
+	if _, err := io.ReadFull(r, key[:]); err != nil {
+		// HKDF can output way more than 32 bytes
+		panic(err)
+	}
+	return suite.newAEAD(key)
+}
+
+// RatchetState is a snapshot of the keys of a connection. Taken once
+// both sides are done with the connection, it is the same for both.
+type RatchetState struct {
+	Steps     uint64   // Diffie-Hellman ratchet steps, in both directions
+	ClientKey [32]byte // key of the frames sent by the client
+	ServerKey [32]byte // key of the frames sent by the server
+}
+
+// ResumptionKey derives a key from the state that both sides can use as
+// Config.PSK for their next connection, chaining it to this one.
+func (s *RatchetState) ResumptionKey() []byte {
+	r := hkdf.New(sha256.New, append(s.ClientKey[:], s.ServerKey[:]...), nil, []byte("resumption"))
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(r, key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// RatchetState returns the current keys of the connection, for
+// persistence across reconnects.
+func (c *Conn) RatchetState() RatchetState {
+	sr, sw := c.Reader.(*SecureReader), c.Writer.(*SecureWriter)
+	sw.mu.Lock()
+	sendKey, sendSteps := *sw.key, sw.ratchetSteps
+	sw.mu.Unlock()
+	sr.keyMu.Lock()
+	recvKey, recvSteps := *sr.key, sr.ratchetSteps
+	sr.keyMu.Unlock()
+
+	s := RatchetState{Steps: sendSteps + recvSteps, ClientKey: sendKey, ServerKey: recvKey}
+	if !c.initiator {
+		s.ClientKey, s.ServerKey = recvKey, sendKey
+	}
+	return s
+}
@@ -0,0 +1,104 @@
+package securecomm
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// tcpConns returns the two sides of a secure connection over TCP.
+func tcpConns(t *testing.T, clientCfg, serverCfg *Config) (*Conn, *Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan *Conn)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			accepted <- nil
+			return
+		}
+		conn, err := newServerConn(context.Background(), c, serverCfg)
+		if err != nil {
+			c.Close()
+		}
+		accepted <- conn
+	}()
+	conn, err := DialConfig(l.Addr().String(), clientCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	if server == nil {
+		t.Fatal("server handshake failed")
+	}
+	return conn.(*Conn), server
+}
+
+func TestRatchet(t *testing.T) {
+	cfg := &Config{RatchetInterval: time.Nanosecond}
+	client, server := tcpConns(t, cfg, cfg)
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		echo(server)
+		server.CloseWrite()
+	}()
+
+	for i := 0; i < 20; i++ {
+		msg := bytes.Repeat([]byte{byte(i)}, 100)
+		if _, err := client.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, len(msg))
+		if _, err := io.ReadFull(client, buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, msg) {
+			t.Fatalf("Unexpected result for message %d", i)
+		}
+	}
+	client.CloseWrite()
+	if _, err := io.ReadAll(client); err != nil {
+		t.Fatal(err)
+	}
+
+	clientState, serverState := client.RatchetState(), server.RatchetState()
+	if clientState != serverState {
+		t.Fatal("Unexpected result. The ratchet states differ.")
+	}
+	// Both directions ratcheted
+	if clientState.Steps < 2 {
+		t.Fatalf("Unexpected result. %d ratchet steps", clientState.Steps)
+	}
+
+	// The state chains the next connection
+	key := clientState.ResumptionKey()
+	next, nextServer := tcpConns(t, &Config{PSK: key}, &Config{PSK: key})
+	next.Close()
+	nextServer.Close()
+}
+
+func TestRatchetVersion(t *testing.T) {
+	client, server := tcpConns(t, &Config{RatchetInterval: time.Nanosecond}, &Config{MaxVersion: Version4})
+	defer client.Close()
+	defer server.Close()
+	go echo(server)
+
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatal(err)
+	}
+	if steps := client.RatchetState().Steps; steps != 0 {
+		t.Fatalf("Unexpected result. %d ratchet steps with Version4", steps)
+	}
+}
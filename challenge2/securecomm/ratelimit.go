@@ -0,0 +1,81 @@
+package securecomm
+
+import (
+	"container/list"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// maxBuckets is the number of addresses tracked by a rate limiter,
+// forgetting the least recently used one past it.
+const maxBuckets = 1024
+
+// rateLimiter is a token bucket rate limiter, with a bucket per key.
+type rateLimiter struct {
+	rate  float64 // tokens added per second
+	burst float64 // capacity of the buckets
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	used    *list.List // of *bucket, least recently used first
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(max(burst, 1)),
+		buckets: make(map[string]*list.Element),
+		used:    list.New(),
+	}
+}
+
+// allow reports whether an event for key may happen at now, taking a
+// token from its bucket if so.
+func (r *rateLimiter) allow(key string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b *bucket
+	if e, ok := r.buckets[key]; ok {
+		r.used.MoveToBack(e)
+		b = e.Value.(*bucket)
+	} else {
+		if len(r.buckets) >= maxBuckets {
+			oldest := r.used.Front()
+			r.used.Remove(oldest)
+			delete(r.buckets, oldest.Value.(*bucket).key)
+		}
+		b = &bucket{key: key, tokens: r.burst, last: now}
+		r.buckets[key] = r.used.PushBack(b)
+	}
+	b.tokens = min(r.burst, b.tokens+now.Sub(b.last).Seconds()*r.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// addrKey returns the key of host in the limits per address. IPv6
+// addresses are grouped by /64, the prefix usually given to a single
+// host, so it can't evade them by changing address.
+func addrKey(host string) string {
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	ip = ip.Unmap().WithZone("")
+	if ip.Is4() {
+		return ip.String()
+	}
+	prefix, _ := ip.Prefix(64)
+	return prefix.String()
+}
@@ -0,0 +1,74 @@
+package securecomm
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	r := newRateLimiter(1, 2)
+	now := time.Now()
+
+	// The burst is allowed, then one event per second
+	tData := []struct {
+		key   string
+		after time.Duration
+		ok    bool
+	}{
+		{"a", 0, true},
+		{"a", 0, true},
+		{"a", 0, false},
+		{"b", 0, true},
+		{"a", 500 * time.Millisecond, false},
+		{"a", time.Second, true},
+		{"a", 0, false},
+	}
+
+	for i, exp := range tData {
+		now = now.Add(exp.after)
+		if got := r.allow(exp.key, now); got != exp.ok {
+			t.Fatalf("%d: unexpected result for %s. Got %t, expected %t", i, exp.key, got, exp.ok)
+		}
+	}
+}
+
+func TestRateLimiterMaxBuckets(t *testing.T) {
+	r := newRateLimiter(0.001, 1)
+	now := time.Now()
+	r.allow("first", now)
+	for i := 0; i < maxBuckets; i++ {
+		r.allow(fmt.Sprint(i), now)
+		r.allow("first", now)
+	}
+	if len(r.buckets) != maxBuckets || r.used.Len() != maxBuckets {
+		t.Fatalf("Unexpected result. Got %d buckets, expected %d", len(r.buckets), maxBuckets)
+	}
+	// The most used bucket is kept, still empty
+	if r.allow("first", now) {
+		t.Fatal("Unexpected result. The bucket of first was forgotten")
+	}
+	// The least recently used one was forgotten
+	if !r.allow("0", now) {
+		t.Fatal("Unexpected result. The bucket of 0 was kept")
+	}
+}
+
+func TestAddrKey(t *testing.T) {
+	tData := []struct {
+		host string
+		key  string
+	}{
+		{"192.0.2.1", "192.0.2.1"},
+		{"::ffff:192.0.2.1", "192.0.2.1"},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1:2::/64"},
+		{"2001:db8:1:2:ffff::1", "2001:db8:1:2::/64"},
+		{"fe80::1%eth0", "fe80::/64"},
+		{"pipe", "pipe"},
+	}
+	for _, exp := range tData {
+		if got := addrKey(exp.host); got != exp.key {
+			t.Fatalf("Unexpected result for %s. Got %s, expected %s", exp.host, got, exp.key)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package securecomm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// A recording holds the frames of a session as they were on the wire,
+// along with its keys so Replay can decrypt them later: regression tests
+// replay recorded sessions to pin the wire format. Holding the keys, it
+// is as secret as them. It starts with
+// recordingMagic, then the session: suite, version, whether the
+// recording side was the client, the key of its received frames and the
+// key of its sent frames. Each read or write of the session follows:
+// its direction, 1 when sent, the time since the handshake in
+// nanoseconds as an uint64, the length as an uint32 and the bytes.
+//
+// Sessions with the Diffie-Hellman ratchet of Version5 can't be
+// replayed, as the ratchet keys are never recorded.
+
+// recordingMagic starts the recordings
+const recordingMagic = "secure recording 1\n"
+
+// recordHeaderSize is the size of the header of a read or write
+const recordHeaderSize = 1 + 8 + 4
+
+var errRecording = errors.New("invalid recording")
+
+// recordingConn records the reads and writes of a connection.
+type recordingConn struct {
+	net.Conn
+	start time.Time
+
+	mu  sync.Mutex
+	w   io.Writer
+	err error // of the first failed write to w
+}
+
+// newRecordingConn records the session of hs over c to w.
+func newRecordingConn(c net.Conn, w io.Writer, hs *handshake) *recordingConn {
+	rc := &recordingConn{Conn: c, w: w, start: time.Now()}
+	b := append([]byte(recordingMagic), byte(hs.suite), hs.version, 0)
+	if hs.initiator {
+		b[len(b)-1] = 1
+	}
+	b = append(b, hs.recvKey[:]...)
+	b = append(b, hs.sendKey[:]...)
+	rc.write(b)
+	return rc
+}
+
+func (rc *recordingConn) write(b []byte) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.err == nil {
+		_, rc.err = rc.w.Write(b)
+	}
+}
+
+func (rc *recordingConn) record(sent bool, p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	b := make([]byte, recordHeaderSize, recordHeaderSize+len(p))
+	if sent {
+		b[0] = 1
+	}
+	binary.BigEndian.PutUint64(b[1:], uint64(time.Since(rc.start)))
+	binary.BigEndian.PutUint32(b[9:], uint32(len(p)))
+	rc.write(append(b, p...))
+}
+
+func (rc *recordingConn) Read(p []byte) (int, error) {
+	n, err := rc.Conn.Read(p)
+	rc.record(false, p[:n])
+	return n, err
+}
+
+func (rc *recordingConn) Write(p []byte) (int, error) {
+	n, err := rc.Conn.Write(p)
+	rc.record(true, p[:n])
+	return n, err
+}
+
+// CloseWrite closes the writing side of the recorded connection, if
+// supported.
+func (rc *recordingConn) CloseWrite() error {
+	cw, ok := rc.Conn.(interface{ CloseWrite() error })
+	if !ok {
+		return errors.New("connection can't be half-closed")
+	}
+	return cw.CloseWrite()
+}
+
+// Replay is a recorded session, see Config.Record.
+type Replay struct {
+	Suite     Suite
+	Version   uint8
+	Initiator bool // whether the recording side was the client
+
+	// Received and Sent are the frames received and sent by the
+	// recording side, as they were on the wire.
+	Received, Sent []byte
+
+	recvKey, sendKey [32]byte
+}
+
+// ReadReplay reads a recording written with Config.Record.
+func ReadReplay(r io.Reader) (*Replay, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(recordingMagic)+3+64)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(recordingMagic)]) != recordingMagic {
+		return nil, errRecording
+	}
+	session := header[len(recordingMagic):]
+	rp := &Replay{Suite: Suite(session[0]), Version: session[1], Initiator: session[2] == 1}
+	copy(rp.recvKey[:], session[3:35])
+	copy(rp.sendKey[:], session[35:67])
+	if _, err := rp.Suite.newAEAD(&rp.recvKey); err != nil {
+		return nil, err
+	}
+
+	var received, sent bytes.Buffer
+	rec := make([]byte, recordHeaderSize)
+	for {
+		_, err := io.ReadFull(br, rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errRecording
+		}
+		dst := &received
+		if rec[0] == 1 {
+			dst = &sent
+		}
+		if _, err := io.CopyN(dst, br, int64(binary.BigEndian.Uint32(rec[9:]))); err != nil {
+			return nil, errRecording
+		}
+	}
+	rp.Received, rp.Sent = received.Bytes(), sent.Bytes()
+	return rp, nil
+}
+
+// ReceivedReader returns a reader decrypting the received frames as the
+// recording side did during the session.
+func (rp *Replay) ReceivedReader() *SecureReader {
+	return rp.reader(rp.Received, &rp.recvKey, rp.Initiator)
+}
+
+// SentReader returns a reader decrypting the sent frames, as the peer
+// did during the session.
+func (rp *Replay) SentReader() *SecureReader {
+	return rp.reader(rp.Sent, &rp.sendKey, !rp.Initiator)
+}
+
+// reader returns a reader of wire for the side of the session that is
+// the client when initiator, set up like newConn does.
+func (rp *Replay) reader(wire []byte, key *[32]byte, initiator bool) *SecureReader {
+	sr := newSecureReader(bytes.NewReader(wire), rp.Suite, key)
+	sr.long = rp.Version >= Version9
+	sr.explicitSeq = rp.Version >= Version10
+	if sr.long {
+		sr.maxSize = maxLargeFrameSize
+	}
+	if rp.Version >= Version4 {
+		sr.counterNonces = true
+		if initiator {
+			sr.direction = 1
+		}
+	}
+	return sr
+}
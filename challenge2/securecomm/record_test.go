@@ -0,0 +1,100 @@
+package securecomm
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateRecordings = flag.Bool("update-recordings", false, "Record the sessions replayed by the tests again")
+
+// recordSession records a session where the client sends "hello" and
+// the server answers "world".
+func recordSession(t *testing.T, cfg *Config) []byte {
+	var recording bytes.Buffer
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	cfg.Record = &recording
+	client, server := tcpConns(t, cfg, nil)
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(server, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Write([]byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(client, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	server.Close()
+	return recording.Bytes()
+}
+
+// checkReplay replays recording, checking the data of the session.
+func checkReplay(t *testing.T, recording []byte) {
+	rp, err := ReadReplay(bytes.NewReader(recording))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rp.Initiator {
+		t.Error("the client recorded a session as the server")
+	}
+	for _, exp := range []struct {
+		sr   *SecureReader
+		want string
+	}{
+		{rp.SentReader(), "hello"},
+		{rp.ReceivedReader(), "world"},
+	} {
+		got, err := io.ReadAll(exp.sr)
+		if err != nil || string(got) != exp.want {
+			t.Errorf("replayed %q, %v, want %q", got, err, exp.want)
+		}
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	for _, cfg := range []*Config{
+		{Suites: []Suite{SuiteNaClBox}},
+		{Suites: []Suite{SuiteAES256GCM}, Padding: true},
+		{MaxVersion: Version3},
+	} {
+		checkReplay(t, recordSession(t, cfg))
+	}
+}
+
+// TestReplayRecording replays a session recorded by an earlier version,
+// so changes of the wire format don't go unnoticed. Run the tests with
+// -update-recordings to record it again once the change is intended.
+func TestReplayRecording(t *testing.T) {
+	path := filepath.Join("testdata", "session.rec")
+	if *updateRecordings {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, recordSession(t, nil), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recording, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReplay(t, recording)
+}
+
+func TestReadReplayInvalid(t *testing.T) {
+	recording := recordSession(t, nil)
+	for _, b := range [][]byte{nil, []byte("not a recording"), recording[:len(recording)-1]} {
+		if _, err := ReadReplay(bytes.NewReader(b)); err == nil {
+			t.Errorf("read a replay from %d invalid bytes", len(b))
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package securecomm
+
+import (
+	"crypto/cipher"
+	"crypto/sha256"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Frame types, sent as the first byte of the sealed payload
+const (
+	frameData byte = iota
+	frameRekey
+	framePing          // since Version2
+	framePong          // since Version2
+	framePadded        // since Version3
+	frameRatchet       // since Version5
+	frameRatchetAck    // since Version5
+	frameRatchetSwitch // since Version5
+)
+
+// A writer switches to a fresh key after sealing this many bytes
+// or using the same key for this long, whichever comes first.
+const (
+	defaultRekeyBytes    = 1 << 30
+	defaultRekeyInterval = time.Hour
+)
+
+func (sw *SecureWriter) needsRekey() bool {
+	return sw.sent >= sw.rekeyBytes || time.Since(sw.rekeyed) >= sw.rekeyInterval
+}
+
+// rekey announces the peer that the next frames are sealed with
+// a new key and switches to it. The reader ratchets its own key
+// when it receives the rekey frame.
+func (sw *SecureWriter) rekey() error {
+	if err := sw.writeFrame(frameRekey, nil); err != nil {
+		return err
+	}
+	aead, err := ratchet(sw.suite, sw.key)
+	if err != nil {
+		return err
+	}
+	sw.aead = aead
+	sw.sent = 0
+	sw.rekeyed = time.Now()
+	return nil
+}
+
+// Rekey switches to a new key for the frames we send right away,
+// without waiting for the byte or time limits.
+func (c *Conn) Rekey() error {
+	sw := c.Writer.(*SecureWriter)
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.rekey()
+}
+
+// ratchet replaces key with a new one derived from it and returns the
+// AEAD of the suite using the new key. Old keys can't be recovered from
+// the new ones.
+func ratchet(suite Suite, key *[32]byte) (cipher.AEAD, error) {
+	r := hkdf.New(sha256.New, key[:], nil, []byte("rekey"))
+	if _, err := io.ReadFull(r, key[:]); err != nil {
+		// HKDF can output way more than 32 bytes
+		panic(err)
+	}
+	return suite.newAEAD(key)
+}
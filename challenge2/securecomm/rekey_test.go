@@ -0,0 +1,73 @@
+package securecomm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRekey(t *testing.T) {
+	priv, pub := &[32]byte{'p', 'r', 'i', 'v'}, &[32]byte{'p', 'u', 'b'}
+
+	tData := []struct {
+		name          string
+		rekeyBytes    uint64
+		rekeyInterval time.Duration
+	}{
+		{"bytes", 10, time.Hour},
+		{"interval", 1 << 30, time.Nanosecond},
+	}
+
+	for _, exp := range tData {
+		var wire bytes.Buffer
+		secureW := NewSecureWriter(&wire, priv, pub).(*SecureWriter)
+		secureW.rekeyBytes = exp.rekeyBytes
+		secureW.rekeyInterval = exp.rekeyInterval
+		initialKey := *secureW.key
+
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(secureW, "message %d\n", i)
+		}
+		if *secureW.key == initialKey {
+			t.Fatalf("%s: expected the writer to rekey", exp.name)
+		}
+
+		secureR := NewSecureReader(&wire, priv, pub).(*SecureReader)
+		buf, err := ioutil.ReadAll(secureR)
+		if err != nil {
+			t.Fatalf("%s: %s", exp.name, err)
+		}
+		if expected := "message 0\nmessage 1\nmessage 2\n"; string(buf) != expected {
+			t.Fatalf("%s: unexpected result: %q != %q", exp.name, buf, expected)
+		}
+		if *secureR.key != *secureW.key {
+			t.Fatalf("%s: reader and writer keys are out of sync", exp.name)
+		}
+	}
+}
+
+func TestConnRekey(t *testing.T) {
+	client, server := tcpConns(t, nil, nil)
+	defer client.Close()
+	defer server.Close()
+	before := client.RatchetState()
+
+	if err := client.Rekey(); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(client, "hello")
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(server, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("Unexpected result: %q", buf)
+	}
+	after := client.RatchetState()
+	if after.ClientKey == before.ClientKey || after != server.RatchetState() {
+		t.Fatal("Unexpected result. The client key did not change on both sides.")
+	}
+}
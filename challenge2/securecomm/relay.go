@@ -0,0 +1,285 @@
+package securecomm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Relay mode: two clients join the same room on a relay server, which
+// splices their connections together once both are there. The clients
+// then run a handshake of their own through the relay, which only sees
+// their encrypted frames. The protocol is a line from each client with
+// the room name, answered once the room is full with "server" to the
+// first client and "client" to the second, their roles in the
+// end-to-end handshake, or with an "ERR <reason>" line.
+
+// relayTimeout is how long the first client of a room waits for the
+// second by default.
+const relayTimeout = 5 * time.Minute
+
+// maxRoomSize bounds the length of the room names
+const maxRoomSize = 64
+
+// maxRooms bounds the number of rooms waiting for a second client.
+const maxRooms = 1024
+
+// Errors of the relay
+var (
+	errRoomName     = errors.New("invalid room name")
+	errRelayTimeout = errors.New("no peer joined the room")
+	errTooManyRooms = errors.New("too many rooms")
+	errRelayEarly   = errors.New("data sent before a peer joined the room")
+)
+
+// Relay pairs the clients joining the same room and splices their
+// connections. It is a Handler.
+type Relay struct {
+	// Timeout is how long the first client of a room waits for the
+	// second, relayTimeout by default.
+	Timeout time.Duration
+
+	// Logger, if not nil, logs the rooms.
+	Logger Logger
+
+	mu    sync.Mutex
+	rooms map[string]*relayWaiter
+}
+
+// relayWaiter is the first client of a room.
+type relayWaiter struct {
+	conn *watchedConn
+	done chan struct{} // closed once its connection was spliced
+}
+
+// watchedConn reads a connection in the background, so a first client
+// leaving its room is noticed. The spliced connection reads the data
+// of that read first.
+type watchedConn struct {
+	io.ReadWriteCloser
+	read    chan struct{} // closed once the background read returned
+	pending []byte
+	err     error
+}
+
+func watchConn(conn io.ReadWriteCloser) *watchedConn {
+	c := &watchedConn{ReadWriteCloser: conn, read: make(chan struct{})}
+	go func() {
+		buf := make([]byte, 1)
+		n, err := conn.Read(buf)
+		c.pending, c.err = buf[:n], err
+		close(c.read)
+	}()
+	return c
+}
+
+func (c *watchedConn) Read(p []byte) (int, error) {
+	<-c.read
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.ReadWriteCloser.Read(p)
+}
+
+func (c *watchedConn) CloseWrite() error {
+	return CloseWrite(c.ReadWriteCloser)
+}
+
+// ServeConn reads the room joined by the client and waits for a peer
+// to join it, or splices conn to the peer waiting there. It then closes
+// conn.
+func (r *Relay) ServeConn(conn io.ReadWriteCloser, peer Peer) error {
+	defer conn.Close()
+	room, err := readRoom(conn)
+	if err != nil {
+		fmt.Fprintf(conn, "ERR %s\n", err)
+		return err
+	}
+
+	r.mu.Lock()
+	if r.rooms == nil {
+		r.rooms = make(map[string]*relayWaiter)
+	}
+	first, ok := r.rooms[room]
+	if ok {
+		delete(r.rooms, room)
+	} else if len(r.rooms) >= maxRooms {
+		r.mu.Unlock()
+		fmt.Fprintf(conn, "ERR %s\n", errTooManyRooms)
+		return errTooManyRooms
+	} else {
+		first = &relayWaiter{conn: watchConn(conn), done: make(chan struct{})}
+		r.rooms[room] = first
+	}
+	r.mu.Unlock()
+	if !ok {
+		return r.wait(room, first)
+	}
+
+	defer close(first.done)
+	if _, err := io.WriteString(first.conn, "server\n"); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(conn, "client\n"); err != nil {
+		return err
+	}
+	logger := r.Logger
+	if logger == nil {
+		logger = discardLogger
+	}
+	logger.Info("relaying", "room", room)
+	splice(first.conn, conn)
+	return nil
+}
+
+// wait waits for the second client of room to splice w, or gives up
+// after the timeout or once the first client leaves.
+func (r *Relay) wait(room string, w *relayWaiter) error {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = relayTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	reason := errRelayTimeout
+	select {
+	case <-w.done:
+		return nil
+	case <-timer.C:
+	case <-w.conn.read:
+		// The client left, or spoke before its turn
+		reason = errRelayEarly
+		if w.conn.err != nil {
+			reason = w.conn.err
+		}
+	}
+
+	r.mu.Lock()
+	waiting := r.rooms[room] == w
+	if waiting {
+		delete(r.rooms, room)
+	}
+	r.mu.Unlock()
+	if !waiting {
+		// A peer joined in the meantime
+		<-w.done
+		return nil
+	}
+	fmt.Fprintf(w.conn, "ERR %s\n", reason)
+	return reason
+}
+
+// readRoom reads the room line, a byte at a time so nothing of the
+// handshake that follows is consumed.
+func readRoom(r io.Reader) (string, error) {
+	line, err := readLine(r, maxRoomSize)
+	if err != nil {
+		return "", err
+	}
+	if line == "" || strings.ContainsAny(line, " \t\r") {
+		return "", errRoomName
+	}
+	return line, nil
+}
+
+// readLine reads a line of up to max bytes, without the newline, a byte
+// at a time.
+func readLine(r io.Reader, max int) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return string(line), nil
+		}
+		if len(line) == max {
+			return "", errRoomName
+		}
+		line = append(line, b[0])
+	}
+}
+
+// relayAddr is the address of a peer reached through a relay, room@relay.
+type relayAddr struct {
+	room, relay string
+}
+
+func (a relayAddr) Network() string { return "relay" }
+func (a relayAddr) String() string  { return a.room + "@" + a.relay }
+
+// relayedConn is the connection to the relay, carrying the end-to-end
+// handshake. Its remote address is the peer, so it is verified as
+// room@relay.
+type relayedConn struct {
+	*Conn
+	peer relayAddr
+}
+
+func (c *relayedConn) RemoteAddr() net.Addr {
+	return c.peer
+}
+
+// DialRelay joins room on the relay server at relay, connecting with
+// relayCfg, and performs the handshake with the peer joining the same
+// room with cfg. The peer is verified as room@relay, so its identity,
+// pre-shared key or password authenticate it, not the relay.
+func DialRelay(ctx context.Context, relay, room string, relayCfg, cfg *Config) (*Conn, error) {
+	if room == "" || len(room) > maxRoomSize || strings.ContainsAny(room, " \t\r\n") {
+		return nil, errRoomName
+	}
+	rwc, err := DialConfigContext(ctx, relay, relayCfg)
+	if err != nil {
+		return nil, err
+	}
+	outer := rwc.(*Conn)
+	c, err := joinRoom(ctx, outer, relay, room, cfg)
+	if err != nil {
+		outer.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func joinRoom(ctx context.Context, outer *Conn, relay, room string, cfg *Config) (*Conn, error) {
+	var role string
+	err := withContext(ctx, outer, func() (err error) {
+		if _, err := io.WriteString(outer, room+"\n"); err != nil {
+			return err
+		}
+		role, err = readLine(outer, 256)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	conn := &relayedConn{Conn: outer, peer: relayAddr{room: room, relay: relay}}
+	switch {
+	case role == "server":
+		return newServerConn(ctx, conn, cfg)
+	case role == "client":
+		return newClientConn(ctx, conn, conn.peer.String(), cfg)
+	case strings.HasPrefix(role, "ERR "):
+		return nil, fmt.Errorf("relay refused to join %s: %s", room, strings.TrimPrefix(role, "ERR "))
+	}
+	return nil, fmt.Errorf("unexpected relay answer %q", role)
+}
+
+// RelayConfig returns cfg without the pre-shared key and the password,
+// for the connection to the relay: they authenticate the peer.
+func RelayConfig(cfg *Config) *Config {
+	c := *cfg
+	c.PSK, c.Password = nil, nil
+	return &c
+}
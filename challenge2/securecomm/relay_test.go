@@ -0,0 +1,115 @@
+package securecomm
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRelay(t *testing.T) {
+	addr, _ := startServer(t, &Server{Handler: &Relay{}})
+
+	var cfgs []*Config
+	for range 2 {
+		_, identity, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfgs = append(cfgs, &Config{Identity: identity, Password: []byte("correct horse")})
+	}
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	results := make([]chan result, 2)
+	for i, cfg := range cfgs {
+		results[i] = make(chan result, 1)
+		go func() {
+			c, err := DialRelay(context.Background(), addr, "room-7", RelayConfig(cfg), cfg)
+			results[i] <- result{c, err}
+		}()
+		// Both orders work, the second joining after the first waits
+		time.Sleep(50 * time.Millisecond)
+	}
+	var conns []*Conn
+	for i := range results {
+		r := <-results[i]
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		defer r.conn.Close()
+		conns = append(conns, r.conn)
+	}
+
+	// The clients authenticated each other, not the relay
+	for i, c := range conns {
+		want := cfgs[1-i].Identity.Public().(ed25519.PublicKey)
+		if !c.PeerIdentity().Equal(want) {
+			t.Errorf("client %d got the identity %s, want %s", i, Fingerprint(c.PeerIdentity()), Fingerprint(want))
+		}
+	}
+	if _, err := conns[0].Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conns[1], buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("got %q, %v", buf, err)
+	}
+	if err := conns[1].CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conns[0].Read(buf); err != io.EOF {
+		t.Fatalf("got %v after the peer closed, want io.EOF", err)
+	}
+}
+
+func TestRelayTimeout(t *testing.T) {
+	addr, _ := startServer(t, &Server{Handler: &Relay{Timeout: 100 * time.Millisecond}})
+	_, err := DialRelay(context.Background(), addr, "empty", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), errRelayTimeout.Error()) {
+		t.Fatalf("got %v, want %v", err, errRelayTimeout)
+	}
+	if _, err := DialRelay(context.Background(), addr, "two words", nil, nil); err != errRoomName {
+		t.Fatalf("got %v, want %v", err, errRoomName)
+	}
+}
+
+func TestRelayFirstClientLeaves(t *testing.T) {
+	r := &Relay{}
+	addr, _ := startServer(t, &Server{Handler: r})
+	rwc, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(rwc, "left\n"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !relayWaiting(r, "left") {
+		if time.Now().After(deadline) {
+			t.Fatal("Unexpected result. The client never waited in the room")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The room is freed once the client leaves, before the timeout
+	rwc.Close()
+	for relayWaiting(r, "left") {
+		if time.Now().After(deadline) {
+			t.Fatal("Unexpected result. The room is still taken")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// relayWaiting reports whether a client waits in room.
+func relayWaiting(r *Relay, room string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.rooms[room]
+	return ok
+}
@@ -0,0 +1,492 @@
+package securecomm
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reliable mode runs a stream over datagrams, such as the secure ones of
+// DatagramConn, so streams go through where TCP is blocked. The stream
+// is cut into segments, each one sent in a datagram:
+//
+//	[type][uint32 sequence number][uint32 acknowledgment][data]
+//
+// Every segment acknowledges the ones received in order, by the sequence
+// number of the next one expected. Receivers buffer the segments arriving
+// early and acknowledge each data segment, even duplicated, leaving the
+// next ones unacknowledged while too much data waits to be read. Senders
+// retransmit the segments not acknowledged within the retransmission
+// timeout, estimated from the round trip times like TCP does (RFC 6298).
+
+// Segment types
+const (
+	segmentData byte = iota
+	segmentAck
+	segmentFin // ends the stream of the sender, like a TCP FIN
+)
+
+// segmentHeaderSize is the size of the header of the segments
+const segmentHeaderSize = 1 + 4 + 4
+
+// maxSegmentData is the largest data of a segment, so datagrams fit in
+// a packet without IP fragmentation.
+const maxSegmentData = 1200
+
+// reliableWindow is the number of segments sent and not acknowledged
+// yet, and of segments buffered when arriving early.
+const reliableWindow = 64
+
+// maxReliableBuffer is the size of the data received in order and not
+// read yet, past which the next segments are left unacknowledged until
+// the reader catches up.
+const maxReliableBuffer = reliableWindow * maxSegmentData
+
+// Retransmission timeouts, see RFC 6298
+const (
+	initialRTO = time.Second
+	minRTO     = 200 * time.Millisecond
+	maxRTO     = 10 * time.Second
+)
+
+// maxRetransmits is the number of times a segment is sent again before
+// the peer is considered unreachable.
+const maxRetransmits = 10
+
+// dupAcks is the number of duplicated acknowledgments after which the
+// oldest segment in flight is sent again without waiting for its timeout,
+// as they tell that the next ones arrived.
+const dupAcks = 3
+
+// retransmitTick is the interval between the checks of the timeouts of
+// the segments in flight.
+const retransmitTick = 10 * time.Millisecond
+
+// ErrPeerUnreachable is returned by reliable connections once the peer
+// stopped acknowledging the segments sent.
+var ErrPeerUnreachable = errors.New("peer stopped acknowledging")
+
+// errWriteClosed is returned when writing after CloseWrite.
+var errWriteClosed = errors.New("writing side closed")
+
+// segment is a segment sent and not acknowledged yet.
+type segment struct {
+	typ         byte
+	seq         uint32
+	data        []byte
+	sent        time.Time
+	retransmits int
+}
+
+// ReliableConn is a reliable and ordered stream over a datagram
+// connection, such as a DatagramConn. It implements net.Conn.
+type ReliableConn struct {
+	conn net.Conn
+	done chan struct{}
+
+	mu      sync.Mutex
+	changed *sync.Cond // broadcast when the state below changes
+	err     error      // set once the connection is broken or closed
+
+	// Sending
+	nextSeq    uint32     // of the next segment sent
+	unacked    []*segment // oldest first
+	dups       int        // duplicated acknowledgments received
+	timed      *segment   // timing the round trip, if any
+	writeShut  bool
+	srtt       time.Duration
+	rttvar     time.Duration
+	rto        time.Duration
+	writeLimit time.Time // write deadline
+
+	// Receiving
+	expected  uint32              // sequence number of the next segment to read
+	early     map[uint32][]byte   // data of the segments received early
+	earlyFin  map[uint32]struct{} // end of the stream received early
+	buf       []byte              // received in order, not read yet
+	eof       bool                // the peer closed its writing side
+	readLimit time.Time           // read deadline
+	timers    [2]*time.Timer      // waking up the reads and writes on deadlines
+}
+
+// ReliableConn is a drop-in replacement for the underlying net.Conn
+var _ net.Conn = (*ReliableConn)(nil)
+
+// NewReliableConn returns a reliable stream over conn, whose reads and
+// writes must be datagrams of up to 64 KiB. Both peers must use it.
+func NewReliableConn(conn net.Conn) *ReliableConn {
+	c := &ReliableConn{
+		conn:     conn,
+		done:     make(chan struct{}),
+		rto:      initialRTO,
+		early:    make(map[uint32][]byte),
+		earlyFin: make(map[uint32]struct{}),
+	}
+	c.changed = sync.NewCond(&c.mu)
+	go c.receive()
+	go c.retransmit()
+	return c
+}
+
+// send writes a segment, acknowledging the segments received. The
+// caller holds c.mu.
+func (c *ReliableConn) send(typ byte, seq uint32, data []byte) error {
+	b := make([]byte, segmentHeaderSize, segmentHeaderSize+len(data))
+	b[0] = typ
+	binary.BigEndian.PutUint32(b[1:], seq)
+	binary.BigEndian.PutUint32(b[5:], c.expected)
+	_, err := c.conn.Write(append(b, data...))
+	return err
+}
+
+// fail breaks the connection with err, unless already broken. The
+// caller holds c.mu.
+func (c *ReliableConn) fail(err error) {
+	if c.err == nil {
+		c.err = err
+		c.changed.Broadcast()
+	}
+}
+
+// receive handles the segments of the peer until the connection breaks.
+func (c *ReliableConn) receive() {
+	b := make([]byte, maxDatagramSize)
+	for {
+		n, err := c.conn.Read(b)
+		if err != nil {
+			c.mu.Lock()
+			c.fail(err)
+			c.mu.Unlock()
+			return
+		}
+		if n < segmentHeaderSize {
+			continue
+		}
+		c.mu.Lock()
+		c.handle(b[0], binary.BigEndian.Uint32(b[1:]), binary.BigEndian.Uint32(b[5:]), b[segmentHeaderSize:n])
+		c.mu.Unlock()
+	}
+}
+
+// handle handles a segment of the peer. The caller holds c.mu.
+func (c *ReliableConn) handle(typ byte, seq, ack uint32, data []byte) {
+	c.acked(typ, ack)
+	if typ != segmentData && typ != segmentFin {
+		return
+	}
+
+	// Keep the segments within the window, then read the ones in order
+	if ahead := int32(seq - c.expected); ahead >= 0 && ahead < reliableWindow {
+		if typ == segmentFin {
+			c.earlyFin[seq] = struct{}{}
+		} else if _, ok := c.early[seq]; !ok {
+			c.early[seq] = append([]byte(nil), data...)
+		}
+	}
+	c.deliver()
+	c.send(segmentAck, 0, nil)
+}
+
+// deliver moves the segments received in order to the data to read,
+// while it is below maxReliableBuffer, telling whether any was. The
+// caller holds c.mu.
+func (c *ReliableConn) deliver() bool {
+	delivered := false
+	for len(c.buf) < maxReliableBuffer {
+		if data, ok := c.early[c.expected]; ok {
+			delete(c.early, c.expected)
+			c.buf = append(c.buf, data...)
+		} else if _, ok := c.earlyFin[c.expected]; ok {
+			delete(c.earlyFin, c.expected)
+			c.eof = true
+		} else {
+			break
+		}
+		c.expected++
+		delivered = true
+		c.changed.Broadcast()
+	}
+	return delivered
+}
+
+// acked removes the segments acknowledged by ack from the ones in
+// flight, updating the retransmission timeout with the timed segment.
+// The caller holds c.mu.
+func (c *ReliableConn) acked(typ byte, ack uint32) {
+	n, recovered := 0, false
+	for n < len(c.unacked) && int32(ack-c.unacked[n].seq) > 0 {
+		s := c.unacked[n]
+		if s == c.timed {
+			c.sampleRTT(time.Since(s.sent))
+			c.timed = nil
+		}
+		recovered = recovered || s.retransmits > 0
+		n++
+	}
+	if n > 0 {
+		// The peer is back, undo the backoff of the timeout
+		if c.srtt != 0 {
+			c.rto = min(max(c.srtt+4*c.rttvar, minRTO), maxRTO)
+		}
+		c.unacked = c.unacked[n:]
+		c.dups = 0
+		c.changed.Broadcast()
+		if recovered && len(c.unacked) > 0 {
+			// A partial acknowledgment after a retransmission: the
+			// next segment was lost too, like TCP NewReno
+			c.resend(c.unacked[0])
+		}
+		return
+	}
+	if typ == segmentAck && len(c.unacked) > 0 {
+		// Fast retransmit, like TCP
+		if c.dups++; c.dups == dupAcks {
+			c.resend(c.unacked[0])
+		}
+	}
+}
+
+// sampleRTT updates the retransmission timeout with a round trip time,
+// following RFC 6298. The caller holds c.mu.
+func (c *ReliableConn) sampleRTT(rtt time.Duration) {
+	if c.srtt == 0 {
+		c.srtt, c.rttvar = rtt, rtt/2
+	} else {
+		c.rttvar = (3*c.rttvar + (c.srtt - rtt).Abs()) / 4
+		c.srtt = (7*c.srtt + rtt) / 8
+	}
+	c.rto = min(max(c.srtt+4*c.rttvar, minRTO), maxRTO)
+}
+
+// retransmit sends the oldest segment in flight again once not
+// acknowledged in time, doubling the timeout, until the connection is
+// closed. The next ones are sent again as the acknowledgments tell they
+// are missing, see acked.
+func (c *ReliableConn) retransmit() {
+	t := time.NewTicker(retransmitTick)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+		}
+		c.mu.Lock()
+		if c.err == nil && len(c.unacked) > 0 && time.Since(c.unacked[0].sent) >= c.rto {
+			if c.unacked[0].retransmits >= maxRetransmits {
+				c.fail(ErrPeerUnreachable)
+			} else {
+				c.resend(c.unacked[0])
+				c.rto = min(2*c.rto, maxRTO)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// resend sends a segment in flight again. The caller holds c.mu.
+func (c *ReliableConn) resend(s *segment) {
+	// Karn's algorithm: the round trip times are unknown during losses
+	c.timed = nil
+	s.retransmits++
+	s.sent = time.Now()
+	c.send(s.typ, s.seq, s.data)
+}
+
+// wait waits for a change of the state, failing once the deadline is
+// exceeded. The caller holds c.mu.
+func (c *ReliableConn) wait(deadline time.Time) error {
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return os.ErrDeadlineExceeded
+	}
+	c.changed.Wait()
+	return nil
+}
+
+// Read reads the data received in order, waiting for it.
+func (c *ReliableConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.buf) == 0 {
+		switch {
+		case c.eof:
+			return 0, io.EOF
+		case c.err != nil:
+			return 0, c.err
+		}
+		if err := c.wait(c.readLimit); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	if c.deliver() {
+		// Acknowledge the segments left waiting for room
+		c.send(segmentAck, 0, nil)
+	}
+	return n, nil
+}
+
+// Write sends p in segments, waiting while too many are in flight.
+func (c *ReliableConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int
+	for len(p) > 0 {
+		if err := c.waitWindow(); err != nil {
+			return n, err
+		}
+		chunk := p[:min(len(p), maxSegmentData)]
+		if err := c.push(segmentData, append([]byte(nil), chunk...)); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// waitWindow waits for room in the window of the segments in flight.
+// The caller holds c.mu.
+func (c *ReliableConn) waitWindow() error {
+	for {
+		switch {
+		case c.err != nil:
+			return c.err
+		case c.writeShut:
+			return errWriteClosed
+		case len(c.unacked) < reliableWindow:
+			return nil
+		}
+		if err := c.wait(c.writeLimit); err != nil {
+			return err
+		}
+	}
+}
+
+// push sends a new segment, kept until acknowledged. The caller holds
+// c.mu.
+func (c *ReliableConn) push(typ byte, data []byte) error {
+	s := &segment{typ: typ, seq: c.nextSeq, data: data, sent: time.Now()}
+	c.nextSeq++
+	c.unacked = append(c.unacked, s)
+	if c.timed == nil {
+		// Like TCP, a segment at a time is timed, as the ones acknowledged
+		// with a retransmitted one would tell a longer round trip
+		c.timed = s
+	}
+	return c.send(typ, s.seq, data)
+}
+
+// CloseWrite ends the stream sent to the peer, which reads io.EOF once
+// it received all of it.
+func (c *ReliableConn) CloseWrite() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.waitWindow(); err != nil {
+		return err
+	}
+	c.writeShut = true
+	return c.push(segmentFin, nil)
+}
+
+// Close ends the stream and closes the underlying connection, once the
+// peer acknowledged all the data or stopped acknowledging it. When the
+// peer ended its own stream and received all the data, it may be gone
+// already, so the end of the stream is only given a retransmission
+// timeout to be acknowledged.
+func (c *ReliableConn) Close() error {
+	c.mu.Lock()
+	if c.err == nil && !c.writeShut {
+		c.writeShut = true
+		c.push(segmentFin, nil)
+	}
+	// Wait for the acknowledgments as long as the peer keeps sending
+	// them, ErrPeerUnreachable telling otherwise
+	wake := time.AfterFunc(c.rto, c.broadcast)
+	limit := time.Now().Add(c.rto)
+	for c.err == nil && len(c.unacked) > 0 {
+		if !(c.eof && len(c.unacked) == 1 && c.unacked[0].typ == segmentFin) {
+			limit = time.Now().Add(c.rto)
+			wake.Reset(c.rto)
+		} else if !time.Now().Before(limit) {
+			break
+		}
+		c.changed.Wait()
+	}
+	wake.Stop()
+	c.fail(net.ErrClosed)
+	for _, t := range c.timers {
+		if t != nil {
+			t.Stop()
+		}
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+	return c.conn.Close()
+}
+
+// broadcast wakes up the reads and writes waiting, to check their
+// deadlines.
+func (c *ReliableConn) broadcast() {
+	c.mu.Lock()
+	c.changed.Broadcast()
+	c.mu.Unlock()
+}
+
+// LocalAddr returns the local address of the underlying connection
+func (c *ReliableConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the underlying connection
+func (c *ReliableConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines
+func (c *ReliableConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline of the reads, waking up the ones
+// waiting when it passes.
+func (c *ReliableConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readLimit = t
+	c.setTimer(0, t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline of the writes waiting for room in
+// the window.
+func (c *ReliableConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeLimit = t
+	c.setTimer(1, t)
+	return nil
+}
+
+// setTimer wakes up the waiting reads or writes at t. The caller holds
+// c.mu.
+func (c *ReliableConn) setTimer(i int, t time.Time) {
+	if c.timers[i] != nil {
+		c.timers[i].Stop()
+	}
+	if t.IsZero() {
+		return
+	}
+	c.timers[i] = time.AfterFunc(time.Until(t), c.broadcast)
+}
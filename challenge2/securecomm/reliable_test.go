@@ -0,0 +1,181 @@
+package securecomm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lossyConn drops a tenth of the datagrams written and delays another
+// tenth after the next one, like a bad network.
+type lossyConn struct {
+	net.Conn
+	mu      sync.Mutex
+	rand    *rand.Rand
+	delayed []byte
+}
+
+func newLossyConn(c net.Conn) *lossyConn {
+	return &lossyConn{Conn: c, rand: rand.New(rand.NewPCG(1, 2))}
+}
+
+func (c *lossyConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch r := c.rand.IntN(10); {
+	case r == 0:
+		return len(p), nil
+	case r == 1 && c.delayed == nil:
+		c.delayed = append([]byte(nil), p...)
+		return len(p), nil
+	}
+	n, err := c.Conn.Write(p)
+	if c.delayed != nil {
+		c.Conn.Write(c.delayed)
+		c.delayed = nil
+	}
+	return n, err
+}
+
+// udpPair returns two UDP connections to each other.
+func udpPair(t *testing.T) (net.Conn, net.Conn) {
+	a, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &punchedConn{a, b.LocalAddr().(*net.UDPAddr)}, &punchedConn{b, a.LocalAddr().(*net.UDPAddr)}
+}
+
+func TestReliableConn(t *testing.T) {
+	a, b := udpPair(t)
+	client := NewReliableConn(newLossyConn(a))
+	server := NewReliableConn(newLossyConn(b))
+	defer client.Close()
+
+	// The server echoes everything, over the lossy network both ways
+	go func() {
+		io.Copy(server, server)
+		server.Close()
+	}()
+	msg := make([]byte, 128<<10)
+	for i := range msg {
+		msg[i] = byte(i % 251)
+	}
+	go func() {
+		client.Write(msg)
+		client.CloseWrite()
+	}()
+	got, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("Unexpected result: got %d bytes, expected %d", len(got), len(msg))
+	}
+}
+
+func TestReliableConnUnreachable(t *testing.T) {
+	a, b := udpPair(t)
+	b.Close()
+	c := NewReliableConn(a)
+	defer c.Close()
+
+	c.mu.Lock()
+	c.rto = time.Millisecond
+	c.mu.Unlock()
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.Read(make([]byte, 5)); !errors.Is(err, ErrPeerUnreachable) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestReliableConnDeadline(t *testing.T) {
+	a, b := udpPair(t)
+	client, server := NewReliableConn(a), NewReliableConn(b)
+	defer client.Close()
+	defer server.Close()
+
+	client.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := client.Read(make([]byte, 5)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestReliableDatagramConn(t *testing.T) {
+	a, b := udpPair(t)
+	accepted := make(chan *DatagramConn)
+	go func() {
+		// The server reads the hello before the handshake
+		b.Read(make([]byte, len(packetHello)))
+		c, err := newDatagramServerConn(t.Context(), b, "", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- c
+	}()
+	dc, err := newDatagramConn(t.Context(), a, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := NewReliableConn(dc), NewReliableConn(<-accepted)
+	defer client.Close()
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("datagram"), 10000), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	sent := make(chan error, 1)
+	go func() {
+		sent <- SendFile(client, path, nil)
+	}()
+	if _, err := ReceiveFile(server, dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReliableConnBuffer(t *testing.T) {
+	a, b := udpPair(t)
+	client, server := NewReliableConn(a), NewReliableConn(b)
+	defer client.Close()
+	defer server.Close()
+
+	msg := bytes.Repeat([]byte("0123456789"), maxReliableBuffer)
+	go client.Write(msg)
+
+	// The data not read is bounded while the reader is away
+	time.Sleep(300 * time.Millisecond)
+	server.mu.Lock()
+	buffered := len(server.buf)
+	server.mu.Unlock()
+	if buffered > maxReliableBuffer+maxSegmentData {
+		t.Fatalf("Unexpected result. Got %d bytes buffered, expected at most %d", buffered, maxReliableBuffer+maxSegmentData)
+	}
+
+	got := make([]byte, len(msg))
+	server.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(server, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatal("Unexpected result. The stream was corrupted")
+	}
+}
@@ -0,0 +1,305 @@
+package securecomm
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// A small RPC layer over the secure connections. Requests and responses
+// are messages prefixed by their uint32 length, encoded with a Codec,
+// and matched by their id, so calls run concurrently. Requests carry
+// the deadline of their context, which servers pass to the method.
+
+// maxRPCMessage bounds the size of the messages, so a peer can't make
+// us allocate too much.
+const maxRPCMessage = 1 << 24
+
+var errRPCTooLarge = errors.New("rpc message too large")
+
+// Codec encodes the RPC messages and their arguments.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// The supported codecs. Both sides must use the same one.
+var (
+	JSONCodec Codec = jsonCodec{}
+	GobCodec  Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(v)
+	return b.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// rpcMessage is a request, with a method, or a response.
+type rpcMessage struct {
+	ID       uint64
+	Method   string `json:",omitempty"`
+	Deadline int64  `json:",omitempty"` // in Unix nanoseconds
+	Error    string `json:",omitempty"`
+	Body     []byte `json:",omitempty"`
+}
+
+func writeRPC(w io.Writer, codec Codec, m *rpcMessage) error {
+	b, err := codec.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if len(b) > maxRPCMessage {
+		return errRPCTooLarge
+	}
+	// A single write, so messages don't interleave
+	buf := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(b)), uint32(len(b)))
+	_, err = w.Write(append(buf, b...))
+	return err
+}
+
+func readRPC(r io.Reader, codec Codec, m *rpcMessage) error {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if n > maxRPCMessage {
+		return errRPCTooLarge
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return err
+	}
+	return codec.Unmarshal(b, m)
+}
+
+// RPCError is an error returned by a remote method.
+type RPCError struct {
+	Method  string
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Method, e.Message)
+}
+
+// RPCServer is a registry of methods served over connections. It is a
+// Handler.
+type RPCServer struct {
+	codec   Codec
+	mu      sync.RWMutex
+	methods map[string]func(ctx context.Context, body []byte) ([]byte, error)
+}
+
+// NewRPCServer returns a server using codec, JSONCodec if nil.
+func NewRPCServer(codec Codec) *RPCServer {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	return &RPCServer{codec: codec, methods: make(map[string]func(context.Context, []byte) ([]byte, error))}
+}
+
+// HandleRPC registers fn as the method of s called name.
+func HandleRPC[Req, Resp any](s *RPCServer, name string, fn func(ctx context.Context, req Req) (Resp, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.methods[name] = func(ctx context.Context, body []byte) ([]byte, error) {
+		var req Req
+		if err := s.codec.Unmarshal(body, &req); err != nil {
+			return nil, err
+		}
+		resp, err := fn(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return s.codec.Marshal(resp)
+	}
+}
+
+// ServeConn serves the requests read from conn, concurrently, until the
+// client closes it. It then closes conn.
+func (s *RPCServer) ServeConn(conn io.ReadWriteCloser, peer Peer) error {
+	var wg sync.WaitGroup
+	var wmu sync.Mutex
+	defer conn.Close()
+	defer wg.Wait()
+
+	for {
+		m := new(rpcMessage)
+		if err := readRPC(conn, s.codec, m); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := s.call(m)
+			wmu.Lock()
+			defer wmu.Unlock()
+			writeRPC(conn, s.codec, resp)
+		}()
+	}
+}
+
+func (s *RPCServer) call(m *rpcMessage) *rpcMessage {
+	resp := &rpcMessage{ID: m.ID}
+	s.mu.RLock()
+	fn := s.methods[m.Method]
+	s.mu.RUnlock()
+	if fn == nil {
+		resp.Error = "unknown method"
+		return resp
+	}
+
+	ctx := context.Background()
+	if m.Deadline != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, time.Unix(0, m.Deadline))
+		defer cancel()
+	}
+	body, err := fn(ctx, m.Body)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	resp.Body = body
+	return resp
+}
+
+// RPCClient calls the methods of a server over a connection.
+type RPCClient struct {
+	conn  io.ReadWriteCloser
+	codec Codec
+	wmu   sync.Mutex
+
+	mu      sync.Mutex
+	next    uint64
+	pending map[uint64]chan *rpcMessage
+	err     error // set once the connection failed
+}
+
+// NewRPCClient returns a client calling the server at the other end of
+// conn, using codec, JSONCodec if nil.
+func NewRPCClient(conn io.ReadWriteCloser, codec Codec) *RPCClient {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	c := &RPCClient{conn: conn, codec: codec, pending: make(map[uint64]chan *rpcMessage)}
+	go c.readResponses()
+	return c
+}
+
+func (c *RPCClient) readResponses() {
+	for {
+		m := new(rpcMessage)
+		if err := readRPC(c.conn, c.codec, m); err != nil {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.err = fmt.Errorf("rpc connection failed: %w", err)
+			for id, ch := range c.pending {
+				close(ch)
+				delete(c.pending, id)
+			}
+			return
+		}
+		if ch := c.forget(m.ID); ch != nil {
+			ch <- m
+		}
+	}
+}
+
+// forget stops waiting for the response to the request id.
+func (c *RPCClient) forget(id uint64) chan *rpcMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := c.pending[id]
+	delete(c.pending, id)
+	return ch
+}
+
+// Call calls method with req and decodes its response into resp. It
+// gives up when ctx is done, whose deadline also applies to the method.
+func (c *RPCClient) Call(ctx context.Context, method string, req, resp any) error {
+	body, err := c.codec.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	ch := make(chan *rpcMessage, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.next++
+	m := &rpcMessage{ID: c.next, Method: method, Body: body}
+	c.pending[m.ID] = ch
+	c.mu.Unlock()
+	if deadline, ok := ctx.Deadline(); ok {
+		m.Deadline = deadline.UnixNano()
+	}
+
+	c.wmu.Lock()
+	err = writeRPC(c.conn, c.codec, m)
+	c.wmu.Unlock()
+	if err != nil {
+		c.forget(m.ID)
+		return err
+	}
+
+	select {
+	case r, ok := <-ch:
+		if !ok {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.err
+		}
+		if r.Error != "" {
+			return &RPCError{Method: method, Message: r.Error}
+		}
+		return c.codec.Unmarshal(r.Body, resp)
+	case <-ctx.Done():
+		c.forget(m.ID)
+		return ctx.Err()
+	}
+}
+
+// Close closes the connection, failing the pending calls.
+func (c *RPCClient) Close() error {
+	return c.conn.Close()
+}
+
+// RPCMethod returns a typed stub calling method on c.
+func RPCMethod[Req, Resp any](c *RPCClient, method string) func(ctx context.Context, req Req) (Resp, error) {
+	return func(ctx context.Context, req Req) (Resp, error) {
+		var resp Resp
+		err := c.Call(ctx, method, req, &resp)
+		return resp, err
+	}
+}
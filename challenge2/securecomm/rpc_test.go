@@ -0,0 +1,98 @@
+package securecomm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type upperArgs struct {
+	Text string
+}
+
+type upperReply struct {
+	Text string
+}
+
+// startRPC serves s and returns a client using codec.
+func startRPC(t *testing.T, s *RPCServer, codec Codec) *RPCClient {
+	server := &Server{Handler: s}
+	addr, _ := startServer(t, server)
+	t.Cleanup(func() { server.Close() })
+
+	conn, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewRPCClient(conn, codec)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestRPC(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec, GobCodec} {
+		s := NewRPCServer(codec)
+		HandleRPC(s, "upper", func(ctx context.Context, req upperArgs) (upperReply, error) {
+			if req.Text == "" {
+				return upperReply{}, errors.New("empty text")
+			}
+			return upperReply{strings.ToUpper(req.Text)}, nil
+		})
+		HandleRPC(s, "sleep", func(ctx context.Context, d time.Duration) (bool, error) {
+			select {
+			case <-time.After(d):
+				return true, nil
+			case <-ctx.Done():
+				return false, ctx.Err()
+			}
+		})
+		c := startRPC(t, s, codec)
+		upper := RPCMethod[upperArgs, upperReply](c, "upper")
+
+		// Concurrent calls
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			go func() {
+				resp, err := upper(context.Background(), upperArgs{"hello"})
+				if err == nil && resp.Text != "HELLO" {
+					err = errors.New("unexpected response " + resp.Text)
+				}
+				errs <- err
+			}()
+		}
+		for i := 0; i < 10; i++ {
+			if err := <-errs; err != nil {
+				t.Fatalf("%T: %v", codec, err)
+			}
+		}
+
+		var rpcErr *RPCError
+		if _, err := upper(context.Background(), upperArgs{}); !errors.As(err, &rpcErr) || rpcErr.Message != "empty text" {
+			t.Fatalf("%T: unexpected error %v", codec, err)
+		}
+		if err := c.Call(context.Background(), "missing", 1, new(int)); !errors.As(err, &rpcErr) {
+			t.Fatalf("%T: unexpected error %v", codec, err)
+		}
+
+		// The deadline stops both sides
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		var ok bool
+		err := c.Call(ctx, "sleep", time.Minute, &ok)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("%T: unexpected error %v", codec, err)
+		}
+
+		// The client still works after a call timed out
+		if resp, err := upper(context.Background(), upperArgs{"again"}); err != nil || resp.Text != "AGAIN" {
+			t.Fatalf("%T: unexpected result %v, %v", codec, resp, err)
+		}
+
+		c.Close()
+		if _, err := upper(context.Background(), upperArgs{"closed"}); err == nil {
+			t.Fatalf("%T: expected calls to fail once closed", codec)
+		}
+	}
+}
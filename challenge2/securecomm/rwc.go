@@ -0,0 +1,79 @@
+package securecomm
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// ClientConn performs the client side of the handshake over rw, any
+// byte pipe such as a serial line, the standard streams of a subprocess
+// or a data channel, and returns the secure connection over it, like
+// tls.Client. The server is verified under the address "rwc".
+func ClientConn(rw io.ReadWriteCloser, cfg *Config) (*Conn, error) {
+	c := rwcConnOf(rw, rwcAddr{"rwc", "rwc"})
+	conn, err := newClientConn(context.Background(), c, c.RemoteAddr().String(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// ServerConn performs the server side of the handshake over rw, see
+// ClientConn. It is not named after tls.Server since Server serves
+// listeners.
+func ServerConn(rw io.ReadWriteCloser, cfg *Config) (*Conn, error) {
+	return newServerConn(context.Background(), rwcConnOf(rw, rwcAddr{"rwc", "rwc"}), cfg)
+}
+
+// rwcConnOf returns rw as a net.Conn, wrapping it with addr as the
+// address of both ends unless it is one.
+func rwcConnOf(rw io.ReadWriteCloser, addr rwcAddr) net.Conn {
+	if c, ok := rw.(net.Conn); ok {
+		return c
+	}
+	return rwcConn{rw, addr}
+}
+
+// rwcAddr is the address of both ends of a byte pipe.
+type rwcAddr struct {
+	network, name string
+}
+
+func (a rwcAddr) Network() string { return a.network }
+func (a rwcAddr) String() string  { return a.name }
+
+// rwcConn is a byte pipe as a net.Conn. The deadlines are set on the
+// pipe when it supports them, like *os.File, otherwise the reads and
+// writes can only be interrupted by closing it, and the timeouts of the
+// config and the heartbeats have no effect.
+type rwcConn struct {
+	io.ReadWriteCloser
+	addr rwcAddr
+}
+
+func (c rwcConn) LocalAddr() net.Addr  { return c.addr }
+func (c rwcConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c rwcConn) SetDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(interface{ SetDeadline(time.Time) error }); ok {
+		return d.SetDeadline(t)
+	}
+	return os.ErrNoDeadline
+}
+
+func (c rwcConn) SetReadDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+	return os.ErrNoDeadline
+}
+
+func (c rwcConn) SetWriteDeadline(t time.Time) error {
+	if d, ok := c.ReadWriteCloser.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return os.ErrNoDeadline
+}
@@ -0,0 +1,80 @@
+package securecomm
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+// pipeEnd is one end of a byte pipe made of two one-way pipes.
+type pipeEnd struct {
+	io.ReadCloser
+	io.WriteCloser
+}
+
+func (p pipeEnd) Close() error {
+	p.ReadCloser.Close()
+	return p.WriteCloser.Close()
+}
+
+func TestClientServerConn(t *testing.T) {
+	ioPipes := func() (pipeEnd, pipeEnd) {
+		r1, w1 := io.Pipe()
+		r2, w2 := io.Pipe()
+		return pipeEnd{r1, w2}, pipeEnd{r2, w1}
+	}
+	osPipes := func() (pipeEnd, pipeEnd) {
+		r1, w1, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r2, w2, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pipeEnd{r1, w2}, pipeEnd{r2, w1}
+	}
+	tData := []struct {
+		name  string
+		pipes func() (pipeEnd, pipeEnd)
+		cfg   *Config
+	}{
+		{"io pipes", ioPipes, nil},
+		{"os pipes", osPipes, nil},
+		{"pre-shared key", ioPipes, &Config{PSK: []byte("secret")}},
+	}
+	for _, exp := range tData {
+		c, s := exp.pipes()
+		accepted := make(chan *Conn, 1)
+		go func() {
+			conn, err := ServerConn(s, exp.cfg)
+			if err != nil {
+				t.Error(err)
+				s.Close()
+			}
+			accepted <- conn
+		}()
+		client, err := ClientConn(c, exp.cfg)
+		if err != nil {
+			t.Fatalf("Unexpected result for %s. Got %v", exp.name, err)
+		}
+		server := <-accepted
+		if server == nil {
+			t.FailNow()
+		}
+		go io.Copy(server, server)
+
+		if _, err := client.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "ping" {
+			t.Fatalf("Unexpected result for %s. Got %q, %v", exp.name, buf, err)
+		}
+		if got := client.RemoteAddr().String(); got != "rwc" {
+			t.Fatalf("Unexpected result for %s. Got remote address %s", exp.name, got)
+		}
+		client.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,43 @@
+package securecomm
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+func TestSecurePipe(t *testing.T) {
+	client, server := SecurePipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		io.Copy(server, server)
+	}()
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("got %q, %v", buf, err)
+	}
+}
+
+func TestSecurePipeConfig(t *testing.T) {
+	_, identity, _ := ed25519.GenerateKey(rand.Reader)
+	client, server, err := SecurePipeConfig(nil, &Config{Identity: identity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	server.Close()
+	if !client.PeerIdentity().Equal(identity.Public().(ed25519.PublicKey)) {
+		t.Error("unexpected server identity")
+	}
+
+	// Failed handshakes are returned
+	if _, _, err := SecurePipeConfig(&Config{PSK: []byte("a")}, &Config{PSK: []byte("b")}); err == nil {
+		t.Fatal("handshake with different pre-shared keys succeeded")
+	}
+}
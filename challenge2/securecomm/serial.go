@@ -0,0 +1,151 @@
+package securecomm
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"go.bug.st/serial"
+)
+
+// Frames of a serial line: the marker, the big-endian length of the
+// data and its complement, the data then the CRC-32 of the length and
+// the data. The complement keeps garbage looking like a header from
+// stalling the reads, waiting for a long frame.
+const (
+	serialMarker   = "\x7eGC"
+	serialHeader   = len(serialMarker) + 4
+	maxSerialFrame = 1024
+)
+
+// serialLine carries a byte stream over a noisy byte pipe in checked
+// frames, so the reads resync on the next frame after garbage, like
+// the boot messages of a microcontroller or line noise.
+type serialLine struct {
+	rwc     io.ReadWriteCloser
+	r       *bufio.Reader
+	pending []byte // data of the last frame not read yet
+}
+
+// NewSerialLine returns a byte stream over rw, like a serial line,
+// skipping the garbage between the frames and the corrupted ones, which
+// the secure connection then fails to decrypt, since nothing is
+// retransmitted. Both ends must use it.
+func NewSerialLine(rw io.ReadWriteCloser) io.ReadWriteCloser {
+	return &serialLine{
+		rwc: rw,
+		r:   bufio.NewReaderSize(rw, serialHeader+maxSerialFrame+crc32.Size),
+	}
+}
+
+func (l *serialLine) Read(p []byte) (int, error) {
+	for len(l.pending) == 0 {
+		data, err := l.readFrame()
+		if err != nil {
+			return 0, err
+		}
+		l.pending = data
+	}
+	n := copy(p, l.pending)
+	l.pending = l.pending[n:]
+	return n, nil
+}
+
+// readFrame returns the data of the next valid frame, dropping the
+// bytes before it one at a time until one is found.
+func (l *serialLine) readFrame() ([]byte, error) {
+	for {
+		header, err := l.r.Peek(serialHeader)
+		if err != nil {
+			return nil, err
+		}
+		if string(header[:len(serialMarker)]) != serialMarker {
+			l.r.Discard(1)
+			continue
+		}
+		size := binary.BigEndian.Uint16(header[len(serialMarker):])
+		if size > maxSerialFrame || ^size != binary.BigEndian.Uint16(header[len(serialMarker)+2:]) {
+			l.r.Discard(1)
+			continue
+		}
+		end := serialHeader + int(size)
+		frame, err := l.r.Peek(end + crc32.Size)
+		if err != nil {
+			return nil, err
+		}
+		if crc32.ChecksumIEEE(frame[len(serialMarker):end]) != binary.BigEndian.Uint32(frame[end:]) {
+			l.r.Discard(1)
+			continue
+		}
+		data := append([]byte(nil), frame[serialHeader:end]...)
+		l.r.Discard(len(frame))
+		return data, nil
+	}
+}
+
+func (l *serialLine) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		size := min(len(p), maxSerialFrame)
+		frame := make([]byte, 0, serialHeader+size+crc32.Size)
+		frame = append(frame, serialMarker...)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(size))
+		frame = binary.BigEndian.AppendUint16(frame, ^uint16(size))
+		frame = append(frame, p[:size]...)
+		frame = binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(frame[len(serialMarker):]))
+		if _, err := l.rwc.Write(frame); err != nil {
+			return written, err
+		}
+		written += size
+		p = p[size:]
+	}
+	return written, nil
+}
+
+func (l *serialLine) Close() error {
+	return l.rwc.Close()
+}
+
+// OpenSerial opens the serial port, like /dev/ttyUSB0 or COM3, at baud
+// bits per second, 8N1, and returns the line over it, see NewSerialLine.
+func OpenSerial(port string, baud int) (io.ReadWriteCloser, error) {
+	p, err := serial.Open(port, &serial.Mode{BaudRate: baud})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", port, err)
+	}
+	return NewSerialLine(p), nil
+}
+
+// DialSerial opens the serial port, see OpenSerial, and performs the
+// client side of the handshake over it. The server is verified under
+// the name of the port.
+func DialSerial(port string, baud int, cfg *Config) (*Conn, error) {
+	line, err := OpenSerial(port, baud)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newClientConn(context.Background(), rwcConnOf(line, rwcAddr{"serial", port}), port, cfg)
+	if err != nil {
+		line.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// AcceptSerial opens the serial port, see OpenSerial, and performs the
+// server side of the handshake over it, waiting for the peer to dial.
+func AcceptSerial(port string, baud int, cfg *Config) (*Conn, error) {
+	line, err := OpenSerial(port, baud)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newServerConn(context.Background(), rwcConnOf(line, rwcAddr{"serial", port}), cfg)
+	if err != nil {
+		line.Close()
+		return nil, err
+	}
+	return c, nil
+}
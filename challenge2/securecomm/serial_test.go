@@ -0,0 +1,67 @@
+package securecomm
+
+import (
+	"io"
+	"testing"
+)
+
+func TestSerialLine(t *testing.T) {
+	r1, w1 := io.Pipe()
+	r2, w2 := io.Pipe()
+	a, b := pipeEnd{r1, w2}, pipeEnd{r2, w1}
+	la, lb := NewSerialLine(a), NewSerialLine(b)
+
+	go func() {
+		a.Write([]byte("boot messages\r\n\x7eGC"))
+		la.Write([]byte("hello"))
+		// A frame corrupted on the way
+		a.Write([]byte("\x7eGC\x00\x03\xff\xfcabc\x00\x00\x00\x00"))
+		la.Write([]byte(" world"))
+	}()
+	buf := make([]byte, len("hello world"))
+	if _, err := io.ReadFull(lb, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello world" {
+		t.Fatalf("Unexpected result. Got %q", buf)
+	}
+	la.Close()
+	lb.Close()
+}
+
+func TestSerialHandshake(t *testing.T) {
+	r1, w1 := io.Pipe()
+	r2, w2 := io.Pipe()
+	c, s := pipeEnd{r1, w2}, pipeEnd{r2, w1}
+
+	accepted := make(chan *Conn, 1)
+	go func() {
+		// The peer was still booting
+		s.Write([]byte("\x00\xffrebooting..."))
+		conn, err := ServerConn(NewSerialLine(s), nil)
+		if err != nil {
+			t.Error(err)
+			s.Close()
+		}
+		accepted <- conn
+	}()
+	client, err := ClientConn(NewSerialLine(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server := <-accepted
+	if server == nil {
+		t.FailNow()
+	}
+	defer server.Close()
+	go io.Copy(server, server)
+
+	msg := make([]byte, 3000)
+	if _, err := client.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(client, msg); err != nil {
+		t.Fatalf("Unexpected result. Got %v", err)
+	}
+}
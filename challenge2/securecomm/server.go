@@ -0,0 +1,457 @@
+package securecomm
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by Server.Serve after a call to Shutdown
+// or Close.
+var ErrServerClosed = errors.New("server closed")
+
+// Errors logged when rejecting a connection
+var (
+	errTooManyConns      = errors.New("too many connections")
+	errTooManyHandshakes = errors.New("too many handshakes in progress")
+	errRateLimited       = errors.New("connection rate exceeded")
+	errAddrDenied        = errors.New("address denied")
+	errAddrBanned        = errors.New("address banned")
+)
+
+// Server serves secure connections with its Handler. Unlike
+// ServeConfig, it keeps track of its listeners and connections so it
+// can be shut down.
+type Server struct {
+	// Config configures the handshake of the connections.
+	Config *Config
+
+	// Handler serves the connections, EchoHandler if nil.
+	Handler Handler
+
+	// MaxConns limits the number of concurrent connections and
+	// MaxHandshakes the number of those still in the handshake.
+	// Zero means no limit.
+	MaxConns      int
+	MaxHandshakes int
+
+	// ConnRate limits the number of new connections per second from
+	// each IP address, allowing bursts of up to ConnBurst connections.
+	// IPv6 addresses count by /64. Zero means no limit.
+	ConnRate  float64
+	ConnBurst int
+
+	// BanThreshold, if not zero, bans the IP addresses failing that
+	// many handshakes or frame authentications within BanWindow, 1
+	// minute if zero, rejecting their connections for BanDuration, 10
+	// minutes if zero. IPv6 addresses are banned by /64.
+	BanThreshold int
+	BanWindow    time.Duration
+	BanDuration  time.Duration
+
+	// PuzzleRate, if not zero, makes the clients solve a proof-of-work
+	// puzzle of PuzzleDifficulty bits, 16 if zero and at most 24, before
+	// the handshake once more than PuzzleRate connections per second are
+	// handled, sparing the CPU of the server during floods.
+	PuzzleRate       float64
+	PuzzleDifficulty int
+
+	// Filter, if not nil, accepts or rejects the clients by their
+	// IP address.
+	Filter *IPFilter
+
+	// IdleTimeout, if not zero, fails the reads of the handler once
+	// the client sent no data for that long. Heartbeats don't keep a
+	// connection active.
+	IdleTimeout time.Duration
+
+	// Collector, if not nil, records the metrics of the server.
+	Collector *Collector
+
+	// Stats, if not nil, records the statistics of the server with
+	// expvar.
+	Stats *Stats
+
+	// Audit, if not nil, records the connections of the server.
+	Audit *AuditLog
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+
+	handshakes int
+	limiter    *rateLimiter
+	bans       *banList
+	load       *rateLimiter // of the connections, for the puzzles
+
+	identity   ed25519.PrivateKey // set by RotateIdentity
+	endorsedBy []byte             // endorsement of identity, until graceEnd
+	graceEnd   time.Time
+}
+
+// Serve accepts connections on l, handling each one in its own
+// goroutine. It always returns a non-nil error, ErrServerClosed once
+// the server is shut down.
+func (s *Server) Serve(l net.Listener) error {
+	if !s.track(l, nil) {
+		return ErrServerClosed
+	}
+	defer s.untrack(l, nil)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		cfg := s.config()
+		if err := cfg.tcp().apply(conn); err != nil {
+			cfg.logger().Warn("setting the TCP options failed", "peer", conn.RemoteAddr().String(), "err", err)
+		}
+		if err := s.admit(conn); err != nil {
+			s.Collector.rejected()
+			s.Stats.rejected()
+			cfg.logger().Warn("connection rejected", "peer", conn.RemoteAddr().String(), "err", err)
+			conn.Close()
+			continue
+		}
+		if !s.track(nil, conn) {
+			s.mu.Lock()
+			s.handshakes--
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.untrack(nil, conn)
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+// handle performs the handshake then runs the handler.
+func (s *Server) handle(c net.Conn) {
+	cfg, idle := s.handshakeConfig()
+	logger := cfg.logger()
+	id, peer := nextConnID(), c.RemoteAddr().String()
+
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	start := time.Now()
+	conn, err := newServerConn(ctx, c, cfg)
+	cancel()
+	s.mu.Lock()
+	s.handshakes--
+	s.mu.Unlock()
+	s.Collector.handshakeDone(time.Since(start), err)
+	s.Stats.handshakeDone(time.Since(start), err)
+	if err != nil {
+		logger.Warn("handshake failed", "conn", id, "peer", peer, "err", err)
+		s.Audit.record(AuditEvent{Event: auditHandshakeFailed, Conn: id, Peer: peer, Reason: err.Error()})
+		s.failed(peer, logger)
+		return
+	}
+	s.Collector.connOpened()
+	s.Stats.connOpened()
+	defer s.Collector.connClosed()
+	defer s.Stats.connClosed()
+	fingerprint := Fingerprint(conn.PeerIdentity())
+	logger.Info("connection established", "conn", id, "peer", peer,
+		"identity", fingerprint, "version", conn.Version(), "suite", conn.Suite().String())
+	s.Audit.record(AuditEvent{
+		Event:       auditConnect,
+		Conn:        id,
+		Peer:        peer,
+		Fingerprint: fingerprint,
+		Version:     conn.Version(),
+		Suite:       conn.Suite().String(),
+	})
+
+	h := s.Handler
+	if h == nil {
+		h = EchoHandler
+	}
+	sc := &serverConn{conn: conn, m: s.Collector, stats: s.Stats, idle: idle, failed: func() { s.failed(peer, logger) }}
+	established := time.Now()
+	err = h.ServeConn(sc, peerOf(conn))
+	n := sc.sent.Load()
+	reason := "client closed"
+	switch {
+	case errors.Is(err, errIdle):
+		reason = "idle timeout"
+		logger.Info("connection closed", "conn", id, "peer", peer, "bytes", n, "reason", reason)
+	case err != nil:
+		reason = err.Error()
+		logger.Error("connection failed", "conn", id, "peer", peer, "bytes", n, "err", err)
+	default:
+		logger.Info("connection closed", "conn", id, "peer", peer, "bytes", n, "reason", reason)
+	}
+	s.Audit.record(AuditEvent{
+		Event:       auditDisconnect,
+		Conn:        id,
+		Peer:        peer,
+		Fingerprint: fingerprint,
+		Sent:        n,
+		Received:    sc.received.Load(),
+		Duration:    time.Since(established).Seconds(),
+		Reason:      reason,
+	})
+}
+
+// RotateIdentity replaces the identity of the server, without
+// interrupting the active connections. During the grace period, the new
+// identity is presented endorsed by the previous one, so clients that
+// trust the previous one can trust and record the new one.
+func (s *Server) RotateIdentity(identity ed25519.PrivateKey, grace time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.identity
+	if previous == nil && s.Config != nil {
+		previous = s.Config.Identity
+	}
+	s.identity = identity
+	s.endorsedBy = nil
+	if previous != nil && grace > 0 {
+		s.endorsedBy = endorse(previous, identity.Public().(ed25519.PublicKey))
+		s.graceEnd = time.Now().Add(grace)
+	}
+}
+
+// failed records a failed handshake or frame authentication of the
+// client at addr, banning its IP address past the threshold.
+func (s *Server) failed(addr string, logger Logger) {
+	s.mu.Lock()
+	bans := s.bans
+	s.mu.Unlock()
+	key := addrKey(hostOf(addr))
+	if bans == nil || !bans.failed(key, time.Now()) {
+		return
+	}
+	logger.Warn("address banned", "peer", key, "duration", bans.duration.String())
+	s.Collector.banned()
+	s.Stats.banned()
+}
+
+// Reload applies the Config, the limits, the filter and the idle timeout
+// of update to the new connections, the active ones keep theirs. When the
+// identity of the config changes, the new one is presented endorsed by the
+// previous one during grace, like with RotateIdentity.
+func (s *Server) Reload(update *Server, grace time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.identity
+	if previous == nil && s.Config != nil {
+		previous = s.Config.Identity
+	}
+	s.Config = update.Config
+	s.MaxConns, s.MaxHandshakes = update.MaxConns, update.MaxHandshakes
+	if s.ConnRate != update.ConnRate || s.ConnBurst != update.ConnBurst {
+		s.ConnRate, s.ConnBurst = update.ConnRate, update.ConnBurst
+		s.limiter = nil
+	}
+	if s.BanThreshold != update.BanThreshold || s.BanWindow != update.BanWindow || s.BanDuration != update.BanDuration {
+		s.BanThreshold, s.BanWindow, s.BanDuration = update.BanThreshold, update.BanWindow, update.BanDuration
+		s.bans = nil
+	}
+	if s.PuzzleRate != update.PuzzleRate {
+		s.PuzzleRate = update.PuzzleRate
+		s.load = nil
+	}
+	s.PuzzleDifficulty = update.PuzzleDifficulty
+	s.Filter = update.Filter
+	s.IdleTimeout = update.IdleTimeout
+
+	var identity ed25519.PrivateKey
+	if s.Config != nil {
+		identity = s.Config.Identity
+	}
+	switch {
+	case previous != nil && identity != nil && !previous.Equal(identity) && grace > 0:
+		s.identity = identity
+		s.endorsedBy = endorse(previous, identity.Public().(ed25519.PublicKey))
+		s.graceEnd = time.Now().Add(grace)
+	case s.identity != nil && s.identity.Equal(identity):
+		// Still presented endorsed by the previous one, if in grace
+	default:
+		s.identity, s.endorsedBy = nil, nil
+	}
+}
+
+// config returns the config of the server, which Reload replaces.
+func (s *Server) config() *Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Config
+}
+
+// handshakeConfig returns the config of the handshakes, with the
+// identity set by RotateIdentity if any and the puzzle if under load,
+// and the idle timeout.
+func (s *Server) handshakeConfig() (*Config, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	puzzle := s.puzzle(time.Now())
+	if s.identity == nil && puzzle == 0 {
+		return s.Config, s.IdleTimeout
+	}
+	var cfg Config
+	if s.Config != nil {
+		cfg = *s.Config
+	}
+	if s.identity != nil {
+		cfg.Identity = s.identity
+		if time.Now().Before(s.graceEnd) {
+			cfg.endorsedBy = s.endorsedBy
+		}
+	}
+	cfg.puzzle = puzzle
+	return &cfg, s.IdleTimeout
+}
+
+// puzzle returns the difficulty of the puzzle of a new connection at
+// now, zero below PuzzleRate. s.mu must be held.
+func (s *Server) puzzle(now time.Time) int {
+	if s.PuzzleRate <= 0 {
+		return 0
+	}
+	if s.load == nil {
+		s.load = newRateLimiter(s.PuzzleRate, int(math.Ceil(s.PuzzleRate)))
+	}
+	if s.load.allow("", now) {
+		return 0
+	}
+	if s.PuzzleDifficulty > 0 {
+		return min(s.PuzzleDifficulty, maxPuzzleDifficulty)
+	}
+	return defaultPuzzleDifficulty
+}
+
+// admit checks the connection limits before tracking a new connection,
+// counting it as an ongoing handshake.
+func (s *Server) admit(c net.Conn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.Filter.allowedAddr(c.RemoteAddr()) {
+		return errAddrDenied
+	}
+	if s.BanThreshold > 0 {
+		if s.bans == nil {
+			s.bans = newBanList(s.BanThreshold, s.BanWindow, s.BanDuration)
+		}
+		if s.bans.banned(addrKey(hostOf(c.RemoteAddr().String())), time.Now()) {
+			return errAddrBanned
+		}
+	}
+	if s.MaxConns > 0 && len(s.conns) >= s.MaxConns {
+		return errTooManyConns
+	}
+	if s.MaxHandshakes > 0 && s.handshakes >= s.MaxHandshakes {
+		return errTooManyHandshakes
+	}
+	if s.ConnRate > 0 {
+		if s.limiter == nil {
+			s.limiter = newRateLimiter(s.ConnRate, s.ConnBurst)
+		}
+		if !s.limiter.allow(addrKey(hostOf(c.RemoteAddr().String())), time.Now()) {
+			return errRateLimited
+		}
+	}
+	s.handshakes++
+	return nil
+}
+
+// Shutdown stops accepting connections and waits for the active ones to
+// end. Once ctx is done, the remaining connections are closed and the
+// context error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closeListeners()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.closeConns()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Close immediately closes the listeners and the active connections.
+func (s *Server) Close() error {
+	s.closeListeners()
+	s.closeConns()
+	s.wg.Wait()
+	return nil
+}
+
+// track registers a listener or a connection, unless the server is
+// closed.
+func (s *Server) track(l net.Listener, c net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if l != nil {
+		if s.listeners == nil {
+			s.listeners = make(map[net.Listener]struct{})
+		}
+		s.listeners[l] = struct{}{}
+	}
+	if c != nil {
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]struct{})
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+	}
+	return true
+}
+
+func (s *Server) untrack(l net.Listener, c net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l != nil {
+		delete(s.listeners, l)
+	}
+	if c != nil {
+		delete(s.conns, c)
+		s.wg.Done()
+	}
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *Server) closeListeners() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+}
+
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
@@ -0,0 +1,313 @@
+package securecomm
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startServer runs s on a random local port.
+func startServer(t *testing.T, s *Server) (string, chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(l)
+	}()
+	return l.Addr().String(), served
+}
+
+func TestServerShutdown(t *testing.T) {
+	s := &Server{}
+	addr, served := startServer(t, s)
+
+	conn, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+
+	// The active connection keeps working until the client is done
+	if _, err := conn.Write([]byte("hello world\n")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len("hello world\n"))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Fatalf("Unexpected result. Expected %v, got %v", ErrServerClosed, err)
+	}
+	if _, err := Dial(addr); err == nil {
+		t.Fatal("Unexpected result. The server still accepts connections")
+	}
+}
+
+func TestServerShutdownDeadline(t *testing.T) {
+	s := &Server{}
+	addr, served := startServer(t, s)
+
+	conn, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Unexpected result. Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Fatalf("Unexpected result. Expected %v, got %v", ErrServerClosed, err)
+	}
+
+	// The connection was closed by the server
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Unexpected result. The connection is still open")
+	}
+}
+
+func TestServerMaxConns(t *testing.T) {
+	s := &Server{MaxConns: 1}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	conn, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if c, err := Dial(addr); err == nil {
+		c.Close()
+		t.Fatal("Unexpected result. The server accepted too many connections")
+	}
+}
+
+// closingListener closes the server once it accepts a connection.
+type closingListener struct {
+	net.Listener
+	s *Server
+}
+
+func (l closingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	l.s.Close()
+	return c, err
+}
+
+func TestServerClosedWhileAccepting(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if c, err := net.Dial("tcp", l.Addr().String()); err == nil {
+			c.Close()
+		}
+	}()
+
+	s := &Server{MaxHandshakes: 1}
+	if err := s.Serve(closingListener{l, s}); err != ErrServerClosed {
+		t.Fatalf("Unexpected result. Expected %v, got %v", ErrServerClosed, err)
+	}
+	// The connection admitted but never tracked is no longer counted
+	if s.handshakes != 0 {
+		t.Fatalf("Unexpected result. Got %d handshakes in progress", s.handshakes)
+	}
+}
+
+func TestServerConnRate(t *testing.T) {
+	s := &Server{ConnRate: 0.001, ConnBurst: 1}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	conn, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if c, err := Dial(addr); err == nil {
+		c.Close()
+		t.Fatal("Unexpected result. The server accepted connections too fast")
+	}
+}
+
+func TestServerLogger(t *testing.T) {
+	var buf bytes.Buffer
+	s := &Server{Config: &Config{Logger: slog.New(slog.NewTextHandler(&buf, nil))}}
+	addr, _ := startServer(t, s)
+
+	conn, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello world\n"))
+	io.ReadFull(conn, make([]byte, len("hello world\n")))
+	conn.Close()
+	s.Shutdown(context.Background())
+
+	for _, expected := range []string{"msg=\"connection established\"", "msg=\"connection closed\"", "conn=", "peer=127.0.0.1:", "bytes=12"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Fatalf("Unexpected result. %q not found in the logs:\n%s", expected, buf.String())
+		}
+	}
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	var buf bytes.Buffer
+	s := &Server{
+		Config:      &Config{Logger: slog.New(slog.NewTextHandler(&buf, nil))},
+		IdleTimeout: 50 * time.Millisecond,
+	}
+	addr, _ := startServer(t, s)
+
+	conn, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The server closes the idle connection
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Unexpected result. The idle connection is still open")
+	}
+	s.Shutdown(context.Background())
+	if !strings.Contains(buf.String(), "reason=\"idle timeout\"") {
+		t.Fatalf("Unexpected result. The close reason was not logged:\n%s", buf.String())
+	}
+}
+
+func TestServerHandler(t *testing.T) {
+	_, serverID, _ := ed25519.GenerateKey(rand.Reader)
+	_, clientID, _ := ed25519.GenerateKey(rand.Reader)
+	peers := make(chan Peer, 1)
+	s := &Server{
+		Config: &Config{Identity: serverID},
+		Handler: HandlerFunc(func(conn io.ReadWriteCloser, peer Peer) error {
+			peers <- peer
+			_, err := io.WriteString(conn, "hello "+Fingerprint(peer.Identity))
+			return err
+		}),
+	}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	conn, err := DialConfig(addr, &Config{Identity: clientID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "hello " + Fingerprint(clientID.Public().(ed25519.PublicKey))
+	if string(got) != expected {
+		t.Fatalf("Unexpected result.\nGot:\t\t%s\nExpected:\t%s\n", got, expected)
+	}
+	if peer := <-peers; peer.Version != maxVersion || peer.Addr == nil {
+		t.Fatalf("Unexpected result. Incomplete peer %+v", peer)
+	}
+}
+
+func TestServerRotateIdentity(t *testing.T) {
+	_, first, _ := ed25519.GenerateKey(rand.Reader)
+	_, second, _ := ed25519.GenerateKey(rand.Reader)
+	_, third, _ := ed25519.GenerateKey(rand.Reader)
+	s := &Server{Config: &Config{Identity: first}}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	kh := NewKnownHosts(filepath.Join(t.TempDir(), "known_hosts"))
+	cfg := &Config{VerifyPeer: kh.Verify, VerifyRotation: kh.VerifyRotation}
+	dial := func() error {
+		conn, err := DialConfig(addr, cfg)
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	if err := dial(); err != nil {
+		t.Fatal(err)
+	}
+	// The previous identity endorses the new one
+	s.RotateIdentity(second, time.Minute)
+	if err := dial(); err != nil {
+		t.Fatalf("Unexpected result. The endorsed identity was rejected: %v", err)
+	}
+	if err := kh.Verify(addr, second.Public().(ed25519.PublicKey)); err != nil {
+		t.Fatalf("Unexpected result. The new identity was not recorded: %v", err)
+	}
+	// Without grace period, the change is not endorsed
+	s.RotateIdentity(third, 0)
+	if err := dial(); !errors.Is(err, ErrHostKeyChanged) {
+		t.Fatalf("Unexpected result. Expected %v, got %v", ErrHostKeyChanged, err)
+	}
+}
+
+func TestServerReload(t *testing.T) {
+	_, first, _ := ed25519.GenerateKey(rand.Reader)
+	_, second, _ := ed25519.GenerateKey(rand.Reader)
+	s := &Server{Config: &Config{Identity: first}}
+	addr, _ := startServer(t, s)
+	defer s.Close()
+
+	kh := NewKnownHosts(filepath.Join(t.TempDir(), "known_hosts"))
+	cfg := &Config{VerifyPeer: kh.Verify, VerifyRotation: kh.VerifyRotation}
+	active, err := DialConfig(addr, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer active.Close()
+
+	// The new connections get the new identity, endorsed by the previous one,
+	// and the new limits
+	s.Reload(&Server{Config: &Config{Identity: second}, MaxConns: 2}, time.Minute)
+	conn, err := DialConfig(addr, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected result. The endorsed identity was rejected: %v", err)
+	}
+	if got := conn.(*Conn).PeerIdentity(); !got.Equal(second.Public()) {
+		t.Fatal("Unexpected result. The new identity was not presented")
+	}
+	if _, err := DialConfig(addr, cfg); err == nil {
+		t.Fatal("Unexpected result. The new connection limit was not applied")
+	}
+	conn.Close()
+
+	// The active connection keeps working
+	if _, err := active.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(active, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("got %q, %v", buf, err)
+	}
+	if got := active.(*Conn).PeerIdentity(); !got.Equal(first.Public()) {
+		t.Fatal("Unexpected result. The active connection changed identity")
+	}
+}
@@ -0,0 +1,46 @@
+package securecomm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// lookupSRV resolves the SRV records of a service name, sorted by
+// priority and randomized by weight within a priority.
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+// IsServiceName reports whether addr is a service name to resolve with
+// SRV records, like _secure._tcp.example.com, rather than a host:port.
+func IsServiceName(addr string) bool {
+	return strings.HasPrefix(addr, "_") && strings.Contains(addr, "._tcp.") && !strings.Contains(addr, ":")
+}
+
+// dialService dials the targets of the SRV records of name in turn,
+// failing over to the next one until a connection succeeds. Each target
+// is verified as its own host:port.
+func dialService(ctx context.Context, name string, cfg *Config) (io.ReadWriteCloser, error) {
+	_, records, err := lookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", name, err)
+	}
+	// A single "." target means the service is not available
+	if len(records) == 0 || (len(records) == 1 && records[0].Target == ".") {
+		return nil, fmt.Errorf("no target for %s", name)
+	}
+	for _, srv := range records {
+		target := net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+		conn, dialErr := DialConfigContext(ctx, target, cfg)
+		if dialErr == nil {
+			return conn, nil
+		}
+		err = dialErr
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("no target of %s reachable, last error: %w", name, err)
+}
@@ -0,0 +1,76 @@
+package securecomm
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestIsServiceName(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"_secure._tcp.example.com": true,
+		"example.com:8080":         false,
+		"_secure._udp.example.com": false,
+		"localhost:_secure._tcp.":  false,
+	} {
+		if got := IsServiceName(addr); got != want {
+			t.Errorf("IsServiceName(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestDialService(t *testing.T) {
+	addr, _ := startServer(t, &Server{})
+	_, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, refused, _ := net.SplitHostPort(closed.Addr().String())
+	closed.Close()
+	r, _ := strconv.Atoi(refused)
+
+	records := []*net.SRV{
+		{Target: "127.0.0.1.", Port: uint16(r), Priority: 10},
+		{Target: "127.0.0.1.", Port: uint16(p), Priority: 20},
+	}
+	defer func(lookup func(context.Context, string, string, string) (string, []*net.SRV, error)) {
+		lookupSRV = lookup
+	}(lookupSRV)
+	lookupSRV = func(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		if name != "_secure._tcp.example.com" {
+			return "", nil, errors.New("no such host")
+		}
+		return name, records, nil
+	}
+
+	// The first target refuses the connection, the client fails over
+	conn, err := Dial("_secure._tcp.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := conn.Read(buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("got %q, %v", buf, err)
+	}
+	conn.Close()
+
+	records = records[:1]
+	if _, err := Dial("_secure._tcp.example.com"); err == nil {
+		t.Fatal("dialing an unreachable service succeeded")
+	}
+	records = []*net.SRV{{Target: "."}}
+	if _, err := Dial("_secure._tcp.example.com"); err == nil {
+		t.Fatal("dialing an unavailable service succeeded")
+	}
+	if _, err := Dial("_secure._tcp.example.org"); err == nil {
+		t.Fatal("dialing an unknown service succeeded")
+	}
+}
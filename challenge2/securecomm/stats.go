@@ -0,0 +1,124 @@
+package securecomm
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Stats records the statistics of a Server with expvar, as an
+// alternative to the Prometheus Collector needing no dependency. It
+// implements expvar.Var, to be published with expvar.Publish. Its
+// methods do nothing on a nil Stats.
+type Stats struct {
+	vars expvar.Map
+}
+
+// Keys of the statistics
+const (
+	statActiveConns       = "active_connections"
+	statConns             = "connections"
+	statRejectedConns     = "rejected_connections"
+	statHandshakes        = "handshakes"
+	statHandshakeFailures = "handshake_failures"
+	statHandshakeSeconds  = "handshake_seconds"
+	statBytesIn           = "received_bytes"
+	statBytesOut          = "sent_bytes"
+	statDecryptErrors     = "decrypt_errors"
+	statBans              = "bans"
+)
+
+// NewStats returns new Stats, all at zero.
+func NewStats() *Stats {
+	s := &Stats{}
+	s.vars.Init()
+	for _, key := range []string{
+		statActiveConns, statConns, statRejectedConns, statHandshakes, statHandshakeFailures,
+		statBytesIn, statBytesOut, statDecryptErrors, statBans,
+	} {
+		s.vars.Set(key, new(expvar.Int))
+	}
+	s.vars.Set(statHandshakeSeconds, new(expvar.Float))
+	return s
+}
+
+// String implements expvar.Var, returning the statistics as a JSON
+// object.
+func (s *Stats) String() string {
+	return s.vars.String()
+}
+
+// ServeStats serves the published expvar variables, with the runtime
+// statistics, on addr at /debug/vars. Only loopback addresses are
+// accepted, as the variables include the command line.
+func ServeStats(addr string) error {
+	if err := checkLoopback(addr); err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	return http.ListenAndServe(addr, mux)
+}
+
+// checkLoopback fails if addr is not a loopback address, for the
+// endpoints exposing the internals of the process.
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("not a loopback address: %s", addr)
+	}
+	return nil
+}
+
+func (s *Stats) add(key string, delta int64) {
+	if s != nil {
+		s.vars.Add(key, delta)
+	}
+}
+
+func (s *Stats) rejected() {
+	s.add(statRejectedConns, 1)
+}
+
+func (s *Stats) handshakeDone(d time.Duration, err error) {
+	if s == nil {
+		return
+	}
+	s.vars.Add(statHandshakes, 1)
+	s.vars.AddFloat(statHandshakeSeconds, d.Seconds())
+	if err != nil {
+		s.vars.Add(statHandshakeFailures, 1)
+	}
+}
+
+func (s *Stats) connOpened() {
+	s.add(statActiveConns, 1)
+	s.add(statConns, 1)
+}
+
+func (s *Stats) connClosed() {
+	s.add(statActiveConns, -1)
+}
+
+func (s *Stats) received(n int) {
+	s.add(statBytesIn, int64(n))
+}
+
+func (s *Stats) sent(n int) {
+	s.add(statBytesOut, int64(n))
+}
+
+func (s *Stats) readFailed(err error) {
+	if isAuthFailure(err) {
+		s.add(statDecryptErrors, 1)
+	}
+}
+
+func (s *Stats) banned() {
+	s.add(statBans, 1)
+}
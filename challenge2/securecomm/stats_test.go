@@ -0,0 +1,61 @@
+package securecomm
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	s := &Server{Config: &Config{PSK: []byte("secret")}, Stats: NewStats()}
+	addr, _ := startServer(t, s)
+
+	conn, err := DialConfig(addr, &Config{PSK: []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if _, err := DialConfig(addr, &Config{PSK: []byte("wrong")}); err == nil {
+		t.Fatal("Unexpected result. The handshake with the wrong PSK succeeded")
+	}
+	s.Close()
+
+	var stats map[string]float64
+	if err := json.Unmarshal([]byte(s.Stats.String()), &stats); err != nil {
+		t.Fatal(err)
+	}
+	tData := []struct {
+		key      string
+		expected float64
+	}{
+		{statActiveConns, 0},
+		{statConns, 1},
+		{statHandshakes, 2},
+		{statHandshakeFailures, 1},
+		{statBytesIn, 5},
+		{statBytesOut, 5},
+		{statDecryptErrors, 0},
+	}
+	for _, exp := range tData {
+		if got := stats[exp.key]; got != exp.expected {
+			t.Fatalf("Unexpected %s. Got %v, expected %v", exp.key, got, exp.expected)
+		}
+	}
+	if stats[statHandshakeSeconds] <= 0 {
+		t.Fatalf("Unexpected result. Got %v handshake seconds", stats[statHandshakeSeconds])
+	}
+}
+
+func TestServeStatsLoopback(t *testing.T) {
+	for _, addr := range []string{":0", "0.0.0.0:0", "192.0.2.1:0"} {
+		if err := ServeStats(addr); err == nil {
+			t.Fatalf("Unexpected result. Served on %s", addr)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package securecomm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// STUN (RFC 5389) tells a peer its public address, as seen from outside
+// its NAT. Only the Binding request is implemented.
+
+const (
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+	stunMagicCookie     = 0x2112a442
+	stunHeaderSize      = 20
+
+	stunMappedAddress    = 0x0001
+	stunXorMappedAddress = 0x0020
+)
+
+// stunRetry is the delay before sending a lost Binding request again
+const stunRetry = 500 * time.Millisecond
+
+var errSTUNResponse = errors.New("invalid STUN response")
+
+// stunMappedAddr asks the STUN server for the public address of pc,
+// sending the Binding request again until a response arrives or ctx is
+// done.
+func stunMappedAddr(ctx context.Context, pc net.PacketConn, server string) (*net.UDPAddr, error) {
+	serverAddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return nil, err
+	}
+	request := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(request[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(request[4:], stunMagicCookie)
+	if _, err := rand.Read(request[8:]); err != nil {
+		return nil, err
+	}
+	defer pc.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 1500)
+	for ctx.Err() == nil {
+		if _, err := pc.WriteTo(request, serverAddr); err != nil {
+			return nil, err
+		}
+		pc.SetReadDeadline(time.Now().Add(stunRetry))
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !sameAddr(from, serverAddr) {
+				continue
+			}
+			addr, err := parseSTUNResponse(buf[:n], request[8:stunHeaderSize])
+			if err == errSTUNResponse {
+				continue
+			}
+			return addr, err
+		}
+	}
+	return nil, ctx.Err()
+}
+
+// parseSTUNResponse returns the address of a Binding response to the
+// transaction txID.
+func parseSTUNResponse(b, txID []byte) (*net.UDPAddr, error) {
+	if len(b) < stunHeaderSize || binary.BigEndian.Uint16(b) != stunBindingResponse ||
+		binary.BigEndian.Uint32(b[4:]) != stunMagicCookie || string(b[8:stunHeaderSize]) != string(txID) ||
+		int(binary.BigEndian.Uint16(b[2:])) != len(b)-stunHeaderSize {
+		return nil, errSTUNResponse
+	}
+	var mapped *net.UDPAddr
+	attrs := b[stunHeaderSize:]
+	for len(attrs) >= 4 {
+		typ, size := binary.BigEndian.Uint16(attrs), int(binary.BigEndian.Uint16(attrs[2:]))
+		if len(attrs) < 4+size {
+			return nil, errSTUNResponse
+		}
+		value := attrs[4 : 4+size]
+		switch typ {
+		case stunXorMappedAddress:
+			// The port and address are xored with the cookie and the
+			// transaction ID, so NATs rewriting them don't tamper
+			addr, ok := parseSTUNAddress(value, b[4:stunHeaderSize])
+			if !ok {
+				return nil, errSTUNResponse
+			}
+			return addr, nil
+		case stunMappedAddress:
+			addr, ok := parseSTUNAddress(value, make([]byte, 16))
+			if !ok {
+				return nil, errSTUNResponse
+			}
+			mapped = addr
+		}
+		// Attributes are padded to 4 bytes
+		attrs = attrs[min(len(attrs), 4+(size+3)&^3):]
+	}
+	if mapped == nil {
+		return nil, errors.New("STUN response without address")
+	}
+	return mapped, nil
+}
+
+// parseSTUNAddress parses an address attribute xored with mask.
+func parseSTUNAddress(value, mask []byte) (*net.UDPAddr, bool) {
+	if len(value) < 4 {
+		return nil, false
+	}
+	size := map[byte]int{1: net.IPv4len, 2: net.IPv6len}[value[1]]
+	if size == 0 || len(value) != 4+size {
+		return nil, false
+	}
+	port := binary.BigEndian.Uint16(value[2:]) ^ binary.BigEndian.Uint16(mask)
+	ip := make(net.IP, size)
+	for i := range ip {
+		ip[i] = value[4+i] ^ mask[i]
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}, true
+}
+
+// sameAddr reports whether the UDP addresses a and b are the same.
+func sameAddr(a net.Addr, b *net.UDPAddr) bool {
+	ua, ok := a.(*net.UDPAddr)
+	return ok && ua.IP.Equal(b.IP) && ua.Port == b.Port
+}
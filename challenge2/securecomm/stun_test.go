@@ -0,0 +1,102 @@
+package securecomm
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// stunResponse returns the Binding response to request telling addr,
+// xored unless plain.
+func stunResponse(request []byte, addr *net.UDPAddr, plain bool) []byte {
+	ip := addr.IP.To4()
+	family := byte(1)
+	if ip == nil {
+		ip, family = addr.IP.To16(), 2
+	}
+	mask := make([]byte, 16)
+	typ := uint16(stunMappedAddress)
+	if !plain {
+		copy(mask, request[4:stunHeaderSize])
+		typ = stunXorMappedAddress
+	}
+	value := []byte{0, family, 0, 0}
+	binary.BigEndian.PutUint16(value[2:], uint16(addr.Port)^binary.BigEndian.Uint16(mask))
+	for i := range ip {
+		value = append(value, ip[i]^mask[i])
+	}
+
+	b := make([]byte, stunHeaderSize, stunHeaderSize+4+len(value))
+	binary.BigEndian.PutUint16(b, stunBindingResponse)
+	binary.BigEndian.PutUint16(b[2:], uint16(4+len(value)))
+	copy(b[4:], request[4:stunHeaderSize])
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+	return append(b, value...)
+}
+
+// startSTUN starts a STUN server answering with the address of the
+// clients, xored unless plain.
+func startSTUN(t *testing.T, plain bool) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n == stunHeaderSize {
+				pc.WriteTo(stunResponse(buf[:n], addr.(*net.UDPAddr), plain), addr)
+			}
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestSTUNMappedAddr(t *testing.T) {
+	for _, plain := range []bool{false, true} {
+		server := startSTUN(t, plain)
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pc.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		addr, err := stunMappedAddr(ctx, pc, server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr.String() != pc.LocalAddr().String() {
+			t.Errorf("got %s, want %s", addr, pc.LocalAddr())
+		}
+	}
+}
+
+func TestParseSTUNResponse(t *testing.T) {
+	request := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(request, stunBindingRequest)
+	binary.BigEndian.PutUint32(request[4:], stunMagicCookie)
+	copy(request[8:], "transaction1")
+	addr := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 4000}
+
+	response := stunResponse(request, addr, false)
+	got, err := parseSTUNResponse(response, request[8:])
+	if err != nil || got.String() != addr.String() {
+		t.Fatalf("got %v, %v", got, err)
+	}
+	if _, err := parseSTUNResponse(response, []byte("transaction2")); err != errSTUNResponse {
+		t.Errorf("got %v for another transaction", err)
+	}
+	if _, err := parseSTUNResponse(response[:len(response)-1], request[8:]); err != errSTUNResponse {
+		t.Errorf("got %v for a truncated response", err)
+	}
+}
@@ -0,0 +1,108 @@
+package securecomm
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/sys/cpu"
+)
+
+// Suite identifies the AEAD sealing the frames. It is negotiated
+// during the handshake: the client sends the suites it supports and
+// the server picks one.
+type Suite byte
+
+// Supported suites. Their values are part of the protocol.
+const (
+	// SuiteNaClBox seals frames with XSalsa20-Poly1305, as NaCl box does
+	SuiteNaClBox Suite = iota + 1
+	// SuiteXChaCha20Poly1305 seals frames with XChaCha20-Poly1305
+	SuiteXChaCha20Poly1305
+	// SuiteAES256GCM seals frames with AES-256-GCM, which is the
+	// fastest when the CPU has AES instructions
+	SuiteAES256GCM
+)
+
+var errNoSuite = errors.New("no cipher suite in common")
+
+func (s Suite) String() string {
+	switch s {
+	case SuiteNaClBox:
+		return "NaCl box"
+	case SuiteXChaCha20Poly1305:
+		return "XChaCha20-Poly1305"
+	case SuiteAES256GCM:
+		return "AES-256-GCM"
+	}
+	return fmt.Sprintf("Suite(%d)", byte(s))
+}
+
+// newAEAD returns the AEAD of the suite using the given key.
+func (s Suite) newAEAD(key *[32]byte) (cipher.AEAD, error) {
+	switch s {
+	case SuiteNaClBox:
+		return &secretboxAEAD{key: *key}, nil
+	case SuiteXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key[:])
+	case SuiteAES256GCM:
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	}
+	return nil, fmt.Errorf("unsupported cipher suite %s", s)
+}
+
+// defaultSuites returns the suites in order of preference,
+// favoring AES-GCM when the CPU accelerates it.
+func defaultSuites() []Suite {
+	if cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ || cpu.ARM64.HasAES && cpu.ARM64.HasPMULL {
+		return []Suite{SuiteAES256GCM, SuiteXChaCha20Poly1305, SuiteNaClBox}
+	}
+	return []Suite{SuiteXChaCha20Poly1305, SuiteAES256GCM, SuiteNaClBox}
+}
+
+// selectSuite returns the first of our suites supported by the peer.
+func selectSuite(ours, theirs []Suite) (Suite, error) {
+	for _, s := range ours {
+		for _, t := range theirs {
+			if s == t {
+				return s, nil
+			}
+		}
+	}
+	return 0, errNoSuite
+}
+
+// secretboxAEAD adapts NaCl secretbox to cipher.AEAD. Additional data
+// is not supported.
+type secretboxAEAD struct {
+	key [32]byte
+}
+
+func (a *secretboxAEAD) NonceSize() int { return 24 }
+
+func (a *secretboxAEAD) Overhead() int { return secretbox.Overhead }
+
+func (a *secretboxAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(additionalData) > 0 {
+		panic("secretbox: additional data is not supported")
+	}
+	return secretbox.Seal(dst, plaintext, (*[24]byte)(nonce), &a.key)
+}
+
+func (a *secretboxAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(additionalData) > 0 {
+		return nil, errors.New("secretbox: additional data is not supported")
+	}
+	plaintext, ok := secretbox.Open(dst, ciphertext, (*[24]byte)(nonce), &a.key)
+	if !ok {
+		return nil, errors.New("could not decrypt box")
+	}
+	return plaintext, nil
+}
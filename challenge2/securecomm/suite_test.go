@@ -0,0 +1,62 @@
+package securecomm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSuites(t *testing.T) {
+	key := &[32]byte{'k', 'e', 'y'}
+	msg := bytes.Repeat([]byte("hello world\n"), 10000)
+
+	for _, suite := range []Suite{SuiteNaClBox, SuiteXChaCha20Poly1305, SuiteAES256GCM} {
+		r, w := io.Pipe()
+		secureR := newSecureReader(r, suite, key)
+		secureW := newSecureWriter(w, suite, key)
+		secureW.rekeyBytes = 4096
+
+		go func() {
+			secureW.Write(msg)
+			w.Close()
+		}()
+
+		buf, err := ioutil.ReadAll(secureR)
+		if err != nil {
+			t.Fatalf("%s: %s", suite, err)
+		}
+		if !bytes.Equal(buf, msg) {
+			t.Fatalf("%s: unexpected result: got %d bytes, expected %d", suite, len(buf), len(msg))
+		}
+	}
+}
+
+func TestSuiteNegotiation(t *testing.T) {
+	tData := []struct {
+		name     string
+		client   []Suite
+		server   []Suite
+		expected Suite
+	}{
+		{"server preference", []Suite{SuiteNaClBox, SuiteAES256GCM}, []Suite{SuiteAES256GCM, SuiteNaClBox}, SuiteAES256GCM},
+		{"only common suite", []Suite{SuiteXChaCha20Poly1305, SuiteNaClBox}, []Suite{SuiteAES256GCM, SuiteNaClBox}, SuiteNaClBox},
+		{"nothing in common", []Suite{SuiteXChaCha20Poly1305}, []Suite{SuiteAES256GCM}, 0},
+	}
+
+	for _, exp := range tData {
+		clientHs, serverHs, err := pipeHandshake(&Config{Suites: exp.client}, &Config{Suites: exp.server})
+		if exp.expected == 0 {
+			if err == nil {
+				t.Fatalf("%s: expected the handshake to fail", exp.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected handshake failure: %v", exp.name, err)
+		}
+		if clientHs.suite != exp.expected || serverHs.suite != exp.expected {
+			t.Fatalf("%s: expected %s, got %s and %s", exp.name, exp.expected, clientHs.suite, serverHs.suite)
+		}
+	}
+}
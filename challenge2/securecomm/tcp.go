@@ -0,0 +1,67 @@
+package securecomm
+
+import (
+	"net"
+	"time"
+)
+
+// TCPOptions tunes the TCP connections dialed by clients and accepted by
+// servers. The zero value keeps the defaults of the net package.
+type TCPOptions struct {
+	// KeepAlive is the period of the TCP keep-alive probes, 15 seconds
+	// by default. Negative disables them.
+	KeepAlive time.Duration
+
+	// Nagle enables Nagle's algorithm, which the net package disables.
+	// It saves packets at the expense of the latency of the frames.
+	Nagle bool
+
+	// ReadBuffer and WriteBuffer, if not zero, set the size of the
+	// socket buffers in bytes, usually to fill high-latency links.
+	ReadBuffer  int
+	WriteBuffer int
+}
+
+// apply sets the options on c, if it is a TCP connection.
+func (o *TCPOptions) apply(c net.Conn) error {
+	if o == nil {
+		return nil
+	}
+	// Unwrap the connections whose first bytes were already read
+	for {
+		inner, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		c = inner.NetConn()
+	}
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if o.KeepAlive < 0 {
+		if err := tc.SetKeepAlive(false); err != nil {
+			return err
+		}
+	} else if o.KeepAlive > 0 {
+		if err := tc.SetKeepAlivePeriod(o.KeepAlive); err != nil {
+			return err
+		}
+	}
+	if o.Nagle {
+		if err := tc.SetNoDelay(false); err != nil {
+			return err
+		}
+	}
+	if o.ReadBuffer > 0 {
+		if err := tc.SetReadBuffer(o.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := tc.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
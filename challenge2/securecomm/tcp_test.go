@@ -0,0 +1,59 @@
+//go:build unix
+
+package securecomm
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTCPOptions(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			defer c.Close()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	opts := &TCPOptions{KeepAlive: 30 * time.Second, Nagle: true, ReadBuffer: 1 << 16}
+	// The options reach the wrapped connections too
+	if err := opts.apply(&bufferedConn{Conn: c}); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := c.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var noDelay, rcvbuf int
+	raw.Control(func(fd uintptr) {
+		noDelay, _ = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+		rcvbuf, _ = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	})
+	if noDelay != 0 {
+		t.Fatal("Unexpected result. Nagle's algorithm is still disabled.")
+	}
+	if rcvbuf < 1<<16 {
+		t.Fatalf("Unexpected result. Got a receive buffer of %d bytes", rcvbuf)
+	}
+
+	// Other connections are left alone
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	if err := opts.apply(c1); err != nil {
+		t.Fatal(err)
+	}
+}
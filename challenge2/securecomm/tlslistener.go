@@ -0,0 +1,54 @@
+package securecomm
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// sniffTimeout is how long the listeners accepting TLS wait for the
+// first bytes of a client. Native clients wait for the preamble of the
+// server, TLS clients speak first.
+const sniffTimeout = 200 * time.Millisecond
+
+// tlsRecordHandshake starts the TLS records of the client hello
+const tlsRecordHandshake = 0x16
+
+// NewTLSListener is like NewListener but also accepts TLS clients on the
+// same port, using tlsConfig, so TLS clients can keep connecting while
+// migrating to the secure handshake. Accept returns a *Conn for native
+// clients and a *tls.Conn for TLS ones.
+//
+// As native clients wait for the server to speak first, connections
+// sending nothing for a short while are considered native, delaying
+// their handshake accordingly.
+func NewTLSListener(inner net.Listener, cfg *Config, tlsConfig *tls.Config) net.Listener {
+	l := NewListener(inner, cfg).(*listener)
+	l.tls = tlsConfig
+	return l
+}
+
+// sniffHandshake runs the TLS handshake if the client starts with a TLS
+// record, the secure handshake otherwise.
+func sniffHandshake(ctx context.Context, c net.Conn, cfg *Config, tlsConfig *tls.Config) (net.Conn, error) {
+	br := bufio.NewReader(c)
+	c.SetReadDeadline(time.Now().Add(sniffTimeout))
+	first, err := br.Peek(1)
+	c.SetReadDeadline(time.Time{})
+	if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil, err
+	}
+	conn := &bufferedConn{c, br}
+	if len(first) == 0 || first[0] != tlsRecordHandshake {
+		return newServerConn(ctx, conn, cfg)
+	}
+	tc := tls.Server(conn, tlsConfig)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
@@ -0,0 +1,112 @@
+package securecomm
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// FrameEvent describes a frame sent or received, see Config.Trace.
+type FrameEvent struct {
+	Sent    bool          // false for received frames
+	Type    string        // data, padded, rekey, ping, pong, ratchet...
+	Length  int           // of the sealed message, without the header
+	Seq     uint64        // sequence number in its direction
+	Elapsed time.Duration // since the connection was established
+	Err     error         // if the frame was rejected
+
+	// Payload is only set with Config.TracePlaintext. It is only valid
+	// during the call.
+	Payload []byte
+}
+
+func (e FrameEvent) String() string {
+	dir := "recv"
+	if e.Sent {
+		dir = "send"
+	}
+	s := fmt.Sprintf("%12.6fs %s seq=%d type=%s len=%d", e.Elapsed.Seconds(), dir, e.Seq, e.Type, e.Length)
+	if e.Err != nil {
+		s += fmt.Sprintf(" err=%q", e.Err)
+	}
+	if e.Payload != nil {
+		s += fmt.Sprintf(" payload=%q", e.Payload)
+	}
+	return s
+}
+
+// TraceWriter returns a Config.Trace function writing the events to w,
+// one per line.
+func TraceWriter(w io.Writer) func(FrameEvent) {
+	var mu sync.Mutex
+	return func(e FrameEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintln(w, e)
+	}
+}
+
+// frameTypeName returns the name of a frame type in the traces.
+func frameTypeName(typ byte) string {
+	switch typ {
+	case frameData:
+		return "data"
+	case frameRekey:
+		return "rekey"
+	case framePing:
+		return "ping"
+	case framePong:
+		return "pong"
+	case framePadded:
+		return "padded"
+	case frameRatchet:
+		return "ratchet"
+	case frameRatchetAck:
+		return "ratchet-ack"
+	case frameRatchetSwitch:
+		return "ratchet-switch"
+	}
+	return fmt.Sprintf("unknown(%d)", typ)
+}
+
+// frameTracer reports the frames of one direction of a connection to
+// Config.Trace and to the span of the connection.
+type frameTracer struct {
+	fn        func(FrameEvent) // nil if only span is set
+	span      *connSpan
+	sent      bool
+	plaintext bool
+	start     time.Time
+}
+
+func newFrameTracer(cfg *Config, sent bool, start time.Time, span *connSpan) *frameTracer {
+	if span == nil && (cfg == nil || cfg.Trace == nil) {
+		return nil
+	}
+	t := &frameTracer{span: span, sent: sent, start: start}
+	if cfg != nil && cfg.Trace != nil {
+		t.fn, t.plaintext = cfg.Trace, cfg.TracePlaintext
+	}
+	return t
+}
+
+// frame reports a frame of the given type, "" if it could not be
+// opened.
+func (t *frameTracer) frame(typ string, length int, seq uint64, payload []byte, err error) {
+	if t == nil {
+		return
+	}
+	t.span.frame(t.sent, typ, length, err)
+	if t.fn == nil {
+		return
+	}
+	e := FrameEvent{Sent: t.sent, Type: typ, Length: length, Seq: seq, Elapsed: time.Since(t.start), Err: err}
+	if t.plaintext && err == nil {
+		e.Payload = payload
+		if e.Payload == nil {
+			e.Payload = []byte{}
+		}
+	}
+	t.fn(e)
+}
@@ -0,0 +1,68 @@
+package securecomm
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	for _, plaintext := range []bool{false, true} {
+		var mu sync.Mutex
+		var events []FrameEvent
+		cfg := &Config{
+			Trace: func(e FrameEvent) {
+				mu.Lock()
+				defer mu.Unlock()
+				e.Payload = append([]byte(nil), e.Payload...)
+				events = append(events, e)
+			},
+			TracePlaintext: plaintext,
+		}
+		client, server := tcpConns(t, cfg, nil)
+		if _, err := client.Write([]byte("secret")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(server, make([]byte, 6)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.Write([]byte("reply")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(client, make([]byte, 5)); err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+		server.Close()
+
+		mu.Lock()
+		if len(events) != 2 {
+			t.Fatalf("got %d events, want 2: %v", len(events), events)
+		}
+		sent, recv := events[0], events[1]
+		if !sent.Sent || sent.Type != "data" || sent.Seq != 0 || sent.Length != 6+1+16 {
+			t.Errorf("unexpected sent frame %v", sent)
+		}
+		if recv.Sent || recv.Type != "data" || recv.Seq != 0 || recv.Elapsed < sent.Elapsed {
+			t.Errorf("unexpected received frame %v", recv)
+		}
+		if got := string(sent.Payload) + string(recv.Payload); plaintext && got != "secretreply" || !plaintext && got != "" {
+			t.Errorf("got the payloads %q with plaintext %v", got, plaintext)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestTraceWriter(t *testing.T) {
+	var buf bytes.Buffer
+	trace := TraceWriter(&buf)
+	trace(FrameEvent{Sent: true, Type: "ping", Length: 17, Seq: 3})
+	trace(FrameEvent{Type: "", Length: 40, Seq: 4, Err: errDecrypt})
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "send seq=3 type=ping len=17") ||
+		!strings.Contains(lines[1], "recv seq=4") || !strings.Contains(lines[1], "err=") {
+		t.Fatalf("unexpected trace:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,433 @@
+package securecomm
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A file transfer starts with the sender describing the file:
+//
+//	[uint16 name length][name][uint64 size][sha256 of the content]
+//
+// The receiver answers with the number of bytes it already has, from an
+// interrupted transfer of the same content, and their hash:
+//
+//	[uint64 offset][sha256 of the first offset bytes]
+//
+// The offset is a multiple of transferChunk, dropping the end of the
+// partial file that may have been torn by the interruption. The sender
+// answers with the offset it resumes from, as an uint64: the one of the
+// receiver when the hashes match, 0 otherwise. It then sends the rest
+// of the file, followed by its manifest:
+//
+//	[uint64 size][sha256 of the content][sha256 of each chunk]
+//
+// The receiver answers with a status byte once it checked its copy
+// against the manifest, keeping the chunks that match for the next
+// transfer when it does not.
+
+// transferChunk is the granularity of the offsets of the resumed
+// transfers.
+const transferChunk = 64 << 10
+
+// Transfer statuses
+const (
+	transferOK byte = iota
+	transferBadHash
+)
+
+// ErrBadHash is returned when a file received does not match the
+// manifest of the sender.
+var ErrBadHash = errors.New("file hash mismatch")
+
+// fileInfo is the metadata sent before the content of a file.
+type fileInfo struct {
+	Name string
+	Size int64
+	Hash [sha256.Size]byte
+}
+
+func writeFileInfo(w io.Writer, info fileInfo) error {
+	if len(info.Name) > 255 {
+		return fmt.Errorf("file name too long: %s", info.Name)
+	}
+	buf := binary.BigEndian.AppendUint16(nil, uint16(len(info.Name)))
+	buf = append(buf, info.Name...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(info.Size))
+	buf = append(buf, info.Hash[:]...)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readFileInfo(r io.Reader) (fileInfo, error) {
+	var info fileInfo
+	var nameSize uint16
+	if err := binary.Read(r, binary.BigEndian, &nameSize); err != nil {
+		return info, err
+	}
+	if nameSize > 255 {
+		return info, fmt.Errorf("file name too long: %d bytes", nameSize)
+	}
+	name := make([]byte, nameSize)
+	if _, err := io.ReadFull(r, name); err != nil {
+		return info, err
+	}
+	var size uint64
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return info, err
+	}
+	if size > math.MaxInt64 {
+		return info, fmt.Errorf("invalid file size: %d bytes", size)
+	}
+	if _, err := io.ReadFull(r, info.Hash[:]); err != nil {
+		return info, err
+	}
+	info.Name, info.Size = string(name), int64(size)
+	return info, nil
+}
+
+// manifest describes the content of a file, sent after it so the
+// receiver can tell which chunks were corrupted.
+type manifest struct {
+	size   int64
+	hash   [sha256.Size]byte
+	chunks [][sha256.Size]byte // of each transferChunk bytes, the last one may be shorter
+}
+
+// chunkCount returns the number of chunks of a file of size bytes.
+func chunkCount(size int64) int64 {
+	count := size / transferChunk
+	if size%transferChunk != 0 {
+		count++
+	}
+	return count
+}
+
+// newManifest returns the manifest of the content of f, from its start.
+func newManifest(f *os.File) (manifest, error) {
+	var m manifest
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return m, err
+	}
+	h := sha256.New()
+	for {
+		chunk := sha256.New()
+		n, err := io.CopyN(io.MultiWriter(h, chunk), f, transferChunk)
+		if n > 0 {
+			m.size += n
+			m.chunks = append(m.chunks, [sha256.Size]byte(chunk.Sum(nil)))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return m, err
+		}
+	}
+	copy(m.hash[:], h.Sum(nil))
+	return m, nil
+}
+
+func writeManifest(w io.Writer, m manifest) error {
+	buf := binary.BigEndian.AppendUint64(nil, uint64(m.size))
+	buf = append(buf, m.hash[:]...)
+	for _, chunk := range m.chunks {
+		buf = append(buf, chunk[:]...)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// readManifest reads the manifest of the file described by info.
+func readManifest(r io.Reader, info fileInfo) (manifest, error) {
+	var m manifest
+	var size uint64
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return m, err
+	}
+	if size != uint64(info.Size) {
+		return m, fmt.Errorf("manifest of %d bytes for a file of %d bytes", size, info.Size)
+	}
+	m.size = info.Size
+	if _, err := io.ReadFull(r, m.hash[:]); err != nil {
+		return m, err
+	}
+	if m.hash != info.Hash {
+		return m, errors.New("manifest of another file")
+	}
+	count := chunkCount(m.size)
+	if count < 0 {
+		return m, fmt.Errorf("invalid file size: %d bytes", m.size)
+	}
+	// The chunks are read before being stored, so a forged size can't
+	// allocate more than the data sent
+	for range count {
+		var chunk [sha256.Size]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return m, err
+		}
+		m.chunks = append(m.chunks, chunk)
+	}
+	return m, nil
+}
+
+// verify returns the index of the first chunk of got not matching m,
+// -1 if all of them match.
+func (m manifest) verify(got manifest) int {
+	for i, chunk := range m.chunks {
+		if i >= len(got.chunks) || got.chunks[i] != chunk {
+			return i
+		}
+	}
+	if got.size != m.size || got.hash != m.hash {
+		return len(m.chunks)
+	}
+	return -1
+}
+
+// hashPrefix returns the sha256 of the first n bytes of f, without
+// moving its offset.
+func hashPrefix(f *os.File, n int64) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, n)); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// SendFile sends the file at path over conn, resuming an interrupted
+// transfer. It returns once the receiver verified its copy against the
+// manifest of the file, ErrBadHash if it did not match. The progress is
+// reported to progress, if not nil.
+func SendFile(conn io.ReadWriter, path string, progress io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	m, err := newManifest(f)
+	if err != nil {
+		return err
+	}
+	if m.size != stat.Size() {
+		return fmt.Errorf("%s changed while reading it", path)
+	}
+	info := fileInfo{Name: filepath.Base(path), Size: m.size, Hash: m.hash}
+	if err := writeFileInfo(conn, info); err != nil {
+		return fmt.Errorf("error sending file info: %s", err)
+	}
+
+	var offset uint64
+	if err := binary.Read(conn, binary.BigEndian, &offset); err != nil {
+		return fmt.Errorf("error reading offset: %s", err)
+	}
+	var received [sha256.Size]byte
+	if _, err := io.ReadFull(conn, received[:]); err != nil {
+		return fmt.Errorf("error reading offset: %s", err)
+	}
+	if offset > uint64(info.Size) {
+		return fmt.Errorf("invalid offset %d for %d bytes", offset, info.Size)
+	}
+	prefix, err := hashPrefix(f, int64(offset))
+	if err != nil {
+		return err
+	}
+	if prefix != received {
+		// The partial file of the receiver is corrupted
+		offset = 0
+	}
+	if err := binary.Write(conn, binary.BigEndian, offset); err != nil {
+		return fmt.Errorf("error sending offset: %s", err)
+	}
+	if _, err := f.Seek(int64(offset), io.SeekStart); err != nil {
+		return err
+	}
+	p := newProgress(progress, info.Name, int64(offset), info.Size)
+	if _, err := io.CopyN(conn, io.TeeReader(f, p), info.Size-int64(offset)); err != nil {
+		return err
+	}
+	p.done()
+	if err := writeManifest(conn, m); err != nil {
+		return fmt.Errorf("error sending manifest: %s", err)
+	}
+
+	var status [1]byte
+	if _, err := io.ReadFull(conn, status[:]); err != nil {
+		return fmt.Errorf("error reading status: %s", err)
+	}
+	if status[0] != transferOK {
+		return ErrBadHash
+	}
+	return nil
+}
+
+// receiving holds the partial files being written by ReceiveFile, so
+// concurrent transfers of the same file don't write the same one.
+var receiving = struct {
+	sync.Mutex
+	partials map[string]bool
+}{partials: make(map[string]bool)}
+
+// ReceiveFile receives a file from conn into dir, returning its path.
+// Interrupted transfers leave a partial file, named after the hash of
+// the content, which is resumed by the next transfer of the same file
+// when the sender has the same beginning. The file is only renamed to
+// its name once it matches the manifest of the sender, otherwise the
+// partial file keeps the chunks before the first corrupted one and
+// ErrBadHash is returned. Files are never replaced: the transfer fails
+// when dir already has a file of the same name, as well as when the
+// same file is being received already.
+func ReceiveFile(conn io.ReadWriter, dir string, progress io.Writer) (string, error) {
+	info, err := readFileInfo(conn)
+	if err != nil {
+		return "", fmt.Errorf("error reading file info: %s", err)
+	}
+	name := filepath.Base(info.Name)
+	if name != info.Name || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid file name %q", info.Name)
+	}
+	path := filepath.Join(dir, name)
+	partial := filepath.Join(dir, fmt.Sprintf(".%s.%x.part", name, info.Hash[:8]))
+	if _, err := os.Lstat(path); err == nil {
+		return "", fmt.Errorf("%s already exists", path)
+	}
+	receiving.Lock()
+	busy := receiving.partials[partial]
+	receiving.partials[partial] = true
+	receiving.Unlock()
+	if busy {
+		return "", fmt.Errorf("%s is being received already", path)
+	}
+	defer func() {
+		receiving.Lock()
+		delete(receiving.partials, partial)
+		receiving.Unlock()
+	}()
+
+	f, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	offset := stat.Size() - stat.Size()%transferChunk
+	if offset > info.Size {
+		offset = 0
+	}
+	if err := f.Truncate(offset); err != nil {
+		return "", err
+	}
+	prefix, err := hashPrefix(f, offset)
+	if err != nil {
+		return "", err
+	}
+	buf := binary.BigEndian.AppendUint64(nil, uint64(offset))
+	if _, err := conn.Write(append(buf, prefix[:]...)); err != nil {
+		return "", fmt.Errorf("error sending offset: %s", err)
+	}
+	var resumed uint64
+	if err := binary.Read(conn, binary.BigEndian, &resumed); err != nil {
+		return "", fmt.Errorf("error reading offset: %s", err)
+	}
+	switch resumed {
+	case uint64(offset):
+	case 0:
+		if err := f.Truncate(0); err != nil {
+			return "", err
+		}
+		offset = 0
+	default:
+		return "", fmt.Errorf("invalid offset %d", resumed)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	p := newProgress(progress, name, offset, info.Size)
+	if _, err := io.CopyN(io.MultiWriter(f, p), conn, info.Size-offset); err != nil {
+		return "", err
+	}
+	p.done()
+
+	expected, err := readManifest(conn, info)
+	if err != nil {
+		return "", fmt.Errorf("error reading manifest: %s", err)
+	}
+	got, err := newManifest(f)
+	if err != nil {
+		return "", err
+	}
+	if bad := expected.verify(got); bad >= 0 {
+		f.Truncate(int64(bad) * transferChunk)
+		conn.Write([]byte{transferBadHash})
+		return "", fmt.Errorf("%w: chunk %d", ErrBadHash, bad)
+	}
+	// Linking fails when the file was created in the meantime, unlike
+	// renaming
+	if err := os.Link(partial, path); err != nil {
+		return "", err
+	}
+	os.Remove(partial)
+	_, err = conn.Write([]byte{transferOK})
+	return path, err
+}
+
+// progress reports the progress of a transfer, at most every
+// progressInterval.
+type progress struct {
+	w           io.Writer
+	name        string
+	count, size int64
+	last        time.Time
+}
+
+const progressInterval = 200 * time.Millisecond
+
+func newProgress(w io.Writer, name string, count, size int64) *progress {
+	return &progress{w: w, name: name, count: count, size: size}
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.count += int64(len(b))
+	if time.Since(p.last) >= progressInterval {
+		p.report()
+	}
+	return len(b), nil
+}
+
+func (p *progress) report() {
+	if p.w == nil {
+		return
+	}
+	percent := int64(100)
+	if p.size > 0 {
+		percent = p.count * 100 / p.size
+	}
+	fmt.Fprintf(p.w, "\r%s: %d/%d bytes (%d%%)", p.name, p.count, p.size, percent)
+	p.last = time.Now()
+}
+
+func (p *progress) done() {
+	p.report()
+	if p.w != nil {
+		fmt.Fprintln(p.w)
+	}
+}
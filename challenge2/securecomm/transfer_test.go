@@ -0,0 +1,293 @@
+package securecomm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// transfer sends the file at path to dir over a secure connection.
+func transfer(t *testing.T, path, dir string) (string, error) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(inner, nil)
+	defer l.Close()
+
+	received := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer conn.Close()
+		path, err := ReceiveFile(conn, dir, nil)
+		if err != nil {
+			errc <- err
+			return
+		}
+		received <- path
+	}()
+
+	conn, err := DialConfig(l.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := SendFile(conn, path, io.Discard); err != nil {
+		return "", err
+	}
+	select {
+	case path := <-received:
+		return path, nil
+	case err := <-errc:
+		return "", err
+	}
+}
+
+func TestFileTransfer(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	content := bytes.Repeat([]byte("0123456789abcdef"), 50000)
+	path := filepath.Join(src, "data.bin")
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := transfer(t, path, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != filepath.Join(dst, "data.bin") {
+		t.Fatalf("Unexpected path %s", got)
+	}
+	received, _ := os.ReadFile(got)
+	if !bytes.Equal(received, content) {
+		t.Fatalf("Unexpected result: got %d bytes, expected %d", len(received), len(content))
+	}
+}
+
+func TestReceiveFileNoReplace(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	content := []byte("new content")
+	path := filepath.Join(src, "data.bin")
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file being received already is left alone
+	hash := sha256.Sum256(content)
+	partial := filepath.Join(dst, fmt.Sprintf(".data.bin.%x.part", hash[:8]))
+	receiving.Lock()
+	receiving.partials[partial] = true
+	receiving.Unlock()
+	if _, err := transfer(t, path, dst); err == nil {
+		t.Fatal("Unexpected result. The file was received twice at once")
+	}
+	receiving.Lock()
+	delete(receiving.partials, partial)
+	receiving.Unlock()
+
+	// An existing file is not replaced
+	existing := filepath.Join(dst, "data.bin")
+	if err := os.WriteFile(existing, []byte("old content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transfer(t, path, dst); err == nil {
+		t.Fatal("Unexpected result. The existing file was replaced")
+	}
+	if got, _ := os.ReadFile(existing); string(got) != "old content" {
+		t.Fatalf("Unexpected result. Got %q", got)
+	}
+}
+
+func TestFileTransferResume(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	content := bytes.Repeat([]byte("0123456789abcdef"), 50000)
+	path := filepath.Join(src, "data.bin")
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A previous transfer stopped halfway
+	hash := sha256.Sum256(content)
+	partial := filepath.Join(dst, fmt.Sprintf(".data.bin.%x.part", hash[:8]))
+	if err := os.WriteFile(partial, content[:len(content)/2], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := transfer(t, path, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, _ := os.ReadFile(got)
+	if !bytes.Equal(received, content) {
+		t.Fatalf("Unexpected result: got %d bytes, expected %d", len(received), len(content))
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Fatal("Unexpected result. The partial file is still there")
+	}
+}
+
+func TestFileTransferCorruptedPartial(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 5*transferChunk/16)
+	garbage := bytes.Repeat([]byte("garbage"), transferChunk)
+	tData := []struct {
+		name    string
+		partial []byte
+	}{
+		// The whole file is sent again
+		{"corrupted", garbage[:3*transferChunk]},
+		// Only the end of the last chunk is dropped
+		{"torn", append(append([]byte{}, content[:2*transferChunk]...), garbage[:100]...)},
+	}
+	for _, exp := range tData {
+		src, dst := t.TempDir(), t.TempDir()
+		path := filepath.Join(src, "data.bin")
+		if err := os.WriteFile(path, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		hash := sha256.Sum256(content)
+		partial := filepath.Join(dst, fmt.Sprintf(".data.bin.%x.part", hash[:8]))
+		if err := os.WriteFile(partial, exp.partial, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := transfer(t, path, dst)
+		if err != nil {
+			t.Fatalf("Unexpected result for %s. Got %v", exp.name, err)
+		}
+		received, _ := os.ReadFile(got)
+		if !bytes.Equal(received, content) {
+			t.Fatalf("Unexpected result for %s. Got %d bytes, expected %d", exp.name, len(received), len(content))
+		}
+	}
+}
+
+func TestReceiveFileName(t *testing.T) {
+	for _, name := range []string{"../evil", "..", "a/b", ""} {
+		c, s := net.Pipe()
+		go func() {
+			writeFileInfo(c, fileInfo{Name: name})
+			c.Close()
+		}()
+		if _, err := ReceiveFile(s, t.TempDir(), nil); err == nil {
+			t.Fatalf("Unexpected result. The file name %q was accepted", name)
+		}
+		s.Close()
+	}
+}
+
+func TestForgedFileSize(t *testing.T) {
+	// A size above math.MaxInt64 is rejected
+	header := binary.BigEndian.AppendUint16(nil, 4)
+	header = append(header, "data"...)
+	header = binary.BigEndian.AppendUint64(header, math.MaxUint64)
+	header = append(header, make([]byte, sha256.Size)...)
+	if _, err := readFileInfo(bytes.NewReader(header)); err == nil {
+		t.Fatal("Unexpected result. The size was accepted")
+	}
+
+	// The manifest of the largest size fails once its chunks run out
+	info := fileInfo{Name: "data", Size: math.MaxInt64}
+	forged := binary.BigEndian.AppendUint64(nil, math.MaxInt64)
+	forged = append(forged, info.Hash[:]...)
+	forged = append(forged, make([]byte, 3*sha256.Size)...)
+	if _, err := readManifest(bytes.NewReader(forged), info); err != io.ErrUnexpectedEOF && err != io.EOF {
+		t.Fatalf("Unexpected result. Got %v, expected %v", err, io.EOF)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 3*transferChunk/16+1)
+	write := func(content []byte) *os.File {
+		f, err := os.CreateTemp(t.TempDir(), "data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		f.Write(content)
+		return f
+	}
+	expected, err := newManifest(write(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expected.chunks) != int(chunkCount(int64(len(content)))) || expected.hash != sha256.Sum256(content) {
+		t.Fatalf("Unexpected result. Got %d chunks", len(expected.chunks))
+	}
+
+	corrupted := bytes.Clone(content)
+	corrupted[2*transferChunk+5] ^= 1
+	tData := []struct {
+		name     string
+		content  []byte
+		expected int
+	}{
+		{"intact", content, -1},
+		{"corrupted", corrupted, 2},
+		{"truncated", content[:len(content)-1], 3},
+		{"longer", append(bytes.Clone(content), 'x'), 3},
+	}
+	for _, exp := range tData {
+		got, err := newManifest(write(exp.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bad := expected.verify(got); bad != exp.expected {
+			t.Fatalf("Unexpected result for %s. Got chunk %d, expected %d", exp.name, bad, exp.expected)
+		}
+	}
+}
+
+func TestReceiveFileCorrupted(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 3*transferChunk/16)
+	src := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(src, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := newManifest(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second chunk is corrupted on the way
+	c, s := net.Pipe()
+	defer s.Close()
+	go func() {
+		defer c.Close()
+		writeFileInfo(c, fileInfo{Name: "data.bin", Size: m.size, Hash: m.hash})
+		io.ReadFull(c, make([]byte, 8+sha256.Size))
+		c.Write(make([]byte, 8))
+		corrupted := bytes.Clone(content)
+		corrupted[transferChunk] ^= 1
+		c.Write(corrupted)
+		writeManifest(c, m)
+		io.ReadFull(c, make([]byte, 1))
+	}()
+	dir := t.TempDir()
+	if _, err := ReceiveFile(s, dir, nil); !errors.Is(err, ErrBadHash) {
+		t.Fatalf("Unexpected result. Got %v, expected %v", err, ErrBadHash)
+	}
+
+	// The first chunk is kept for the next transfer
+	partial := filepath.Join(dir, fmt.Sprintf(".data.bin.%x.part", m.hash[:8]))
+	if stat, err := os.Stat(partial); err != nil || stat.Size() != transferChunk {
+		t.Fatalf("Unexpected result. Got %v, %v", stat, err)
+	}
+}
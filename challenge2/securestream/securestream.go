@@ -0,0 +1,188 @@
+// Package securestream encrypts streams of arbitrary length with a
+// 32 bytes key, in authenticated chunks like the frames of the secure
+// connections, without handshake: files, pipes and message queues can
+// use it with a key agreed on beforehand.
+//
+// A stream starts with a random 16 bytes header, followed by the chunks:
+//
+//	[uint16 length][secretbox(flag || data)]
+//
+// sealed with the nonce made of the header and the sequence number of the
+// chunk, so chunks can't be reordered or replayed, and the same key can
+// seal many streams. The last chunk is flagged, so truncated streams are
+// detected.
+package securestream
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// HeaderSize is the length of the header starting a stream
+const HeaderSize = 16
+
+// MaxChunkSize is the maximum length of the data of a chunk
+const MaxChunkSize = math.MaxUint16 - secretbox.Overhead - 1
+
+// Chunk flags
+const (
+	flagData byte = iota
+	flagFinal
+)
+
+// ErrTruncated is returned when a stream ends before its last chunk.
+var ErrTruncated = errors.New("securestream: truncated stream")
+
+// ErrAuthentication is returned when a chunk fails authentication.
+var ErrAuthentication = errors.New("securestream: chunk authentication failed")
+
+// Writer encrypts the data written to it. Close must be called to write
+// the last chunk.
+type Writer struct {
+	w      io.Writer
+	key    *[32]byte
+	nonce  [24]byte
+	seq    uint64
+	buf    []byte // data not sealed yet
+	header bool   // whether the header was written
+	err    error
+}
+
+// NewWriter returns a Writer sealing the data with key to w.
+func NewWriter(w io.Writer, key *[32]byte) *Writer {
+	return &Writer{w: w, key: key, buf: make([]byte, 0, MaxChunkSize)}
+}
+
+// Write buffers p, sealing a chunk every MaxChunkSize bytes.
+func (sw *Writer) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if sw.err != nil {
+			return n, sw.err
+		}
+		if len(sw.buf) == MaxChunkSize {
+			sw.err = sw.writeChunk(flagData)
+			continue
+		}
+		m := copy(sw.buf[len(sw.buf):MaxChunkSize], p)
+		sw.buf = sw.buf[:len(sw.buf)+m]
+		n += m
+		p = p[m:]
+	}
+	return n, sw.err
+}
+
+// Flush seals the buffered data right away, so the reader gets it.
+func (sw *Writer) Flush() error {
+	if sw.err == nil && len(sw.buf) > 0 {
+		sw.err = sw.writeChunk(flagData)
+	}
+	return sw.err
+}
+
+// Close writes the last chunk. It does not close the underlying writer.
+func (sw *Writer) Close() error {
+	if sw.err == nil {
+		sw.err = sw.writeChunk(flagFinal)
+	}
+	if sw.err != nil && sw.err != errClosed {
+		return sw.err
+	}
+	sw.err = errClosed
+	return nil
+}
+
+var errClosed = errors.New("securestream: write on closed writer")
+
+func (sw *Writer) writeChunk(flag byte) error {
+	if !sw.header {
+		if _, err := io.ReadFull(rand.Reader, sw.nonce[:HeaderSize]); err != nil {
+			return err
+		}
+		if _, err := sw.w.Write(sw.nonce[:HeaderSize]); err != nil {
+			return err
+		}
+		sw.header = true
+	}
+	binary.BigEndian.PutUint64(sw.nonce[HeaderSize:], sw.seq)
+	sw.seq++
+
+	plaintext := append([]byte{flag}, sw.buf...)
+	chunk := secretbox.Seal(make([]byte, 2, 2+len(plaintext)+secretbox.Overhead), plaintext, &sw.nonce, sw.key)
+	binary.BigEndian.PutUint16(chunk, uint16(len(chunk)-2))
+	sw.buf = sw.buf[:0]
+	_, err := sw.w.Write(chunk)
+	return err
+}
+
+// Reader decrypts a stream sealed by a Writer.
+type Reader struct {
+	r     io.Reader
+	key   *[32]byte
+	nonce [24]byte
+	seq   uint64
+	buf   []byte // data opened but not read yet
+	final bool
+	err   error
+}
+
+// NewReader returns a Reader opening the stream read from r with key.
+func NewReader(r io.Reader, key *[32]byte) *Reader {
+	return &Reader{r: r, key: key}
+}
+
+// Read reads the decrypted data. It returns io.EOF after the last chunk,
+// ErrTruncated if the stream ends before it and ErrAuthentication if a
+// chunk was tampered with.
+func (sr *Reader) Read(p []byte) (int, error) {
+	for len(sr.buf) == 0 {
+		if sr.final {
+			return 0, io.EOF
+		}
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		sr.err = sr.readChunk()
+	}
+	n := copy(p, sr.buf)
+	sr.buf = sr.buf[n:]
+	return n, nil
+}
+
+func (sr *Reader) readChunk() error {
+	if sr.seq == 0 {
+		if _, err := io.ReadFull(sr.r, sr.nonce[:HeaderSize]); err != nil {
+			return truncated(err)
+		}
+	}
+	var size [2]byte
+	if _, err := io.ReadFull(sr.r, size[:]); err != nil {
+		return truncated(err)
+	}
+	chunk := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(sr.r, chunk); err != nil {
+		return truncated(err)
+	}
+	binary.BigEndian.PutUint64(sr.nonce[HeaderSize:], sr.seq)
+	sr.seq++
+	plaintext, ok := secretbox.Open(nil, chunk, &sr.nonce, sr.key)
+	if !ok || len(plaintext) == 0 || plaintext[0] > flagFinal {
+		return ErrAuthentication
+	}
+	sr.final = plaintext[0] == flagFinal
+	sr.buf = plaintext[1:]
+	return nil
+}
+
+// truncated replaces the EOF errors with ErrTruncated.
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
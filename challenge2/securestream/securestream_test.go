@@ -0,0 +1,58 @@
+package securestream
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	key := &[32]byte{'k', 'e', 'y'}
+
+	for _, size := range []int{0, 1, MaxChunkSize, MaxChunkSize + 1, 3 * MaxChunkSize} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, key)
+		msg := bytes.Repeat([]byte{'x'}, size)
+		if _, err := w.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(NewReader(&buf, key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatalf("Unexpected result: got %d bytes, expected %d", len(got), size)
+		}
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	key := &[32]byte{'k', 'e', 'y'}
+	var buf bytes.Buffer
+	w := NewWriter(&buf, key)
+	w.Write([]byte("hello"))
+	w.Flush()
+	w.Write([]byte(" world"))
+	w.Close()
+	stream := buf.Bytes()
+
+	tData := []struct {
+		name     string
+		stream   []byte
+		key      *[32]byte
+		expected error
+	}{
+		{"truncated", stream[:len(stream)-1], key, ErrTruncated},
+		{"last chunk dropped", stream[:HeaderSize+2+1+5+16], key, ErrTruncated},
+		{"wrong key", stream, &[32]byte{}, ErrAuthentication},
+		{"flipped bit", append(append([]byte{}, stream[:20]...), append([]byte{stream[20] ^ 1}, stream[21:]...)...), key, ErrAuthentication},
+	}
+	for _, exp := range tData {
+		if _, err := io.ReadAll(NewReader(bytes.NewReader(exp.stream), exp.key)); err != exp.expected {
+			t.Fatalf("Unexpected result for %s. Expected %v, got %v", exp.name, exp.expected, err)
+		}
+	}
+}
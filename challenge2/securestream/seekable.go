@@ -0,0 +1,225 @@
+package securestream
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// The seekable format seals fixed-size blocks independently, so any
+// part of the data can be read without opening what comes before:
+//
+//	[16 bytes id][uint32 block size][secretbox(block)]...[secretbox(index)]
+//
+// Each block is sealed with the nonce made of the id and its number.
+// The index sealed at the end holds the size of the data and the block
+// size, so truncated or resized files are detected before reading.
+
+// DefaultBlockSize is the size of the blocks of the seekable format,
+// unless specified otherwise.
+const DefaultBlockSize = 64 << 10
+
+// seekableHeaderSize is the length of the id and the block size
+const seekableHeaderSize = HeaderSize + 4
+
+// indexSize is the length of the sealed index
+const indexSize = 8 + 4 + secretbox.Overhead
+
+// indexBlock is the block number of the index, in its nonce
+const indexBlock = math.MaxUint64
+
+// ErrCorrupted is returned when a seekable file is truncated or fails
+// authentication.
+var ErrCorrupted = errors.New("securestream: corrupted seekable file")
+
+// SeekableWriter writes the seekable format, read by SeekableReader.
+// Close must be called to write the index.
+type SeekableWriter struct {
+	w         io.Writer
+	key       *[32]byte
+	nonce     [24]byte
+	blockSize int
+	blocks    uint64
+	size      uint64
+	buf       []byte
+	err       error
+}
+
+// NewSeekableWriter returns a SeekableWriter sealing the data written to
+// w with key, in blocks of blockSize bytes, DefaultBlockSize if zero.
+func NewSeekableWriter(w io.Writer, key *[32]byte, blockSize int) (*SeekableWriter, error) {
+	if blockSize == 0 {
+		blockSize = DefaultBlockSize
+	}
+	if blockSize < 0 || blockSize > math.MaxUint32-secretbox.Overhead {
+		return nil, fmt.Errorf("securestream: invalid block size %d", blockSize)
+	}
+	sw := &SeekableWriter{w: w, key: key, blockSize: blockSize, buf: make([]byte, 0, blockSize)}
+	if _, err := io.ReadFull(rand.Reader, sw.nonce[:HeaderSize]); err != nil {
+		return nil, err
+	}
+	header := binary.BigEndian.AppendUint32(sw.nonce[:HeaderSize:HeaderSize], uint32(blockSize))
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+// Write seals p in blocks.
+func (sw *SeekableWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 && sw.err == nil {
+		m := copy(sw.buf[len(sw.buf):sw.blockSize], p)
+		sw.buf = sw.buf[:len(sw.buf)+m]
+		n += m
+		p = p[m:]
+		if len(sw.buf) == sw.blockSize {
+			sw.err = sw.writeBlock()
+		}
+	}
+	return n, sw.err
+}
+
+// Close seals the last block and the index. It does not close the
+// underlying writer.
+func (sw *SeekableWriter) Close() error {
+	if sw.err != nil {
+		return sw.err
+	}
+	if len(sw.buf) > 0 {
+		if sw.err = sw.writeBlock(); sw.err != nil {
+			return sw.err
+		}
+	}
+	index := binary.BigEndian.AppendUint64(nil, sw.size)
+	index = binary.BigEndian.AppendUint32(index, uint32(sw.blockSize))
+	binary.BigEndian.PutUint64(sw.nonce[HeaderSize:], indexBlock)
+	_, sw.err = sw.w.Write(secretbox.Seal(nil, index, &sw.nonce, sw.key))
+	if sw.err == nil {
+		sw.err = errClosed
+		return nil
+	}
+	return sw.err
+}
+
+func (sw *SeekableWriter) writeBlock() error {
+	binary.BigEndian.PutUint64(sw.nonce[HeaderSize:], sw.blocks)
+	sw.blocks++
+	sw.size += uint64(len(sw.buf))
+	_, err := sw.w.Write(secretbox.Seal(nil, sw.buf, &sw.nonce, sw.key))
+	sw.buf = sw.buf[:0]
+	return err
+}
+
+// SeekableReader gives random access to the data of a seekable file.
+// ReadAt can be called concurrently, unlike Read and Seek.
+type SeekableReader struct {
+	r         io.ReaderAt
+	key       *[32]byte
+	id        [HeaderSize]byte
+	blockSize int64
+	size      int64
+	offset    int64 // of Read
+}
+
+// NewSeekableReader returns a SeekableReader opening the seekable file
+// of the given size read from r with key. The index is verified
+// right away.
+func NewSeekableReader(r io.ReaderAt, size int64, key *[32]byte) (*SeekableReader, error) {
+	if size < seekableHeaderSize+indexSize {
+		return nil, ErrCorrupted
+	}
+	sr := &SeekableReader{r: r, key: key}
+	header := make([]byte, seekableHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	copy(sr.id[:], header)
+	sr.blockSize = int64(binary.BigEndian.Uint32(header[HeaderSize:]))
+
+	sealed := make([]byte, indexSize)
+	if _, err := r.ReadAt(sealed, size-indexSize); err != nil {
+		return nil, err
+	}
+	index, ok := secretbox.Open(nil, sealed, sr.nonce(indexBlock), key)
+	if !ok || int64(binary.BigEndian.Uint32(index[8:])) != sr.blockSize || sr.blockSize == 0 {
+		return nil, ErrCorrupted
+	}
+	sr.size = int64(binary.BigEndian.Uint64(index))
+	blocks := (sr.size + sr.blockSize - 1) / sr.blockSize
+	if size != seekableHeaderSize+sr.size+blocks*secretbox.Overhead+indexSize {
+		return nil, ErrCorrupted
+	}
+	return sr, nil
+}
+
+// Size returns the size of the decrypted data.
+func (sr *SeekableReader) Size() int64 {
+	return sr.size
+}
+
+func (sr *SeekableReader) nonce(block uint64) *[24]byte {
+	var nonce [24]byte
+	copy(nonce[:], sr.id[:])
+	binary.BigEndian.PutUint64(nonce[HeaderSize:], block)
+	return &nonce
+}
+
+// ReadAt reads the decrypted data at off, opening the blocks it spans.
+func (sr *SeekableReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("securestream: negative offset")
+	}
+	n := 0
+	for len(p) > 0 && off < sr.size {
+		block := off / sr.blockSize
+		start := block * sr.blockSize
+		length := min(sr.blockSize, sr.size-start)
+		sealed := make([]byte, length+secretbox.Overhead)
+		if _, err := sr.r.ReadAt(sealed, seekableHeaderSize+block*(sr.blockSize+secretbox.Overhead)); err != nil {
+			return n, err
+		}
+		plaintext, ok := secretbox.Open(nil, sealed, sr.nonce(uint64(block)), sr.key)
+		if !ok {
+			return n, ErrCorrupted
+		}
+		m := copy(p, plaintext[off-start:])
+		n += m
+		off += int64(m)
+		p = p[m:]
+	}
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read reads the decrypted data from the current offset.
+func (sr *SeekableReader) Read(p []byte) (int, error) {
+	if sr.offset >= sr.size {
+		return 0, io.EOF
+	}
+	n, err := sr.ReadAt(p[:min(int64(len(p)), sr.size-sr.offset)], sr.offset)
+	sr.offset += int64(n)
+	return n, err
+}
+
+// Seek sets the offset of the next Read, see io.Seeker.
+func (sr *SeekableReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += sr.offset
+	case io.SeekEnd:
+		offset += sr.size
+	}
+	if offset < 0 {
+		return 0, errors.New("securestream: negative offset")
+	}
+	sr.offset = offset
+	return offset, nil
+}
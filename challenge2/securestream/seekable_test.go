@@ -0,0 +1,80 @@
+package securestream
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestSeekable(t *testing.T) {
+	key := &[32]byte{'k', 'e', 'y'}
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	for _, size := range []int{0, 1, 1024, 1025, len(data)} {
+		var buf bytes.Buffer
+		w, err := NewSeekableWriter(&buf, key, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data[:size])
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewSeekableReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Size() != int64(size) {
+			t.Fatalf("Unexpected result. Got size %d, expected %d", r.Size(), size)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(got, data[:size]) {
+			t.Fatalf("Unexpected result: got %d bytes, expected %d (%v)", len(got), size, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	w, _ := NewSeekableWriter(&buf, key, 1024)
+	w.Write(data)
+	w.Close()
+	file := buf.Bytes()
+	r, err := NewSeekableReader(bytes.NewReader(file), int64(len(file)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Random access across the blocks
+	p := make([]byte, 2000)
+	if n, err := r.ReadAt(p, 3000); n != len(p) || err != nil || !bytes.Equal(p, data[3000:5000]) {
+		t.Fatalf("Unexpected result. Read %d bytes (%v)", n, err)
+	}
+	if n, err := r.ReadAt(p, 9000); n != 1000 || err != io.EOF || !bytes.Equal(p[:n], data[9000:]) {
+		t.Fatalf("Unexpected result. Read %d bytes (%v)", n, err)
+	}
+	if _, err := r.Seek(-10, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(r); !bytes.Equal(got, data[len(data)-10:]) {
+		t.Fatalf("Unexpected result. Got %v", got)
+	}
+
+	// Truncated and tampered files are detected
+	if _, err := NewSeekableReader(bytes.NewReader(file), int64(len(file)-1050), key); err != ErrCorrupted {
+		t.Fatalf("Unexpected result. Expected %v, got %v", ErrCorrupted, err)
+	}
+	tampered := append([]byte{}, file...)
+	tampered[2000] ^= 1
+	r, err = NewSeekableReader(bytes.NewReader(tampered), int64(len(tampered)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadAt(p[:10], 0); err != nil {
+		t.Fatalf("Unexpected result. The untouched block failed: %v", err)
+	}
+	if _, err := r.ReadAt(p[:10], 1024); err != ErrCorrupted {
+		t.Fatalf("Unexpected result. Expected %v, got %v", ErrCorrupted, err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+func serialCommand(args []string) {
+	fs := flag.NewFlagSet("serial", flag.ExitOnError)
+	baud := fs.Int("baud", 115200, "Speed of the serial line, in bits per second")
+	accept := fs.Bool("accept", false, "Wait for the device on the other end to dial, performing the server side of the handshake")
+	config := configFlags(fs)
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		log.Fatalf("Usage: %s serial [flags] [-baud 115200] [-accept] <port>", os.Args[0])
+	}
+	cfg := config(!*accept)
+	var conn *securecomm.Conn
+	var err error
+	if *accept {
+		conn, err = securecomm.AcceptSerial(fs.Arg(0), *baud, cfg)
+	} else {
+		conn, err = securecomm.DialSerial(fs.Arg(0), *baud, cfg)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("connected over %s (%s)\n", fs.Arg(0), securecomm.Fingerprint(conn.PeerIdentity()))
+	if err := pipe(conn, os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
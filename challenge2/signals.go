@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+// Exit statuses of the server and the transfers
+const (
+	exitOK       = 0 // shut down once the connections were done
+	exitError    = 1 // failed to serve
+	exitDrainCut = 2 // shut down closing the connections left after the grace period
+	exitBadHash  = 3 // the file sent did not match its manifest once received
+)
+
+// serveUntilSignal serves l with s until SIGINT or SIGTERM, then shuts
+// it down, calling reload on SIGHUP, see serveUntil.
+func serveUntilSignal(s *securecomm.Server, l net.Listener, grace time.Duration, reload func() error) int {
+	stop := make(chan os.Signal, 2)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	return serveUntil(s, l, grace, stop, hup, reload)
+}
+
+// serveUntil serves l with s until a signal is received on stop. The
+// server then stops accepting connections and waits up to grace for the
+// active ones to end, or until a second signal, before closing them.
+// Meanwhile, reload is called for each signal received on hup; the
+// server keeps running when it fails. It returns the exit status of the
+// process.
+func serveUntil(s *securecomm.Server, l net.Listener, grace time.Duration, stop, hup <-chan os.Signal, reload func() error) int {
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(l)
+	}()
+
+	logger := slog.Default()
+wait:
+	for {
+		select {
+		case err := <-served:
+			logger.Error("server failed", "err", err)
+			return exitError
+		case <-hup:
+			if err := reload(); err != nil {
+				logger.Error("reloading failed, keeping the current config", "err", err)
+				continue
+			}
+			logger.Info("config reloaded")
+		case sig := <-stop:
+			logger.Info("shutting down", "signal", sig.String(), "grace", grace.String())
+			break wait
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	if err := s.Shutdown(ctx); err != nil {
+		logger.Warn("connections closed before they were done", "err", err)
+		return exitDrainCut
+	}
+	if err := <-served; !errors.Is(err, securecomm.ErrServerClosed) {
+		logger.Error("server failed", "err", err)
+		return exitError
+	}
+	logger.Info("server stopped")
+	return exitOK
+}
+
+// reloadOnSIGHUP reloads a every time the process receives SIGHUP.
+func reloadOnSIGHUP(a *securecomm.AuthorizedKeys, logger securecomm.Logger) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			if err := a.Reload(); err != nil {
+				logger.Error("reloading authorized keys failed", "err", err)
+				continue
+			}
+			logger.Info("authorized keys reloaded")
+		}
+	}()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+func TestServeUntil(t *testing.T) {
+	tData := []struct {
+		name     string
+		grace    time.Duration
+		expected int
+	}{
+		{"drained", time.Second, exitOK},
+		{"grace expired", 50 * time.Millisecond, exitDrainCut},
+	}
+	for _, exp := range tData {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &securecomm.Server{}
+		stop := make(chan os.Signal, 1)
+		status := make(chan int)
+		go func() {
+			status <- serveUntil(s, l, exp.grace, stop, nil, nil)
+		}()
+
+		conn, err := securecomm.Dial(l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("x"))
+		io.ReadFull(conn, make([]byte, 1))
+		stop <- syscall.SIGTERM
+
+		// The client is done before the grace period ends, or not
+		if exp.expected == exitOK {
+			time.Sleep(20 * time.Millisecond)
+			conn.Close()
+		}
+		if got := <-status; got != exp.expected {
+			t.Fatalf("Unexpected result for %s. Got status %d, expected %d", exp.name, got, exp.expected)
+		}
+		conn.Close()
+	}
+}
+
+func TestServeUntilReload(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &securecomm.Server{}
+	stop := make(chan os.Signal, 1)
+	hup := make(chan os.Signal, 1)
+	reloaded := make(chan bool)
+	fail := true
+	reload := func() error {
+		// The first reload fails, the server keeps running
+		defer func() { reloaded <- !fail }()
+		if fail {
+			return errors.New("invalid config")
+		}
+		s.Reload(&securecomm.Server{IdleTimeout: time.Minute}, 0)
+		return nil
+	}
+	status := make(chan int)
+	go func() {
+		status <- serveUntil(s, l, time.Second, stop, hup, reload)
+	}()
+
+	hup <- syscall.SIGHUP
+	if <-reloaded {
+		t.Fatal("Unexpected result. The failed reload was applied")
+	}
+	fail = false
+	hup <- syscall.SIGHUP
+	<-reloaded
+	if s.IdleTimeout != time.Minute {
+		t.Fatalf("Unexpected result. Got idle timeout %s", s.IdleTimeout)
+	}
+	conn, err := securecomm.Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	stop <- syscall.SIGTERM
+	if got := <-status; got != exitOK {
+		t.Fatalf("Unexpected result. Got status %d, expected %d", got, exitOK)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+func sendCommand(args []string) {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	retries := fs.Int("retries", 5, "Reconnect and resume this many times when the connection fails")
+	relay := fs.String("relay", "", "Send through this relay, host:port, to the receiver joining the room given instead of the address")
+	config := configFlags(fs)
+	parseFlags(fs, args)
+	if fs.NArg() != 2 {
+		log.Fatalf("Usage: %s send [flags] <file> <addr> | <advertised name>\n"+
+			"       %s send [flags] -relay <host:port> <file> <room>", os.Args[0], os.Args[0])
+	}
+	cfg := config(true)
+	dial := func() (io.ReadWriteCloser, error) {
+		return securecomm.DialRelay(context.Background(), *relay, fs.Arg(1), securecomm.RelayConfig(cfg), cfg)
+	}
+	if *relay == "" {
+		addr, err := resolveAdvertised(fs.Arg(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		dial = func() (io.ReadWriteCloser, error) {
+			return securecomm.DialConfig(addr, cfg)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := sendFileTo(dial, fs.Arg(0))
+		if err == nil {
+			return
+		}
+		if errors.Is(err, securecomm.ErrBadHash) {
+			log.Print(err)
+			os.Exit(exitBadHash)
+		}
+		if attempt >= *retries {
+			log.Fatal(err)
+		}
+		log.Printf("%s, resuming\n", err)
+		time.Sleep(time.Second)
+	}
+}
+
+func sendFileTo(dial func() (io.ReadWriteCloser, error), path string) error {
+	conn, err := dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return securecomm.SendFile(conn, path, os.Stderr)
+}
+
+func recvCommand(args []string) {
+	fs := flag.NewFlagSet("recv", flag.ExitOnError)
+	port := fs.Int("l", 0, "Listen on this port")
+	dir := fs.String("o", ".", "Directory receiving the files")
+	advertiseServer := fs.Bool("advertise", false, "Advertise the receiver on the local network with mDNS, under the host name")
+	relay := fs.String("relay", "", "Receive through this relay, host:port, from the senders joining -room")
+	room := fs.String("room", "", "Room joined on -relay")
+	config := configFlags(fs)
+	parseFlags(fs, args)
+	if (*port == 0) == (*relay == "") || (*relay != "") != (*room != "") {
+		log.Fatalf("Usage: %s recv [flags] -l <port> [-o dir] [-advertise]\n"+
+			"       %s recv [flags] -relay <host:port> -room <room> [-o dir]", os.Args[0], os.Args[0])
+	}
+	cfg := config(false)
+	if *relay != "" {
+		recvRelay(*relay, *room, *dir, cfg)
+		return
+	}
+
+	inner, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *advertiseServer {
+		defer advertise(inner, cfg)()
+	}
+	l := securecomm.NewListener(inner, cfg)
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			defer conn.Close()
+			path, err := securecomm.ReceiveFile(conn, *dir, os.Stderr)
+			if err != nil {
+				log.Printf("error receiving file from %s: %s\n", conn.RemoteAddr().String(), err)
+				return
+			}
+			log.Printf("received %s from %s\n", path, conn.RemoteAddr().String())
+		}()
+	}
+}
+
+// recvRelay receives the files of the senders joining room on relay, one
+// at a time.
+func recvRelay(relay, room, dir string, cfg *securecomm.Config) {
+	for {
+		conn, err := securecomm.DialRelay(context.Background(), relay, room, securecomm.RelayConfig(cfg), cfg)
+		if err != nil {
+			log.Printf("error joining %s: %s\n", room, err)
+			time.Sleep(time.Second)
+			continue
+		}
+		path, err := securecomm.ReceiveFile(conn, dir, os.Stderr)
+		conn.Close()
+		if err != nil {
+			log.Printf("error receiving file from %s: %s\n", room, err)
+			continue
+		}
+		log.Printf("received %s from %s\n", path, room)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+	"golang.org/x/term"
+)
+
+// The terminal UI client shows the health of the secure connection above
+// the messages exchanged: its state, the fingerprint of the server, the
+// traffic and the latency measured by the heartbeats.
+
+// tuiRefresh is the interval between the refreshes of the status panel
+const tuiRefresh = time.Second
+
+// maxLogLines bounds the messages kept in the log of the terminal UI
+const maxLogLines = 500
+
+// tuiState is what the terminal UI shows.
+type tuiState struct {
+	Addr        string
+	State       string // connecting, connected or closed
+	Err         error  // why the connection closed, if it failed
+	Fingerprint string
+	Session     string // version and cipher suite
+	Sent        int64
+	Received    int64
+	Latency     time.Duration
+	Log         []string
+	Input       string
+}
+
+// logf adds a line to the message log.
+func (st *tuiState) logf(format string, args ...any) {
+	st.Log = append(st.Log, fmt.Sprintf(format, args...))
+	if len(st.Log) > maxLogLines {
+		st.Log = st.Log[len(st.Log)-maxLogLines:]
+	}
+}
+
+// render draws the state on a terminal of width columns and height rows,
+// in raw mode: the status panel at the top, the end of the message log
+// and the input line at the bottom.
+func (st *tuiState) render(w io.Writer, width, height int) {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J") // home and clear screen
+	line := func(format string, args ...any) {
+		s := fmt.Sprintf(format, args...)
+		if utf8.RuneCountInString(s) > width {
+			s = string([]rune(s)[:width])
+		}
+		b.WriteString(s + "\r\n")
+	}
+
+	state := st.State
+	if st.Err != nil {
+		state += ": " + st.Err.Error()
+	}
+	latency := "-"
+	if st.Latency > 0 {
+		latency = st.Latency.Round(100 * time.Microsecond).String()
+	}
+	rule := strings.Repeat("─", width)
+	line("%s", rule)
+	line(" Server       %s", st.Addr)
+	line(" State        %s", state)
+	line(" Fingerprint  %s", orDash(st.Fingerprint))
+	line(" Session      %s", orDash(st.Session))
+	line(" Traffic      sent %s, received %s", formatSize(st.Sent), formatSize(st.Received))
+	line(" Latency      %s", latency)
+	line("%s", rule)
+
+	// The log fills the rows left, above the input line
+	rows := max(height-9, 0)
+	logLines := st.Log[max(len(st.Log)-rows, 0):]
+	for _, l := range logLines {
+		line("%s", l)
+	}
+	for i := len(logLines); i < rows; i++ {
+		b.WriteString("\r\n")
+	}
+	b.WriteString(replPrompt + st.Input)
+	io.WriteString(w, b.String())
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// countingConn counts the bytes sent and received by the terminal UI.
+type countingConn struct {
+	*securecomm.Conn
+	sent, received atomic.Int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.received.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.sent.Add(int64(n))
+	return n, err
+}
+
+// runTUI runs the terminal UI on tty, of the size returned by size,
+// connecting to addr with dial. Each line typed is sent as a message and
+// each line received is added to the log. It returns when the user
+// presses Ctrl-C or Ctrl-D.
+func runTUI(addr string, dial func() (*securecomm.Conn, error), tty io.ReadWriter, size func() (int, int)) error {
+	st := &tuiState{Addr: addr, State: "connecting"}
+	draw := func() {
+		width, height := size()
+		st.render(tty, width, height)
+	}
+	draw()
+
+	type dialResult struct {
+		conn *securecomm.Conn
+		err  error
+	}
+	dialed := make(chan dialResult, 1)
+	go func() {
+		c, err := dial()
+		dialed <- dialResult{c, err}
+	}()
+	var conn *countingConn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	received := make(chan string)
+	closed := make(chan error, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	receive := func() {
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			select {
+			case received <- scanner.Text():
+			case <-stop:
+				return
+			}
+		}
+		closed <- scanner.Err()
+	}
+
+	keys := make(chan []byte)
+	keysDone := make(chan error, 1)
+	go func() {
+		for {
+			buf := make([]byte, 64)
+			n, err := tty.Read(buf)
+			if err != nil {
+				keysDone <- err
+				return
+			}
+			select {
+			case keys <- buf[:n]:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(tuiRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case res := <-dialed:
+			if res.err != nil {
+				st.State, st.Err = "closed", res.err
+				break
+			}
+			conn = &countingConn{Conn: res.conn}
+			go receive()
+			st.State = "connected"
+			st.Fingerprint = securecomm.Fingerprint(conn.PeerIdentity())
+			st.Session = fmt.Sprintf("version %d, %s", conn.Version(), conn.Suite())
+			if conn.PostQuantum() {
+				st.Session += ", post-quantum"
+			}
+		case msg := <-received:
+			st.logf("< %s", msg)
+		case err := <-closed:
+			st.State, st.Err = "closed", err
+		case err := <-keysDone:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-ticker.C:
+		case b := <-keys:
+			if quit := st.typed(b, conn); quit {
+				return nil
+			}
+		}
+		if conn != nil {
+			st.Sent, st.Received = conn.sent.Load(), conn.received.Load()
+			st.Latency = conn.Latency()
+		}
+		draw()
+	}
+}
+
+// typed handles the keys typed, editing the input line and sending it
+// to conn on Enter. It tells whether the user asked to quit.
+func (st *tuiState) typed(b []byte, conn *countingConn) bool {
+	for len(b) > 0 {
+		switch c := b[0]; {
+		case c == 3 || c == 4: // Ctrl-C, Ctrl-D
+			return true
+		case c == '\r' || c == '\n':
+			if st.Input == "" {
+				break
+			}
+			switch {
+			case conn == nil || st.State != "connected":
+				st.logf("! not connected")
+			default:
+				if _, err := io.WriteString(conn, st.Input+"\n"); err != nil {
+					st.logf("! %v", err)
+				} else {
+					st.logf("> %s", st.Input)
+				}
+			}
+			st.Input = ""
+		case c == 127 || c == 8: // Backspace
+			if _, size := utf8.DecodeLastRuneInString(st.Input); size > 0 {
+				st.Input = st.Input[:len(st.Input)-size]
+			}
+		case c == 0x1b:
+			// Skip the escape sequences of the arrows and such
+			if len(b) > 2 && b[1] == '[' {
+				b = b[2:]
+			}
+		case c >= 0x20:
+			r, size := utf8.DecodeRune(b)
+			st.Input += string(r)
+			b = b[size:]
+			continue
+		}
+		b = b[1:]
+	}
+	return false
+}
+
+func tuiCommand(args []string) {
+	fs := flag.NewFlagSet("tui", flag.ExitOnError)
+	config := configFlags(fs)
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		log.Fatalf("Usage: %s tui [flags] <port> | <service> | <named pipe>", os.Args[0])
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		log.Fatal("tui needs a terminal")
+	}
+
+	addr := "localhost:" + fs.Arg(0)
+	if securecomm.IsServiceName(fs.Arg(0)) || securecomm.IsNamedPipe(fs.Arg(0)) {
+		addr = fs.Arg(0)
+	}
+	// The heartbeats measure the latency
+	cfg := securecomm.WithHeartbeat(config(true))
+	dial := func() (*securecomm.Conn, error) {
+		conn, err := securecomm.DialConfig(addr, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return conn.(*securecomm.Conn), nil
+	}
+	size := func() (int, int) {
+		width, height, err := term.GetSize(fd)
+		if err != nil {
+			return 80, 24
+		}
+		return width, height
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tty := struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}
+	io.WriteString(os.Stdout, "\x1b[?1049h") // alternate screen
+	err = runTUI(addr, dial, tty, size)
+	io.WriteString(os.Stdout, "\x1b[?1049l")
+	term.Restore(fd, state)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mauricioabreu/go-challenges/challenge2/securecomm"
+)
+
+func TestTUI(t *testing.T) {
+	_, identity, _ := ed25519.GenerateKey(rand.Reader)
+	dial := func() (*securecomm.Conn, error) {
+		client, server, err := securecomm.SecurePipeConfig(&securecomm.Config{HeartbeatInterval: 10 * time.Millisecond}, &securecomm.Config{Identity: identity})
+		if err != nil {
+			return nil, err
+		}
+		go func() {
+			io.Copy(server, server)
+			server.Close()
+		}()
+		return client, nil
+	}
+	size := func() (int, int) { return 80, 24 }
+
+	keys, typed := io.Pipe()
+	tty := &fakeTerminal{keys: keys}
+	done := make(chan error, 1)
+	go func() {
+		done <- runTUI("example.com:2000", dial, tty, size)
+	}()
+
+	tty.waitOutput(t, "connected")
+	tty.waitOutput(t, securecomm.Fingerprint(identity.Public().(ed25519.PublicKey)))
+	io.WriteString(typed, "hello\r")
+	tty.waitOutput(t, "< hello")
+	tty.waitOutput(t, "sent 6.0 B, received 6.0 B")
+	io.WriteString(typed, "\x04")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTUIDialFailed(t *testing.T) {
+	dial := func() (*securecomm.Conn, error) {
+		return nil, errors.New("connection refused")
+	}
+	keys, typed := io.Pipe()
+	tty := &fakeTerminal{keys: keys}
+	done := make(chan error, 1)
+	go func() {
+		done <- runTUI("example.com:2000", dial, tty, func() (int, int) { return 80, 24 })
+	}()
+
+	tty.waitOutput(t, "closed: connection refused")
+	io.WriteString(typed, "hello\r")
+	tty.waitOutput(t, "! not connected")
+	typed.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTUIRender(t *testing.T) {
+	st := &tuiState{Addr: "example.com:2000", State: "connected", Latency: 1500 * time.Microsecond}
+	for i := 0; i < 20; i++ {
+		st.logf("< message %d", i)
+	}
+	st.typed([]byte("héllo\x7f\x1b[Ax"), nil)
+
+	var buf bytes.Buffer
+	st.render(&buf, 40, 12)
+	out := buf.String()
+	for _, s := range []string{"Latency      1.5ms", "Fingerprint  -", "< message 19\r\n> héllx"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("%q not rendered in %q", s, out)
+		}
+	}
+	// Only the end of the log fits
+	if strings.Contains(out, "message 16") || !strings.Contains(out, "message 17") {
+		t.Errorf("unexpected log in %q", out)
+	}
+}